@@ -0,0 +1,75 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// API classification of every package, by the rules of the classifier package: test-framework, internal, experimental,
+// impl or public. It is the api column of the packages, and any scan can be limited to some of the classes:
+//  go run . -d ./platform -api public,experimental
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bzz/jet-search/classifier"
+)
+
+var apiClasses = func() []string {
+	classes := make([]string, len(classifier.Classes))
+	for i, c := range classifier.Classes {
+		classes[i] = string(c)
+	}
+	return classes
+}()
+
+// findAPIStatus updates .apiStatus with the @ApiStatus annotation of package-info.java.
+func findAPIStatus(p *pkg, f *sourceFile) error {
+	if f.name() != "package-info.java" {
+		return nil
+	}
+	content, err := f.content()
+	if err != nil {
+		return err
+	}
+	if status := classifier.Status(content); status != "" {
+		p.apiStatus = string(status)
+	}
+	return nil
+}
+
+// apiClass returns one of the apiClasses of the package, by the classifier package that the other tools import too.
+func (p *pkg) apiClass() string {
+	return string(classifier.Classify(classifier.Package{Name: p.name, Module: p.module, Status: classifier.Classification(p.apiStatus)}))
+}
+
+// parseAPIClasses parses a comma-separated list of classes, nil for an empty one.
+func parseAPIClasses(list string) (map[string]bool, error) {
+	if list == "" {
+		return nil, nil
+	}
+	classes := map[string]bool{}
+	for _, c := range strings.Split(list, ",") {
+		c = strings.TrimSpace(c)
+		known := false
+		for _, k := range apiClasses {
+			known = known || c == k
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown API class %q, expected some of %s", c, strings.Join(apiClasses, ", "))
+		}
+		classes[c] = true
+	}
+	return classes, nil
+}
+
+// filterAPI drops the packages of other API classes than the given by -api, if any.
+func filterAPI(pkgs map[string]*pkg) (map[string]*pkg, error) {
+	classes, err := parseAPIClasses(*apiFlag)
+	if err != nil || classes == nil {
+		return pkgs, err
+	}
+	for dir, p := range pkgs {
+		if !classes[p.apiClass()] {
+			delete(pkgs, dir)
+		}
+	}
+	return pkgs, nil
+}
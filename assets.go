@@ -0,0 +1,160 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Inventory of the non-source assets per module, for the repository size cleanups:
+//  go run . report assets -d ./platform -large-kb 1024 -files
+// An asset is an image, an archive or a native library, or any other file over the -large-kb size.
+// Every file in the dir of an .iml belongs to its module, unless it is in the dir of a nested one.
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var assetExts = map[string]string{
+	".png": "image", ".jpg": "image", ".jpeg": "image", ".gif": "image", ".svg": "image", ".ico": "image", ".icns": "image",
+	".jar": "archive", ".zip": "archive", ".gz": "archive", ".tgz": "archive",
+	".dll": "native", ".so": "native", ".dylib": "native", ".exe": "native",
+}
+
+// asset is a non-source file of a module.
+type asset struct {
+	module string
+	path   string
+	kind   string // image, archive, native or large
+	size   int64
+}
+
+// moduleAssets is the inventory of a module.
+type moduleAssets struct {
+	module string
+	assets []asset
+	size   int64
+}
+
+// walkModuleFiles calls fn for every file in the dirs of the modules, with the module it belongs to.
+func walkModuleFiles(fsys fs.FS, modulesPaths []string, fn func(module, path string, size int64) error) error {
+	moduleOfDir := map[string]string{}
+	for _, mp := range modulesPaths {
+		moduleOfDir[filepath.Dir(mp)] = mp
+	}
+
+	for dir, mp := range moduleOfDir {
+		err := fs.WalkDir(fsys, filepath.ToSlash(dir), func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if strings.HasPrefix(d.Name(), ".") && path != dir {
+					return fs.SkipDir
+				}
+				if _, nested := moduleOfDir[filepath.FromSlash(path)]; nested && filepath.FromSlash(path) != dir {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			return fn(mp, path, info.Size())
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findAssets returns the assets of the modules, the largest modules first.
+func findAssets(fsys fs.FS, modulesPaths []string, largeSize int64) ([]*moduleAssets, error) {
+	byModule := map[string]*moduleAssets{}
+	err := walkModuleFiles(fsys, modulesPaths, func(mp, path string, size int64) error {
+		kind := assetExts[strings.ToLower(filepath.Ext(path))]
+		if kind == "" && size >= largeSize && !(&sourceFile{path: path}).isSource() {
+			kind = "large"
+		}
+		if kind == "" {
+			return nil
+		}
+
+		ma, ok := byModule[mp]
+		if !ok {
+			ma = &moduleAssets{module: mp}
+			byModule[mp] = ma
+		}
+		ma.assets = append(ma.assets, asset{mp, path, kind, size})
+		ma.size += size
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	mods := make([]*moduleAssets, 0, len(byModule))
+	for _, ma := range byModule {
+		sort.Slice(ma.assets, func(i, j int) bool { return ma.assets[i].path < ma.assets[j].path })
+		mods = append(mods, ma)
+	}
+	sort.Slice(mods, func(i, j int) bool {
+		if mods[i].size != mods[j].size {
+			return mods[i].size > mods[j].size
+		}
+		return mods[i].module < mods[j].module
+	})
+	return mods, nil
+}
+
+func reportAssets(args []string) error {
+	fs := flag.NewFlagSet("report assets", flag.ExitOnError)
+	dir := fs.String("d", "", "dir to scan for modules")
+	largeKB := fs.Int64("large-kb", 1024, "size of a file in KB, over which it is an asset regardless of the type")
+	files := fs.Bool("files", false, "list every asset instead of the module totals")
+	testFramework := fs.Bool("test-framework", false, "include testFramework modules")
+	addFormatFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		fs.Usage()
+		return nil
+	}
+
+	modulesPaths, err := findAllModules(osFS{}, *dir, *testFramework)
+	if err != nil {
+		return err
+	}
+	mods, err := findAssets(osFS{}, modulesPaths, *largeKB*1024)
+	if err != nil {
+		return err
+	}
+
+	sep := "\t"
+	if *mdFlag {
+		sep = " | "
+	}
+	if *files {
+		printHeader([]string{"module", "kind", "size", "path"})
+		for _, ma := range mods {
+			for _, a := range ma.assets {
+				fmt.Println(strings.Join([]string{a.module, a.kind, fmt.Sprint(a.size), a.path}, sep))
+			}
+		}
+		return nil
+	}
+
+	printHeader([]string{"module", "assets", "size", "image", "archive", "native", "large"})
+	for _, ma := range mods {
+		kinds := map[string]int{}
+		for _, a := range ma.assets {
+			kinds[a.kind]++
+		}
+		fmt.Println(strings.Join([]string{ma.module, fmt.Sprint(len(ma.assets)), fmt.Sprint(ma.size),
+			fmt.Sprint(kinds["image"]), fmt.Sprint(kinds["archive"]), fmt.Sprint(kinds["native"]), fmt.Sprint(kinds["large"])}, sep))
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Backfill of the history with the scans of past revisions, for the trends to start before the first -history scan:
+//  go run . history backfill -d ./platform -history trend.jsonl -every 1month -since 2020-01
+// or the time series of the past revisions right away, as history export writes them, without a history file:
+//  go run . history -d ./platform -every 1month -since 2021-01 -format csv
+// A revision is scanned at every step from -since until -until, now by default: the last first-parent commit
+// of -rev at that time, read by the -rev reader so the checkout is not touched. The records are at the commit times,
+// and the revisions already in the history, or repeated as no commit was made for a step, are scanned once.
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var historyCommands = map[string]func(args []string) error{
+	"backfill": runBackfill,
+	"export":   runExport,
+}
+
+// runHistory runs a history subcommand by name, given as the first argument.
+func runHistory(args []string) error {
+	if len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		return runTrend(args)
+	}
+	if len(args) == 0 || historyCommands[args[0]] == nil {
+		names := make([]string, 0, len(historyCommands))
+		for name := range historyCommands {
+			names = append(names, name)
+		}
+		return fmt.Errorf("usage: history [<%s>] [flags]", strings.Join(names, "|"))
+	}
+	return historyCommands[args[0]](args[1:])
+}
+
+// period is a calendar step, i.e 1month is the same day of the next month whatever its length.
+type period struct {
+	years, months, days int
+}
+
+// parsePeriod parses a number and a unit of d, w, month or y, i.e 2w or 1month, the plural forms included.
+func parsePeriod(s string) (period, error) {
+	i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if i <= 0 {
+		return period{}, fmt.Errorf("bad period %q, want a number and a unit, i.e 1month", s)
+	}
+	n, err := strconv.Atoi(s[:i])
+	if err != nil || n == 0 {
+		return period{}, fmt.Errorf("bad period %q, want a positive number of the units", s)
+	}
+	switch strings.TrimSuffix(s[i:], "s") {
+	case "d", "day":
+		return period{days: n}, nil
+	case "w", "week":
+		return period{days: 7 * n}, nil
+	case "month":
+		return period{months: n}, nil
+	case "y", "year":
+		return period{years: n}, nil
+	}
+	return period{}, fmt.Errorf("bad period %q, want a unit of d, w, month or y", s)
+}
+
+func (p period) after(t time.Time) time.Time {
+	return t.AddDate(p.years, p.months, p.days)
+}
+
+// parseDate parses a date, a month or a year, i.e 2020-01, as the start of it in UTC.
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", "2006-01", "2006"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("bad date %q, want 2006-01-02, 2006-01 or 2006", s)
+}
+
+// backfillRevisions returns the last first-parent commit of the rev at every step from since until until,
+// skipping the steps before the first commit and the repeated commits.
+func backfillRevisions(dir, rev string, since, until time.Time, every period) ([]string, error) {
+	var commits []string
+	for t := since; !t.After(until); t = every.after(t) {
+		commit, err := gitOutput(dir, "rev-list", "-1", "--first-parent", "--before="+t.Format(time.RFC3339), rev, "--")
+		if err != nil {
+			return nil, err
+		}
+		if commit != "" && (len(commits) == 0 || commits[len(commits)-1] != commit) {
+			commits = append(commits, commit)
+		}
+	}
+	return commits, nil
+}
+
+// revisionFlags are the flags of the revisions to scan, the first-parent commits of -rev at every step.
+type revisionFlags struct {
+	dir, rev, since, until, every *string
+}
+
+func addRevisionFlags(fs *flag.FlagSet) revisionFlags {
+	fs.BoolVar(testFrameworkFlag, "test-framework", false, "include testFramework modules, that ship test APIs, skipped by default")
+	fs.BoolVar(locFlag, "loc", false, "count the code, comment and blank lines of the modules")
+	return revisionFlags{
+		dir:   fs.String("d", "", "dir to scan the past revisions of, in a git working tree"),
+		rev:   fs.String("rev", "HEAD", "revision whose first-parent history is scanned"),
+		since: fs.String("since", "", "date of the first revision, i.e 2020-01"),
+		until: fs.String("until", "", "date of the last revision, now by default"),
+		every: fs.String("every", "1month", "time between the revisions, in d, w, month or y, i.e 2w"),
+	}
+}
+
+// commits returns the revisions of the flags, see backfillRevisions.
+func (f revisionFlags) commits() ([]string, error) {
+	step, err := parsePeriod(*f.every)
+	if err != nil {
+		return nil, err
+	}
+	from, err := parseDate(*f.since)
+	if err != nil {
+		return nil, err
+	}
+	to := time.Now().UTC()
+	if *f.until != "" {
+		if to, err = parseDate(*f.until); err != nil {
+			return nil, err
+		}
+	}
+	return backfillRevisions(*f.dir, *f.rev, from, to, step)
+}
+
+// scanRevisions scans the dir at every commit, but the ones at the skipped commit times, and adds their summaries.
+func scanRevisions(dir string, commits []string, skip map[int64]bool, add func(rec *historyRecord) error) error {
+	var visitors []visitor
+	if *locFlag {
+		visitors = append(visitors, countLOC)
+	}
+	for _, commit := range commits {
+		g, err := newGitFS(dir, commit)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skipping %s: %v\n", commit, err)
+			continue
+		}
+		if skip[g.time.Unix()] {
+			g.Close()
+			continue
+		}
+		pkgs, err := scanFS(g, dir, *testFrameworkFlag, visitors...)
+		g.Close()
+		if err != nil {
+			return fmt.Errorf("error scanning %s: %v", commit, err)
+		}
+		rec := &historyRecord{Time: g.time.UTC(), Dir: dir, Modules: summarizeModules(pkgs)}
+		if err := add(rec); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "scanned %s of %s: %d packages\n", commit, rec.Time.Format("2006-01-02"), len(pkgs))
+	}
+	return nil
+}
+
+// runBackfill scans the past revisions of the dir and appends their summaries to the history.
+func runBackfill(args []string) error {
+	fs := flag.NewFlagSet("history backfill", flag.ExitOnError)
+	revs := addRevisionFlags(fs)
+	historyPath := fs.String("history", "", "history file to append the summaries of the revisions to")
+	namespace := fs.String("namespace", "", "product/branch to label the records with, as -namespace does")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *revs.dir == "" || *historyPath == "" || *revs.since == "" {
+		fs.Usage()
+		return nil
+	}
+	if *namespace != "" && !validNamespace(*namespace) {
+		return fmt.Errorf("bad namespace %q, want product/branch", *namespace)
+	}
+
+	commits, err := revs.commits()
+	if err != nil {
+		return err
+	}
+	scanned := map[int64]bool{} // the commit times
+	if _, err := os.Stat(*historyPath); err == nil {
+		records, err := readHistory(*historyPath)
+		if err != nil {
+			return err
+		}
+		for _, r := range inNamespace(records, *namespace) {
+			if r.Dir == *revs.dir {
+				scanned[r.Time.Unix()] = true
+			}
+		}
+	}
+
+	appended := 0
+	err = scanRevisions(*revs.dir, commits, scanned, func(rec *historyRecord) error {
+		rec.Namespace = *namespace
+		scanned[rec.Time.Unix()] = true
+		appended++
+		return appendHistory(*historyPath, rec)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%d of %d revisions appended to %s\n", appended, len(commits), *historyPath)
+	return nil
+}
+
+// runTrend scans the past revisions of the dir and writes the time series of them, without a history file.
+func runTrend(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	revs := addRevisionFlags(fs)
+	modules := fs.String("modules", "", "glob of the .iml paths of the modules to write, all by default")
+	format := fs.String("format", "json", "format of the series: json|csv")
+	out := fs.String("o", "", "file to write the series to, stdout by default")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *revs.since == "" {
+		fs.Usage()
+		return nil
+	}
+	if *revs.dir == "" {
+		*revs.dir = "."
+	}
+	if *format != "json" && *format != "csv" {
+		return fmt.Errorf("unknown format %q, expected json or csv", *format)
+	}
+	scope, err := globToRegexp(*modules)
+	if err != nil {
+		return fmt.Errorf("bad modules %q: %v", *modules, err)
+	}
+
+	commits, err := revs.commits()
+	if err != nil {
+		return err
+	}
+	var records []*historyRecord
+	err = scanRevisions(*revs.dir, commits, nil, func(rec *historyRecord) error {
+		records = append(records, rec)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+	return writeSeries(*out, *format, timeSeries(records, scope))
+}
@@ -0,0 +1,89 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Doc coverage badges, for the module READMEs to show:
+//  go run . -d ./platform -badge-dir badges/
+// writes badges/<module>.json, the endpoint of shields.io, and badges/<module>.svg to embed as is, for every module,
+// and badges/all.json and all.svg for the scanned dir. The coverage is by -coverage, red below 50%, yellow below 80%.
+//  ![docs](https://img.shields.io/endpoint?url=https://example.com/badges/intellij.platform.core.json)
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// badge is the shields.io endpoint schema, see https://shields.io/badges/endpoint-badge
+type badge struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Label         string `json:"label"`
+	Message       string `json:"message"`
+	Color         string `json:"color"`
+}
+
+// badge colors, by the shields.io names and the hex of them for the SVG
+var badgeColors = map[string]string{"red": "#e05d44", "yellow": "#dfb317", "brightgreen": "#4c1"}
+
+func coverageBadge(pkgs map[string]*pkg) badge {
+	documented, total := docCoverage(pkgs, *coverageFlag)
+	pct := percent(documented, total)
+	b := badge{SchemaVersion: 1, Label: "doc coverage", Message: fmt.Sprintf("%.0f%%", pct), Color: "brightgreen"}
+	switch {
+	case pct < 50:
+		b.Color = "red"
+	case pct < 80:
+		b.Color = "yellow"
+	}
+	return b
+}
+
+// writeBadges writes the badges of every module, by the name of its .iml, and of all the packages.
+func writeBadges(dir string, pkgs map[string]*pkg) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	byModule := map[string]map[string]*pkg{"all": pkgs}
+	for pkgDir, p := range pkgs {
+		name := strings.TrimSuffix(filepath.Base(p.module), filepath.Ext(p.module))
+		if byModule[name] == nil {
+			byModule[name] = map[string]*pkg{}
+		}
+		byModule[name][pkgDir] = p
+	}
+	for name, modulePkgs := range byModule {
+		b := coverageBadge(modulePkgs)
+		err := writeFile(filepath.Join(dir, name+".json"), func(w io.Writer) error {
+			return json.NewEncoder(w).Encode(b)
+		})
+		if err != nil {
+			return err
+		}
+		if err := writeFile(filepath.Join(dir, name+".svg"), func(w io.Writer) error { return writeBadgeSVG(w, b) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeBadgeSVG draws the badge in the flat style, the widths estimated by 7px per character.
+func writeBadgeSVG(w io.Writer, b badge) error {
+	labelWidth, messageWidth := 10+7*utf8.RuneCountInString(b.Label), 10+7*utf8.RuneCountInString(b.Message)
+	width := labelWidth + messageWidth
+	_, err := fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="20" role="img" aria-label="%s: %s">
+<title>%s: %s</title>
+<rect width="%d" height="20" fill="#555"/>
+<rect x="%d" width="%d" height="20" fill="%s"/>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="%d" y="14">%s</text>
+<text x="%d" y="14">%s</text>
+</g>
+</svg>
+`, width, xmlEscape(b.Label), xmlEscape(b.Message), xmlEscape(b.Label), xmlEscape(b.Message),
+		labelWidth, labelWidth, messageWidth, badgeColors[b.Color],
+		labelWidth/2, xmlEscape(b.Label), labelWidth+messageWidth/2, xmlEscape(b.Message))
+	return err
+}
@@ -0,0 +1,217 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// BigQuery sink, appending a row per package of every scan to a table:
+//  go run . -d ./platform -publish
+// with the table from the config:
+//  {"sinks": {"bigquery": {"project": "eng-metrics", "dataset": "docs", "table": "packages", "credentials": "/secrets/sa.json"}}}
+// It authenticates as the service account of the credentials key file, GOOGLE_APPLICATION_CREDENTIALS by default.
+// The table has to exist, with the columns of packageRow. Rows have insert IDs by the scan time and the package dir,
+// so a retried publish does not duplicate them.
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// bigQueryBatch is the number of rows per insertAll request, as recommended by BigQuery.
+const bigQueryBatch = 500
+
+var bigQueryURL = "https://bigquery.googleapis.com" // of the API, a var for the tests
+
+func init() {
+	sinkTypes["bigquery"] = newBigQuerySink
+}
+
+// bigQueryConfig is the table to append to.
+type bigQueryConfig struct {
+	Project     string `json:"project"`
+	Dataset     string `json:"dataset"`
+	Table       string `json:"table"`
+	Credentials string `json:"credentials"` // path to the service account key file
+}
+
+// serviceAccountKey is the part of the service account key file needed to get a token.
+type serviceAccountKey struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+type bigQueryClient struct {
+	bigQueryConfig
+	key  serviceAccountKey
+	http *http.Client
+}
+
+func newBigQuerySink(config json.RawMessage) (sink, error) {
+	var c bigQueryConfig
+	if err := json.Unmarshal(config, &c); err != nil {
+		return nil, err
+	}
+	if c.Project == "" || c.Dataset == "" || c.Table == "" {
+		return nil, fmt.Errorf("project, dataset and table are required")
+	}
+	if c.Credentials == "" {
+		c.Credentials = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	if c.Credentials == "" {
+		return nil, fmt.Errorf("credentials or GOOGLE_APPLICATION_CREDENTIALS are required")
+	}
+	blob, err := os.ReadFile(c.Credentials)
+	if err != nil {
+		return nil, err
+	}
+	var key serviceAccountKey
+	if err := json.Unmarshal(blob, &key); err != nil {
+		return nil, fmt.Errorf("error parsing credentials %q: %v", c.Credentials, err)
+	}
+	if key.TokenURI == "" {
+		key.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	return &bigQueryClient{bigQueryConfig: c, key: key, http: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+func (b *bigQueryClient) publish(s *snapshot, findings []finding) error {
+	token, err := b.accessToken()
+	if err != nil {
+		return err
+	}
+
+	type insertRow struct {
+		InsertID string     `json:"insertId"`
+		JSON     packageRow `json:"json"`
+	}
+	var rows []insertRow
+	for _, p := range sortedPackages(s.pkgs) {
+		row := newPackageRow(s, p)
+		id := sha256.Sum256([]byte(row.ScanTime + "\x00" + p.pkgDir))
+		rows = append(rows, insertRow{hex.EncodeToString(id[:16]), row})
+	}
+
+	path := fmt.Sprintf("%s/bigquery/v2/projects/%s/datasets/%s/tables/%s/insertAll", bigQueryURL,
+		url.PathEscape(b.Project), url.PathEscape(b.Dataset), url.PathEscape(b.Table))
+	for start := 0; start < len(rows); start += bigQueryBatch {
+		end := start + bigQueryBatch
+		if end > len(rows) {
+			end = len(rows)
+		}
+		var resp struct {
+			InsertErrors []struct {
+				Index  int `json:"index"`
+				Errors []struct {
+					Reason  string `json:"reason"`
+					Message string `json:"message"`
+				} `json:"errors"`
+			} `json:"insertErrors"`
+		}
+		req := map[string]interface{}{"kind": "bigquery#tableDataInsertAllRequest", "rows": rows[start:end]}
+		if err := b.call(path, token, req, &resp); err != nil {
+			return err
+		}
+		if len(resp.InsertErrors) > 0 {
+			e := resp.InsertErrors[0]
+			msg := "unknown error"
+			if len(e.Errors) > 0 {
+				msg = e.Errors[0].Reason + ": " + e.Errors[0].Message
+			}
+			return fmt.Errorf("%d rows not inserted, i.e %s: %s", len(resp.InsertErrors), rows[start+e.Index].JSON.PkgDir, msg)
+		}
+	}
+	return nil
+}
+
+// accessToken exchanges a JWT signed by the service account key for an OAuth access token.
+func (b *bigQueryClient) accessToken() (string, error) {
+	block, _ := pem.Decode([]byte(b.key.PrivateKey))
+	if block == nil {
+		return "", errors.New("no PEM private key in the credentials")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("error parsing the private key: %v", err)
+	}
+	rsaKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", errors.New("the private key is not RSA")
+	}
+
+	now := time.Now()
+	segment := func(v interface{}) string {
+		blob, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(blob)
+	}
+	unsigned := segment(map[string]string{"alg": "RS256", "typ": "JWT"}) + "." + segment(map[string]interface{}{
+		"iss":   b.key.ClientEmail,
+		"scope": "https://www.googleapis.com/auth/bigquery.insertdata",
+		"aud":   b.key.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	hash := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + base64.RawURLEncoding.EncodeToString(sig)},
+	}
+	resp, err := b.http.PostForm(b.key.TokenURI, form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("token: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+func (b *bigQueryClient) call(path, token string, body, result interface{}) error {
+	blob, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, path, bytes.NewReader(blob))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := b.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("POST %s: %s: %s", strings.TrimPrefix(path, bigQueryURL), resp.Status, bytes.TrimSpace(msg))
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
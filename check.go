@@ -0,0 +1,163 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Checks that are meant to be run on CI, i.e
+//  go run . check toolchain -d ./platform -min-java 17 -min-kotlin 1.9 -fail
+// With -fail, a check fails the build only if its severity does, so teams can adopt the checks gradually:
+//  {"checks": {"naming": "warning", "small-packages": "info"}, "failOn": ["error"]}
+// Checks are error by default, and only error fails by default. Besides the ones below, there are
+// diff and undocumented-new for the packages that lost the docs or are newly public, see diff.go.
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// errCheckFailed is returned by a check that found violations and was asked to fail.
+var errCheckFailed = errors.New("check failed")
+
+var checks = map[string]func(args []string) error{
+	"toolchain": checkToolchain,
+	"naming":    checkNaming,
+
+	"small-packages": checkSmallPackages,
+	"large-packages": checkLargePackages,
+
+	"licenses": checkLicenses,
+	"orphans":  checkOrphans,
+
+	"module-docs": checkModuleDocs,
+}
+
+var severities = []string{"error", "warning", "info"}
+
+func knownSeverity(s string) bool {
+	for _, known := range severities {
+		if s == known {
+			return true
+		}
+	}
+	return false
+}
+
+// severity returns the severity of the check from the config, error by default.
+func (c *config) severity(check string) string {
+	if s := c.Checks[check]; s != "" {
+		return s
+	}
+	return "error"
+}
+
+// failedCheck returns errCheckFailed if the check found violations, is asked to fail and its severity fails the build.
+func failedCheck(check string, fail bool, violations int) error {
+	if !fail || violations == 0 {
+		return nil
+	}
+	severity := cfg.severity(check)
+	failOn := cfg.FailOn
+	if len(failOn) == 0 {
+		failOn = []string{"error"}
+	}
+	for _, s := range failOn {
+		if s == severity {
+			return errCheckFailed
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%s: %d violations of %s severity do not fail the build\n", check, violations, severity)
+	return nil
+}
+
+// runCheck runs a check by name, given as the first argument.
+func runCheck(args []string) error {
+	if len(args) == 0 || checks[args[0]] == nil {
+		names := make([]string, 0, len(checks))
+		for name := range checks {
+			names = append(names, name)
+		}
+		return fmt.Errorf("usage: check <%s> [flags]", strings.Join(names, "|"))
+	}
+	return checks[args[0]](args[1:])
+}
+
+// checkToolchain lists modules with Java language level, JVM target or Kotlin apiVersion below the given ones.
+func checkToolchain(args []string) error {
+	fs := flag.NewFlagSet("check toolchain", flag.ExitOnError)
+	dir := fs.String("d", "", "dir to scan for modules")
+	minJava := fs.String("min-java", "", "minimal Java language level and JVM target, e.g. 17")
+	minKotlin := fs.String("min-kotlin", "", "minimal Kotlin apiVersion, e.g. 1.9")
+	fail := fs.Bool("fail", false, "exit with non-zero code if any module is below the minimal versions")
+	addFormatFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *dir == "" || (*minJava == "" && *minKotlin == "") {
+		fs.Usage()
+		return nil
+	}
+
+	modulesPaths, err := findModulesPaths(osFS{}, *dir, ".iml")
+	if err != nil {
+		return err
+	}
+	if modulesPaths, err = skipClassifiedModules(osFS{}, modulesPaths); err != nil {
+		return err
+	}
+	mods, err := readModules(*dir, modulesPaths)
+	if err != nil {
+		return err
+	}
+
+	var below []*moduleInfo
+	for _, m := range mods {
+		if javaVersionLess(m.javaLevel, *minJava) || javaVersionLess(m.jvmTarget, *minJava) ||
+			kotlinVersionLess(m.kotlinAPI, *minKotlin) {
+			below = append(below, m)
+		}
+	}
+	printModules(below)
+	fmt.Fprintf(os.Stderr, "%d of %d modules below Java %q Kotlin %q\n", len(below), len(mods), *minJava, *minKotlin)
+
+	return failedCheck("toolchain", *fail, len(below))
+}
+
+// javaVersionLess compares Java versions as set in the project model, i.e JDK_1_8 < 11 < JDK_17_PREVIEW.
+// Unknown (empty) versions are never less.
+func javaVersionLess(v, min string) bool {
+	a, b := javaVersion(v), javaVersion(min)
+	return a > 0 && b > 0 && a < b
+}
+
+// javaVersion returns a major version for JDK_1_8, 1.8, 17 or JDK_17_PREVIEW, JDK_X being the latest.
+func javaVersion(v string) int {
+	v = strings.TrimSuffix(strings.TrimPrefix(v, "JDK_"), "_PREVIEW")
+	if v == "X" {
+		return 1 << 16
+	}
+	v = strings.TrimPrefix(strings.ReplaceAll(v, "_", "."), "1.")
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// kotlinVersionLess compares dotted Kotlin versions, i.e 1.9 < 1.10 < 2.0
+// Unknown (empty) versions are never less.
+func kotlinVersionLess(v, min string) bool {
+	if v == "" || min == "" {
+		return false
+	}
+	a, b := strings.Split(v, "."), strings.Split(min, ".")
+	for i := 0; i < len(a) && i < len(b); i++ {
+		x, _ := strconv.Atoi(a[i])
+		y, _ := strconv.Atoi(b[i])
+		if x != y {
+			return x < y
+		}
+	}
+	return len(a) < len(b)
+}
@@ -0,0 +1,87 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+
+// Package classifier classifies the packages of the IntelliJ Platform by API stability, for jet-search and the other
+// tools to tell the public API alike, by the signals in the order of precedence:
+//
+//	test-framework  the package is in a testFramework module
+//	internal        @ApiStatus.Internal on package-info.java, or an .internal segment in the name
+//	experimental    @ApiStatus.Experimental on package-info.java, or an .experimental segment in the name
+//	impl            an .impl segment in the name
+//	public          the rest, the public API
+//
+// It depends on the package name, the path to its module .iml file and its package-info.java only, not on a scan:
+//
+//	c := classifier.Classify(classifier.Package{Name: "com.intellij.openapi.impl", Module: "platform/core/intellij.platform.core.iml"})
+package classifier
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+)
+
+// Classification is the API class of a package.
+type Classification string
+
+const (
+	Public        Classification = "public"
+	Experimental  Classification = "experimental"
+	Impl          Classification = "impl"
+	Internal      Classification = "internal"
+	TestFramework Classification = "test-framework"
+)
+
+// Classes are all the classifications, the most stable first.
+var Classes = []Classification{Public, Experimental, Impl, Internal, TestFramework}
+
+// Package is what a package is classified by.
+type Package struct {
+	Name   string         // as in `import ...`, i.e com.intellij.openapi.vfs
+	Module string         // path to the .iml file of its module
+	Status Classification // by the @ApiStatus of package-info.java, see Status, "" if there is none
+}
+
+// Classify returns the classification of the package.
+func Classify(p Package) Classification {
+	if IsTestFramework(p.Module) {
+		return TestFramework
+	}
+	if p.Status != "" {
+		return p.Status
+	}
+	segments := map[string]bool{}
+	for _, s := range strings.Split(p.Name, ".") {
+		segments[s] = true
+	}
+	for _, c := range []Classification{Internal, Experimental, Impl} {
+		if segments[string(c)] {
+			return c
+		}
+	}
+	return Public
+}
+
+// Status returns the classification by the @ApiStatus annotation of the content of package-info.java, "" if there is none.
+func Status(packageInfo []byte) Classification {
+	switch {
+	case bytes.Contains(packageInfo, []byte("@ApiStatus.Internal")):
+		return Internal
+	case bytes.Contains(packageInfo, []byte("@ApiStatus.Experimental")):
+		return Experimental
+	}
+	return ""
+}
+
+// IsTestFramework checks if the .iml module is a part of a testFramework, i.e
+//
+//	platform/testFramework/intellij.platform.testFramework.iml
+//	platform/testFramework/extensions/intellij.platform.testExtensions.iml
+//	xml/testFramework/intellij.xml.testFramework.iml
+func IsTestFramework(modulePath string) bool {
+	for _, p := range strings.Split(filepath.ToSlash(filepath.Dir(modulePath)), "/") {
+		if p == "testFramework" {
+			return true
+		}
+	}
+	return strings.Contains(filepath.Base(modulePath), ".testFramework")
+}
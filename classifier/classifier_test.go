@@ -0,0 +1,24 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package classifier
+
+import "testing"
+
+func TestClassify(t *testing.T) {
+	for _, tc := range []struct {
+		p    Package
+		want Classification
+	}{
+		{Package{Name: "com.intellij.openapi.vfs", Module: "platform/core/intellij.platform.core.iml"}, Public},
+		{Package{Name: "com.intellij.openapi.vfs.impl", Module: "platform/core/intellij.platform.core.iml"}, Impl},
+		{Package{Name: "com.intellij.impl.experimental", Module: "platform/core/intellij.platform.core.iml"}, Experimental},
+		{Package{Name: "com.intellij.internal.impl", Module: "platform/core/intellij.platform.core.iml"}, Internal},
+		{Package{Name: "com.intellij.openapi", Module: "platform/core/intellij.platform.core.iml", Status: Status([]byte("@ApiStatus.Internal\npackage com.intellij.openapi;"))}, Internal},
+		{Package{Name: "com.intellij.openapi.impl", Module: "platform/core/intellij.platform.core.iml", Status: Status([]byte("@ApiStatus.Experimental"))}, Experimental},
+		{Package{Name: "com.intellij.internal", Module: "platform/testFramework/intellij.platform.testFramework.iml"}, TestFramework},
+		{Package{Name: "com.intellij.xml", Module: "xml/intellij.xml.testFramework.iml"}, TestFramework},
+	} {
+		if got := Classify(tc.p); got != tc.want {
+			t.Errorf("%+v: got %s, want %s", tc.p, got, tc.want)
+		}
+	}
+}
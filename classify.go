@@ -0,0 +1,105 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Modules are classified by the rules of the config, the first matching one wins:
+//  {"moduleClasses": [
+//    {"class": "test", "modules": "*Tests", "skip": true},
+//    {"class": "sample", "modules": "**/samples/**", "skip": true},
+//    {"class": "deprecated", "modules": "platform/old/**"}
+//  ]}
+// A rule matches modules by a glob over the .iml path or the module name, as the scopes of the rules.
+// Modules of the skipped classes are not scanned for packages, but are listed by -modules with their class,
+// so that a misclassified module is easy to spot. Without the rules, *Tests and *tests modules are skipped as test ones.
+//
+// A module can also be skipped by a .jet-search-ignore file next to its .iml, with the reason in it, i.e
+//  demo of the API for the SDK docs, never shipped
+// that is recorded in the decision log and listed by -modules as the "ignored" class.
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// moduleClass is a module classification rule.
+type moduleClass struct {
+	Class   string `json:"class"`
+	Modules string `json:"modules"` // glob over the .iml path or the module name
+	Skip    bool   `json:"skip"`    // from the package scans
+}
+
+var defaultModuleClasses = []moduleClass{
+	{Class: "test", Modules: "*Tests", Skip: true},
+	{Class: "test", Modules: "*tests", Skip: true},
+}
+
+// moduleClassifier returns the class of the module and if it is skipped, "" for a regular one.
+type moduleClassifier func(modulePath string) (class string, skip bool)
+
+// newModuleClassifier compiles the rules, the default ones if there are none.
+func newModuleClassifier(rules []moduleClass) (moduleClassifier, error) {
+	if len(rules) == 0 {
+		rules = defaultModuleClasses
+	}
+	globs := make([]*regexp.Regexp, len(rules))
+	for i, r := range rules {
+		if r.Class == "" || r.Modules == "" {
+			return nil, fmt.Errorf("module class rule %d: both class and modules are required", i)
+		}
+		re, err := globToRegexp(r.Modules)
+		if err != nil {
+			return nil, fmt.Errorf("module class %q: bad modules %q: %v", r.Class, r.Modules, err)
+		}
+		globs[i] = re
+	}
+
+	return func(modulePath string) (string, bool) {
+		path := filepath.ToSlash(modulePath)
+		name := strings.TrimSuffix(filepath.Base(path), ".iml")
+		for i, re := range globs {
+			if re.MatchString(path) || re.MatchString(name) {
+				return rules[i].Class, rules[i].Skip
+			}
+		}
+		return "", false
+	}, nil
+}
+
+const ignoreFile = ".jet-search-ignore"
+
+// ignoredModule returns the reason from the ignore file of the module, if it has one.
+func ignoredModule(fsys fs.FS, modulePath string) (reason string, ignored bool) {
+	blob, err := fs.ReadFile(fsys, filepath.ToSlash(filepath.Join(filepath.Dir(modulePath), ignoreFile)))
+	if err != nil {
+		return "", false
+	}
+	reason = strings.TrimSpace(strings.SplitN(string(blob), "\n", 2)[0])
+	if reason == "" {
+		reason = "no reason given"
+	}
+	return reason, true
+}
+
+// skipClassifiedModules returns the given modules except the ignored ones and the ones of the skipped classes,
+// logging the decision.
+func skipClassifiedModules(fsys fs.FS, modulesPaths []string) ([]string, error) {
+	classify, err := newModuleClassifier(cfg.ModuleClasses)
+	if err != nil {
+		return nil, err
+	}
+	var mods []string
+	for _, mp := range modulesPaths {
+		if reason, ignored := ignoredModule(fsys, mp); ignored {
+			logDecision(mp, "skipped: ignored by %s: %s", ignoreFile, reason)
+			continue
+		}
+		if class, skip := classify(mp); skip {
+			logDecision(mp, "skipped: a %s module", class)
+			continue
+		}
+		mods = append(mods, mp)
+	}
+	return mods, nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// ClickHouse sink, appending a row per source file of every scan, for SQL over the long history of the scans:
+//  go run . -d ./platform -publish
+// with the table from the config:
+//  {"sinks": {"clickhouse": {"url": "https://clickhouse.example.com:8443", "database": "docs", "table": "files", "user": "jet-search"}}}
+// The password is the clickhouse-password secret, see secrets.go. The table has to exist, i.e
+//  CREATE TABLE docs.files (scan_time DateTime, dir String, module String, package String, pkg_dir String,
+//    file String, ext LowCardinality(String), documented Bool, api LowCardinality(String))
+//  ENGINE = MergeTree ORDER BY (scan_time, pkg_dir, file)
+// The rows are streamed gzipped over the HTTP interface in a single INSERT, so it is all or nothing.
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+func init() {
+	sinkTypes["clickhouse"] = newClickHouseSink
+}
+
+// clickHouseConfig is the table to append to.
+type clickHouseConfig struct {
+	URL      string `json:"url"` // of the HTTP interface
+	Database string `json:"database"`
+	Table    string `json:"table"`
+	User     string `json:"user"`
+}
+
+type clickHouseClient struct {
+	clickHouseConfig
+	password string
+	http     *http.Client
+}
+
+func newClickHouseSink(config json.RawMessage) (sink, error) {
+	var c clickHouseConfig
+	if err := json.Unmarshal(config, &c); err != nil {
+		return nil, err
+	}
+	if c.URL == "" || c.Table == "" {
+		return nil, fmt.Errorf("url and table are required")
+	}
+	if c.Database == "" {
+		c.Database = "default"
+	}
+	password, err := secret("clickhouse-password")
+	if err != nil {
+		return nil, err
+	}
+	return &clickHouseClient{clickHouseConfig: c, password: password, http: &http.Client{Timeout: 10 * time.Minute}}, nil
+}
+
+// writeClickHouseRows writes the files of the scan as JSONEachRow, see fileRow.
+func writeClickHouseRows(w io.Writer, s *snapshot) error {
+	enc := json.NewEncoder(w)
+	for _, p := range sortedPackages(s.pkgs) {
+		pr := newPackageRow(s, p)
+		for _, f := range p.files {
+			if err := enc.Encode(newFileRow(pr, f)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *clickHouseClient) publish(s *snapshot, findings []finding) error {
+	r, w := io.Pipe()
+	go func() {
+		gz := gzip.NewWriter(w)
+		err := writeClickHouseRows(gz, s)
+		if err == nil {
+			err = gz.Close()
+		}
+		w.CloseWithError(err)
+	}()
+
+	query := url.Values{
+		"query":                  {fmt.Sprintf("INSERT INTO %s.%s FORMAT JSONEachRow", c.Database, c.Table)},
+		"date_time_input_format": {"best_effort"}, // for the RFC 3339 scan time
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(c.URL, "/")+"/?"+query.Encode(), r)
+	if err != nil {
+		r.Close()
+		return err
+	}
+	req.Header.Set("Content-Encoding", "gzip")
+	if c.User != "" {
+		req.Header.Set("X-ClickHouse-User", c.User)
+		req.Header.Set("X-ClickHouse-Key", c.password)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("INSERT INTO %s.%s: %s: %s", c.Database, c.Table, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
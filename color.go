@@ -0,0 +1,77 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Colors of the default table in a terminal, to tell the undocumented and the oversized packages at a glance:
+//  go run . -d ./platform -color always | less -R
+// The documentation is green for package-info.java, yellow for package.html and red if missing, the files and
+// the code lines are red over the packageLimits of the config, see large.go, and the debt markers yellow if any.
+// With -color auto, the default, only a terminal is colored, and NO_COLOR or TERM=dumb turn the colors off.
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var colorModes = []string{"auto", "always", "never"}
+
+func checkColorMode(mode string) error {
+	if !contains(colorModes, mode) {
+		return fmt.Errorf("unknown color mode %q, expected one of %s", mode, strings.Join(colorModes, ", "))
+	}
+	return nil
+}
+
+const (
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorReset  = "\x1b[0m"
+)
+
+// colorized tells if the default table written to w is colored, by -color.
+func colorized(w io.Writer) bool {
+	switch *colorFlag {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// paint wraps the text in the color, unless the color or the text is "".
+func paint(color, s string) string {
+	if color == "" || s == "" {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// docColor is the color of the documentation status of the package.
+func docColor(p *pkg) string {
+	switch {
+	case p.isDocumented():
+		return colorGreen
+	case p.isLegacyDoc():
+		return colorYellow
+	}
+	return colorRed
+}
+
+// overColor is red if the value is over the limit, 0 being no limit.
+func overColor(value, limit int) string {
+	if limit > 0 && value > limit {
+		return colorRed
+	}
+	return ""
+}
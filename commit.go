@@ -0,0 +1,74 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Commit of the scanned checkout, so the readers know which revision the stats describe:
+//  go run . -d ./platform -commit -md
+// prints the SHA of `git rev-parse HEAD` above the table, and
+//  go run . -d ./platform -links pinned -md
+// links the files at that commit rather than at the head of the default branch, so the links do not rot
+// as the code moves. With repos in the config, each of them is linked at its own HEAD.
+// The snapshots keep the commit, so the convert of a snapshot links at it as well.
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// scanCommit is the commit of the scanned dir, empty unless -commit or -links pinned is set.
+var scanCommit string
+
+var linkModes = []string{"head", "pinned"}
+
+func checkLinkMode(mode string) error {
+	if !contains(linkModes, mode) {
+		return fmt.Errorf("unknown link mode %q, expected one of %s", mode, strings.Join(linkModes, ", "))
+	}
+	return nil
+}
+
+// headCommit returns the SHA of the commit checked out in the dir.
+func headCommit(dir string) (string, error) {
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			return "", fmt.Errorf("git rev-parse HEAD in %q: %v: %s", dir, err, strings.TrimSpace(string(ee.Stderr)))
+		}
+		return "", fmt.Errorf("git rev-parse HEAD in %q: %v", dir, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// resolveCommits gets the commits of the scanned dir and of the repos of the config, to link the files at.
+// Either all of them are linked at their commits or none is.
+func resolveCommits(dir string) error {
+	commit, err := headCommit(dir)
+	if err != nil {
+		return err
+	}
+	repoCommits := make([]string, len(cfg.Repos))
+	for i, r := range cfg.Repos {
+		if repoCommits[i], err = headCommit(r.Dir); err != nil {
+			return err
+		}
+	}
+	scanCommit = commit
+	for i := range cfg.Repos {
+		cfg.Repos[i].commit = repoCommits[i]
+	}
+	return nil
+}
+
+// fprintCommit prints the commit of the scan above a table in the format selected by the flags, if there is one.
+func fprintCommit(w io.Writer) {
+	if scanCommit == "" {
+		return
+	}
+	switch {
+	case *mdFlag:
+		fmt.Fprintf(w, "commit: `%s`\n\n", scanCommit)
+	case *gsFlag:
+		fmt.Fprintf(w, "commit\t%s\n", scanCommit)
+	}
+}
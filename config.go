@@ -0,0 +1,196 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Every flag of every command can be set, in order of precedence, by
+//  1. the command line                   -max-scan-age 1h
+//  2. an environment variable            JET_SEARCH_MAX_SCAN_AGE=1h
+//  3. the "flags" of a config file       {"flags": {"max-scan-age": "1h"}}
+// The config file is given by -config or JET_SEARCH_CONFIG, defaulting to ./.jet-search.json if it exists.
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	envPrefix     = "JET_SEARCH_"
+	defaultConfig = ".jet-search.json"
+)
+
+// config is a JSON config file.
+type config struct {
+	Flags map[string]interface{} `json:"flags"` // flag name -> value, for any command that has it
+	Rules []rule                 `json:"rules"` // custom inspections
+
+	PackagePrefixes   []string `json:"packagePrefixes"`   // allowed package name prefixes, see namingFindings
+	SmallPackageFiles *int     `json:"smallPackageFiles"` // max source files of a consolidation candidate, 1 by default
+
+	PackageLimits packageLimits `json:"packageLimits"` // of a refactoring candidate, see largePackages
+	DocRules      []docRule     `json:"docRules"`      // what counts as documentation, package-info.java by default, see docrules.go
+
+	Email      emailConfig                `json:"email"`      // for digests
+	Milestones []milestone                `json:"milestones"` // doc review deadlines
+	Sinks      map[string]json.RawMessage `json:"sinks"`      // sink name -> its config, see sinkTypes
+
+	Vault vaultConfig `json:"vault"` // secrets of the integrations, see secrets.go
+
+	Repos []repo `json:"repos"` // overlaid checkouts, to link to the right remote
+
+	ModuleClasses []moduleClass `json:"moduleClasses"` // test, sample, deprecated, etc. modules, see newModuleClassifier
+
+	Checks map[string]string `json:"checks"` // check name -> severity: error, warning or info, see failedCheck
+	FailOn []string          `json:"failOn"` // severities that fail the checks, error by default
+}
+
+// cfg is the config file read by parseFlags, empty if there is none.
+var cfg = &config{}
+
+// parseFlags parses the command line, setting the rest of the flags from the environment and the config file.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	configPath := fs.String("config", "", "JSON config file, "+defaultConfig+" by default (flags there are overridden by "+envPrefix+"* env vars and the command line)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	if !set["config"] {
+		*configPath = os.Getenv(envVar("config"))
+	}
+	c, err := readConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	cfg = c
+
+	var errs []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || f.Name == "config" {
+			return
+		}
+		value, ok := os.LookupEnv(envVar(f.Name))
+		if !ok {
+			v, inConfig := cfg.Flags[f.Name]
+			if !inConfig {
+				return
+			}
+			var err error
+			if value, err = configFlagValue(v); err != nil {
+				errs = append(errs, fmt.Sprintf("-%s: %v", f.Name, err))
+				return
+			}
+		}
+		if err := fs.Set(f.Name, value); err != nil {
+			errs = append(errs, fmt.Sprintf("-%s=%q: %v", f.Name, value, err))
+		}
+	})
+	// -offline holds for every subcommand, the ones without the flag too, see offline.go
+	if fs.Lookup("offline") == nil {
+		value, ok := os.LookupEnv(envVar("offline"))
+		if v, inConfig := cfg.Flags["offline"]; !ok && inConfig {
+			var err error
+			if value, err = configFlagValue(v); err != nil {
+				errs = append(errs, fmt.Sprintf("-offline: %v", err))
+			}
+			ok = err == nil
+		}
+		if ok {
+			if err := flag.CommandLine.Set("offline", value); err != nil {
+				errs = append(errs, fmt.Sprintf("-offline=%q: %v", value, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid flag values from the environment or config: %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// configFlagValue formats a flag value of the config as on the command line: the numbers without an exponent,
+// i.e 1000000 for -j, and the arrays comma-separated, i.e ["internal", "impl"] for -api.
+func configFlagValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []interface{}:
+		values := make([]string, len(v))
+		for i, e := range v {
+			switch e.(type) {
+			case []interface{}, map[string]interface{}:
+				return "", fmt.Errorf("nested %T in an array", e)
+			}
+			value, err := configFlagValue(e)
+			if err != nil {
+				return "", err
+			}
+			values[i] = value
+		}
+		return strings.Join(values, ","), nil
+	case map[string]interface{}:
+		return "", fmt.Errorf("an object is not a flag value")
+	case nil:
+		return "", fmt.Errorf("null is not a flag value")
+	}
+	return fmt.Sprint(v), nil
+}
+
+// parseArgs is parseFlags that also takes the flags after the arguments, as in convert scan.json --to md,
+// returning the arguments.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	if err := parseFlags(fs, args); err != nil {
+		return nil, err
+	}
+	var rest []string
+	for fs.NArg() > 0 {
+		rest = append(rest, fs.Arg(0))
+		if err := fs.Parse(fs.Args()[1:]); err != nil {
+			return nil, err
+		}
+	}
+	return rest, nil
+}
+
+// envVar returns the environment variable name for a flag, i.e JET_SEARCH_MIN_JAVA for -min-java
+func envVar(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// readConfig reads the given config file or the default one, if it exists.
+func readConfig(path string) (*config, error) {
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfig
+	}
+
+	blob, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return &config{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading config %q: %v", path, err)
+	}
+
+	var c config
+	if err := json.Unmarshal(blob, &c); err != nil {
+		return nil, fmt.Errorf("error parsing config %q: %v", path, err)
+	}
+	used := append([]string{}, c.FailOn...)
+	for _, s := range c.Checks {
+		used = append(used, s)
+	}
+	for _, s := range used {
+		if !knownSeverity(s) {
+			return nil, fmt.Errorf("error in config %q: unknown severity %q, expected one of %s", path, s, strings.Join(severities, ", "))
+		}
+	}
+	for _, r := range c.Rules {
+		if _, err := ruleLevel(r.Severity); err != nil {
+			return nil, fmt.Errorf("error in config %q: rule %q: %v", path, r.Name, err)
+		}
+	}
+	return &c, nil
+}
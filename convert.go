@@ -0,0 +1,125 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Convert formats the packages of an existing scan, so the cheap formatting does not need the expensive scan again:
+//  go run . -d ./platform -snapshot scan.json
+//  go run . convert scan.json --to md
+//  go run . convert scan.json --to xlsx -o packages.xlsx
+// The scan is a snapshot file (JSON or protobuf), an index, a namespace URL of a server,
+// or the JSON array of the packages printed with -json.
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var convertFormats = []string{"tsv", "md", "gs", "json", "jsonl", "csv", "xlsx", "parquet"}
+
+func runConvert(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	to := fs.String("to", "tsv", "output format: "+strings.Join(convertFormats, "|"))
+	out := fs.String("o", "", "file to write, stdout by default")
+	fs.BoolVar(docCoverageFlag, "doc-coverage", false, "add columns with the share of the public top-level types with a doc comment and if there is package-info.java")
+	fs.BoolVar(readmeFlag, "readme", false, "add columns with the README of the package dir and of the module dir, if the scan found them")
+	fs.BoolVar(locFlag, "loc", false, "add columns with the code, comment and blank lines, if the scan counted them")
+	fs.BoolVar(debtMarkersFlag, "debt-markers", false, "add a column with the number of TODO, FIXME and XXX markers, if the scan counted them")
+	fs.StringVar(linkStyleFlag, "link-style", "space", "link the files at Space, at the GitHub mirror, or not at all: "+strings.Join(linkStyles, "|"))
+	fs.StringVar(linkBaseFlag, "link-base", "", "URL of the scanned tree to link the files at, instead of the one of -link-style, i.e of a fork")
+	fs.StringVar(linksFlag, "links", "head", "link the files at the head of the default branch, or pinned to the commit of the scan, if it has one: "+strings.Join(linkModes, "|"))
+	fs.BoolVar(offlineFlag, "offline", false, "disable all the network access, for the snapshot files only")
+	fs.Var(extValue{}, "ext", extUsage)
+	fs.StringVar(apiFlag, "api", "", "comma-separated API classes of the packages to convert, all by default: "+strings.Join(apiClasses, ","))
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s convert <snapshot> [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	srcs, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(srcs) != 1 {
+		fs.Usage()
+		return nil
+	}
+	src := srcs[0]
+	if !contains(convertFormats, *to) {
+		return fmt.Errorf("unknown format %q, expected one of %s", *to, strings.Join(convertFormats, ", "))
+	}
+	if err := checkLinkMode(*linksFlag); err != nil {
+		return err
+	}
+	if err := checkLinkStyle(*linkStyleFlag); err != nil {
+		return err
+	}
+
+	s, err := loadScan(src)
+	if err != nil {
+		return err
+	}
+	if s.pkgs, err = filterAPI(s.pkgs); err != nil {
+		return err
+	}
+	scanCommit = s.commit
+	if *linksFlag == "pinned" && s.commit == "" {
+		fmt.Fprintf(os.Stderr, "linking the files at the default branch: %s has no commit, scan with -commit or -links pinned\n", src)
+	}
+
+	if *out != "" {
+		return writeFile(*out, func(w io.Writer) error { return convert(w, s, *to) })
+	}
+	return convert(os.Stdout, s, *to)
+}
+
+// convert writes the packages of the scan in the format.
+func convert(w io.Writer, s *snapshot, format string) error {
+	switch format {
+	case "csv":
+		return writePackagesCSV(w, s.pkgs)
+	case "xlsx":
+		return writeXLSX(w, packagesTable(s.pkgs))
+	case "parquet":
+		return writeParquet(w, packagesParquetColumns(s), len(s.pkgs))
+	}
+
+	was := [4]bool{*mdFlag, *gsFlag, *jsonFlag, *jsonlFlag}
+	defer func() { *mdFlag, *gsFlag, *jsonFlag, *jsonlFlag = was[0], was[1], was[2], was[3] }()
+	*mdFlag, *gsFlag, *jsonFlag, *jsonlFlag = format == "md", format == "gs", format == "json", format == "jsonl"
+	printPackages(w, s.pkgs, nil)
+	return nil
+}
+
+// loadScan reads the packages of a scan, from a snapshot or from the -json output.
+func loadScan(src string) (*snapshot, error) {
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") || isProtobuf(src) {
+		return loadSnapshot(src)
+	}
+	blob, err := os.ReadFile(src)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(blob), []byte("[")) {
+		return readSnapshot(src)
+	}
+
+	var list []*pkg
+	if err := json.Unmarshal(blob, &list); err != nil {
+		return nil, fmt.Errorf("error parsing packages %q: %v", src, err)
+	}
+	pkgs := make(map[string]*pkg, len(list))
+	for _, p := range list {
+		if p == nil || p.pkgDir == "" {
+			return nil, fmt.Errorf("error parsing packages %q: a package without pkgDir", src)
+		}
+		pkgs[p.pkgDir] = p
+	}
+	fi, err := os.Stat(src)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot{time: fi.ModTime().UTC(), pkgs: pkgs, modules: summarizeModules(pkgs)}, nil
+}
@@ -0,0 +1,313 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Distributed scan: the coordinator hands out batches of the modules to the worker processes over HTTP
+// and assembles their snapshots into one, for the nightly scans of the large repos on a fleet of workers:
+//  JET_SEARCH_COORDINATOR_TOKEN=s3cret go run . coordinate -d ./platform -batch 20 -o platform.json
+//  JET_SEARCH_COORDINATOR_TOKEN=s3cret go run . work -coordinator http://coordinator:8090   # on every worker
+// The workers scan the same checkout at the same path as the coordinator, i.e a shared volume or the same CI layout.
+//  POST /api/batches/lease  leases a batch: {"id": 3, "modules": [...]}, 204 if all are leased, 410 once all are done
+//  PUT  /api/batches/<id>   the snapshot of the batch, as pushed by -push
+//  GET  /api/batches        the progress
+// A batch not done within -lease is leased again, to another worker, so a crashed worker only delays the scan.
+// Once all the batches are done, the snapshot is merged, written to -o and the coordinator exits.
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// coordinator leases the batches of the modules and collects their snapshots.
+type coordinator struct {
+	dir      string
+	leaseFor time.Duration
+
+	mu      sync.Mutex
+	batches [][]string
+	leased  map[int]time.Time // batch -> until when
+	done    map[int]*snapshot
+	all     chan struct{} // closed once all the batches are done
+}
+
+func newCoordinator(dir string, modulesPaths []string, batchSize int, leaseFor time.Duration) *coordinator {
+	c := &coordinator{dir: dir, leaseFor: leaseFor, leased: map[int]time.Time{}, done: map[int]*snapshot{}, all: make(chan struct{})}
+	for start := 0; start < len(modulesPaths); start += batchSize {
+		end := start + batchSize
+		if end > len(modulesPaths) {
+			end = len(modulesPaths)
+		}
+		c.batches = append(c.batches, modulesPaths[start:end])
+	}
+	if len(c.batches) == 0 {
+		close(c.all)
+	}
+	return c
+}
+
+// batchLease is a batch leased to a worker.
+type batchLease struct {
+	ID      int      `json:"id"`
+	Dir     string   `json:"dir"`
+	Modules []string `json:"modules"`
+}
+
+var (
+	errAllLeased = errors.New("all the batches are leased")
+	errAllDone   = errors.New("all the batches are done")
+)
+
+// lease returns a batch that is neither done nor leased, or one with an expired lease.
+func (c *coordinator) lease(now time.Time) (*batchLease, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.done) == len(c.batches) {
+		return nil, errAllDone
+	}
+	for id, modules := range c.batches {
+		if _, ok := c.done[id]; ok {
+			continue
+		}
+		if until, ok := c.leased[id]; ok && now.Before(until) {
+			continue
+		}
+		c.leased[id] = now.Add(c.leaseFor)
+		return &batchLease{id, c.dir, modules}, nil
+	}
+	return nil, errAllLeased
+}
+
+// complete stores the snapshot of the batch, the first one wins if it was leased twice.
+func (c *coordinator) complete(id int, s *snapshot) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if id < 0 || id >= len(c.batches) {
+		return fmt.Errorf("no batch %d", id)
+	}
+	if _, ok := c.done[id]; ok {
+		return nil
+	}
+	c.done[id] = s
+	delete(c.leased, id)
+	if len(c.done) == len(c.batches) {
+		close(c.all)
+	}
+	return nil
+}
+
+// merged returns the snapshot of all the done batches.
+func (c *coordinator) merged() (*snapshot, []string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	snapshots, names := []*snapshot{}, []string{}
+	for id := range c.batches {
+		if s, ok := c.done[id]; ok {
+			snapshots, names = append(snapshots, s), append(names, "batch "+strconv.Itoa(id))
+		}
+	}
+	if len(snapshots) == 0 {
+		return &snapshot{dir: c.dir, time: time.Now().UTC(), pkgs: map[string]*pkg{}, modules: map[string]*moduleSummary{}, publicTypes: true}, nil, nil
+	}
+	merged, conflicts, err := mergeSnapshots(snapshots, names, "first") // only the source roots shared by the modules
+	if err == nil {
+		merged.dir = c.dir
+	}
+	return merged, conflicts, err
+}
+
+func (c *coordinator) handleLease(w http.ResponseWriter, r *http.Request, token string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorized(w, r, token) {
+		return
+	}
+	b, err := c.lease(time.Now())
+	switch err {
+	case nil:
+		writeJSON(w, http.StatusOK, b)
+	case errAllLeased:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, err.Error(), http.StatusGone)
+	}
+}
+
+func (c *coordinator) handleBatch(w http.ResponseWriter, r *http.Request, token string) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/batches")
+	if rest == "" || rest == "/" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		c.mu.Lock()
+		progress := map[string]int{"batches": len(c.batches), "done": len(c.done), "leased": len(c.leased)}
+		c.mu.Unlock()
+		writeJSON(w, http.StatusOK, progress)
+		return
+	}
+	if r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorized(w, r, token) {
+		return
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(rest, "/"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("bad batch %q", rest), http.StatusNotFound)
+		return
+	}
+	s, ok := readUploadedSnapshot(w, r)
+	if !ok {
+		return
+	}
+	if err := c.complete(id, s); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]int{"id": id, "packages": len(s.pkgs)})
+}
+
+func (c *coordinator) mux(token string) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/batches/lease", func(w http.ResponseWriter, r *http.Request) { c.handleLease(w, r, token) })
+	mux.HandleFunc("/api/batches", func(w http.ResponseWriter, r *http.Request) { c.handleBatch(w, r, token) })
+	mux.HandleFunc("/api/batches/", func(w http.ResponseWriter, r *http.Request) { c.handleBatch(w, r, token) })
+	return mux
+}
+
+func runCoordinate(args []string) error {
+	fs := flag.NewFlagSet("coordinate", flag.ExitOnError)
+	dir := fs.String("d", "", "dir to scan for packages, at the same path on the workers")
+	addr := fs.String("addr", ":8090", "address to listen on for the workers")
+	out := fs.String("o", "", "snapshot file to write once all the batches are done, protobuf if it ends with .pb")
+	batchSize := fs.Int("batch", 20, "number of modules in a batch")
+	leaseFor := fs.Duration("lease", 10*time.Minute, "time for a worker to scan a batch, before it is leased to another one")
+	testFramework := fs.Bool("test-framework", false, "include testFramework modules")
+	fs.BoolVar(offlineFlag, "offline", false, "disable all the network access, failing as the workers need it")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *dir == "" || *out == "" || *batchSize < 1 {
+		fs.Usage()
+		return nil
+	}
+	if *offlineFlag {
+		return fmt.Errorf("coordinating the workers needs network, disabled by -offline")
+	}
+
+	modulesPaths, err := findModules(osFS{}, *dir, *testFramework)
+	if err != nil {
+		return err
+	}
+	token, err := secret("coordinator-token")
+	if err != nil {
+		return err
+	}
+	c := newCoordinator(*dir, modulesPaths, *batchSize, *leaseFor)
+	server := &http.Server{Addr: *addr, Handler: c.mux(token)}
+	served := make(chan error, 1)
+	go func() { served <- server.ListenAndServe() }()
+	fmt.Fprintf(os.Stderr, "coordinating %d batches of the %d modules of %q on %s\n", len(c.batches), len(modulesPaths), *dir, *addr)
+
+	select {
+	case err := <-served:
+		return err
+	case <-c.all:
+	}
+	merged, conflicts, err := c.merged()
+	if err != nil {
+		return err
+	}
+	for _, conflict := range conflicts {
+		fmt.Fprintln(os.Stderr, conflict)
+	}
+	if err := saveSnapshot(*out, merged); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "scanned %d packages in %d batches to %s\n", len(merged.pkgs), len(c.batches), *out)
+	time.Sleep(time.Second) // for the last worker to get the response and then 410
+	return server.Close()
+}
+
+func runWork(args []string) error {
+	fs := flag.NewFlagSet("work", flag.ExitOnError)
+	coordinatorURL := fs.String("coordinator", "", "URL of the coordinator, i.e http://coordinator:8090")
+	poll := fs.Duration("poll", 5*time.Second, "time to wait for a batch while all are leased")
+	fs.BoolVar(offlineFlag, "offline", false, "disable all the network access, failing as the coordinator needs it")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *coordinatorURL == "" {
+		fs.Usage()
+		return nil
+	}
+	if *offlineFlag {
+		return fmt.Errorf("leasing the batches of %s needs network, disabled by -offline", *coordinatorURL)
+	}
+	token, err := secret("coordinator-token")
+	if err != nil {
+		return err
+	}
+	n, err := work(strings.TrimSuffix(*coordinatorURL, "/"), token, *poll)
+	fmt.Fprintf(os.Stderr, "scanned %d batches\n", n)
+	return err
+}
+
+// work scans the batches leased from the coordinator until all are done, returning the number of the scanned ones.
+func work(coordinatorURL, token string, poll time.Duration) (int, error) {
+	client := &http.Client{Timeout: time.Minute}
+	scanned := 0
+	for {
+		req, err := http.NewRequest(http.MethodPost, coordinatorURL+"/api/batches/lease", nil)
+		if err != nil {
+			return scanned, err
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return scanned, err
+		}
+		var b batchLease
+		switch resp.StatusCode {
+		case http.StatusOK:
+			err = json.NewDecoder(resp.Body).Decode(&b)
+			resp.Body.Close()
+		case http.StatusNoContent:
+			resp.Body.Close()
+			time.Sleep(poll)
+			continue
+		case http.StatusGone:
+			resp.Body.Close()
+			return scanned, nil
+		default:
+			resp.Body.Close()
+			return scanned, fmt.Errorf("POST %s/api/batches/lease: %s", coordinatorURL, resp.Status)
+		}
+		if err != nil {
+			return scanned, err
+		}
+
+		pkgs, err := scanModules(osFS{}, b.Modules, countSize, hashContent)
+		if err != nil {
+			return scanned, fmt.Errorf("scanning batch %d: %v", b.ID, err)
+		}
+		s := newSnapshot(b.Dir, pkgs)
+		s.publicTypes = true
+		if err := pushSnapshot(fmt.Sprintf("%s/api/batches/%d", coordinatorURL, b.ID), token, s); err != nil {
+			return scanned, err
+		}
+		scanned++
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Documentation coverage, by one of the metrics:
+//  packages      share of packages with package-info.java
+//  api-weighted  share of top-level public types in such packages,
+//                so a large undocumented package counts more than a small one
+//  loc-weighted  share of lines of code in such packages, see countLOC
+
+import (
+	"fmt"
+	"strings"
+)
+
+var coverageMetrics = []string{"packages", "api-weighted", "loc-weighted"}
+
+// isDocumented checks if the package has package-info.java, or the doc of the docRules of the config,
+// legacy package.html does not count.
+func (p *pkg) isDocumented() bool {
+	return p.doc != "" && !p.isLegacyDoc()
+}
+
+// isLegacyDoc checks if the package is documented in package.html only.
+func (p *pkg) isLegacyDoc() bool {
+	return strings.HasSuffix(p.doc, ".html")
+}
+
+func checkCoverageMetric(metric string) error {
+	for _, m := range coverageMetrics {
+		if m == metric {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown coverage metric %q, expected one of %s", metric, strings.Join(coverageMetrics, ", "))
+}
+
+// coverageVisitors returns the analyses needed to compute the coverage by the metric.
+func coverageVisitors(metric string) []visitor {
+	switch metric {
+	case "api-weighted":
+		return []visitor{countSize}
+	case "loc-weighted":
+		return []visitor{countLOC}
+	}
+	return nil
+}
+
+// docCoverage returns the documented and total weight of the packages by the metric.
+func docCoverage(pkgs map[string]*pkg, metric string) (documented, total int) {
+	for _, p := range pkgs {
+		weight := 1
+		switch metric {
+		case "api-weighted":
+			weight = p.publicTypes
+		case "loc-weighted":
+			weight = p.codeLines
+		}
+
+		total += weight
+		if p.isDocumented() {
+			documented += weight
+		}
+	}
+	return documented, total
+}
+
+// typeDocCoverage returns the share of the public types with a doc comment, i.e 67% (2/3), or - if there are none.
+func (p *pkg) typeDocCoverage() string {
+	if p.publicTypes == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.0f%% (%d/%d)", percent(p.documentedTypes, p.publicTypes), p.documentedTypes, p.publicTypes)
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// percent returns the share in %, 0 for an empty total.
+func percent(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(part) / float64(total)
+}
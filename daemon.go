@@ -0,0 +1,378 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Daemon keeps the scanned packages warm in memory on a developer machine,
+// so that repeated CLI commands do not re-walk the tree:
+//  go run . daemon -d ./platform &
+//  go run . search concurrency
+//  go run . explain com.intellij.util.concurrency
+// CLI commands talk to it over a unix socket: a request is a JSON array of the command and its arguments,
+// a response is the plain text output of the command.
+// The same socket controls the daemon, for scripting and health checks:
+//  go run . ctl status|rescan|flush-cache|dump-profile <name>
+// The socket is in the per-user runtime dir by default, $XDG_RUNTIME_DIR or jet-search-<uid> in the temp dir,
+// private to the user, and the heap profiles are written under it, see dumpProfile.
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var defaultSocket = filepath.Join(runtimeDir(), "jet-search.sock")
+
+// runtimeDir is the per-user dir of the socket and the profiles, not to be reached or squatted by the other users.
+func runtimeDir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("jet-search-%d", os.Getuid()))
+}
+
+// privateDir creates the dir with 0700 if it does not exist, and checks that it is the user's and no one else has access to it.
+func privateDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() || fi.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("%s is not a private dir, want the mode drwx------, got %v", dir, fi.Mode())
+	}
+	if !ownedByUser(fi) {
+		return fmt.Errorf("%s is not a private dir, owned by another user", dir)
+	}
+	return nil
+}
+
+// daemon holds the packages of a single scan.
+type daemon struct {
+	dir           string
+	testFramework bool
+	started       time.Time
+	events        *hub     // of the scans, pushed to the WebSocket clients of serve mode
+	origins       []string // of the web UIs of the other hosts, allowed to connect to the events, see -ws-origins
+
+	scanMu sync.Mutex // one scan at a time
+
+	mu      sync.RWMutex
+	pkgs    map[string]*pkg // replaced on every scan, never updated
+	scanned time.Time
+	scanErr error // of the last scan
+	feed    []feedEntry
+}
+
+// daemonCommands are served by the daemon, writing the output to w.
+var daemonCommands = map[string]func(d *daemon, w io.Writer, args []string) error{
+	"search":  (*daemon).search,
+	"explain": (*daemon).explain,
+
+	// control
+	"status":       (*daemon).status,
+	"rescan":       (*daemon).rescanCmd,
+	"flush-cache":  (*daemon).flushCache,
+	"dump-profile": (*daemon).dumpProfile,
+}
+
+func runDaemon(args []string) error {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	dir := fs.String("d", "", "dir to scan for packages")
+	socket := fs.String("socket", defaultSocket, "unix socket to listen on")
+	testFramework := fs.Bool("test-framework", false, "include testFramework modules")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		fs.Usage()
+		return nil
+	}
+
+	d := newDaemon(*dir, *testFramework)
+	if err := d.rescan(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "serving %d packages of %q on %s\n", len(d.pkgs), *dir, *socket)
+	return d.listen(*socket)
+}
+
+func newDaemon(dir string, testFramework bool) *daemon {
+	return &daemon{dir: dir, testFramework: testFramework, started: time.Now(), events: newHub()}
+}
+
+// listen serves the commands on the unix socket, until it fails.
+func (d *daemon) listen(socket string) error {
+	l, err := listenUnix(socket)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go d.serve(conn)
+	}
+}
+
+// listenUnix listens on the socket, replacing the one left by a previous daemon, but not a live one or another file.
+// The dir of the default socket is created private, see runtimeDir.
+func listenUnix(socket string) (net.Listener, error) {
+	if filepath.Dir(socket) == runtimeDir() {
+		if err := privateDir(runtimeDir()); err != nil {
+			return nil, err
+		}
+	}
+	if fi, err := os.Lstat(socket); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", socket)
+		}
+		if conn, err := net.DialTimeout("unix", socket, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("a daemon is already listening on %s", socket)
+		}
+		if err := os.Remove(socket); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", socket)
+}
+
+// rescan walks the dir, replacing the packages in memory.
+func (d *daemon) rescan() error {
+	d.scanMu.Lock()
+	defer d.scanMu.Unlock()
+	return d.scan()
+}
+
+// scan must be called under the scanMu.
+func (d *daemon) scan() error {
+	finished := d.scanEvents()
+	pkgs, err := scanDir(d.dir, d.testFramework, d.events.progressVisitor())
+
+	d.mu.Lock()
+	prev := d.pkgs
+	if err == nil {
+		d.updateFeed(prev, pkgs, time.Now())
+		d.pkgs, d.scanned = pkgs, time.Now()
+	}
+	d.scanErr = err
+	d.mu.Unlock()
+
+	finished(prev, pkgs, err)
+	return err
+}
+
+// packages returns the packages of the last scan, scanning again if the cache was flushed.
+func (d *daemon) packages() (map[string]*pkg, error) {
+	d.mu.RLock()
+	pkgs := d.pkgs
+	d.mu.RUnlock()
+	if pkgs != nil {
+		return pkgs, nil
+	}
+
+	d.scanMu.Lock()
+	defer d.scanMu.Unlock()
+	d.mu.RLock()
+	pkgs = d.pkgs // scanned while waiting
+	d.mu.RUnlock()
+	if pkgs != nil {
+		return pkgs, nil
+	}
+	if err := d.scan(); err != nil {
+		return nil, err
+	}
+	return d.pkgs, nil
+}
+
+// serve handles a single request per connection.
+func (d *daemon) serve(conn net.Conn) {
+	defer conn.Close()
+
+	var req []string
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err == nil {
+		err = json.Unmarshal(line, &req)
+	}
+	if err != nil || len(req) == 0 {
+		fmt.Fprintf(conn, "bad request %q: %v\n", line, err)
+		return
+	}
+
+	cmd, ok := daemonCommands[req[0]]
+	if !ok {
+		fmt.Fprintf(conn, "unknown command %q\n", req[0])
+		return
+	}
+
+	if err := cmd(d, conn, req[1:]); err != nil {
+		fmt.Fprintln(conn, err)
+	}
+}
+
+// search lists packages which names match the query, see searchPackages.
+func (d *daemon) search(w io.Writer, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: search <query>")
+	}
+
+	pkgs, err := d.packages()
+	if err != nil {
+		return err
+	}
+	printSearch(w, searchPackages(pkgs, args[0]))
+	return nil
+}
+
+// explain prints everything known about the packages with the given name, as it can be split between modules.
+func (d *daemon) explain(w io.Writer, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: explain <package>")
+	}
+
+	pkgs, err := d.packages()
+	if err != nil {
+		return err
+	}
+
+	var found []*pkg
+	for _, p := range pkgs {
+		if p.name == args[0] {
+			found = append(found, p)
+		}
+	}
+	if len(found) == 0 {
+		return fmt.Errorf("no package %q in %q", args[0], d.dir)
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].pkgDir < found[j].pkgDir })
+	for _, p := range found {
+		fmt.Fprintf(w, "package:\t%s\nmodule:\t%s\nsource root:\t%s\ndir:\t%s\nlink:\t%s\n", p.name, p.module, p.srcDir, p.pkgDir, link(p.pkgDir))
+		if r, _ := repoOf(p.pkgDir); r != nil {
+			fmt.Fprintf(w, "repo:\t%s\n", r.Name)
+		}
+		counts := make([]string, len(sourceExts))
+		for i, ext := range sourceExts {
+			counts[i] = fmt.Sprintf("%s %d", ext, p.filesCnt[ext])
+		}
+		fmt.Fprintf(w, "documentation:\t%s\nfiles:\t%d (%s)\n\n", p.doc, len(p.files), strings.Join(counts, ", "))
+	}
+	return nil
+}
+
+// status prints the scanned dir, the number of packages and the age of the scan.
+func (d *daemon) status(w io.Writer, args []string) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	fmt.Fprintf(w, "dir:\t%s\nuptime:\t%s\npackages:\t%d\n", d.dir, time.Since(d.started).Round(time.Second), len(d.pkgs))
+	if d.pkgs == nil {
+		fmt.Fprintln(w, "scanned:\tnever, the cache was flushed")
+	} else {
+		fmt.Fprintf(w, "scanned:\t%s (%s ago)\n", d.scanned.Format(time.RFC3339), time.Since(d.scanned).Round(time.Second))
+	}
+	return nil
+}
+
+func (d *daemon) rescanCmd(w io.Writer, args []string) error {
+	if err := d.rescan(); err != nil {
+		return err
+	}
+	return d.status(w, nil)
+}
+
+// flushCache drops the packages in memory, so the next command scans again.
+func (d *daemon) flushCache(w io.Writer, args []string) error {
+	d.mu.Lock()
+	d.pkgs = nil
+	d.mu.Unlock()
+	runtime.GC()
+
+	fmt.Fprintln(w, "flushed")
+	return nil
+}
+
+// dumpProfile writes a heap profile, to be read by `go tool pprof`, to the file of the given name in the profiles
+// dir of runtimeDir, not to let the clients of the socket write anywhere the daemon can.
+func (d *daemon) dumpProfile(w io.Writer, args []string) error {
+	if len(args) != 1 || args[0] != filepath.Base(args[0]) || args[0] == "." || args[0] == ".." {
+		return errors.New("usage: dump-profile <name>, of a file in the profiles dir")
+	}
+	dir := filepath.Join(runtimeDir(), "profiles")
+	if err := privateDir(dir); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, args[0])
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	runtime.GC() // up-to-date heap statistics
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "heap profile written to %s\n", path)
+	return nil
+}
+
+// runCtl sends a control command to the daemon.
+func runCtl(args []string) error {
+	fs := flag.NewFlagSet("ctl", flag.ExitOnError)
+	socket := fs.String("socket", defaultSocket, "unix socket of the daemon")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("usage: ctl [-socket path] status|rescan|flush-cache|dump-profile <name>")
+	}
+	return callDaemon(*socket, os.Stdout, fs.Args())
+}
+
+// daemonClient returns a command that sends itself to the daemon, printing the response.
+func daemonClient(name string) func(args []string) error {
+	return func(args []string) error {
+		fs := flag.NewFlagSet(name, flag.ExitOnError)
+		socket := fs.String("socket", defaultSocket, "unix socket of the daemon")
+		if err := parseFlags(fs, args); err != nil {
+			return err
+		}
+		return callDaemon(*socket, os.Stdout, append([]string{name}, fs.Args()...))
+	}
+}
+
+// callDaemon sends a request to the daemon, copying the response to w.
+func callDaemon(socket string, w io.Writer, req []string) error {
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return fmt.Errorf("no daemon at %s, start one with `daemon -d <dir>`: %v", socket, err)
+	}
+	defer conn.Close()
+
+	blob, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(append(blob, '\n')); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, conn)
+	return err
+}
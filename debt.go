@@ -0,0 +1,32 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Debt markers are the TODO, FIXME and XXX occurrences in the package sources, counted with -debt-markers
+// and printed in an extra column for the tech-debt dashboards:
+//  go run . -d ./platform -debt-markers -gs
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+)
+
+var debtMarker = regexp.MustCompile(`\b(TODO|FIXME|XXX)\b`)
+
+// countDebtMarkers updates .debtMarkers with the ones of a source file.
+func countDebtMarkers(p *pkg, f *sourceFile) error {
+	if !f.isSource() {
+		return nil
+	}
+	content, err := f.content()
+	if err != nil {
+		return err
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(content))
+	s.Buffer(nil, 1024*1024)
+	for s.Scan() {
+		p.debtMarkers += len(debtMarker.FindAllIndex(s.Bytes(), -1))
+	}
+	return s.Err()
+}
@@ -0,0 +1,59 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// The decision log records the heuristics that changed what got scanned, i.e. a module skipped or parsed
+// in safe mode, so that surprising numbers in a report can be traced back to them:
+//  go run . -d ./platform -decision-log decisions.tsv
+// Every line is the path of a module or a dir and the decision about it, tab-separated.
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+)
+
+var (
+	decisionMu  sync.Mutex
+	decisionLog io.Writer = io.Discard // set by -decision-log
+)
+
+// logDecision records a decision about the path.
+func logDecision(path, format string, args ...interface{}) {
+	decisionMu.Lock()
+	defer decisionMu.Unlock()
+	fmt.Fprintf(decisionLog, "%s\t%s\n", path, fmt.Sprintf(format, args...))
+}
+
+var (
+	sourceFolderTag = regexp.MustCompile(`<sourceFolder\b[^>]*>`)
+	xmlAttr         = regexp.MustCompile(`([\w-]+)\s*=\s*"([^"]*)"`)
+)
+
+// parseModuleSafeMode extracts the source folders from a broken .iml line by line,
+// which is enough to scan the module, while the rest of the settings are lost.
+func parseModuleSafeMode(blob []byte) *module {
+	var root contentRoot
+	for _, tag := range sourceFolderTag.FindAll(blob, -1) {
+		var sd srcDir
+		for _, a := range xmlAttr.FindAllSubmatch(tag, -1) {
+			switch v := string(a[2]); string(a[1]) {
+			case "url":
+				sd.Url = v
+			case "isTestSource":
+				sd.IsTest = v == "true"
+			case "generated":
+				sd.Generated = v == "true"
+			case "type":
+				sd.Type = v
+			}
+		}
+		if sd.Url != "" {
+			root.SourceFolders = append(root.SourceFolders, sd)
+		}
+	}
+	if len(root.SourceFolders) == 0 {
+		return nil
+	}
+	return &module{Components: []component{{Name: "NewModuleRootManager", ContentRoots: []contentRoot{root}}}}
+}
@@ -0,0 +1,335 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Diffs of the documentation between branches, i.e to verify the doc fixes in master were cherry-picked to a release:
+//  go run . diff master.json https://jet-search.internal/api/snapshots/idea/241
+//  GET /api/diff?base=idea/master&head=idea/241
+// By default, only the packages documented in the base but not in the head are listed, -all lists every difference,
+// including the packages with other sources if both snapshots have the content hashes, see hash.go.
+// Packages that became public in the head are always listed if undocumented, and fail the diff with -fail
+// as they must be documented before a release. Telling them needs the public types, counted in the snapshots of
+// -snapshot, -push and the release reports, but not in the older ones.
+// Each side is a snapshot file, written by -snapshot, or a namespace URL of a server.
+// Packages are matched by their dir relative to the scanned one, as branches are usually checked out to different dirs.
+// A package absent in the head is renamed to one absent in the base if they have the same content hash, or else
+// the same file names and no other package absent in the base has them; it is listed once, at its head dir.
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// docState is how a package is on a branch: documented, undocumented or absent.
+func docState(p *pkg) string {
+	switch {
+	case p == nil:
+		return "absent"
+	case p.isDocumented():
+		return "documented"
+	}
+	return "undocumented"
+}
+
+// branchDiff is a package that differs between the base and the head branches.
+type branchDiff struct {
+	Dir     string `json:"dir"`
+	Package string `json:"package"`
+	Base    string `json:"base"` // docState on the base
+	Head    string `json:"head"`
+
+	NewlyPublic bool   `json:"newlyPublic,omitempty"` // internal or absent in the base, see isPublic
+	Change      string `json:"change,omitempty"`      // of the sources, see contentChange
+	RenamedFrom string `json:"renamedFrom,omitempty"` // dir in the base of a renamed package
+	BaseFiles   int    `json:"baseFiles"`
+	HeadFiles   int    `json:"headFiles"`
+}
+
+// missing is true for a package documented in the base but not in the head.
+func (d branchDiff) missing() bool {
+	return d.Base == "documented" && d.Head != "documented"
+}
+
+// failed is true for a package missing the documentation, or newly public without it.
+func (d branchDiff) failed() bool {
+	return d.missing() || (d.NewlyPublic && d.Head != "documented")
+}
+
+// isPublic checks if the package exports any API: has top-level public types, counted by countSize,
+// and is public or experimental, see apiClass
+func (p *pkg) isPublic() bool {
+	return p != nil && p.publicTypes > 0 && (p.apiClass() == "public" || p.apiClass() == "experimental")
+}
+
+// byRelDir returns the packages of the snapshot by their dir relative to the scanned one.
+func byRelDir(s *snapshot) map[string]*pkg {
+	pkgs := make(map[string]*pkg, len(s.pkgs))
+	for dir, p := range s.pkgs {
+		if rel, err := filepath.Rel(s.dir, dir); err == nil && s.dir != "" {
+			dir = filepath.ToSlash(rel)
+		}
+		pkgs[dir] = p
+	}
+	return pkgs
+}
+
+// diffBranches compares the documentation of the packages, only the missing ones unless all.
+func diffBranches(baseSnap, headSnap *snapshot, all bool) []branchDiff {
+	base, head := byRelDir(baseSnap), byRelDir(headSnap)
+	counted := baseSnap.publicTypes && headSnap.publicTypes
+	renamed := findRenames(base, head) // head dir -> base dir
+	var diffs []branchDiff
+	add := func(dir string, b, h *pkg) {
+		d := branchDiff{Dir: dir, Base: docState(b), Head: docState(h)}
+		if b != nil {
+			d.Package, d.BaseFiles = b.name, len(b.files)
+		}
+		if h != nil {
+			d.Package, d.HeadFiles = h.name, len(h.files)
+		}
+		d.NewlyPublic = counted && h.isPublic() && !b.isPublic()
+		d.Change = contentChange(b, h)
+		d.RenamedFrom = renamed[dir]
+		if (d.Base != d.Head || d.NewlyPublic || d.Change != "" || d.RenamedFrom != "") && (all || d.failed()) {
+			diffs = append(diffs, d)
+		}
+	}
+	renamedFrom := map[string]bool{}
+	for _, from := range renamed {
+		renamedFrom[from] = true
+	}
+	for dir, b := range base {
+		if !renamedFrom[dir] {
+			add(dir, b, head[dir])
+		}
+	}
+	for dir, h := range head {
+		if _, ok := base[dir]; !ok {
+			add(dir, base[renamed[dir]], h)
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Dir < diffs[j].Dir })
+	return diffs
+}
+
+// findRenames pairs the packages absent in the head with the ones absent in the base by the content hash,
+// or else by the file names if no other package absent in the base has the same, returning the head dir -> base dir.
+func findRenames(base, head map[string]*pkg) map[string]string {
+	var removed, added []string
+	for dir := range base {
+		if head[dir] == nil {
+			removed = append(removed, dir)
+		}
+	}
+	for dir := range head {
+		if base[dir] == nil {
+			added = append(added, dir)
+		}
+	}
+	sort.Strings(removed)
+	sort.Strings(added)
+
+	renamed := map[string]string{}
+	for _, from := range removed {
+		b := base[from]
+		var byHash, byFiles []string
+		for _, to := range added {
+			h := head[to]
+			if _, taken := renamed[to]; taken || len(h.files) == 0 {
+				continue
+			}
+			if b.contentHash != "" && b.contentHash == h.contentHash {
+				byHash = append(byHash, to)
+			} else if sameFiles(b.files, h.files) {
+				byFiles = append(byFiles, to)
+			}
+		}
+		switch {
+		case len(byHash) > 0:
+			renamed[byHash[0]] = from
+		case len(byFiles) == 1:
+			renamed[byFiles[0]] = from
+		}
+	}
+	return renamed
+}
+
+func runDiff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	all := fs.Bool("all", false, "list all the differences, not only the packages documented in the base but not in the head")
+	fail := fs.Bool("fail", false, "exit with non-zero code if any package documented in the base is not in the head, or a newly public one is undocumented")
+	addFormatFlags(fs)
+	fs.BoolVar(jsonFlag, "json", false, "format output as JSON")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "usage: diff [flags] <base snapshot file or URL> <head snapshot file or URL>")
+		fs.PrintDefaults()
+		return nil
+	}
+
+	base, err := loadSnapshot(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	head, err := loadSnapshot(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	diffs := diffBranches(base, head, *all)
+	if *jsonFlag {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(diffs); err != nil {
+			return err
+		}
+	} else {
+		printBranchDiffs(diffs, branchLabel(fs.Arg(0)), branchLabel(fs.Arg(1)))
+	}
+
+	missing, public := 0, 0
+	for _, d := range diffs {
+		if d.missing() {
+			missing++
+		} else if d.failed() {
+			public++
+		}
+	}
+	if *all {
+		fmt.Fprintln(os.Stderr, diffStats(diffs))
+	}
+	fmt.Fprintf(os.Stderr, "%d packages documented in %s are not in %s, %d newly public packages are undocumented\n", missing, fs.Arg(0), fs.Arg(1), public)
+	if err := failedCheck("diff", *fail, missing); err != nil {
+		return err
+	}
+	return failedCheck("undocumented-new", *fail, public)
+}
+
+func printBranchDiffs(diffs []branchDiff, base, head string) {
+	printHeader([]string{"package", "dir", base, head, "newly public", "change", "files", "renamed from"})
+	sep := "\t"
+	if *mdFlag {
+		sep = " | "
+	}
+	for _, d := range diffs {
+		public := ""
+		if d.NewlyPublic {
+			public = "yes"
+		}
+		files := ""
+		if d.BaseFiles != d.HeadFiles {
+			files = fmt.Sprintf("%d -> %d", d.BaseFiles, d.HeadFiles)
+		}
+		fmt.Println(strings.Join([]string{d.Package, d.Dir, d.Base, d.Head, public, d.Change, files, d.RenamedFrom}, sep))
+	}
+}
+
+// diffStats counts the packages by the kind of the difference, a package counted in every kind it has.
+func diffStats(diffs []branchDiff) string {
+	var added, removed, renamed, docs, files int
+	for _, d := range diffs {
+		switch {
+		case d.RenamedFrom != "":
+			renamed++
+		case d.Base == "absent":
+			added++
+		case d.Head == "absent":
+			removed++
+		}
+		if d.Base != d.Head && d.Base != "absent" && d.Head != "absent" {
+			docs++
+		}
+		if d.BaseFiles != d.HeadFiles && d.Base != "absent" && d.Head != "absent" {
+			files++
+		}
+	}
+	return fmt.Sprintf("%d packages added, %d removed, %d renamed, %d with the documentation changed, %d with the number of files changed",
+		added, removed, renamed, docs, files)
+}
+
+// branchLabel is the name of a side of the diff for the header, i.e idea/241 for a namespace URL.
+func branchLabel(src string) string {
+	if i := strings.Index(src, "/api/snapshots/"); i >= 0 {
+		return src[i+len("/api/snapshots/"):]
+	}
+	return strings.TrimSuffix(src, ".json")
+}
+
+// loadSnapshot reads a snapshot file, or the packages of a namespace URL with the dir from the list of namespaces.
+func loadSnapshot(src string) (*snapshot, error) {
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		return readSnapshot(src)
+	}
+	i := strings.Index(src, "/api/snapshots/")
+	if i < 0 {
+		return nil, fmt.Errorf("bad snapshot URL %q, want https://host/api/snapshots/<product>/<branch>", src)
+	}
+	name := strings.Trim(src[i+len("/api/snapshots/"):], "/")
+
+	var list []namespaceSummary
+	if err := getJSON(src[:i]+"/api/snapshots", &list); err != nil {
+		return nil, err
+	}
+	var pkgsList []*pkg
+	if err := getJSON(src, &pkgsList); err != nil {
+		return nil, err
+	}
+
+	s := &snapshot{pkgs: make(map[string]*pkg, len(pkgsList))}
+	for _, ns := range list {
+		if ns.Namespace == name {
+			s.dir = ns.Dir
+			s.time, _ = time.Parse(time.RFC3339, ns.Time)
+			s.publicTypes = ns.PublicTypes
+		}
+	}
+	for _, p := range pkgsList {
+		s.pkgs[p.pkgDir] = p
+	}
+	return s, nil
+}
+
+func getJSON(url string, v interface{}) error {
+	resp, err := (&http.Client{Timeout: 5 * time.Minute}).Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("error parsing %q: %v", url, err)
+	}
+	return nil
+}
+
+// handleDiff compares the snapshots of two namespaces, given by the base and head params.
+func (ns *namespaces) handleDiff(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var snaps [2]*snapshot
+	for i, param := range []string{"base", "head"} {
+		name := q.Get(param)
+		if !validNamespace(name) {
+			http.Error(w, fmt.Sprintf("bad %s namespace %q, want product/branch", param, name), http.StatusBadRequest)
+			return
+		}
+		if snaps[i] = ns.get(name); snaps[i] == nil {
+			http.Error(w, fmt.Sprintf("no snapshot in %q", name), http.StatusNotFound)
+			return
+		}
+	}
+	diffs := diffBranches(snaps[0], snaps[1], q.Get("all") == "true")
+	if diffs == nil {
+		diffs = []branchDiff{}
+	}
+	writeJSON(w, http.StatusOK, diffs)
+}
@@ -0,0 +1,208 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Email digest of the weekly changes from the history, for the teams that do not follow the chat channels:
+//  go run . digest -history history.jsonl -since 168h
+// sending a message per module group from the config:
+//  {"email": {
+//    "smtp": "smtp.example.com:587", "from": "jet-search@example.com", "username": "jet-search",
+//    "groups": [{"name": "Core", "modules": "platform/core*/**", "recipients": ["core-team@example.com"]}]
+//  }}
+// The SMTP password is the smtp-password secret, see secrets.go. With -chart, the message has the chart of the group
+// coverage over the whole history attached, see timeseries.go.
+
+import (
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+// emailConfig is where and whom to send the digests.
+type emailConfig struct {
+	SMTP     string       `json:"smtp"` // host:port
+	From     string       `json:"from"`
+	Username string       `json:"username"`
+	Groups   []emailGroup `json:"groups"`
+}
+
+// emailGroup is a set of modules, given by a glob over .iml paths, and the recipients of their digest.
+type emailGroup struct {
+	Name       string   `json:"name"`
+	Modules    string   `json:"modules"`
+	Recipients []string `json:"recipients"`
+}
+
+func runDigest(args []string) error {
+	fs := flag.NewFlagSet("digest", flag.ExitOnError)
+	historyPath := fs.String("history", "", "history file, written by -history")
+	since := fs.Duration("since", 7*24*time.Hour, "compare the latest scan with the one that is that older")
+	dryRun := fs.Bool("dry-run", false, "print the messages instead of sending them")
+	chart := fs.Bool("chart", false, "attach a PNG chart of the coverage of the group modules over the whole history")
+	namespace := fs.String("namespace", "", "product/branch of the uploaded snapshots to compare, the local scans by default")
+	fs.BoolVar(offlineFlag, "offline", false, "disable all the network access, for -dry-run only")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *historyPath == "" || len(cfg.Email.Groups) == 0 {
+		fs.Usage()
+		return fmt.Errorf("a history file and email groups in the config are required")
+	}
+	if *offlineFlag && !*dryRun {
+		return fmt.Errorf("sending the digest needs network, disabled by -offline, use -dry-run")
+	}
+
+	records, err := readHistory(*historyPath)
+	if err != nil {
+		return err
+	}
+	from, to := historyRange(inNamespace(records, *namespace), *since)
+	if from == nil {
+		return fmt.Errorf("no scans in %q", *historyPath)
+	}
+
+	for _, g := range cfg.Email.Groups {
+		body, err := digestBody(g, from, to)
+		if err != nil {
+			return err
+		}
+		subject := fmt.Sprintf("jet-search digest for %s: %s - %s", g.Name, from.Time.Format("2006-01-02"), to.Time.Format("2006-01-02"))
+		var attachments []emailAttachment
+		if *chart {
+			scope, _ := globToRegexp(g.Modules) // checked by digestBody
+			data, err := chartPNG(inNamespace(records, *namespace), scope)
+			if err != nil {
+				return err
+			}
+			attachments = append(attachments, emailAttachment{name: "coverage.png", contentType: "image/png", data: data})
+		}
+		if *dryRun {
+			fmt.Printf("To: %s\nSubject: %s\n\n%s\n", strings.Join(g.Recipients, ", "), subject, body)
+			for _, a := range attachments {
+				fmt.Printf("Attached: %s, %d bytes\n", a.name, len(a.data))
+			}
+			continue
+		}
+		if err := sendEmail(cfg.Email, g.Recipients, subject, body, attachments...); err != nil {
+			return fmt.Errorf("error sending the digest for %q: %v", g.Name, err)
+		}
+	}
+	return nil
+}
+
+// digestBody summarizes the changes of the group modules between the two scans.
+func digestBody(g emailGroup, from, to *historyRecord) (string, error) {
+	scope, err := globToRegexp(g.Modules)
+	if err != nil {
+		return "", fmt.Errorf("group %q: bad modules %q: %v", g.Name, g.Modules, err)
+	}
+
+	var mods []string
+	for _, r := range []*historyRecord{from, to} {
+		for m := range r.Modules {
+			if scope.MatchString(m) && (r == from || from.Modules[m] == nil) {
+				mods = append(mods, m)
+			}
+		}
+	}
+	sort.Strings(mods)
+
+	var b strings.Builder
+	var total [2]moduleSummary
+	fmt.Fprintf(&b, "Documented packages in %s, %s to %s\n\n", g.Name, from.Time.Format("2006-01-02"), to.Time.Format("2006-01-02"))
+	for _, m := range mods {
+		was, now := from.Modules[m], to.Modules[m]
+		if was == nil {
+			was = &moduleSummary{}
+		}
+		if now == nil {
+			now = &moduleSummary{}
+		}
+		total[0].Packages, total[0].Documented = total[0].Packages+was.Packages, total[0].Documented+was.Documented
+		total[1].Packages, total[1].Documented = total[1].Packages+now.Packages, total[1].Documented+now.Documented
+		if *was == *now {
+			continue
+		}
+		fmt.Fprintf(&b, "  %s: %d/%d -> %d/%d packages documented, %+d files\n", m, was.Documented, was.Packages, now.Documented, now.Packages, now.Files-was.Files)
+	}
+	fmt.Fprintf(&b, "\nTotal: %.1f%% -> %.1f%% of %d packages documented\n",
+		percent(total[0].Documented, total[0].Packages), percent(total[1].Documented, total[1].Packages), total[1].Packages)
+	return b.String(), nil
+}
+
+// emailAttachment is a file attached to the digest, i.e the chart of the group.
+type emailAttachment struct {
+	name, contentType string
+	data              []byte
+}
+
+func sendEmail(c emailConfig, to []string, subject, body string, attachments ...emailAttachment) error {
+	if *offlineFlag {
+		return fmt.Errorf("smtp %s: %w", c.SMTP, errOffline)
+	}
+	host, _, err := net.SplitHostPort(c.SMTP)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if c.Username != "" {
+		password, err := secret("smtp-password")
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", c.Username, password, host)
+	}
+
+	msg, err := emailMessage(c.From, to, subject, body, attachments)
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(c.SMTP, auth, c.From, to, msg)
+}
+
+// emailMessage formats the message, in plain text, or in multipart/mixed with the attachments encoded in base64.
+func emailMessage(from string, to []string, subject, body string, attachments []emailAttachment) ([]byte, error) {
+	body = strings.ReplaceAll(body, "\n", "\r\n")
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\nTo: %s\r\nSubject: %s\r\n", from, strings.Join(to, ", "), subject)
+	if len(attachments) == 0 {
+		fmt.Fprintf(&b, "Content-Type: text/plain; charset=UTF-8\r\n\r\n%s", body)
+		return b.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(&b)
+	fmt.Fprintf(&b, "MIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+	part, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=UTF-8"}})
+	if err != nil {
+		return nil, err
+	}
+	io.WriteString(part, body)
+	for _, a := range attachments {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {a.contentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {fmt.Sprintf("attachment; filename=%q", a.name)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(a.data)
+		for len(encoded) > 76 {
+			io.WriteString(part, encoded[:76]+"\r\n")
+			encoded = encoded[76:]
+		}
+		io.WriteString(part, encoded+"\r\n")
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,153 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// What counts as the documentation of a package, package-info.java by default, or by the rules of the config,
+// as the teams of the Kotlin and of the Java packages have agreed on different standards:
+//  {"docRules": [
+//    {"language": "kotlin", "files": ["package.md", "package-info.java"], "sections": ["# Package"], "minLength": 200},
+//    {"language": "java", "files": ["package-info.java"], "minLength": 40}
+//  ]}
+// The language of a package is the one of the most of its sources, java on a tie, and the first rule of it, or of
+// no language, applies. The doc is the first file of the package dir of a name matching the files globs that has all
+// the sections, i.e a heading or @since, and at least minLength characters of the text, without the comment markers,
+// the package statement and the imports. Without one, package.html is still the legacy doc, and otherwise it is missing.
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// docRule tells what counts as the documentation of the packages of a language.
+type docRule struct {
+	Language  string   `json:"language"`  // java, kotlin, scala or groovy, "" for any
+	Files     []string `json:"files"`     // globs of the doc file names, i.e package.md
+	Sections  []string `json:"sections"`  // text the doc must have
+	MinLength int      `json:"minLength"` // characters of the text of the doc
+}
+
+// languages of the sources by extension, for the docRules
+var languages = map[string]string{".java": "java", ".kt": "kotlin", ".scala": "scala", ".groovy": "groovy"}
+
+// language returns the language of the most of the package sources, of sourceExts order on a tie.
+func (p *pkg) language() string {
+	lang, most := "", 0
+	for _, ext := range sourceExts {
+		if n := p.filesCnt[ext]; n > most {
+			lang, most = languages[ext], n
+			if lang == "" { // i.e ts with -ext .ts
+				lang = strings.TrimPrefix(ext, ".")
+			}
+		}
+	}
+	return lang
+}
+
+// isDocFile tells if the file is a doc of any of the rules, or the legacy package.html.
+func isDocFile(rules []docRule, name string) bool {
+	if name == "package.html" {
+		return true
+	}
+	for _, r := range rules {
+		if matchesAny(r.Files, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDocRules validates the globs of the rules.
+func checkDocRules(rules []docRule) error {
+	for i, r := range rules {
+		if len(r.Files) == 0 {
+			return fmt.Errorf("doc rule %d: files are required", i)
+		}
+		for _, glob := range r.Files {
+			if _, err := path.Match(glob, ""); err != nil {
+				return fmt.Errorf("doc rule %d: bad files glob %q: %v", i, glob, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ruleOf returns the rule of the language, nil if there is none.
+func ruleOf(rules []docRule, lang string) *docRule {
+	for i, r := range rules {
+		if r.Language == "" || r.Language == lang {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// applyDocRules sets .doc of the packages from .docFiles, found by findDoc, by the rules of their language.
+func applyDocRules(fsys fs.FS, pkgs map[string]*pkg, rules []docRule) error {
+	if err := checkDocRules(rules); err != nil {
+		return err
+	}
+	for _, p := range pkgs {
+		p.doc = ""
+		r := ruleOf(rules, p.language())
+		for _, file := range p.docFiles {
+			name := path.Base(file)
+			if name == "package.html" {
+				if p.doc == "" {
+					p.doc = file
+				}
+				continue
+			}
+			if r == nil || !matchesAny(r.Files, name) {
+				continue
+			}
+			content, err := fs.ReadFile(fsys, file)
+			if err != nil {
+				return err
+			}
+			if r.satisfiedBy(docText(string(content))) {
+				p.doc = file
+				break
+			}
+		}
+		p.docFiles = nil
+	}
+	return nil
+}
+
+func matchesAny(globs []string, name string) bool {
+	for _, glob := range globs {
+		if ok, _ := path.Match(glob, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *docRule) satisfiedBy(text string) bool {
+	for _, s := range r.Sections {
+		if !strings.Contains(text, s) {
+			return false
+		}
+	}
+	return utf8.RuneCountInString(text) >= r.MinLength
+}
+
+// docText returns the text of a doc file, without the comment markers, the package statement and the imports.
+func docText(content string) string {
+	var lines []string
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "package ") || strings.HasPrefix(line, "import ") {
+			continue
+		}
+		for _, marker := range []string{"/**", "/*", "*/", "//", "*"} {
+			line = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(line, marker), "*/"))
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,212 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Duplicate files, identical source files in several packages, are copy-pasted utilities to move to a shared module:
+//  go run . report duplicates -d ./platform -min-bytes 512
+// The contents are hashed during the scan, most wasted bytes first.
+// Near-copies of whole packages, that have mostly the same file names, are listed in pairs by
+//  go run . report similar-packages -d ./platform -min-similarity 0.8
+
+import (
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// duplicates indexes the source files by the hash of their content.
+type duplicates struct {
+	mu     sync.Mutex
+	byHash map[[sha256.Size]byte][]duplicateFile
+}
+
+type duplicateFile struct {
+	pkg  *pkg
+	path string
+	size int64
+}
+
+// visit is a visitor hashing every source file.
+func (d *duplicates) visit(p *pkg, f *sourceFile) error {
+	if !f.isSource() || f.size == 0 {
+		return nil
+	}
+	content, err := f.content()
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(content)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.byHash == nil {
+		d.byHash = map[[sha256.Size]byte][]duplicateFile{}
+	}
+	d.byHash[hash] = append(d.byHash[hash], duplicateFile{p, f.path, f.size})
+	return nil
+}
+
+// groups returns the files with the same content in more than one package, of at least minBytes,
+// the most wasted bytes first.
+func (d *duplicates) groups(minBytes int64) [][]duplicateFile {
+	var groups [][]duplicateFile
+	for _, files := range d.byHash {
+		if files[0].size < minBytes {
+			continue
+		}
+		pkgs := map[string]bool{}
+		for _, f := range files {
+			pkgs[f.pkg.pkgDir] = true
+		}
+		if len(pkgs) < 2 {
+			continue
+		}
+		sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+		groups = append(groups, files)
+	}
+	wasted := func(g []duplicateFile) int64 { return g[0].size * int64(len(g)-1) }
+	sort.Slice(groups, func(i, j int) bool {
+		if wasted(groups[i]) != wasted(groups[j]) {
+			return wasted(groups[i]) > wasted(groups[j])
+		}
+		return groups[i][0].path < groups[j][0].path
+	})
+	return groups
+}
+
+func reportDuplicates(args []string) error {
+	fs := flag.NewFlagSet("report duplicates", flag.ExitOnError)
+	dir := fs.String("d", "", "dir to scan for packages")
+	minBytes := fs.Int64("min-bytes", 1, "size of the smallest duplicate to report")
+	testFramework := fs.Bool("test-framework", false, "include testFramework modules")
+	addFormatFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		fs.Usage()
+		return nil
+	}
+
+	var dupes duplicates
+	if _, err := scanDir(*dir, *testFramework, dupes.visit); err != nil {
+		return err
+	}
+
+	sep := "\t"
+	if *mdFlag {
+		sep = " | "
+	}
+	groups := dupes.groups(*minBytes)
+	printHeader([]string{"group", "bytes", "module", "package", "path"})
+	for i, g := range groups {
+		for _, f := range g {
+			fmt.Println(strings.Join([]string{fmt.Sprint(i + 1), fmt.Sprint(f.size), f.pkg.module, f.pkg.name, f.path}, sep))
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%d groups of identical files in different packages\n", len(groups))
+	return nil
+}
+
+// similarPackages is a pair of packages in different modules with mostly the same file names, near-copies
+// that are common after module splits.
+type similarPackages struct {
+	a, b       *pkg
+	shared     int
+	similarity float64 // Jaccard index of the file names
+}
+
+// maxNameOccurrences is how many packages can have a file name for it to still tell the packages apart,
+// as Utils.java or Bundle.kt are everywhere.
+const maxNameOccurrences = 50
+
+// findSimilarPackages returns the pairs of packages in different modules, with at least minFiles source files
+// each and file names at least that similar, the most similar first.
+func findSimilarPackages(pkgs map[string]*pkg, minFiles int, minSimilarity float64) []similarPackages {
+	list := sortedPackages(pkgs)
+	names := make([]map[string]bool, len(list))
+	byName := map[string][]int{}
+	for i, p := range list {
+		names[i] = map[string]bool{}
+		for _, f := range p.files {
+			if f != "package-info.java" {
+				names[i][f] = true
+			}
+		}
+		if len(names[i]) < minFiles {
+			continue
+		}
+		for f := range names[i] {
+			byName[f] = append(byName[f], i)
+		}
+	}
+
+	var pairs []similarPackages
+	for i, p := range list {
+		if len(names[i]) < minFiles {
+			continue
+		}
+		shared := map[int]int{}
+		for f := range names[i] {
+			if others := byName[f]; len(others) <= maxNameOccurrences {
+				for _, j := range others {
+					if j > i && list[j].module != p.module {
+						shared[j]++
+					}
+				}
+			}
+		}
+		for j, n := range shared {
+			similarity := float64(n) / float64(len(names[i])+len(names[j])-n)
+			if similarity >= minSimilarity {
+				pairs = append(pairs, similarPackages{p, list[j], n, similarity})
+			}
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].similarity != pairs[j].similarity {
+			return pairs[i].similarity > pairs[j].similarity
+		}
+		if pairs[i].a.pkgDir != pairs[j].a.pkgDir {
+			return pairs[i].a.pkgDir < pairs[j].a.pkgDir
+		}
+		return pairs[i].b.pkgDir < pairs[j].b.pkgDir
+	})
+	return pairs
+}
+
+func reportSimilarPackages(args []string) error {
+	fs := flag.NewFlagSet("report similar-packages", flag.ExitOnError)
+	dir := fs.String("d", "", "dir to scan for packages")
+	minFiles := fs.Int("min-files", 2, "number of source files of the smallest package to compare")
+	minSimilarity := fs.Float64("min-similarity", 0.8, "share of the same file names, from 0 to 1")
+	testFramework := fs.Bool("test-framework", false, "include testFramework modules")
+	addFormatFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		fs.Usage()
+		return nil
+	}
+
+	pkgs, err := scanDir(*dir, *testFramework)
+	if err != nil {
+		return err
+	}
+
+	sep := "\t"
+	if *mdFlag {
+		sep = " | "
+	}
+	pairs := findSimilarPackages(pkgs, *minFiles, *minSimilarity)
+	printHeader([]string{"similarity", "same files", "package", "module", "similar package", "module"})
+	for _, s := range pairs {
+		fmt.Println(strings.Join([]string{fmt.Sprintf("%.2f", s.similarity), fmt.Sprint(s.shared), s.a.pkgDir, s.a.module, s.b.pkgDir, s.b.module}, sep))
+	}
+	fmt.Fprintf(os.Stderr, "%d pairs of similar packages in different modules, candidates to consolidate\n", len(pairs))
+	return nil
+}
@@ -0,0 +1,203 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Elasticsearch/OpenSearch sink, indexing a document per package and per source file of every scan:
+//  go run . -d ./platform -publish
+// into the <index>-packages and <index>-files indices from the config:
+//  {"sinks": {"elasticsearch": {"url": "https://search.example.com:9200", "index": "jet-search", "user": "jet-search"}}}
+// The password is the elasticsearch-password secret, i.e the JET_SEARCH_ELASTICSEARCH_PASSWORD env var, see secrets.go.
+// The indices are created with the mappings below, if they do not exist, so the dashboards can rely on the field types.
+// Documents have IDs by the scan time and the path, so a retried publish does not duplicate them.
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// elasticBulkSize is the number of documents per bulk request.
+const elasticBulkSize = 1000
+
+func init() {
+	sinkTypes["elasticsearch"] = newElasticSink
+}
+
+// elasticMappings are the mappings of the indices, by the index suffix.
+var elasticMappings = map[string]string{
+	"packages": `{"mappings": {"properties": {
+  "scan_time": {"type": "date"}, "dir": {"type": "keyword"}, "module": {"type": "keyword"},
+  "package": {"type": "keyword", "fields": {"text": {"type": "text"}}}, "pkg_dir": {"type": "keyword"},
+  "doc": {"type": "keyword"}, "documented": {"type": "boolean"}, "api": {"type": "keyword"},
+  "files": {"type": "integer"}, "java_files": {"type": "integer"}, "kt_files": {"type": "integer"}
+}}}`,
+	"files": `{"mappings": {"properties": {
+  "scan_time": {"type": "date"}, "dir": {"type": "keyword"}, "module": {"type": "keyword"},
+  "package": {"type": "keyword"}, "pkg_dir": {"type": "keyword"}, "file": {"type": "keyword"}, "ext": {"type": "keyword"},
+  "documented": {"type": "boolean"}, "api": {"type": "keyword"}
+}}}`,
+}
+
+// elasticConfig is the cluster and the prefix of the indices.
+type elasticConfig struct {
+	URL   string `json:"url"`
+	Index string `json:"index"`
+	User  string `json:"user"`
+}
+
+type elasticClient struct {
+	elasticConfig
+	password string
+	http     *http.Client
+}
+
+func newElasticSink(config json.RawMessage) (sink, error) {
+	var c elasticConfig
+	if err := json.Unmarshal(config, &c); err != nil {
+		return nil, err
+	}
+	if c.URL == "" || c.Index == "" {
+		return nil, fmt.Errorf("url and index are required")
+	}
+	password, err := secret("elasticsearch-password")
+	if err != nil {
+		return nil, err
+	}
+	return &elasticClient{elasticConfig: c, password: password, http: &http.Client{Timeout: time.Minute}}, nil
+}
+
+// elasticDoc is a document to index, by its ID.
+type elasticDoc struct {
+	id     string
+	source interface{}
+}
+
+func elasticID(parts ...string) string {
+	h := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(h[:16])
+}
+
+func (e *elasticClient) publish(s *snapshot, findings []finding) error {
+	var pkgDocs, fileDocs []elasticDoc
+	for _, p := range sortedPackages(s.pkgs) {
+		pr := newPackageRow(s, p)
+		pkgDocs = append(pkgDocs, elasticDoc{elasticID(pr.ScanTime, p.pkgDir), pr})
+		for _, f := range p.files {
+			fileDocs = append(fileDocs, elasticDoc{elasticID(pr.ScanTime, p.pkgDir, f), newFileRow(pr, f)})
+		}
+	}
+
+	for _, index := range []struct {
+		suffix string
+		docs   []elasticDoc
+	}{{"packages", pkgDocs}, {"files", fileDocs}} {
+		name := e.Index + "-" + index.suffix
+		if err := e.ensureIndex(name, elasticMappings[index.suffix]); err != nil {
+			return err
+		}
+		for start := 0; start < len(index.docs); start += elasticBulkSize {
+			end := start + elasticBulkSize
+			if end > len(index.docs) {
+				end = len(index.docs)
+			}
+			if err := e.bulk(name, index.docs[start:end]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ensureIndex creates the index with the mappings, if it does not exist.
+func (e *elasticClient) ensureIndex(name, mappings string) error {
+	resp, err := e.call(http.MethodHead, "/"+name, "", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+	case resp.StatusCode/100 == 2:
+		return nil
+	default:
+		return fmt.Errorf("HEAD /%s: %s", name, resp.Status)
+	}
+	return e.do(http.MethodPut, "/"+name, "application/json", strings.NewReader(mappings), nil)
+}
+
+// bulk indexes the documents, failing if any of them failed.
+func (e *elasticClient) bulk(index string, docs []elasticDoc) error {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	for _, d := range docs {
+		enc.Encode(map[string]interface{}{"index": map[string]string{"_index": index, "_id": d.id}})
+		if err := enc.Encode(d.source); err != nil {
+			return err
+		}
+	}
+
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			ID    string `json:"_id"`
+			Error *struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := e.do(http.MethodPost, "/_bulk", "application/x-ndjson", &b, &result); err != nil {
+		return err
+	}
+	if !result.Errors {
+		return nil
+	}
+	failed := 0
+	var first string
+	for _, item := range result.Items {
+		for _, r := range item {
+			if r.Error != nil {
+				if failed == 0 {
+					first = r.Error.Type + ": " + r.Error.Reason
+				}
+				failed++
+			}
+		}
+	}
+	return fmt.Errorf("%d of %d documents not indexed in %s, i.e %s", failed, len(docs), index, first)
+}
+
+func (e *elasticClient) do(method, path, contentType string, body io.Reader, result interface{}) error {
+	resp, err := e.call(method, path, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(msg))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func (e *elasticClient) call(method, path, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, strings.TrimSuffix(e.URL, "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if e.User != "" {
+		req.SetBasicAuth(e.User, e.password)
+	}
+	return e.http.Do(req)
+}
@@ -1,15 +1,22 @@
+import os
 from typing import List
 
 import openai
 from tenacity import retry, stop_after_attempt, wait_random_exponential
 import numpy as np
 
-@retry(wait=wait_random_exponential(min=1, max=20), stop=stop_after_attempt(6))
 def get_embeddings(
     list_of_tokens: List[int], engine="text-embedding-ada-002"
 ) -> List[np.ndarray]: #List[float]
     assert len(list_of_tokens) <= 2048, "The batch size should not be larger than 2048."
+    # checked before the retries, to fail fast
+    if os.environ.get("JET_SEARCH_OFFLINE", "").lower() in ("1", "t", "true"):
+        raise RuntimeError("the OpenAI API is disabled by JET_SEARCH_OFFLINE")
+    return _create_embeddings(list_of_tokens, engine)
+
 
+@retry(wait=wait_random_exponential(min=1, max=20), stop=stop_after_attempt(6))
+def _create_embeddings(list_of_tokens: List[int], engine: str) -> List[np.ndarray]:
     # replace newlines, which accoring to OpenAI can negatively affect performance.
     # list_of_tokens = [text.replace("\n", " ") for text in list_of_tokens]
     ## we did this earlier, during tokenization
@@ -0,0 +1,171 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Live events of serve mode, pushed over a WebSocket at /ws so that a web UI updates without polling:
+//  {"type": "scan-started", "time": "..."}
+//  {"type": "scan-progress", "packages": 500}
+//  {"type": "scan-finished", "packages": 9876, "duration": "12s"}
+//  {"type": "scan-failed", "error": "..."}
+//  {"type": "package-changed", "change": "added|removed|documented|undocumented", "dir": "...", "package": "..."}
+// A client that does not keep up misses events, rather than slowing the scans down. The web UIs of the other
+// hosts need their origins in -ws-origins of serve, see upgradeWebSocket.
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	progressEvery    = 500 // packages between scan-progress events
+	eventsBufferSize = 256 // per client
+)
+
+// event is a message pushed to the clients.
+type event struct {
+	Type     string `json:"type"`
+	Time     string `json:"time,omitempty"`
+	Packages int    `json:"packages,omitempty"`
+	Duration string `json:"duration,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Change   string `json:"change,omitempty"`
+	Dir      string `json:"dir,omitempty"`
+	Package  string `json:"package,omitempty"`
+}
+
+// hub broadcasts the events to the subscribed clients.
+type hub struct {
+	mu      sync.Mutex
+	clients map[chan []byte]bool
+}
+
+func newHub() *hub {
+	return &hub{clients: map[chan []byte]bool{}}
+}
+
+func (h *hub) subscribe() chan []byte {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c := make(chan []byte, eventsBufferSize)
+	h.clients[c] = true
+	return c
+}
+
+func (h *hub) unsubscribe(c chan []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients, c)
+}
+
+// publish sends the event to every client that has room for it.
+func (h *hub) publish(e event) {
+	blob, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients {
+		select {
+		case c <- blob:
+		default: // slow client
+		}
+	}
+}
+
+// progressVisitor publishes a scan-progress event every progressEvery packages.
+func (h *hub) progressVisitor() visitor {
+	var last *pkg
+	n := 0
+	return func(p *pkg, f *sourceFile) error {
+		if p == last {
+			return nil
+		}
+		last = p
+		if n++; n%progressEvery == 0 {
+			h.publish(event{Type: "scan-progress", Packages: n})
+		}
+		return nil
+	}
+}
+
+// packageChanges returns the packages that were added, removed, or gained or lost the documentation.
+func packageChanges(prev, cur map[string]*pkg) []event {
+	var changes []event
+	for dir, p := range cur {
+		old, ok := prev[dir]
+		switch {
+		case !ok:
+			changes = append(changes, event{Change: "added", Dir: dir, Package: p.name})
+		case p.isDocumented() && !old.isDocumented():
+			changes = append(changes, event{Change: "documented", Dir: dir, Package: p.name})
+		case !p.isDocumented() && old.isDocumented():
+			changes = append(changes, event{Change: "undocumented", Dir: dir, Package: p.name})
+		}
+	}
+	for dir, p := range prev {
+		if _, ok := cur[dir]; !ok {
+			changes = append(changes, event{Change: "removed", Dir: dir, Package: p.name})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Dir < changes[j].Dir })
+	for i := range changes {
+		changes[i].Type = "package-changed"
+	}
+	return changes
+}
+
+// handleEvents pushes the events to a WebSocket client, until it goes away.
+func (d *daemon) handleEvents(w http.ResponseWriter, r *http.Request) {
+	ws, err := upgradeWebSocket(w, r, d.origins)
+	if errors.Is(err, errWebSocketOrigin) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer ws.Close()
+
+	events := d.events.subscribe()
+	defer d.events.unsubscribe(events)
+
+	closed := make(chan struct{})
+	go func() {
+		ws.readUntilClose()
+		close(closed)
+	}()
+
+	for {
+		select {
+		case blob := <-events:
+			if err := ws.writeText(blob); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// scanEvents publishes the start of a scan, returning a func to publish its result.
+func (d *daemon) scanEvents() func(prev, cur map[string]*pkg, err error) {
+	started := time.Now()
+	d.events.publish(event{Type: "scan-started", Time: started.UTC().Format(time.RFC3339)})
+	return func(prev, cur map[string]*pkg, err error) {
+		if err != nil {
+			d.events.publish(event{Type: "scan-failed", Error: err.Error()})
+			return
+		}
+		if prev != nil {
+			for _, e := range packageChanges(prev, cur) {
+				d.events.publish(e)
+			}
+		}
+		d.events.publish(event{Type: "scan-finished", Packages: len(cur), Duration: time.Since(started).Round(time.Millisecond).String()})
+	}
+}
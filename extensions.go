@@ -0,0 +1,227 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Catalog of the extension points and services, for the list of them in the SDK docs:
+//  go run . report extension-points -d ./platform -md
+// The extension points and the services are read from the plugin descriptors in the module dirs,
+// i.e resources/META-INF/plugin.xml, and the light services from the @Service annotated classes.
+// Each is listed with the package of its interface or class, and if that package is documented.
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// extensionEntry is an extension point or a service.
+type extensionEntry struct {
+	kind   string // extension point, application service, project service, module service or light service
+	name   string // qualified name of the extension point, or the service class
+	class  string // interface or bean class of the extension point, the service interface
+	module string
+}
+
+// pkgName returns the package of the class, "" for a class without one.
+func (e extensionEntry) pkgName() string {
+	if i := strings.LastIndex(e.class, "."); i > 0 {
+		return e.class[:i]
+	}
+	return ""
+}
+
+// pluginDescriptor is the part of plugin.xml with the extension points and the services.
+type pluginDescriptor struct {
+	XMLName         xml.Name `xml:"idea-plugin"`
+	ID              string   `xml:"id"`
+	ExtensionPoints []struct {
+		Name          string `xml:"name,attr"`
+		QualifiedName string `xml:"qualifiedName,attr"`
+		Interface     string `xml:"interface,attr"`
+		BeanClass     string `xml:"beanClass,attr"`
+	} `xml:"extensionPoints>extensionPoint"`
+	Extensions []struct {
+		Items []struct {
+			XMLName               xml.Name
+			ServiceInterface      string `xml:"serviceInterface,attr"`
+			ServiceImplementation string `xml:"serviceImplementation,attr"`
+		} `xml:",any"`
+	} `xml:"extensions"`
+}
+
+var serviceKinds = map[string]string{
+	"applicationService": "application service",
+	"projectService":     "project service",
+	"moduleService":      "module service",
+}
+
+// descriptorEntries returns the extension points and the services of a plugin descriptor.
+func descriptorEntries(fsys fs.FS, path, module string) ([]extensionEntry, error) {
+	blob, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+	var d pluginDescriptor
+	if err := xml.Unmarshal(blob, &d); err != nil {
+		return nil, fmt.Errorf("error parsing %q: %v", path, err)
+	}
+
+	pluginID := d.ID
+	if pluginID == "" {
+		pluginID = "com.intellij" // the platform descriptors and their fragments have none
+	}
+	var entries []extensionEntry
+	for _, ep := range d.ExtensionPoints {
+		name := ep.QualifiedName
+		if name == "" {
+			name = pluginID + "." + ep.Name
+		}
+		class := ep.Interface
+		if class == "" {
+			class = ep.BeanClass
+		}
+		entries = append(entries, extensionEntry{"extension point", name, class, module})
+	}
+	for _, ext := range d.Extensions {
+		for _, item := range ext.Items {
+			kind, ok := serviceKinds[item.XMLName.Local]
+			if !ok {
+				continue
+			}
+			class := item.ServiceInterface
+			if class == "" {
+				class = item.ServiceImplementation
+			}
+			entries = append(entries, extensionEntry{kind, class, class, module})
+		}
+	}
+	return entries, nil
+}
+
+var (
+	serviceAnnotation = regexp.MustCompile(`^\s*@Service\b`)
+	declaredClass     = regexp.MustCompile(`\b(?:class|object)\s+(\w+)`)
+)
+
+// lightServices collects the @Service annotated classes.
+type lightServices struct {
+	mu      sync.Mutex
+	entries []extensionEntry
+}
+
+// visit is a visitor finding the @Service annotations and the classes they annotate.
+func (ls *lightServices) visit(p *pkg, f *sourceFile) error {
+	if !f.isSource() {
+		return nil
+	}
+	content, err := f.content()
+	if err != nil {
+		return err
+	}
+	if !bytes.Contains(content, []byte("@Service")) {
+		return nil
+	}
+
+	annotated := false
+	s := bufio.NewScanner(bytes.NewReader(content))
+	s.Buffer(nil, 1024*1024)
+	for s.Scan() {
+		line := s.Text()
+		if serviceAnnotation.MatchString(line) {
+			annotated = true
+		}
+		if m := declaredClass.FindStringSubmatch(line); annotated && m != nil {
+			class := p.name + "." + m[1]
+			ls.mu.Lock()
+			ls.entries = append(ls.entries, extensionEntry{"light service", class, class, p.module})
+			ls.mu.Unlock()
+			annotated = false
+		}
+	}
+	return s.Err()
+}
+
+// findExtensions returns the catalog of the modules, sorted by kind and name.
+func findExtensions(fsys fs.FS, modulesPaths []string) ([]extensionEntry, map[string]*pkg, error) {
+	var ls lightServices
+	pkgs, err := scanModules(fsys, modulesPaths, ls.visit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entries := ls.entries
+	err = walkModuleFiles(fsys, modulesPaths, func(module, path string, size int64) error {
+		if filepath.Ext(path) != ".xml" || !isPluginDescriptor(fsys, path) {
+			return nil
+		}
+		found, err := descriptorEntries(fsys, path, module)
+		entries = append(entries, found...)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].kind != entries[j].kind {
+			return entries[i].kind < entries[j].kind
+		}
+		return entries[i].name < entries[j].name
+	})
+	return entries, pkgs, nil
+}
+
+// printExtensions prints the catalog, with the doc status of the package of each entry.
+func printExtensions(entries []extensionEntry, pkgs map[string]*pkg) {
+	documented := map[string]bool{}
+	for _, p := range pkgs {
+		documented[p.name] = documented[p.name] || p.isDocumented()
+	}
+
+	sep := "\t"
+	if *mdFlag {
+		sep = " | "
+	}
+	printHeader([]string{"kind", "name", "class", "package", "module", "documented"})
+	for _, e := range entries {
+		doc := "no"
+		if documented[e.pkgName()] {
+			doc = "yes"
+		}
+		fmt.Println(strings.Join([]string{e.kind, e.name, e.class, e.pkgName(), e.module, doc}, sep))
+	}
+}
+
+func reportExtensionPoints(args []string) error {
+	fs := flag.NewFlagSet("report extension-points", flag.ExitOnError)
+	dir := fs.String("d", "", "dir to scan for modules")
+	testFramework := fs.Bool("test-framework", false, "include testFramework modules")
+	addFormatFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		fs.Usage()
+		return nil
+	}
+
+	modulesPaths, err := findModules(osFS{}, *dir, *testFramework)
+	if err != nil {
+		return err
+	}
+	entries, pkgs, err := findExtensions(osFS{}, modulesPaths)
+	if err != nil {
+		return err
+	}
+	printExtensions(entries, pkgs)
+	fmt.Fprintf(os.Stderr, "%d extension points and services\n", len(entries))
+	return nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Atom feed of packages that appeared without documentation since the previous scan, or lost it,
+// served at /feed.atom so doc writers can subscribe instead of re-reading the whole report.
+// Serve mode keeps the last maxFeedEntries of them in memory, rescanning with -rescan-every.
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const maxFeedEntries = 200
+
+// feedEntry is a package that became undocumented at the given time.
+type feedEntry struct {
+	pkg  *pkg
+	seen time.Time
+}
+
+// newlyUndocumented returns packages that are undocumented now but were either absent or documented before.
+func newlyUndocumented(prev, cur map[string]*pkg) []*pkg {
+	var found []*pkg
+	for dir, p := range cur {
+		if p.isDocumented() {
+			continue
+		}
+		if old, ok := prev[dir]; !ok || old.isDocumented() {
+			found = append(found, p)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].pkgDir < found[j].pkgDir })
+	return found
+}
+
+// updateFeed adds the newly undocumented packages to the feed, must be called under the mu.
+func (d *daemon) updateFeed(prev, cur map[string]*pkg, now time.Time) {
+	if prev == nil { // first scan, or after the flushed cache: nothing to compare with
+		return
+	}
+	for _, p := range newlyUndocumented(prev, cur) {
+		d.feed = append(d.feed, feedEntry{p, now})
+	}
+	if len(d.feed) > maxFeedEntries {
+		d.feed = d.feed[len(d.feed)-maxFeedEntries:]
+	}
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	Title   string   `xml:"title"`
+	ID      string   `xml:"id"`
+	Updated string   `xml:"updated"`
+	Link    atomLink `xml:"link"`
+	Summary string   `xml:"summary"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+}
+
+// handleFeed responds with the Atom feed, the latest entries first.
+func (d *daemon) handleFeed(w http.ResponseWriter, r *http.Request) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	feed := atomFeed{Title: fmt.Sprintf("Newly undocumented packages in %s", d.dir), ID: "urn:jet-search:" + d.dir, Updated: d.scanned.Format(time.RFC3339)}
+	for i := len(d.feed) - 1; i >= 0; i-- {
+		e := d.feed[i]
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:   e.pkg.name,
+			ID:      fmt.Sprintf("urn:jet-search:%s:%d", e.pkg.pkgDir, e.seen.Unix()),
+			Updated: e.seen.Format(time.RFC3339),
+			Link:    atomLink{link(e.pkg.pkgDir)},
+			Summary: fmt.Sprintf("%s in %s has %d files and no package-info.java", e.pkg.name, e.pkg.module, len(e.pkg.files)),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml")
+	fmt.Fprint(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Scans of a past revision, read from the git objects rather than the working tree, so the historical scans
+// for the trend DB do not touch the checkout of the developer:
+//  go run . -d ./platform -rev 241.14494 -history trend.jsonl
+// The tree of the revision is listed once by `git ls-tree` and the files are read by a single `git cat-file --batch`.
+// The revision is the commit of the scan, the files are linked at it and the history record is at its commit time.
+// Submodules are not read, and neither -modules nor -record and -replay work with -rev.
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gitFS is the filesystem of a git revision, by the paths of the working tree, relative to the working dir or absolute.
+type gitFS struct {
+	top    string // of the working tree
+	commit string
+	time   time.Time // of the commit
+	files  map[string]gitEntry
+	dirs   map[string][]gitEntry
+
+	mu    sync.Mutex // of the cat-file process
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	out   *bufio.Reader
+}
+
+// gitEntry is a blob or a tree, by its path relative to the top of the working tree.
+type gitEntry struct {
+	name, object string
+	size         int64
+	dir          bool
+}
+
+// newGitFS lists the tree of the revision of the repo the dir is in, under the dir.
+func newGitFS(dir, rev string) (*gitFS, error) {
+	top, err := gitOutput(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	commit, err := gitOutput(dir, "rev-parse", "--verify", "--end-of-options", rev+"^{commit}")
+	if err != nil {
+		return nil, err
+	}
+	committed, err := gitOutput(dir, "show", "-s", "--format=%cI", commit)
+	if err != nil {
+		return nil, err
+	}
+	g := &gitFS{top: top, commit: commit, files: map[string]gitEntry{}, dirs: map[string][]gitEntry{}}
+	if g.time, err = time.Parse(time.RFC3339, committed); err != nil {
+		return nil, fmt.Errorf("bad commit time %q of %s: %v", committed, commit, err)
+	}
+
+	rel, err := g.rel(dir)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"ls-tree", "-r", "-t", "-l", "-z", "--full-tree", commit}
+	if rel != "." {
+		args = append(args, "--", rel)
+	}
+	listing, err := gitOutput(top, args...)
+	if err != nil {
+		return nil, err
+	}
+	g.dirs["."] = nil
+	for _, line := range strings.Split(listing, "\x00") {
+		// <mode> SP <type> SP <object> SP+ <size> TAB <path>
+		tab := strings.IndexByte(line, '\t')
+		if tab < 0 {
+			continue
+		}
+		fields, p := strings.Fields(line[:tab]), line[tab+1:]
+		if len(fields) != 4 || fields[1] == "commit" { // a submodule
+			continue
+		}
+		e := gitEntry{name: path.Base(p), object: fields[2], dir: fields[1] == "tree"}
+		if _, ok := g.dirs[p]; e.dir && !ok {
+			g.dirs[p] = nil
+		} else if !e.dir {
+			e.size, _ = strconv.ParseInt(fields[3], 10, 64)
+			g.files[p] = e
+		}
+		parent := path.Dir(p)
+		g.dirs[parent] = append(g.dirs[parent], e)
+	}
+	if _, ok := g.dirs[rel]; !ok {
+		return nil, fmt.Errorf("no %q in %s", dir, rev)
+	}
+	return g, nil
+}
+
+// rel returns the path relative to the top of the working tree, with slashes, as git does.
+func (g *gitFS) rel(name string) (string, error) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(g.top, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%q is not in the working tree of %s", name, g.top)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+func (g *gitFS) Open(name string) (fs.File, error) {
+	rel, err := g.rel(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if e, ok := g.files[rel]; ok {
+		data, err := g.catFile(e.object)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &memFile{Reader: bytes.NewReader(data), info: memInfo{e.name, int64(len(data)), false}}, nil
+	}
+	if _, ok := g.dirs[rel]; ok {
+		return &memFile{Reader: bytes.NewReader(nil), info: memInfo{filepath.Base(name), 0, true}}, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func (g *gitFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	rel, err := g.rel(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	listed, ok := g.dirs[rel]
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	entries := make([]fs.DirEntry, len(listed))
+	for i, e := range listed {
+		entries[i] = fs.FileInfoToDirEntry(memInfo{e.name, e.size, e.dir})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// catFile reads the blob by the cat-file process, started on the first read.
+func (g *gitFS) catFile(object string) ([]byte, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.cmd == nil {
+		cmd := exec.Command("git", "-C", g.top, "cat-file", "--batch")
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, err
+		}
+		g.cmd, g.stdin, g.out = cmd, stdin, bufio.NewReader(stdout)
+	}
+
+	if _, err := fmt.Fprintln(g.stdin, object); err != nil {
+		return nil, err
+	}
+	header, err := g.out.ReadString('\n') // <object> SP <type> SP <size> LF
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(header)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("git cat-file %s: %s", object, strings.TrimSpace(header))
+	}
+	size, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("git cat-file %s: %s", object, strings.TrimSpace(header))
+	}
+	data := make([]byte, size+1) // and LF
+	if _, err := io.ReadFull(g.out, data); err != nil {
+		return nil, err
+	}
+	return data[:size], nil
+}
+
+// Close stops the cat-file process, if it was started.
+func (g *gitFS) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.cmd == nil {
+		return nil
+	}
+	g.stdin.Close()
+	err := g.cmd.Wait()
+	g.cmd = nil
+	return err
+}
+
+// gitOutput runs git in the dir, returning the trimmed output.
+func gitOutput(dir string, args ...string) (string, error) {
+	out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			return "", fmt.Errorf("git %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(ee.Stderr)))
+		}
+		return "", fmt.Errorf("git %s: %v", strings.Join(args, " "), err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
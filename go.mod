@@ -0,0 +1,3 @@
+module github.com/bzz/jet-search
+
+go 1.21
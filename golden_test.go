@@ -0,0 +1,2552 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Golden tests of every output format, scanning the synthetic module trees in testdata/fixtures.
+// After an intended change of the output, update the golden files with
+//  go test -run Golden -update
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	bin "encoding/binary" // not to clash with the binary type of the rule expressions in rules.go
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"image/png"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+	"unicode/utf8"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata/golden")
+
+const basicFixture = "testdata/fixtures/basic"
+
+func scanFixture(t *testing.T, fixture string, extra ...visitor) map[string]*pkg {
+	t.Helper()
+	pkgs, err := scanFS(os.DirFS(fixture), "platform", false, extra...)
+	if err != nil {
+		t.Fatalf("scanning %q: %v", fixture, err)
+	}
+	return pkgs
+}
+
+// captureStdout returns what f prints, as the formatters print to stdout.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	out := make(chan []byte)
+	go func() {
+		blob, _ := io.ReadAll(r)
+		out <- blob
+	}()
+	f()
+	w.Close()
+	return string(<-out)
+}
+
+// withFormat sets the output format flags for the duration of the test.
+func withFormat(t *testing.T, md, gs, json bool) {
+	t.Helper()
+	was := [3]bool{*mdFlag, *gsFlag, *jsonFlag}
+	*mdFlag, *gsFlag, *jsonFlag = md, gs, json
+	t.Cleanup(func() { *mdFlag, *gsFlag, *jsonFlag = was[0], was[1], was[2] })
+}
+
+// withSummary runs the print with -summary set.
+func withSummary(print func()) {
+	*summaryFlag = true
+	defer func() { *summaryFlag = false }()
+	print()
+}
+
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", "golden", name)
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v, run with -update to create it", err)
+	}
+	if got != string(want) {
+		t.Errorf("%s differs from the golden file, run with -update if intended\ngot:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+func TestGolden(t *testing.T) {
+	cfg = &config{}
+	var decisions bytes.Buffer
+	decisionLog = &decisions
+	defer func() { decisionLog = io.Discard }()
+	pkgs := scanFixture(t, basicFixture)
+	checkGolden(t, "decisions.tsv", decisions.String())
+
+	findings, err := collectFindings(pkgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	formats := []struct {
+		name         string
+		md, gs, json bool
+		print        func()
+	}{
+		{"packages.tsv", false, false, false, func() { printPackages(os.Stdout, pkgs, nil) }},
+		{"packages.md", true, false, false, func() { printPackages(os.Stdout, pkgs, nil) }},
+		{"packages.gs.tsv", false, true, false, func() { printPackages(os.Stdout, pkgs, nil) }},
+		{"findings.tsv", false, false, false, func() { printFindings(findings) }},
+		{"findings.md", true, false, false, func() { printFindings(findings) }},
+		{"by-module.tsv", false, false, false, func() { printModuleSummaries(os.Stdout, pkgs) }},
+		{"by-module.md", true, false, false, func() { printModuleSummaries(os.Stdout, pkgs) }},
+		{"by-module.json", false, false, true, func() { printModuleSummaries(os.Stdout, pkgs) }},
+		{"packages.summary.tsv", false, false, false, func() { withSummary(func() { printPackages(os.Stdout, pkgs, nil) }) }},
+		{"packages.summary.md", true, false, false, func() { withSummary(func() { printPackages(os.Stdout, pkgs, nil) }) }},
+		{"by-module.summary.json", false, false, true, func() { withSummary(func() { printModuleSummaries(os.Stdout, pkgs) }) }},
+		{"packages.plain.txt", false, false, false, func() {
+			*plainFlag = true
+			defer func() { *plainFlag = false }()
+			withSummary(func() { printPackages(os.Stdout, pkgs, nil) })
+		}},
+		{"packages.gs-url.tsv", false, true, false, func() {
+			*gsLinksFlag = "url"
+			defer func() { *gsLinksFlag = "formula" }()
+			printPackages(os.Stdout, pkgs, nil)
+		}},
+		{"packages.missing-docs.tsv", false, false, false, func() {
+			*missingDocsFlag = true
+			defer func() { *missingDocsFlag = false }()
+			printPackages(os.Stdout, missingDocs(pkgs), nil)
+		}},
+		{"treemap.json", false, false, false, func() { panicIfError(writeTreemap(os.Stdout, "platform", pkgs)) }},
+		{"packages.json", false, false, true, func() { printPackages(os.Stdout, pkgs, nil) }},
+		{"packages.jsonl", false, false, false, func() {
+			*jsonlFlag = true
+			defer func() { *jsonlFlag = false }()
+			printPackages(os.Stdout, pkgs, nil)
+		}},
+	}
+	for _, f := range formats {
+		t.Run(f.name, func(t *testing.T) {
+			withFormat(t, f.md, f.gs, f.json)
+			checkGolden(t, f.name, captureStdout(t, f.print))
+		})
+	}
+
+	t.Run("packages.debt.tsv", func(t *testing.T) {
+		withFormat(t, false, false, false)
+		*debtMarkersFlag = true
+		defer func() { *debtMarkersFlag = false }()
+		debtPkgs := scanFixture(t, basicFixture, countDebtMarkers)
+		checkGolden(t, "packages.debt.tsv", captureStdout(t, func() { printPackages(os.Stdout, debtPkgs, nil) }))
+	})
+
+	t.Run("packages.color.tsv", func(t *testing.T) {
+		withFormat(t, false, false, false)
+		was := cfg
+		*colorFlag, cfg = "always", &config{PackageLimits: packageLimits{Files: 2}}
+		defer func() { *colorFlag, cfg = "auto", was }()
+		checkGolden(t, "packages.color.tsv", captureStdout(t, func() { printPackages(os.Stdout, pkgs, nil) }))
+		if !colorized(os.Stdout) {
+			t.Error("not colored with -color always")
+		}
+		*colorFlag = "auto"
+		if colorized(&bytes.Buffer{}) {
+			t.Error("colored a buffer with -color auto")
+		}
+	})
+
+	t.Run("packages.ext.md", func(t *testing.T) {
+		withFormat(t, true, false, false)
+		if err := setSourceExts(".kt,.java"); err != nil {
+			t.Fatal(err)
+		}
+		defer func() { sourceExts = defaultSourceExts }()
+		ktFirst := scanFixture(t, basicFixture)
+		checkGolden(t, "packages.ext.md", captureStdout(t, func() { printPackages(os.Stdout, ktFirst, nil) }))
+	})
+
+	t.Run("packages.loc.md", func(t *testing.T) {
+		withFormat(t, true, false, false)
+		*locFlag = true
+		defer func() { *locFlag = false }()
+		counted := scanFixture(t, basicFixture, countLOC)
+		checkGolden(t, "packages.loc.md", captureStdout(t, func() { printPackages(os.Stdout, counted, nil) }))
+	})
+
+	t.Run("packages.doc-coverage.tsv", func(t *testing.T) {
+		withFormat(t, false, false, false)
+		*docCoverageFlag = true
+		defer func() { *docCoverageFlag = false }()
+		sized := scanFixture(t, basicFixture, countSize)
+		checkGolden(t, "packages.doc-coverage.tsv", captureStdout(t, func() { printPackages(os.Stdout, sized, nil) }))
+	})
+
+	t.Run("packages.readme.gs.tsv", func(t *testing.T) {
+		withFormat(t, false, true, false)
+		*readmeFlag = true
+		defer func() { *readmeFlag = false }()
+		withReadmes := scanFixture(t, basicFixture, findReadme)
+		if err := findModuleReadmes(os.DirFS(basicFixture), withReadmes); err != nil {
+			t.Fatal(err)
+		}
+		checkGolden(t, "packages.readme.gs.tsv", captureStdout(t, func() { printPackages(os.Stdout, withReadmes, nil) }))
+	})
+
+	t.Run("packages.html", func(t *testing.T) {
+		withFormat(t, false, false, false)
+		*docCoverageFlag = true
+		defer func() { *docCoverageFlag = false }()
+		sized := scanFixture(t, basicFixture, countSize)
+		var b bytes.Buffer
+		if err := writeHTML(&b, "platform", sized, nil, time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+			t.Fatal(err)
+		}
+		checkGolden(t, "packages.html", b.String())
+	})
+
+	t.Run("orphans.tsv", func(t *testing.T) {
+		withFormat(t, false, false, false)
+		orphans, err := orphanedSourcesFindings(os.DirFS(basicFixture), "platform")
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkGolden(t, "orphans.tsv", captureStdout(t, func() { printFindings(orphans) }))
+	})
+
+	t.Run("convert.md", func(t *testing.T) {
+		blob, err := json.Marshal(newSnapshot(basicFixture, pkgs))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var s snapshot
+		if err := json.Unmarshal(blob, &s); err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if err := convert(&out, &s, "md"); err != nil {
+			t.Fatal(err)
+		}
+		checkGolden(t, "convert.md", out.String())
+	})
+
+	t.Run("graph.dot", func(t *testing.T) {
+		fsys := os.DirFS(basicFixture)
+		modulesPaths, err := findModules(fsys, "platform", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b bytes.Buffer
+		if err := writeGraph(&b, fsys, modulesPaths, pkgs); err != nil {
+			t.Fatal(err)
+		}
+		checkGolden(t, "graph.dot", b.String())
+	})
+
+	t.Run("cycles.txt", func(t *testing.T) {
+		fsys := os.DirFS(basicFixture)
+		modulesPaths, err := findModules(fsys, "platform", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		deps, err := readModuleDeps(fsys, modulesPaths)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b bytes.Buffer
+		if err := writeCycles(&b, findCycles(deps), deps); err != nil {
+			t.Fatal(err)
+		}
+		checkGolden(t, "cycles.txt", b.String())
+	})
+
+	t.Run("licenses.tsv", func(t *testing.T) {
+		withFormat(t, false, false, false)
+		licensed := scanFixture(t, basicFixture, detectLicense)
+		checkGolden(t, "licenses.tsv", captureStdout(t, func() { printFindings(licenseFindings(licensed, commonLicense(licensed))) }))
+	})
+
+	t.Run("files.index.csv", func(t *testing.T) {
+		var b bytes.Buffer
+		if err := writeFilesIndex(&b, pkgs); err != nil {
+			t.Fatal(err)
+		}
+		cwd, err := os.Getwd()
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkGolden(t, "files.index.csv", strings.ReplaceAll(b.String(), realPath(cwd)+string(filepath.Separator), ""))
+	})
+
+	t.Run("search.tsv", func(t *testing.T) {
+		var b bytes.Buffer
+		for _, query := range []string{"com.intellij.util", "util", "uConc", "CoIm", "nothing"} {
+			fmt.Fprintf(&b, "# %s\n", query)
+			printSearch(&b, searchPackages(pkgs, query))
+		}
+		checkGolden(t, "search.tsv", b.String())
+	})
+
+	t.Run("scan.sql", func(t *testing.T) {
+		var b bytes.Buffer
+		if err := writeSQL(&b, nil, pkgs); err != nil {
+			t.Fatal(err)
+		}
+		checkGolden(t, "scan.sql", b.String())
+	})
+
+	t.Run("extensions.tsv", func(t *testing.T) {
+		withFormat(t, false, false, false)
+		fixture := os.DirFS(basicFixture)
+		modulesPaths, err := findModules(fixture, "platform", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries, extPkgs, err := findExtensions(fixture, modulesPaths)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkGolden(t, "extensions.tsv", captureStdout(t, func() { printExtensions(entries, extPkgs) }))
+	})
+
+	t.Run("sarif.json", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "jet-search.sarif.json")
+		if err := writeSarif(path, findings); err != nil {
+			t.Fatal(err)
+		}
+		blob, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkGolden(t, "sarif.json", string(blob))
+	})
+
+	t.Run("calendar.ics", func(t *testing.T) {
+		milestones := []milestone{{Name: "Core API", Modules: "platform/core/**", Date: "2023-03-01", Coverage: 80}}
+		var b bytes.Buffer
+		if err := writeICal(&b, milestones, pkgs, time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+			t.Fatal(err)
+		}
+		checkGolden(t, "calendar.ics", b.String())
+		if _, err := os.Stat("/dev/full"); err == nil {
+			if err := saveICal("/dev/full", milestones, pkgs); err == nil {
+				t.Error("saved the calendar to a full disk")
+			}
+		}
+	})
+
+	modulesPaths, err := findAllModules(osFS{}, basicFixture, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mods, err := readModules(basicFixture, modulesPaths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []struct {
+		name     string
+		md, json bool
+	}{{"modules.tsv", false, false}, {"modules.md", true, false}, {"modules.json", false, true}} {
+		t.Run(f.name, func(t *testing.T) {
+			withFormat(t, f.md, false, f.json)
+			checkGolden(t, f.name, captureStdout(t, func() { printModules(mods) }))
+		})
+	}
+}
+
+// TestScanDeterministic checks that repeated scans print the same, regardless of the map order.
+func TestScanDeterministic(t *testing.T) {
+	withFormat(t, false, false, false)
+	first := captureStdout(t, func() { printPackages(os.Stdout, scanFixture(t, basicFixture), nil) })
+	for i := 0; i < 10; i++ {
+		if got := captureStdout(t, func() { printPackages(os.Stdout, scanFixture(t, basicFixture), nil) }); got != first {
+			t.Fatalf("scan %d differs:\n%s\nfirst:\n%s", i, got, first)
+		}
+	}
+}
+
+func TestScanFS(t *testing.T) {
+	iml := func(srcDirs ...string) *fstest.MapFile {
+		var b strings.Builder
+		b.WriteString(`<module type="JAVA_MODULE" version="4"><component name="NewModuleRootManager"><content url="file://$MODULE_DIR$">`)
+		for _, d := range srcDirs {
+			b.WriteString(d)
+		}
+		b.WriteString(`</content></component></module>`)
+		return &fstest.MapFile{Data: []byte(b.String())}
+	}
+	src := func(pkgName string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte("package " + pkgName + ";\n\nclass A {}\n")}
+	}
+	fsys := fstest.MapFS{
+		"p/a/intellij.a.iml": iml(`<sourceFolder url="file://$MODULE_DIR$/src" isTestSource="false" />`,
+			`<sourceFolder url="file://$MODULE_DIR$/test" isTestSource="true" />`),
+		"p/a/src/com/a/A.java":              src("com.a"),
+		"p/a/src/com/a/package-info.java":   src("com.a"),
+		"p/a/src/com/a/_Template.java":      src("com.a.template"),
+		"p/a/src/com/a/testData/Data.java":  src("com.a.data"),
+		"p/a/src/com/a/empty/Empty.java":    {},
+		"p/a/test/com/a/ATest.java":         src("com.a"),
+		"p/gen/intellij.gen.iml":            iml(`<sourceFolder url="file://$MODULE_DIR$/src" isTestSource="false" />`),
+		"p/gen/src/Gen.java":                src("gen"),
+		"p/a/tests/intellij.a.tests.iml":    iml(`<sourceFolder url="file://$MODULE_DIR$/src" isTestSource="false" />`),
+		"p/a/tests/src/com/a/t/ATest.java":  src("com.a.t"),
+		"p/testFramework/intellij.tf.iml":   iml(`<sourceFolder url="file://$MODULE_DIR$/src" isTestSource="false" />`),
+		"p/testFramework/src/com/tf/T.java": src("com.tf"),
+	}
+
+	pkgs, err := scanFS(fsys, "p", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range sortedPackages(pkgs) {
+		got = append(got, p.pkgDir+" "+p.name+" "+p.doc+" "+strings.Join(p.files, ","))
+	}
+	want := []string{"p/a/src/com/a com.a p/a/src/com/a/package-info.java A.java,_Template.java,package-info.java"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got packages\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	pkgs, err = scanFS(fsys, "p", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := pkgs["p/testFramework/src/com/tf"]; p == nil || p.name != "com.tf" {
+		t.Errorf("testFramework package is not scanned with testFramework: %v", p)
+	}
+	// every source root, nested ones attributed to themselves
+	roots := fstest.MapFS{
+		"r/m/intellij.m.iml": iml(`<sourceFolder url="file://$MODULE_DIR$/src" isTestSource="false" />`,
+			`<sourceFolder url="file://$MODULE_DIR$/src/api" isTestSource="false" />`,
+			`<sourceFolder url="file://$MODULE_DIR$/ui/src" isTestSource="false" />`),
+		"r/m/src/com/m/M.java":       src("com.m"),
+		"r/m/src/api/com/m/A.java":   src("com.m"),
+		"r/m/ui/src/com/m/ui/U.java": src("com.m.ui"),
+	}
+	pkgs, err = scanFS(roots, "r", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for dir, srcDir := range map[string]string{"r/m/src/com/m": "r/m/src", "r/m/src/api/com/m": "r/m/src/api", "r/m/ui/src/com/m/ui": "r/m/ui/src"} {
+		if p := pkgs[dir]; p == nil || p.srcDir != srcDir {
+			t.Errorf("package %s: got %v, want in the source root %s", dir, p, srcDir)
+		}
+	}
+}
+
+func TestIndexIncremental(t *testing.T) {
+	iml := func(srcDirs ...string) *fstest.MapFile {
+		var b strings.Builder
+		b.WriteString(`<module type="JAVA_MODULE" version="4"><component name="NewModuleRootManager"><content url="file://$MODULE_DIR$">`)
+		for _, d := range srcDirs {
+			b.WriteString(`<sourceFolder url="file://$MODULE_DIR$/` + d + `" isTestSource="false" />`)
+		}
+		b.WriteString(`</content></component></module>`)
+		return &fstest.MapFile{Data: []byte(b.String())}
+	}
+	then := time.Date(2023, 1, 31, 10, 0, 0, 0, time.UTC)
+	src := func(pkgName string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte("package " + pkgName + ";\n\npublic class A {}\n"), ModTime: then}
+	}
+	fsys := fstest.MapFS{
+		"p/a/intellij.a.iml":         iml("src", "src/api"),
+		"p/a/src/com/a/A.java":       src("com.a"),
+		"p/a/src/api/com/a/A.java":   src("com.a"),
+		"p/b/intellij.b.iml":         iml("src"),
+		"p/b/src/com/b/B.java":       src("com.b"),
+		"p/c/intellij.c.iml":         iml("src", "src/api"),
+		"p/c/src/com/c/C.java":       src("com.c"),
+		"p/c/src/com/c/ui/Panel.kt":  src("com.c.ui"),
+		"p/c/src/api/com/c/Api.java": src("com.c"),
+	}
+	full, read, err := indexDir(fsys, "p", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read != 5 {
+		t.Errorf("read %d source roots of a new index, want all 5", read)
+	}
+
+	fsys["p/b/src/com/b/B2.java"] = &fstest.MapFile{Data: []byte("package com.b;\n"), ModTime: then.Add(time.Hour)}
+	fsys["p/c/src/api/com/c/Api.java"] = &fstest.MapFile{Data: []byte("package com.c;\n"), ModTime: then.Add(time.Hour)}
+	delete(fsys, "p/c/src/com/c/ui/Panel.kt")
+	incremental, read, err := indexDir(fsys, "p", false, full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read != 3 { // p/b/src and both roots of p/c, as one is nested in the other
+		t.Errorf("read %d source roots, want 3 modified", read)
+	}
+	rescanned, _, err := indexDir(fsys, "p", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := incremental.hash(), rescanned.hash(); got != want {
+		t.Errorf("incremental index differs from a full one:\n%v\n%v", sortedPackages(incremental.pkgs), sortedPackages(rescanned.pkgs))
+	}
+}
+
+func TestProtobufRoundTrip(t *testing.T) {
+	pkgs := scanFixture(t, basicFixture, countSize)
+	s := newSnapshot(basicFixture, pkgs)
+	s.version, s.publicTypes = snapshotVersion, true
+	s.roots = map[string]time.Time{"src": time.Date(2023, 1, 31, 10, 0, 0, 1, time.UTC)}
+	var b bytes.Buffer
+	if err := writeSnapshotPB(&b, s); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readSnapshotPB(b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.hash() != s.hash() || got.dir != s.dir || !got.time.Equal(s.time) || !reflect.DeepEqual(got.roots, s.roots) || !got.publicTypes {
+		t.Errorf("snapshot differs after protobuf round trip:\n%v\n%v", sortedPackages(got.pkgs), sortedPackages(s.pkgs))
+	}
+
+	findings, err := collectFindings(pkgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "findings.pb")
+	if err := writeFindingsPB(path, findings); err != nil {
+		t.Fatal(err)
+	}
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotFindings, err := readFindingsPB(blob); err != nil || !reflect.DeepEqual(gotFindings, findings) {
+		t.Errorf("findings differ after protobuf round trip (%v):\n%v\n%v", err, gotFindings, findings)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	then := time.Date(2023, 1, 31, 10, 0, 0, 0, time.UTC)
+	shard := func(dir string, at time.Time, pkgs ...*pkg) *snapshot {
+		s := &snapshot{dir: dir, time: at, pkgs: map[string]*pkg{}, publicTypes: true}
+		for _, p := range pkgs {
+			s.pkgs[p.pkgDir] = p
+		}
+		return s
+	}
+	pkgAt := func(pkgDir, doc string) *pkg {
+		return &pkg{module: "p/m.iml", pkgDir: pkgDir, name: filepath.Base(pkgDir), doc: doc, filesCnt: map[string]int{}}
+	}
+	a := shard("p/a", then, pkgAt("p/a/x", ""), pkgAt("p/shared", ""), pkgAt("p/same", "p/same/package-info.java"))
+	b := shard("./p/b", then.Add(time.Hour), pkgAt("p/b/y", ""), pkgAt("p/shared", "p/shared/package-info.java"), pkgAt("p/same", "p/same/package-info.java"))
+
+	for _, tc := range []struct {
+		rule, doc string
+	}{{"fail", ""}, {"first", ""}, {"last", "p/shared/package-info.java"}, {"newest", "p/shared/package-info.java"}} {
+		merged, conflicts, err := mergeSnapshots([]*snapshot{a, b}, []string{"a.json", "b.json"}, tc.rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"p/shared differs in a.json and b.json"}; !reflect.DeepEqual(conflicts, want) {
+			t.Errorf("%s: conflicts %q, want %q", tc.rule, conflicts, want)
+		}
+		if len(merged.pkgs) != 4 || merged.pkgs["p/shared"].doc != tc.doc {
+			t.Errorf("%s: merged %v, want p/shared with doc %q", tc.rule, sortedPackages(merged.pkgs), tc.doc)
+		}
+		if merged.dir != "p" || !merged.time.Equal(b.time) {
+			t.Errorf("%s: merged %q at %v, want p at %v", tc.rule, merged.dir, merged.time, b.time)
+		}
+	}
+	if _, _, err := mergeSnapshots([]*snapshot{a, b}, []string{"a.json", "b.json"}, "oldest"); err == nil {
+		t.Error("merged with an unknown conflict rule")
+	}
+}
+
+func TestLinks(t *testing.T) {
+	cfg = &config{}
+	scanCommit = "0123abc"
+	defer func() { scanCommit, *linksFlag, *linkStyleFlag, *linkBaseFlag = "", "head", "space", "" }()
+	for _, tc := range []struct{ mode, style, base, want string }{
+		{"head", "space", "", spaceURL + "platform/core/src"},
+		{"pinned", "space", "", spaceURL + "0123abc/platform/core/src"},
+		{"head", "github", "", "https://github.com/JetBrains/intellij-community/blob/HEAD/platform/core/src"},
+		{"pinned", "github", "https://github.com/me/fork/", "https://github.com/me/fork/blob/0123abc/platform/core/src"},
+		{"pinned", "none", "", ""},
+	} {
+		*linksFlag, *linkStyleFlag, *linkBaseFlag = tc.mode, tc.style, tc.base
+		if got := link("platform/core/src"); got != tc.want {
+			t.Errorf("-links %s -link-style %s -link-base %q: got %q, want %q", tc.mode, tc.style, tc.base, got, tc.want)
+		}
+	}
+}
+
+func TestGsCells(t *testing.T) {
+	withFormat(t, false, true, false)
+	for _, tc := range []struct{ value, want string }{
+		{"platform/core", "platform/core"},
+		{"=cmd|' /C calc'!A0", "'=cmd|' /C calc'!A0"},
+		{"+1", "'+1"},
+		{"-2", "'-2"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"a\tb\nc", "a b c"},
+	} {
+		if got := gsCell(tc.value); got != tc.want {
+			t.Errorf("gsCell(%q): got %q, want %q", tc.value, got, tc.want)
+		}
+	}
+	if got, want := hyperlink(`https://host/a"b`, `=x`), `=HYPERLINK("https://host/a""b","=x")`; got != want {
+		t.Errorf("formula: got %q, want %q", got, want)
+	}
+	if got, want := hyperlink("", "=x"), "'=x"; got != want {
+		t.Errorf("no link: got %q, want %q", got, want)
+	}
+}
+
+func TestShards(t *testing.T) {
+	modulesPaths, err := findModules(os.DirFS(basicFixture), "platform", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 3
+	seen := map[string]int{}
+	for i := 1; i <= n; i++ {
+		for _, mp := range shardModules("platform", modulesPaths, i, n) {
+			if j, ok := seen[mp]; ok {
+				t.Errorf("%s is in shards %d and %d", mp, j, i)
+			}
+			seen[mp] = i
+		}
+	}
+	if len(seen) != len(modulesPaths) {
+		t.Errorf("shards have %d of %d modules", len(seen), len(modulesPaths))
+	}
+
+	// balanced in the platform/X/intellij.platform.X.iml layout, where X cancels out in a poorly mixed hash
+	var layout []string
+	for i := 0; i < 300; i++ {
+		layout = append(layout, fmt.Sprintf("platform/m%d/intellij.platform.m%d.iml", i, i))
+	}
+	for _, n := range []int{2, 3} {
+		for i := 1; i <= n; i++ {
+			if len(shardModules("platform", modulesPaths, i, n)) == 0 {
+				t.Errorf("shard %d/%d of the fixture is empty", i, n)
+			}
+			if got, mean := len(shardModules("platform", layout, i, n)), len(layout)/n; got < mean*4/5 || got > mean*6/5 {
+				t.Errorf("shard %d/%d has %d of %d modules, want about %d", i, n, got, len(layout), mean)
+			}
+		}
+	}
+	for _, bad := range []string{"0/3", "4/3", "3", "a/b", "1/0"} {
+		if _, _, err := parseShard(bad); err == nil {
+			t.Errorf("parsed bad shard %q", bad)
+		}
+	}
+}
+
+func TestCoordinator(t *testing.T) {
+	dir := filepath.Join(basicFixture, "platform")
+	modulesPaths, err := findModules(osFS{}, dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newCoordinator(dir, modulesPaths, 2, time.Minute)
+	server := httptest.NewServer(c.mux("s3cret"))
+	defer server.Close()
+
+	if _, err := work(server.URL, "wrong", time.Millisecond); err == nil {
+		t.Error("worked with a wrong token")
+	}
+	scanned, err := work(server.URL, "s3cret", time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scanned != len(c.batches) {
+		t.Errorf("scanned %d of %d batches", scanned, len(c.batches))
+	}
+	merged, _, err := c.merged()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range merged.pkgs {
+		if p.contentHash == "" {
+			t.Errorf("%s: no content hash in the batch snapshot, for the diffs", p.pkgDir)
+		}
+	}
+	whole, err := scanModules(osFS{}, modulesPaths, countSize, hashContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := merged.hash(), newSnapshot(dir, whole).hash(); got != want {
+		t.Errorf("merged batches differ from a whole scan:\n%v\n%v", sortedPackages(merged.pkgs), sortedPackages(whole))
+	}
+}
+
+func TestOffline(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("%s %s reached the server offline", r.Method, r.URL)
+	}))
+	defer server.Close()
+	*offlineFlag = true
+	defer func() { *offlineFlag, *pushFlag = false, "" }()
+
+	s := newSnapshot(basicFixture, map[string]*pkg{})
+	if err := pushSnapshot(server.URL+"/api/snapshots/idea/master", "", s); !errors.Is(err, errOffline) {
+		t.Errorf("push offline: %v", err)
+	}
+	if _, err := loadSnapshot(server.URL + "/api/snapshots/idea/master"); !errors.Is(err, errOffline) {
+		t.Errorf("load offline: %v", err)
+	}
+	if _, err := dialTCP(server.Listener.Addr().String(), time.Second); !errors.Is(err, errOffline) {
+		t.Errorf("dial offline: %v", err)
+	}
+	*pushFlag = server.URL
+	if err := checkOffline(); err == nil {
+		t.Error("-push is allowed offline")
+	}
+
+	// by the env var for the subcommands without the flag too
+	defer func(c *config) { cfg = c }(cfg)
+	t.Setenv("JET_SEARCH_OFFLINE", "true")
+	*offlineFlag = false
+	if err := parseFlags(flag.NewFlagSet("history", flag.ContinueOnError), nil); err != nil || !*offlineFlag {
+		t.Errorf("JET_SEARCH_OFFLINE is not set for a subcommand without -offline: %v", err)
+	}
+	*offlineFlag = false
+	if err := runWork([]string{"-coordinator", server.URL}); err == nil || !strings.Contains(err.Error(), "disabled by -offline") {
+		t.Errorf("work offline: %v", err)
+	}
+	*offlineFlag = false
+	if err := runCoordinate([]string{"-d", basicFixture, "-o", filepath.Join(t.TempDir(), "all.json"), "-addr", "127.0.0.1:0"}); err == nil || !strings.Contains(err.Error(), "disabled by -offline") {
+		t.Errorf("coordinate offline: %v", err)
+	}
+}
+
+func TestScalaGroovy(t *testing.T) {
+	iml := &fstest.MapFile{Data: []byte(`<module type="JAVA_MODULE" version="4"><component name="NewModuleRootManager"><content url="file://$MODULE_DIR$">` +
+		`<sourceFolder url="file://$MODULE_DIR$/src" isTestSource="false" /></content></component></module>`)}
+	fsys := fstest.MapFS{
+		"p/s/intellij.s.iml":                      iml,
+		"p/s/src/com/s/Chained.scala":             {Data: []byte("// header\npackage com\npackage s\n\nimport java.util\n\ncase class Point(x: Int)\nprivate class Hidden\n")},
+		"p/s/src/com/s/obj/package.scala":         {Data: []byte("package com.s\n\npackage object obj {\n  val answer = 42\n}\n")},
+		"p/s/src/com/s/obj/Obj.scala":             {Data: []byte("package com.s.obj\n\n/** Documented. */\ntrait Obj\n")},
+		"p/s/src/com/s/groovy/Script.groovy":      {Data: []byte("package com.s.groovy\n\nclass Script {}\n")},
+		"p/s/src/com/s/groovy/Build.groovy":       {Data: []byte("package com.s.groovy;\n\n@CompileStatic\nabstract class Build {}\n")},
+		"p/s/src/com/s/groovy/notes/Notes.txt":    {Data: []byte("package notes\n")},
+		"p/s/src/com/s/groovy/scripts/run.gradle": {Data: []byte("apply plugin: 'java'\n")},
+	}
+	pkgs, err := scanFS(fsys, "p", false, countSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range sortedPackages(pkgs) {
+		got = append(got, fmt.Sprintf("%s %s scala=%d groovy=%d public=%d documented=%d", p.pkgDir, p.name, p.filesCnt[".scala"], p.filesCnt[".groovy"], p.publicTypes, p.documentedTypes))
+	}
+	want := []string{
+		"p/s/src/com/s com.s scala=1 groovy=0 public=1 documented=0",
+		"p/s/src/com/s/groovy com.s.groovy scala=0 groovy=2 public=2 documented=0",
+		"p/s/src/com/s/obj com.s.obj scala=2 groovy=0 public=1 documented=1",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got packages\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if name, err := readPkgNameFromFirstLines(fsys, "p/s/src/com/s/obj/package.scala", 100); err != nil || name != "com.s.obj" {
+		t.Errorf("package object: got %q, %v, want com.s.obj", name, err)
+	}
+}
+
+func TestSecrets(t *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/secret/data/jet-search" || r.Header.Get("X-Vault-Token") != "root" {
+			http.Error(w, "permission denied", http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `{"data": {"data": {"jira-token": "from-vault", "push-token": "shadowed"}}}`)
+	}))
+	defer vault.Close()
+	was := cfg
+	defer func() { cfg, secrets.vault, secrets.resolved = was, nil, nil }()
+	cfg = &config{Vault: vaultConfig{Addr: vault.URL, Path: "secret/data/jet-search"}}
+	t.Setenv("VAULT_TOKEN", "root")
+
+	file := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(file, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("JET_SEARCH_PUSH_TOKEN", "from-env")
+	t.Setenv("JET_SEARCH_UPLOAD_TOKEN_FILE", file)
+	for name, want := range map[string]string{"push-token": "from-env", "upload-token": "from-file", "jira-token": "from-vault", "redis-password": ""} {
+		if got, err := secret(name); err != nil || got != want {
+			t.Errorf("secret %s: got %q, %v, want %q", name, got, err, want)
+		}
+	}
+	if got := redact("PUT https://host?token=from-env: 401 from-vault"); got != "PUT https://host?token=[redacted]: 401 [redacted]" {
+		t.Errorf("redacted %q", got)
+	}
+	if _, err := newRedisClient("redis://:from-env@localhost"); err == nil || strings.Contains(err.Error(), "from-env") {
+		t.Errorf("password in the Redis URL: %v", err)
+	}
+}
+
+func TestSelfUpdate(t *testing.T) {
+	binary := []byte("#!/bin/sh\necho jet-search 1.5.0\n")
+	sum := sha256.Sum256(binary)
+	latestVersion := "1.5.0"
+	requests := 0
+	store := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/latest.json":
+			fmt.Fprintf(w, `{"version": %q, "sha256": {%q: %q}}`, latestVersion, platform(), hex.EncodeToString(sum[:]))
+		case "/1.5.0/jet-search-" + platform(), "/1.5.0/jet-search-" + platform() + ".exe":
+			w.Write(binary)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer store.Close()
+
+	for _, tc := range []struct {
+		a, b  string
+		older bool
+	}{{"1.4.0", "1.5.0", true}, {"1.10", "1.9.1", false}, {"1.5", "1.5.0", false}, {"dev", "0.1", true}} {
+		if got := olderVersion(tc.a, tc.b); got != tc.older {
+			t.Errorf("olderVersion(%q, %q) = %v", tc.a, tc.b, got)
+		}
+	}
+	for _, bad := range []string{"../1.5.0", "1.5.0/../../x", "1.5.0-rc1", "1..5", "v1.5"} {
+		latestVersion = bad
+		if _, err := latestRelease(store.URL); err == nil || !strings.Contains(err.Error(), "bad version") {
+			t.Errorf("version %q: got %v, want a bad version", bad, err)
+		}
+	}
+	latestVersion = "1.5.0"
+
+	defer func(c *config) { cfg, *offlineFlag = c, false }(cfg)
+	t.Setenv("JET_SEARCH_OFFLINE", "1")
+	requests = 0
+	if err := runVersion([]string{"-check", "-updates-url", store.URL}); err == nil || !strings.Contains(err.Error(), "disabled by -offline") {
+		t.Errorf("version -check offline: %v", err)
+	}
+	if err := runSelfUpdate([]string{"-updates-url", store.URL}); err == nil || !strings.Contains(err.Error(), "disabled by -offline") {
+		t.Errorf("self-update offline: %v", err)
+	}
+	if requests != 0 {
+		t.Errorf("%d requests to the store offline", requests)
+	}
+	t.Setenv("JET_SEARCH_OFFLINE", "")
+	*offlineFlag = false
+
+	latest, err := latestRelease(store.URL)
+	if err != nil || latest.Version != "1.5.0" {
+		t.Fatalf("latest release: %v, %v", latest, err)
+	}
+	path := filepath.Join(t.TempDir(), "jet-search")
+	if err := os.WriteFile(path, []byte("stale"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := installRelease(store.URL, latest, path); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, binary) {
+		t.Errorf("installed %q", got)
+	}
+	latest.SHA256[platform()] = strings.Repeat("0", 64)
+	if err := installRelease(store.URL, latest, path); err == nil {
+		t.Error("installed a binary with a wrong checksum")
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("left %d files next to the binary", len(entries))
+	}
+}
+
+func TestLOC(t *testing.T) {
+	src := "// header\n\npackage a;\n\n/*\n * block\n */\nclass A { /* inline */ }\nint x; // trailing\n/* open */ int y; /* and\nclosed */\n"
+	p := &pkg{}
+	if err := countLOC(p, &sourceFile{path: "a/A.java", data: []byte(src)}); err != nil {
+		t.Fatal(err)
+	}
+	if p.codeLines != 4 || p.commentLines != 5 || p.blankLines != 2 {
+		t.Errorf("got %d code, %d comment and %d blank lines, want 4, 5 and 2", p.codeLines, p.commentLines, p.blankLines)
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	dir := filepath.Join(basicFixture, "platform")
+	b := newBundle()
+	recorded, err := scanFS(&recordFS{b: b}, dir, false, countSize, countLOC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "scan.json.gz")
+	if err := saveBundle(path, b); err != nil {
+		t.Fatal(err)
+	}
+	if b, err = readBundle(path); err != nil {
+		t.Fatal(err)
+	}
+	replayed, err := scanFS(bundleFS{b}, dir, false, countSize, countLOC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(replayed, recorded) {
+		t.Errorf("replayed %d packages differ from the %d recorded ones", len(replayed), len(recorded))
+	}
+	if _, err := (bundleFS{b}).Open("testdata/golden/packages.md"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("opened a file not read by the scan: %v", err)
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	iml := &fstest.MapFile{Data: []byte(`<module type="JAVA_MODULE" version="4"><component name="NewModuleRootManager"><content url="file://$MODULE_DIR$">` +
+		`<sourceFolder url="file://$MODULE_DIR$/src" isTestSource="false" /></content></component></module>`)}
+	fsys := fstest.MapFS{
+		"p/a/intellij.a.iml":        iml,
+		"p/a/src/com/a/A.java":      {Data: []byte("package com.a;\n\npublic class A {}\n")},
+		"p/a/src/com/a/edited/E.kt": {Data: []byte("package com.a.edited\n\nclass E\n")},
+		"p/a/src/com/a/added/B.kt":  {Data: []byte("package com.a.added\n\nclass B\n")},
+	}
+	scan := func() *snapshot {
+		pkgs, err := scanFS(fsys, "p", false, hashContent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return newSnapshot("p", pkgs)
+	}
+	base := scan()
+	fsys["p/a/src/com/a/edited/E.kt"] = &fstest.MapFile{Data: []byte("package com.a.edited\n\nclass E(val x: Int)\n")}
+	fsys["p/a/src/com/a/added/C.kt"] = &fstest.MapFile{Data: []byte("package com.a.added\n\nclass C\n")}
+	head := scan()
+
+	var got []string
+	for _, d := range diffBranches(base, head, true) {
+		got = append(got, d.Dir+" "+d.Change)
+	}
+	want := []string{"a/src/com/a/added files", "a/src/com/a/edited content"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got changes %q, want %q", got, want)
+	}
+}
+
+func TestXLSX(t *testing.T) {
+	pkgs := scanFixture(t, basicFixture)
+	var b bytes.Buffer
+	if err := writeWorkbook(&b, packagesWorkbook("platform", pkgs)); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[f.Name] = string(data)
+	}
+
+	for _, sheet := range []string{"broken", "core", "kt", "old", "util"} {
+		if !strings.Contains(parts["xl/workbook.xml"], `<sheet name="`+sheet+`"`) {
+			t.Errorf("no sheet %q in %s", sheet, parts["xl/workbook.xml"])
+		}
+	}
+	core := parts["xl/worksheets/sheet2.xml"]
+	for _, want := range []string{`state="frozen"`, `<c r="A1" s="1" t="inlineStr"><is><t>files</t></is></c>`, `<c r="A3" s="2"><v>4</v></c>`, `<hyperlink ref="G2" r:id="rId1"/>`} {
+		if !strings.Contains(core, want) {
+			t.Errorf("no %s in the core sheet:\n%s", want, core)
+		}
+	}
+	if rels := parts["xl/worksheets/_rels/sheet2.xml.rels"]; !strings.Contains(rels, `Target="https://jetbrains.team/p/ij/repositories/community/files/platform/core/src/com/intellij/core" TargetMode="External"`) {
+		t.Errorf("no link to the core package in %s", rels)
+	}
+	if taken := map[string]bool{"a": true}; xlsxSheetName("A", taken) != "A~2" || xlsxSheetName("a/b:c", taken) != "a_b_c" {
+		t.Error("sheet names are not unique and valid")
+	}
+}
+
+func TestContentRootsAsSrcDirs(t *testing.T) {
+	iml := func(moduleType, content string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(`<module type="` + moduleType + `" version="4"><component name="NewModuleRootManager" inherit-compiler-output="true">` +
+			`<exclude-output />` + content + `<orderEntry type="inheritedJdk" /><orderEntry type="sourceFolder" forTests="false" /></component></module>`)}
+	}
+	fsys := fstest.MapFS{
+		"p/rpc/intellij.nodeRpcClient.iml":      iml("JAVA_MODULE", `<content url="file://$MODULE_DIR$" />`),
+		"p/rpc/com/intellij/rpc/Client.java":    {Data: []byte("package com.intellij.rpc;\n\npublic class Client {}\n")},
+		"p/web/intellij.web.iml":                iml("WEB_MODULE", `<content url="file://$MODULE_DIR$" />`),
+		"p/web/com/intellij/web/Ignored.java":   {Data: []byte("package com.intellij.web;\n")},
+		"p/none/intellij.none.iml":              iml("JAVA_MODULE", ""),
+		"p/none/com/intellij/none/Ignored.java": {Data: []byte("package com.intellij.none;\n")},
+	}
+	var decisions bytes.Buffer
+	decisionLog = &decisions
+	defer func() { decisionLog = io.Discard }()
+	pkgs, err := scanFS(fsys, "p", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := pkgs["p/rpc/com/intellij/rpc"]; len(pkgs) != 1 || p == nil || p.name != "com.intellij.rpc" {
+		t.Errorf("got packages %v, want com.intellij.rpc of the content root only", sortedPackages(pkgs))
+	}
+	want := "p/none/intellij.none.iml\tskipped: no <sourceFolder /> nor <content /> root\n" +
+		"p/rpc/intellij.nodeRpcClient.iml\tcontent roots are the source roots: no <sourceFolder />, but <orderEntry type=\"sourceFolder\" forTests=\"false\" />\n" +
+		"p/web/intellij.web.iml\tskipped: no <sourceFolder />, and a WEB_MODULE has no JVM sources in its content roots\n"
+	if decisions.String() != want {
+		t.Errorf("got decisions:\n%s\nwant:\n%s", decisions.String(), want)
+	}
+}
+
+// gitRepo is a git repo in a temp dir, for the scans of the revisions.
+type gitRepo struct {
+	t   *testing.T
+	dir string
+}
+
+func newGitRepo(t *testing.T) *gitRepo {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("no git")
+	}
+	r := &gitRepo{t: t, dir: t.TempDir()}
+	r.git("init", "-q")
+	return r
+}
+
+func (r *gitRepo) write(path, content string) {
+	r.t.Helper()
+	path = filepath.Join(r.dir, filepath.FromSlash(path))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		r.t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+func (r *gitRepo) git(args ...string) {
+	r.t.Helper()
+	r.gitAt("", args...)
+}
+
+// gitAt runs git with the author and committer dates set to the date, unless it is empty.
+func (r *gitRepo) gitAt(date string, args ...string) {
+	r.t.Helper()
+	cmd := exec.Command("git", append([]string{"-C", r.dir, "-c", "user.name=a", "-c", "user.email=a@b"}, args...)...)
+	if date != "" {
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_DATE="+date, "GIT_COMMITTER_DATE="+date)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		r.t.Fatalf("git %v: %v: %s", args, err, out)
+	}
+}
+
+// commit commits all the changes at the date, i.e 2020-01-15T00:00:00Z.
+func (r *gitRepo) commit(date string) {
+	r.t.Helper()
+	r.git("add", "-A")
+	r.gitAt(date, "commit", "-q", "-m", date)
+}
+
+const gitRepoModule = `<module type="JAVA_MODULE" version="4"><component name="NewModuleRootManager"><content url="file://$MODULE_DIR$">` +
+	`<sourceFolder url="file://$MODULE_DIR$/src" isTestSource="false" /></content></component></module>`
+
+func TestGitRev(t *testing.T) {
+	repo := newGitRepo(t)
+	repo.write("p/a/intellij.a.iml", gitRepoModule)
+	repo.write("p/a/src/com/a/A.java", "package com.a;\n\npublic class A {}\n")
+	repo.write("p/a/src/com/a/package-info.java", "/** Docs. */\npackage com.a;\n")
+	repo.write("p/a/src/com/a/b/B.kt", "package com.a.b\n\nclass B\n")
+	repo.commit("2020-01-15T00:00:00Z")
+	dir := filepath.Join(repo.dir, "p")
+	committed, err := scanFS(osFS{}, dir, false, countSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo.write("p/a/src/com/a/c/C.java", "package com.a.c;\n")
+	if err := os.Remove(filepath.Join(repo.dir, "p/a/src/com/a/package-info.java")); err != nil {
+		t.Fatal(err)
+	}
+	g, err := newGitFS(dir, "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	scanned, err := scanFS(g, dir, false, countSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(scanned, committed) {
+		t.Errorf("scanned %v at HEAD, want the committed %v", sortedPackages(scanned), sortedPackages(committed))
+	}
+	if _, err := newGitFS(filepath.Join(repo.dir, "p/a/src/com/a/c"), "HEAD"); err == nil {
+		t.Error("scanned a dir not in the revision")
+	}
+}
+
+func TestReleaseSnapshots(t *testing.T) {
+	repo := newGitRepo(t)
+	repo.write("p/a/intellij.a.iml", gitRepoModule)
+	repo.write("p/a/src/com/a/A.java", "package com.a;\n\npublic class A {}\n")
+	repo.commit("2020-01-15T00:00:00Z")
+	repo.git("tag", "233.0")
+	repo.write("p/a/src/com/a/A.java", "package com.a;\n\npublic class A { void a() {} }\n")
+	repo.commit("2020-04-01T00:00:00Z")
+	repo.git("tag", "241.0")
+
+	snapshots := t.TempDir()
+	hashes := map[string]string{}
+	for _, tag := range []string{"233.0", "241.0", "233.0"} { // the last one read from the snapshots dir
+		s, err := tagSnapshot(repo.dir, "p", tag, snapshots, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range s.pkgs {
+			if p.name != "com.a" {
+				continue
+			}
+			if p.contentHash == "" {
+				t.Errorf("%s: no content hash, for the diffs", tag)
+			} else if prev, ok := hashes[tag]; ok && prev != p.contentHash {
+				t.Errorf("%s: got the content hash %s of the saved snapshot, want %s", tag, p.contentHash, prev)
+			}
+			hashes[tag] = p.contentHash
+		}
+	}
+	if hashes["233.0"] == hashes["241.0"] {
+		t.Errorf("the same content hash of the changed sources at both tags")
+	}
+}
+
+func TestBackfill(t *testing.T) {
+	repo := newGitRepo(t)
+	repo.write("p/a/intellij.a.iml", gitRepoModule)
+	repo.write("p/a/src/com/a/A.java", "package com.a;\n")
+	repo.commit("2020-01-15T00:00:00Z")
+	repo.write("p/a/src/com/a/b/B.java", "package com.a.b;\n")
+	repo.commit("2020-02-10T00:00:00Z")
+	repo.write("p/a/src/com/a/b/C.java", "package com.a.b;\n") // the same month
+	repo.commit("2020-02-20T00:00:00Z")
+	repo.write("p/a/src/com/a/package-info.java", "/** Docs. */\npackage com.a;\n")
+	repo.commit("2020-04-01T00:00:00Z")
+
+	dir := filepath.Join(repo.dir, "p")
+	history := filepath.Join(t.TempDir(), "trend.jsonl")
+	args := []string{"backfill", "-d", dir, "-history", history, "-every", "1month", "-since", "2020-01", "-until", "2020-05"}
+	for i := 0; i < 2; i++ { // the second run appends none
+		if err := runHistory(args); err != nil {
+			t.Fatal(err)
+		}
+	}
+	records, err := readHistory(history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range records {
+		m := r.Modules[filepath.Join(dir, "a/intellij.a.iml")]
+		if m == nil {
+			t.Fatalf("no module in %+v", r.Modules)
+		}
+		got = append(got, fmt.Sprintf("%s %d/%d %d", r.Time.Format("2006-01-02"), m.Documented, m.Packages, m.Files))
+	}
+	want := []string{"2020-01-15 0/1 1", "2020-02-20 0/2 3", "2020-04-01 1/2 4"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("backfilled %q, want %q", got, want)
+	}
+
+	series := filepath.Join(t.TempDir(), "series.csv")
+	if err := runHistory([]string{"-d", dir, "-since", "2020-01", "-until", "2020-05", "-format", "csv", "-o", series}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(series)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows := strings.Count(string(data), ",total,"); rows != 3 {
+		t.Errorf("got %d total rows of the 3 revisions:\n%s", rows, data)
+	}
+
+	if _, err := parsePeriod("1fortnight"); err == nil {
+		t.Error("parsed a period of unknown units")
+	}
+	if p, err := parsePeriod("2weeks"); err != nil || p != (period{days: 14}) {
+		t.Errorf("parsed 2weeks as %+v, %v", p, err)
+	}
+}
+
+func TestTimeSeries(t *testing.T) {
+	records := []*historyRecord{
+		{Time: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), Modules: map[string]*moduleSummary{
+			"platform/core/intellij.platform.core.iml": {Packages: 4, Documented: 1, Files: 20, Java: 20},
+			"platform/util/intellij.platform.util.iml": {Packages: 2, Documented: 0, Files: 5, Java: 4, Kotlin: 1},
+			"plugins/git/intellij.git.iml":             {Packages: 9, Documented: 9, Files: 90},
+		}},
+		{Time: time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC), Modules: map[string]*moduleSummary{
+			"platform/core/intellij.platform.core.iml": {Packages: 4, Documented: 3, Files: 22, Java: 18, Kotlin: 4},
+			"platform/util/intellij.platform.util.iml": {Packages: 3, Documented: 1, Files: 7, Java: 4, Kotlin: 3},
+		}},
+	}
+	scope, err := globToRegexp("platform/**")
+	if err != nil {
+		t.Fatal(err)
+	}
+	points := timeSeries(records, scope)
+	var csv, svg strings.Builder
+	if err := writeSeriesCSV(&csv, points); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "series.csv", csv.String())
+	if err := writeChartSVG(&svg, points); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "chart.svg", svg.String())
+
+	data, err := chartPNG(records, scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img, err := png.Decode(bytes.NewReader(data)); err != nil || img.Bounds().Dx() != chartWidth {
+		t.Errorf("decoded the chart as %v, %v", img, err)
+	}
+	msg, err := emailMessage("a@b", []string{"c@d"}, "digest", "body\n", []emailAttachment{{"coverage.png", "image/png", data}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(msg), "multipart/mixed") || !strings.Contains(string(msg), `filename="coverage.png"`) {
+		t.Errorf("no chart attached to %.300s", msg)
+	}
+}
+
+func TestDiffRenames(t *testing.T) {
+	iml := &fstest.MapFile{Data: []byte(gitRepoModule)}
+	fsys := fstest.MapFS{
+		"p/a/intellij.a.iml":                  iml,
+		"p/a/src/com/a/old/Moved.java":        {Data: []byte("package com.a.old;\n")},
+		"p/a/src/com/a/old/package-info.java": {Data: []byte("/** Docs. */\npackage com.a.old;\n")},
+		"p/a/src/com/a/grown/G.java":          {Data: []byte("package com.a.grown;\n")},
+		"p/a/src/com/a/gone/A.java":           {Data: []byte("package com.a.gone;\n")},
+	}
+	scan := func() *snapshot {
+		pkgs, err := scanFS(fsys, "p", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return newSnapshot("p", pkgs)
+	}
+	base := scan()
+	delete(fsys, "p/a/src/com/a/old/Moved.java")
+	delete(fsys, "p/a/src/com/a/old/package-info.java")
+	delete(fsys, "p/a/src/com/a/gone/A.java")
+	fsys["p/a/src/com/a/moved/Moved.java"] = &fstest.MapFile{Data: []byte("package com.a.moved;\n")}
+	fsys["p/a/src/com/a/moved/package-info.java"] = &fstest.MapFile{Data: []byte("/** Docs. */\npackage com.a.moved;\n")}
+	fsys["p/a/src/com/a/grown/H.java"] = &fstest.MapFile{Data: []byte("package com.a.grown;\n")}
+	fsys["p/a/src/com/a/added/A.java"] = &fstest.MapFile{Data: []byte("package com.a.added;\n")} // the files of gone, but not a rename of it
+	fsys["p/a/src/com/a/added2/A.java"] = &fstest.MapFile{Data: []byte("package com.a.added2;\n")}
+	head := scan()
+
+	var got []string
+	diffs := diffBranches(base, head, true)
+	for _, d := range diffs {
+		got = append(got, fmt.Sprintf("%s %s->%s %s %d->%d %s", d.Dir, d.Base, d.Head, d.Change, d.BaseFiles, d.HeadFiles, d.RenamedFrom))
+	}
+	want := []string{
+		"a/src/com/a/added absent->undocumented  0->1 ",
+		"a/src/com/a/added2 absent->undocumented  0->1 ",
+		"a/src/com/a/gone undocumented->absent  1->0 ",
+		"a/src/com/a/grown undocumented->undocumented files 1->2 ",
+		"a/src/com/a/moved documented->documented  2->2 a/src/com/a/old",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got diffs\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if missing := diffBranches(base, head, false); len(missing) != 0 {
+		t.Errorf("the docs of the renamed package are missing: %v", missing)
+	}
+	if got, want := diffStats(diffs), "2 packages added, 1 removed, 1 renamed, 0 with the documentation changed, 1 with the number of files changed"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestBadges(t *testing.T) {
+	pkgs := scanFixture(t, basicFixture)
+	dir := t.TempDir()
+	if err := writeBadges(dir, pkgs); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"all.json", "intellij.platform.core.json", "intellij.platform.core.svg"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkGolden(t, "badges/"+name, string(data))
+	}
+}
+
+func TestGenPackageInfo(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"mod/mod.iml": `<module type="JAVA_MODULE" version="4"><component name="NewModuleRootManager"><content url="file://$MODULE_DIR$">` +
+			`<sourceFolder url="file://$MODULE_DIR$/src" isTestSource="false" /></content></component></module>`,
+		"mod/src/com/example/a/A.java":            "package com.example.a;\n",
+		"mod/src/com/example/a/b/B.java":          "package com.example.a.b;\n",
+		"mod/src/com/example/c/C.java":            "package com.example.c;\n",
+		"mod/src/com/example/k/K.kt":              "package com.example.k\n",
+		"mod/src/com/example/d/D.java":            "package com.example.d;\n",
+		"mod/src/com/example/d/package-info.java": "package com.example.d;\n",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pkgs, err := scanDir(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if n, err := genPackageInfos(&out, pkgs, "", "", true); err != nil || n != 3 {
+		t.Fatalf("dry run: %d stubs, %v:\n%s", n, err, out.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "mod/src/com/example/c/package-info.java")); !os.IsNotExist(err) {
+		t.Errorf("dry run created a stub: %v", err)
+	}
+
+	out.Reset()
+	if n, err := genPackageInfos(&out, pkgs, "com.example.a.*", "// Copyright", false); err != nil || n != 2 {
+		t.Fatalf("com.example.a.*: %d stubs, %v:\n%s", n, err, out.String())
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "mod/src/com/example/a/b/package-info.java"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "// Copyright\n/**\n * TODO: describe the package, what it is for and where to start.\n */\npackage com.example.a.b;\n"; string(data) != want {
+		t.Errorf("got\n%s\nwant\n%s", data, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "mod/src/com/example/c/package-info.java")); !os.IsNotExist(err) {
+		t.Errorf("created a stub of an unselected package: %v", err)
+	}
+	if _, err := genPackageInfos(&out, pkgs, "com.example.a", "", false); err == nil {
+		t.Error("overwrote an existing package-info.java")
+	}
+}
+
+func TestModuleDocs(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a/a.iml":            {},
+		"a/Readme.md":        {},
+		"b/b.iml":            {},
+		"b/docs/overview.md": {},
+		"c/c.iml":            {},
+		"c/docs/diagram.png": {},
+		"d/d.iml":            {},
+		"d/src/com/d/D.java": {},
+	}
+	pkgs := map[string]*pkg{}
+	for _, m := range []string{"a/a.iml", "b/b.iml", "c/c.iml", "d/d.iml"} {
+		pkgs[m+"/pkg"] = &pkg{module: m}
+	}
+	for module, want := range map[string]string{"a/a.iml": "a/Readme.md", "b/b.iml": "b/docs/overview.md", "c/c.iml": ""} {
+		if got, err := moduleDoc(fsys, module); err != nil || got != want {
+			t.Errorf("%s: got %q, %v, want %q", module, got, err, want)
+		}
+	}
+	findings, total, err := moduleDocFindings(fsys, pkgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 4 || len(findings) != 2 || findings[0].path != "c/c.iml" || findings[1].path != "d/d.iml" {
+		t.Errorf("got %d modules, findings %v", total, findings)
+	}
+}
+
+func TestDocRules(t *testing.T) {
+	src := func(content string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(content)} }
+	fsys := fstest.MapFS{
+		"p/m/m.iml": src(`<module type="JAVA_MODULE" version="4"><component name="NewModuleRootManager"><content url="file://$MODULE_DIR$">` +
+			`<sourceFolder url="file://$MODULE_DIR$/src" isTestSource="false" /></content></component></module>`),
+		"p/m/src/kt/A.kt":                      src("package kt\n"),
+		"p/m/src/kt/package.md":                src("# Package kt\n\nThe Kotlin APIs of the platform, start with A.\n"),
+		"p/m/src/kt/short/A.kt":                src("package kt.short\n"),
+		"p/m/src/kt/short/package.md":          src("# Package kt.short\n"),
+		"p/m/src/kt/nosection/A.kt":            src("package kt.nosection\n"),
+		"p/m/src/kt/nosection/package.md":      src("The Kotlin APIs without the heading, start with A.\n"),
+		"p/m/src/java/A.java":                  src("package java;\n"),
+		"p/m/src/java/package-info.java":       src("/**\n * The Java APIs.\n */\npackage java;\n"),
+		"p/m/src/java/short/A.java":            src("package java.short;\n"),
+		"p/m/src/java/short/package-info.java": src("/** Short. */\npackage java.short;\n"),
+		"p/m/src/java/legacy/A.java":           src("package java.legacy;\n"),
+		"p/m/src/java/legacy/package.html":     src("<p>Legacy</p>\n"),
+	}
+	defer func(rules []docRule) { cfg.DocRules = rules }(cfg.DocRules)
+	cfg.DocRules = []docRule{
+		{Language: "kotlin", Files: []string{"package.md"}, Sections: []string{"# Package"}, MinLength: 40},
+		{Language: "java", Files: []string{"package-info.java"}, MinLength: 10},
+	}
+	pkgs, err := scanFS(fsys, "p", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for dir, want := range map[string]string{
+		"p/m/src/kt":           "p/m/src/kt/package.md",
+		"p/m/src/kt/short":     "",
+		"p/m/src/kt/nosection": "",
+		"p/m/src/java":         "p/m/src/java/package-info.java",
+		"p/m/src/java/short":   "",
+		"p/m/src/java/legacy":  "p/m/src/java/legacy/package.html",
+	} {
+		if p := pkgs[dir]; p == nil || p.doc != want {
+			t.Errorf("%s: got %v, want doc %q", dir, p, want)
+		}
+	}
+	if p := pkgs["p/m/src/kt"]; p == nil || !p.isDocumented() {
+		t.Errorf("package.md does not document the Kotlin package")
+	}
+	if p := pkgs["p/m/src/java/legacy"]; p == nil || p.isDocumented() || !p.isLegacyDoc() {
+		t.Errorf("package.html is not the legacy doc")
+	}
+
+	cfg.DocRules = []docRule{{Files: []string{"[package.md"}}}
+	if _, err := scanFS(fsys, "p", false); err == nil {
+		t.Error("no error of a bad glob")
+	}
+}
+
+func TestConfigFlags(t *testing.T) {
+	defer func(c *config) { cfg = c }(cfg)
+	path := filepath.Join(t.TempDir(), "config.json")
+	config := `{"flags": {"j": 1000000, "api": ["public", "experimental"], "d": "from-config", "md": true, "link-style": "github"}}`
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(envVar("d"), "from-env")
+	t.Setenv(envVar("link-style"), "none")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	j := fs.Int("j", 1, "")
+	api := fs.String("api", "", "")
+	dir := fs.String("d", "", "")
+	md := fs.Bool("md", false, "")
+	linkStyle := fs.String("link-style", "space", "")
+	if err := parseFlags(fs, []string{"-config", path, "-link-style", "space"}); err != nil {
+		t.Fatal(err)
+	}
+	if *j != 1000000 || *api != "public,experimental" || !*md {
+		t.Errorf("config: got -j %d -api %q -md %v", *j, *api, *md)
+	}
+	if *dir != "from-env" {
+		t.Errorf("env over config: got -d %q", *dir)
+	}
+	if *linkStyle != "space" {
+		t.Errorf("command line over env and config: got -link-style %q", *linkStyle)
+	}
+
+	for _, bad := range []string{`{"flags": {"d": {"a": 1}}}`, `{"flags": {"d": [["a"]]}}`} {
+		if err := os.WriteFile(path, []byte(bad), 0644); err != nil {
+			t.Fatal(err)
+		}
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.String("d", "", "")
+		os.Unsetenv(envVar("d"))
+		if err := parseFlags(fs, []string{"-config", path}); err == nil {
+			t.Errorf("%s: no error", bad)
+		}
+	}
+}
+
+func TestGraphQL(t *testing.T) {
+	fields, err := parseGraphQL(`query Q { first: packages(doc: "missing", minFiles: 1, limit: 1000000) { name } }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := fields[0]; f.alias != "first" || f.name != "packages" || f.args["doc"] != "missing" || f.args["limit"] != 1000000 || len(f.selection) != 1 {
+		t.Errorf("got %+v", f)
+	}
+	deep := "{ packages { module { packages { module { packages { module { packages { module { name } } } } } } } } }"
+	for _, bad := range []string{"{ packages { name }", "{ packages(limit: $n) { name } }", "{ 1 }", deep} {
+		if _, err := parseGraphQL(bad); err == nil {
+			t.Errorf("%s: no error", bad)
+		}
+	}
+
+	d := newDaemon(basicFixture, false)
+	d.pkgs = scanFixture(t, basicFixture)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { d.handleGraphQL(w, r, "") }))
+	defer srv.Close()
+	post := func(body string) (int, string) {
+		t.Helper()
+		resp, err := http.Post(srv.URL, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+	query, _ := json.Marshal(map[string]string{"query": `{ packages(doc: "legacy", limit: 1000000) { name module { name packagesCount } } ` +
+		`core: package(dir: "platform/core/src/com/intellij/core") { documented } }`})
+	code, got := post(string(query))
+	want := `{"data":{"packages":[{"name":"com.intellij.util","module":{"name":"intellij.platform.util","packagesCount":3}}],"core":{"documented":true}}}`
+	var gotJSON, wantJSON interface{}
+	json.Unmarshal([]byte(got), &gotJSON)
+	json.Unmarshal([]byte(want), &wantJSON)
+	if code != http.StatusOK || !reflect.DeepEqual(gotJSON, wantJSON) {
+		t.Errorf("got %d %s, want %s", code, got, want)
+	}
+	if code, got := post(`{"query": "{ packages { nope } }"}`); code != http.StatusOK || !strings.Contains(got, `"errors"`) {
+		t.Errorf("unknown field: got %d %s", code, got)
+	}
+	if code, _ := post(`{"query": "` + strings.Repeat(" ", maxGraphQLBody) + `"}`); code != http.StatusBadRequest {
+		t.Errorf("too large body: got %d", code)
+	}
+}
+
+func TestQodanaRerun(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "qodana.sarif.json")
+	qodana := `{"version": "2.1.0", "runs": [{"tool": {"driver": {"name": "QDJVM", "rules": [{"id": "UnusedSymbol"}]}},
+		"results": [{"ruleId": "UnusedSymbol", "level": "warning", "message": {"text": "unused"}}]}]}`
+	if err := os.WriteFile(path, []byte(qodana), 0644); err != nil {
+		t.Fatal(err)
+	}
+	findings := []finding{
+		{rule: "UndocumentedPackage", level: "warning", message: "no docs", path: "a"},
+		{rule: "UndocumentedPackage", level: "warning", message: "no docs", path: "b"},
+	}
+	for i := 0; i < 2; i++ {
+		if err := writeQodana(dir, findings); err != nil {
+			t.Fatal(err)
+		}
+	}
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log struct {
+		Runs []struct {
+			Tool struct {
+				Driver     sarifComponent   `json:"driver"`
+				Extensions []sarifComponent `json:"extensions"`
+			} `json:"tool"`
+			Results []sarifResult `json:"results"`
+		} `json:"runs"`
+	}
+	if err := json.Unmarshal(blob, &log); err != nil {
+		t.Fatal(err)
+	}
+	run := log.Runs[0]
+	if run.Tool.Driver.Name != "QDJVM" || len(run.Tool.Extensions) != 1 || run.Tool.Extensions[0].Name != toolName {
+		t.Errorf("got tool %+v", run.Tool)
+	}
+	if len(run.Results) != 3 || run.Results[0].RuleID != "UnusedSymbol" {
+		t.Errorf("got %d results, want the Qodana one and ours once: %+v", len(run.Results), run.Results)
+	}
+}
+
+func TestRuleSeverity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	rules := `{"rules": [{"name": "Big", "condition": "files >= 2", "severity": "info"}, {"name": "Kotlin", "condition": "kt > 0"}]}`
+	if err := os.WriteFile(path, []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := readConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings, err := ruleFindings(c.Rules, scanFixture(t, basicFixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	levels := map[string]string{}
+	for _, f := range findings {
+		levels[f.rule] = f.level
+	}
+	if levels["Big"] != "note" || levels["Kotlin"] != "warning" {
+		t.Errorf("got levels %v, want note for info and warning by default", levels)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"rules": [{"name": "Big", "condition": "files >= 2", "severity": "fatal"}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readConfig(path); err == nil {
+		t.Error("no error of an unknown rule severity")
+	}
+}
+
+func TestRedisReply(t *testing.T) {
+	for _, tc := range []struct {
+		reply string
+		want  interface{}
+		err   error
+	}{
+		{"+OK\r\n", "OK", nil},
+		{":42\r\n", int64(42), nil},
+		{"$5\r\nhe\r\no\r\n", "he\r\no", nil},
+		{"$-1\r\n", nil, redisNil},
+		{"*3\r\n$1\r\na\r\n$-1\r\n:1\r\n", []interface{}{"a", nil, int64(1)}, nil},
+		{"-ERR wrong\r\n", nil, redisError("ERR wrong")},
+	} {
+		got, err := readRedisReply(bufio.NewReader(strings.NewReader(tc.reply)))
+		if err != tc.err || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: got %#v, %v, want %#v, %v", tc.reply, got, err, tc.want, tc.err)
+		}
+	}
+	for _, bad := range []string{"?\r\n", "$5\r\nab", ""} {
+		if _, err := readRedisReply(bufio.NewReader(strings.NewReader(bad))); err == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+}
+
+func TestRedisReconnect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	commands := make(chan string, 8)
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			r := bufio.NewReader(conn)
+			for {
+				args, err := readRedisReply(r) // a command is an array of bulk strings
+				if err != nil {
+					break
+				}
+				list, _ := args.([]interface{})
+				commands <- fmt.Sprint(list...)
+				if i == 0 { // drops the first connection after the first command
+					break
+				}
+				conn.Write([]byte("+PONG\r\n"))
+			}
+			conn.Close()
+		}
+	}()
+
+	c := &redisClient{addr: l.Addr().String(), password: "secret"}
+	for i := 0; i < 2; i++ {
+		if reply, err := c.do("PING"); err != nil || reply != "PONG" {
+			t.Fatalf("ping %d: got %v, %v", i, reply, err)
+		}
+	}
+	c.close()
+	var got []string
+	for len(commands) > 0 {
+		got = append(got, <-commands)
+	}
+	want := []string{"AUTHsecret", "AUTHsecret", "PING", "PING"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got commands %q, want %q", got, want)
+	}
+}
+
+func TestUploads(t *testing.T) {
+	ns, err := newNamespaces("", "", newDaemon(basicFixture, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns.token = "s3cret"
+	ns.historyPath = filepath.Join(t.TempDir(), "history.jsonl")
+	srv := httptest.NewServer(http.HandlerFunc(ns.handleSnapshots))
+	defer srv.Close()
+
+	snap, err := json.Marshal(newSnapshot("platform", scanFixture(t, basicFixture)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(snap)
+	zw.Close()
+	upload := func(auth string, body []byte, gzipped bool) (int, uploadResult) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPut, srv.URL+"/api/snapshots/idea/241", bytes.NewReader(body))
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		if gzipped {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var res uploadResult
+		json.NewDecoder(resp.Body).Decode(&res)
+		return resp.StatusCode, res
+	}
+
+	for _, auth := range []string{"", "s3cret", "Basic s3cret", "Bearer wrong"} {
+		if code, _ := upload(auth, snap, false); code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: got %d, want 401", auth, code)
+		}
+	}
+	ns.token = "" // no upload-token secret
+	for _, auth := range []string{"", "Bearer "} {
+		if code, _ := upload(auth, snap, false); code != http.StatusForbidden {
+			t.Errorf("no token, Authorization %q: got %d, want 403", auth, code)
+		}
+	}
+	ns.token = "s3cret"
+	if code, _ := upload("Bearer s3cret", []byte("not gzip"), true); code != http.StatusBadRequest {
+		t.Errorf("bad gzip: got %d, want 400", code)
+	}
+	func() {
+		defer func(max int64) { maxSnapshotSize = max }(maxSnapshotSize)
+		maxSnapshotSize = int64(len(snap)) / 2
+		if code, _ := upload("Bearer s3cret", snap, false); code != http.StatusBadRequest {
+			t.Errorf("too large: got %d, want 400", code)
+		}
+		maxSnapshotSize = int64(gz.Len())
+		if code, _ := upload("Bearer s3cret", gz.Bytes(), true); code != http.StatusBadRequest {
+			t.Errorf("too large when gunzipped: got %d, want 400", code)
+		}
+	}()
+
+	// concurrent identical uploads, one of them is recorded
+	codes := make(chan int, 4)
+	for i := 0; i < cap(codes); i++ {
+		go func() {
+			code, _ := upload("Bearer s3cret", gz.Bytes(), true)
+			codes <- code
+		}()
+	}
+	created := 0
+	for i := 0; i < cap(codes); i++ {
+		switch code := <-codes; code {
+		case http.StatusCreated:
+			created++
+		case http.StatusOK:
+		default:
+			t.Errorf("concurrent upload: got %d", code)
+		}
+	}
+	if created != 1 {
+		t.Errorf("got %d uploads created, want 1", created)
+	}
+	if code, res := upload("Bearer s3cret", snap, false); code != http.StatusOK || !res.Unchanged || res.Packages == 0 {
+		t.Errorf("same upload: got %d %+v, want unchanged", code, res)
+	}
+	records, err := readHistory(ns.historyPath)
+	if err != nil || len(records) != 1 || records[0].Namespace != "idea/241" {
+		t.Errorf("got history %v, %v, want a record of idea/241", records, err)
+	}
+
+	// anyone with -insecure-uploads
+	ns.token, ns.insecure = "", true
+	if code, _ := upload("", snap, false); code != http.StatusOK {
+		t.Errorf("-insecure-uploads: got %d, want 200", code)
+	}
+}
+
+func TestSaveSQLite(t *testing.T) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("no sqlite3")
+	}
+	defer func() { sourceExts = defaultSourceExts }()
+	if err := setSourceExts(".java,.kt,.scala,.kts"); err != nil {
+		t.Fatal(err)
+	}
+	pkgs := scanFixture(t, basicFixture)
+	path := filepath.Join(t.TempDir(), "scan.db")
+	for i := 0; i < 2; i++ { // over the previous one
+		if err := saveSQLite(path, nil, pkgs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out, err := exec.Command("sqlite3", path, "select sum(java_files), sum(kt_files), sum(scala_files), sum(kts_files) from packages").CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	var want [4]int
+	for _, p := range pkgs {
+		for i, ext := range sourceExts {
+			want[i] += p.filesCnt[ext]
+		}
+	}
+	if got := strings.TrimSpace(string(out)); got != fmt.Sprintf("%d|%d|%d|%d", want[0], want[1], want[2], want[3]) {
+		t.Errorf("got the files counts %s, want %v", got, want)
+	}
+	saved, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a failing sqlite3 leaves the previous database and no temp files
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "sqlite3"), []byte("#!/bin/sh\ncat > /dev/null\necho broken >&2\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+	if err := saveSQLite(path, nil, pkgs); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("got %v, want the sqlite3 error", err)
+	}
+	if got, err := os.ReadFile(path); err != nil || !bytes.Equal(got, saved) {
+		t.Errorf("the previous database is gone: %v", err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*")); len(files) != 1 {
+		t.Errorf("got %v, want the database only", files)
+	}
+}
+
+func TestKafkaEncoding(t *testing.T) {
+	// the cases of testMurmur2 in the UtilsTest of the Kafka clients
+	for key, want := range map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	} {
+		if got := int32(murmur2([]byte(key))); got != want {
+			t.Errorf("murmur2(%q) = %d, want %d", key, got, want)
+		}
+	}
+
+	// the check value of CRC-32C, as of RFC 3720
+	if got := crc32.Checksum([]byte("123456789"), crc32.MakeTable(crc32.Castagnoli)); got != 0xe3069283 {
+		t.Errorf("CRC-32C = %#x, want 0xe3069283", got)
+	}
+
+	first := time.UnixMilli(1700000000000)
+	batch := encodeRecordBatch([]kafkaRecord{
+		{key: []byte("k"), value: []byte("v"), time: first},
+		{key: []byte("key"), value: []byte("value"), time: first.Add(5 * time.Millisecond)},
+	})
+	want := strings.Join([]string{ // by the record batch v2 of the Kafka protocol
+		"0000000000000000",                     // base offset
+		"00000049",                             // length
+		"ffffffff",                             // partition leader epoch
+		"02",                                   // magic
+		"9a68c368",                             // CRC-32C of the rest
+		"0000",                                 // attributes
+		"00000001",                             // last offset delta
+		"0000018bcfe56800", "0000018bcfe56805", // first and max timestamps
+		"ffffffffffffffff", "ffff", "ffffffff", // producer ID, epoch and base sequence
+		"00000002",                                           // records
+		"10", "00", "00", "00", "02", "6b", "02", "76", "00", // length, attributes, deltas, key, value, headers
+		"1c", "00", "0a", "02", "06", "6b6579", "0a", "76616c7565", "00",
+	}, "")
+	if got := hex.EncodeToString(batch); got != want {
+		t.Errorf("got the record batch\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestBigQuerySink(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var inserted []string // insert IDs
+	insertErrors := ""
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+			http.Error(w, "bad grant_type", http.StatusBadRequest)
+			return
+		}
+		parts := strings.Split(r.FormValue("assertion"), ".")
+		if len(parts) != 3 {
+			http.Error(w, "bad assertion", http.StatusBadRequest)
+			return
+		}
+		sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, hash[:], sig); err != nil {
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+		var claims struct {
+			Iss, Scope, Aud string
+			Iat, Exp        int64
+		}
+		blob, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		json.Unmarshal(blob, &claims)
+		if claims.Iss != "jet-search@eng-metrics.iam.gserviceaccount.com" || claims.Aud != "http://"+r.Host+"/token" ||
+			claims.Scope != "https://www.googleapis.com/auth/bigquery.insertdata" || claims.Exp-claims.Iat != 3600 {
+			http.Error(w, fmt.Sprintf("bad claims %+v", claims), http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"access_token": "ya29.token", "token_type": "Bearer", "expires_in": 3599}`)
+	})
+	mux.HandleFunc("/bigquery/v2/projects/eng-metrics/datasets/docs/tables/packages/insertAll", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer ya29.token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var req struct {
+			Rows []struct {
+				InsertID string     `json:"insertId"`
+				JSON     packageRow `json:"json"`
+			}
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, row := range req.Rows {
+			inserted = append(inserted, row.InsertID)
+		}
+		if insertErrors != "" {
+			fmt.Fprint(w, insertErrors)
+			return
+		}
+		fmt.Fprint(w, `{"kind": "bigquery#tableDataInsertAllResponse"}`)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	defer func(u string) { bigQueryURL = u }(bigQueryURL)
+	bigQueryURL = srv.URL
+
+	credentials := filepath.Join(t.TempDir(), "sa.json")
+	blob, _ := json.Marshal(serviceAccountKey{
+		ClientEmail: "jet-search@eng-metrics.iam.gserviceaccount.com",
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		TokenURI:    srv.URL + "/token",
+	})
+	if err := os.WriteFile(credentials, blob, 0600); err != nil {
+		t.Fatal(err)
+	}
+	sk, err := newBigQuerySink(json.RawMessage(`{"project": "eng-metrics", "dataset": "docs", "table": "packages", "credentials": "` + credentials + `"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newSnapshot("platform", scanFixture(t, basicFixture))
+	if err := sk.publish(s, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(inserted) != len(s.pkgs) {
+		t.Errorf("inserted %d rows, want %d", len(inserted), len(s.pkgs))
+	}
+	first := append([]string(nil), inserted...)
+	inserted = nil
+	if err := sk.publish(s, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(inserted, first) {
+		t.Errorf("a retried publish has the insert IDs %v, want the same %v", inserted, first)
+	}
+
+	insertErrors = `{"insertErrors": [{"index": 1, "errors": [{"reason": "invalid", "message": "no such field: lines"}]}]}`
+	err = sk.publish(s, nil)
+	if want := "1 rows not inserted, i.e " + sortedPackages(s.pkgs)[1].pkgDir + ": invalid: no such field: lines"; err == nil || err.Error() != want {
+		t.Errorf("got %v, want %s", err, want)
+	}
+
+	sk.(*bigQueryClient).key.ClientEmail = "someone@else.iam.gserviceaccount.com"
+	if err := sk.publish(s, nil); err == nil || !strings.Contains(err.Error(), "token: 401") {
+		t.Errorf("got %v, want the token refused", err)
+	}
+}
+
+func TestClickHouseSink(t *testing.T) {
+	t.Setenv("JET_SEARCH_CLICKHOUSE_PASSWORD", "s3cret")
+	defer func() { secrets.resolved = nil }()
+	var rows []fileRow
+	fail := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-ClickHouse-User") != "jet-search" || r.Header.Get("X-ClickHouse-Key") != "s3cret" {
+			http.Error(w, "Code: 516. DB::Exception: jet-search: Authentication failed", http.StatusUnauthorized)
+			return
+		}
+		if q := r.URL.Query(); q.Get("query") != "INSERT INTO docs.files FORMAT JSONEachRow" || q.Get("date_time_input_format") != "best_effort" {
+			http.Error(w, "unexpected query "+r.URL.RawQuery, http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("Content-Encoding") != "gzip" {
+			http.Error(w, "not gzipped", http.StatusBadRequest)
+			return
+		}
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		dec := json.NewDecoder(zr)
+		for dec.More() {
+			var row fileRow
+			if err := dec.Decode(&row); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			rows = append(rows, row)
+		}
+		if fail {
+			http.Error(w, "Code: 60. DB::Exception: Table docs.files does not exist.", http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	sk, err := newClickHouseSink(json.RawMessage(`{"url": "` + srv.URL + `/", "database": "docs", "table": "files", "user": "jet-search"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newSnapshot("platform", scanFixture(t, basicFixture))
+	if err := sk.publish(s, nil); err != nil {
+		t.Fatal(err)
+	}
+	files := 0
+	for _, p := range s.pkgs {
+		files += len(p.files)
+	}
+	if len(rows) != files {
+		t.Errorf("inserted %d rows, want %d", len(rows), files)
+	}
+	for _, row := range rows {
+		if row.ScanTime != s.time.Format(time.RFC3339) || row.Dir != "platform" || row.File == "" || row.Ext != filepath.Ext(row.File) {
+			t.Errorf("bad row %+v", row)
+		}
+	}
+
+	fail = true
+	if err := sk.publish(s, nil); err == nil || err.Error() != "INSERT INTO docs.files: 404 Not Found: Code: 60. DB::Exception: Table docs.files does not exist." {
+		t.Errorf("got %v, want the ClickHouse exception", err)
+	}
+	sk.(*clickHouseClient).password = "wrong"
+	if err := sk.publish(s, nil); err == nil || !strings.Contains(err.Error(), "401 Unauthorized") {
+		t.Errorf("got %v, want the authentication failed", err)
+	}
+}
+
+func TestElasticSink(t *testing.T) {
+	t.Setenv("JET_SEARCH_ELASTICSEARCH_PASSWORD", "s3cret")
+	defer func() { secrets.resolved = nil }()
+	indices := map[string]int{} // name -> documents
+	var created []string
+	failID := ""
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "jet-search" || password != "s3cret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		switch {
+		case r.Method == http.MethodHead:
+			if _, ok := indices[name]; !ok {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case r.Method == http.MethodPut:
+			var mappings struct {
+				Mappings struct{ Properties map[string]interface{} }
+			}
+			if err := json.NewDecoder(r.Body).Decode(&mappings); err != nil || len(mappings.Mappings.Properties) == 0 {
+				http.Error(w, fmt.Sprintf("bad mappings: %v", err), http.StatusBadRequest)
+				return
+			}
+			indices[name] = 0
+			created = append(created, name)
+		case r.Method == http.MethodPost && name == "_bulk":
+			if r.Header.Get("Content-Type") != "application/x-ndjson" {
+				http.Error(w, "not ndjson", http.StatusNotAcceptable)
+				return
+			}
+			var items []map[string]interface{}
+			failed := false
+			dec := json.NewDecoder(r.Body)
+			for dec.More() {
+				var action map[string]map[string]string
+				var source map[string]interface{}
+				if err := dec.Decode(&action); err != nil || dec.Decode(&source) != nil {
+					http.Error(w, "bad bulk", http.StatusBadRequest)
+					return
+				}
+				index, id := action["index"]["_index"], action["index"]["_id"]
+				result := map[string]interface{}{"_index": index, "_id": id, "status": 201}
+				if _, ok := indices[index]; !ok || id == failID {
+					result["status"] = 400
+					result["error"] = map[string]string{"type": "mapper_parsing_exception", "reason": "failed to parse"}
+					failed = true
+				} else {
+					indices[index]++
+				}
+				items = append(items, map[string]interface{}{"index": result})
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"errors": failed, "items": items})
+		default:
+			http.Error(w, "unexpected "+r.Method+" "+r.URL.Path, http.StatusMethodNotAllowed)
+		}
+	}))
+	defer srv.Close()
+
+	sk, err := newElasticSink(json.RawMessage(`{"url": "` + srv.URL + `", "index": "jet-search", "user": "jet-search"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newSnapshot("platform", scanFixture(t, basicFixture))
+	files := 0
+	for _, p := range s.pkgs {
+		files += len(p.files)
+	}
+	for i := 0; i < 2; i++ { // the indices are created once, the documents are replaced by their IDs
+		if err := sk.publish(s, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []string{"jet-search-packages", "jet-search-files"}; !reflect.DeepEqual(created, want) {
+		t.Errorf("created the indices %v, want %v", created, want)
+	}
+	if got, want := indices, map[string]int{"jet-search-packages": 2 * len(s.pkgs), "jet-search-files": 2 * files}; !reflect.DeepEqual(got, want) {
+		t.Errorf("indexed %v, want %v", got, want)
+	}
+
+	p := sortedPackages(s.pkgs)[0]
+	failID = elasticID(s.time.Format(time.RFC3339), p.pkgDir)
+	err = sk.publish(s, nil)
+	if want := fmt.Sprintf("1 of %d documents not indexed in jet-search-packages, i.e mapper_parsing_exception: failed to parse", len(s.pkgs)); err == nil || err.Error() != want {
+		t.Errorf("got %v, want %s", err, want)
+	}
+}
+
+func TestDaemonSocket(t *testing.T) {
+	runDir := t.TempDir()
+	if err := os.Chmod(runDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("XDG_RUNTIME_DIR", runDir)
+	socket := filepath.Join(runDir, "jet-search.sock")
+	if _, err := listenUnix(socket); err == nil || !strings.Contains(err.Error(), "not a private dir") {
+		t.Errorf("got %v, want the runtime dir refused", err)
+	}
+	if err := os.Chmod(runDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	// a stale socket is replaced, a live one or another file is not
+	l, err := listenUnix(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	if l, err = listenUnix(socket); err != nil {
+		t.Fatalf("stale socket: %v", err)
+	}
+	defer l.Close()
+	if _, err := listenUnix(socket); err == nil || !strings.Contains(err.Error(), "already listening") {
+		t.Errorf("got %v, want the live socket kept", err)
+	}
+	file := filepath.Join(runDir, "file")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listenUnix(file); err == nil || !strings.Contains(err.Error(), "not a socket") {
+		t.Errorf("got %v, want the file kept", err)
+	}
+
+	// squatted by another user, with the right mode, can only be set up by root
+	if os.Getuid() == 0 && runtime.GOOS != "windows" {
+		squatted := filepath.Join(t.TempDir(), "jet-search-0")
+		if err := os.Mkdir(squatted, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chown(squatted, 65534, 65534); err != nil {
+			t.Fatal(err)
+		}
+		if err := privateDir(squatted); err == nil || !strings.Contains(err.Error(), "owned by another user") {
+			t.Errorf("got %v, want the dir of another user refused", err)
+		}
+	}
+
+	d := newDaemon(basicFixture, false)
+	for _, name := range []string{"../heap.pprof", "/tmp/heap.pprof", "..", ""} {
+		if err := d.dumpProfile(io.Discard, []string{name}); err == nil {
+			t.Errorf("dump-profile %q: want an error", name)
+		}
+	}
+	var out bytes.Buffer
+	if err := d.dumpProfile(&out, []string{"heap.pprof"}); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(runDir, "profiles", "heap.pprof")
+	if fi, err := os.Stat(path); err != nil || fi.Size() == 0 || out.String() != "heap profile written to "+path+"\n" {
+		t.Errorf("got %q, %v, want the profile in %s", out.String(), err, path)
+	}
+}
+
+func TestICalFolding(t *testing.T) {
+	for _, line := range []string{
+		"SUMMARY:short",
+		"DESCRIPTION:" + strings.Repeat("x", 63),
+		"DESCRIPTION:" + strings.Repeat("x", 64),
+		"DESCRIPTION:" + strings.Repeat("документация, ", 20),
+		"SUMMARY:" + strings.Repeat("文档", 40),
+	} {
+		var b strings.Builder
+		writeICalLine(&b, line)
+		folded := b.String()
+		if !strings.HasSuffix(folded, "\r\n") {
+			t.Errorf("%q: no CRLF at the end", folded)
+		}
+		lines := strings.Split(strings.TrimSuffix(folded, "\r\n"), "\r\n")
+		for i, l := range lines {
+			if len(l) > 75 || !utf8.ValidString(l) || (i > 0) != strings.HasPrefix(l, " ") {
+				t.Errorf("bad folded line %q of %q", l, line)
+			}
+		}
+		if got := strings.ReplaceAll(folded, "\r\n ", ""); got != line+"\r\n" {
+			t.Errorf("unfolded %q, want %q", got, line)
+		}
+	}
+}
+
+// thriftReader decodes the structs of the Thrift compact protocol into the values by the field ids, for TestParquet.
+type thriftReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *thriftReader) byte() byte {
+	if r.pos >= len(r.data) {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	r.pos++
+	return r.data[r.pos-1]
+}
+
+func (r *thriftReader) varint() uint64 {
+	var v uint64
+	for shift := 0; r.err == nil; shift += 7 {
+		b := r.byte()
+		v |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			break
+		}
+	}
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(kind byte) interface{} {
+	switch kind {
+	case 1, 2: // bool in the field header
+		return kind == 1
+	case thriftI32, thriftI64, 4: // and i16
+		return r.zigzag()
+	case thriftBinary:
+		n := int(r.varint())
+		if r.err != nil || r.pos+n > len(r.data) {
+			r.err = io.ErrUnexpectedEOF
+			return ""
+		}
+		r.pos += n
+		return string(r.data[r.pos-n : r.pos])
+	case thriftList:
+		header := r.byte()
+		size := int(header >> 4)
+		if size == 15 {
+			size = int(r.varint())
+		}
+		var list []interface{}
+		for i := 0; i < size && r.err == nil; i++ {
+			list = append(list, r.value(header&0x0f))
+		}
+		return list
+	case thriftStruct:
+		return r.structure()
+	}
+	r.err = fmt.Errorf("unexpected type %d at %d", kind, r.pos)
+	return nil
+}
+
+func (r *thriftReader) structure() map[int]interface{} {
+	fields := map[int]interface{}{}
+	id := 0
+	for r.err == nil {
+		header := r.byte()
+		if header == 0 {
+			break
+		}
+		if delta := int(header >> 4); delta != 0 {
+			id += delta
+		} else {
+			id = int(r.zigzag())
+		}
+		fields[id] = r.value(header & 0x0f)
+	}
+	return fields
+}
+
+func TestParquet(t *testing.T) {
+	withFormat(t, false, false, false)
+	s := newSnapshot("platform", scanFixture(t, basicFixture))
+	var b bytes.Buffer
+	if err := writeParquet(&b, packagesParquetColumns(s), len(s.pkgs)); err != nil {
+		t.Fatal(err)
+	}
+	file := b.Bytes()
+	if len(file) < 12 || string(file[:4]) != "PAR1" || string(file[len(file)-4:]) != "PAR1" {
+		t.Fatalf("no PAR1 magic around the file")
+	}
+	footerLen := int(bin.LittleEndian.Uint32(file[len(file)-8:]))
+	footerStart := len(file) - 8 - footerLen
+	if footerStart < 4 {
+		t.Fatalf("footer length %d out of the file of %d bytes", footerLen, len(file))
+	}
+	r := &thriftReader{data: file[:len(file)-8], pos: footerStart}
+	meta := r.structure()
+	if r.err != nil || r.pos != len(file)-8 {
+		t.Fatalf("footer of %d bytes decoded to %d: %v", footerLen, r.pos-footerStart, r.err)
+	}
+	if meta[3] != int64(len(s.pkgs)) {
+		t.Errorf("num_rows %v, want %d", meta[3], len(s.pkgs))
+	}
+
+	var names []string
+	types := map[string]int64{}
+	for _, e := range meta[2].([]interface{})[1:] { // after the root
+		el := e.(map[int]interface{})
+		name := el[4].(string)
+		names, types[name] = append(names, name), el[1].(int64)
+	}
+	wantNames := []string{"scan_time", "dir", "module", "package", "pkg_dir", "doc", "documented", "api", "files", "java_files", "kt_files"}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("got the columns %v, want %v", names, wantNames)
+	}
+	if types["package"] != parquetByteArray || types["documented"] != parquetBoolean || types["files"] != parquetInt32 {
+		t.Errorf("got the column types %v", types)
+	}
+
+	rowGroups := meta[4].([]interface{})
+	columns := rowGroups[0].(map[int]interface{})[1].([]interface{})
+	if len(columns) != len(wantNames) {
+		t.Fatalf("got %d column chunks, want %d", len(columns), len(wantNames))
+	}
+	for i, c := range columns {
+		cm := c.(map[int]interface{})[3].(map[int]interface{})
+		offset, size := int(cm[9].(int64)), int(cm[7].(int64))
+		if path := cm[3].([]interface{}); len(path) != 1 || path[0] != wantNames[i] {
+			t.Errorf("column %d: path_in_schema %v, want %s", i, path, wantNames[i])
+		}
+		if offset < 4 || offset+size > footerStart {
+			t.Errorf("%s: the chunk at %d of %d bytes is out of the data", wantNames[i], offset, size)
+			continue
+		}
+		pr := &thriftReader{data: file[:footerStart], pos: offset}
+		page := pr.structure()
+		data := page[5].(map[int]interface{})
+		if pr.err != nil || page[1] != int64(0) || data[1] != int64(len(s.pkgs)) {
+			t.Errorf("%s: no data page header at %d: %v %v", wantNames[i], offset, page, pr.err)
+			continue
+		}
+		if got := pr.pos - offset + int(page[3].(int64)); got != size {
+			t.Errorf("%s: the page of %d bytes, want the chunk size %d", wantNames[i], got, size)
+		}
+		if wantNames[i] == "package" { // PLAIN byte arrays
+			var got, want []string
+			for values := file[pr.pos : offset+size]; len(values) >= 4; {
+				n := int(bin.LittleEndian.Uint32(values))
+				got, values = append(got, string(values[4:4+n])), values[4+n:]
+			}
+			for _, p := range sortedPackages(s.pkgs) {
+				want = append(want, p.name)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got the packages %v, want %v", got, want)
+			}
+		}
+	}
+}
+
+func TestJiraSink(t *testing.T) {
+	t.Setenv("JET_SEARCH_JIRA_TOKEN", "s3cret")
+	defer func() { secrets.resolved = nil }()
+	s := newSnapshot("platform", scanFixture(t, basicFixture))
+	var findings []finding
+	modules := map[string]bool{}
+	for _, p := range sortedPackages(s.pkgs) {
+		if !p.isDocumented() {
+			findings = append(findings, finding{rule: "UndocumentedPackage", level: "warning", path: p.pkgDir})
+			modules[p.module] = true
+		}
+	}
+	if len(modules) < 3 {
+		t.Fatalf("want undocumented packages in 3 modules at least, got %v", modules)
+	}
+	var names []string
+	for m := range modules {
+		names = append(names, m)
+	}
+	sort.Strings(names)
+	openSummary, _ := jiraIssue(names[0], nil)                                                   // filed before
+	findings = append(findings, finding{rule: "UndocumentedPackage", path: "", suppressed: "x"}) // ignored
+
+	var created []map[string]interface{}
+	requests, failCreate := 0, false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if user, token, ok := r.BasicAuth(); !ok || user != "jet-search@example.com" || token != "s3cret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/rest/api/2/search":
+			if jql := r.URL.Query().Get("jql"); jql != `project = "DOC" AND labels = "jet-search" AND statusCategory != Done` {
+				http.Error(w, "unexpected jql "+jql, http.StatusBadRequest)
+				return
+			}
+			// a page of an issue each
+			if r.URL.Query().Get("startAt") == "0" {
+				fmt.Fprintf(w, `{"total": 2, "issues": [{"fields": {"summary": %q}}]}`, openSummary)
+			} else {
+				fmt.Fprint(w, `{"total": 2, "issues": [{"fields": {"summary": "Something else"}}]}`)
+			}
+		case r.Method == http.MethodPost && r.URL.Path == "/rest/api/2/issue":
+			if failCreate {
+				http.Error(w, `{"errorMessages": [], "errors": {"issuetype": "issue type is required"}}`, http.StatusBadRequest)
+				return
+			}
+			var req struct{ Fields map[string]interface{} }
+			json.NewDecoder(r.Body).Decode(&req)
+			created = append(created, req.Fields)
+			fmt.Fprintf(w, `{"id": "1000%d", "key": "DOC-%d"}`, len(created), len(created))
+		default:
+			http.Error(w, "unexpected "+r.Method+" "+r.URL.Path, http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	sk, err := newJiraSink(json.RawMessage(`{"url": "` + srv.URL + `/", "project": "DOC", "user": "jet-search@example.com"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := sk.(*jiraClient)
+
+	j.dryRun = true
+	out := captureStdout(t, func() {
+		if err := sk.publish(s, findings); err != nil {
+			t.Error(err)
+		}
+	})
+	if requests != 0 || strings.Count(out, "Document packages of ") != len(names) {
+		t.Errorf("dry run: %d requests, printed\n%s", requests, out)
+	}
+
+	j.dryRun = false
+	out = captureStdout(t, func() {
+		if err := sk.publish(s, findings); err != nil {
+			t.Error(err)
+		}
+	})
+	if len(created) != len(names)-1 {
+		t.Fatalf("created %d issues, want one per module but the open one, %d", len(created), len(names)-1)
+	}
+	for i, fields := range created {
+		summary, _ := jiraIssue(names[i+1], nil)
+		if fields["summary"] != summary || fields["issuetype"].(map[string]interface{})["name"] != "Task" ||
+			fields["project"].(map[string]interface{})["key"] != "DOC" || !reflect.DeepEqual(fields["labels"], []interface{}{"jet-search"}) {
+			t.Errorf("issue %d: got %v", i, fields)
+		}
+	}
+	if want := fmt.Sprintf("DOC-1\t%s\t%s/browse/DOC-1\n", names[1], srv.URL); !strings.HasPrefix(out, want) {
+		t.Errorf("got\n%s\nwant it to start with\n%s", out, want)
+	}
+
+	failCreate = true
+	err = sk.publish(s, findings)
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("error filing the issue for %q: POST /rest/api/2/issue: 400 Bad Request", names[1])) {
+		t.Errorf("got %v, want the create error", err)
+	}
+	j.token = "wrong"
+	if err := sk.publish(s, findings); err == nil || !strings.Contains(err.Error(), "401 Unauthorized") {
+		t.Errorf("got %v, want the search unauthorized", err)
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	d := newDaemon(basicFixture, false)
+	d.origins = []string{"https://dashboard.example.com"}
+	srv := httptest.NewServer(http.HandlerFunc(d.handleEvents))
+	defer srv.Close()
+	host := srv.Listener.Addr().String()
+
+	for origin, want := range map[string]string{
+		"":                              "101",
+		"http://" + host:                "101",
+		"https://dashboard.example.com": "101",
+		"https://evil.example.com":      "403",
+		"http://" + host + ".evil.com":  "403",
+		"null":                          "403",
+	} {
+		conn, err := net.Dial("tcp", host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := "GET /ws HTTP/1.1\r\nHost: " + host + "\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"
+		if origin != "" {
+			req += "Origin: " + origin + "\r\n"
+		}
+		fmt.Fprint(conn, req+"\r\n")
+		status, err := bufio.NewReader(conn).ReadString('\n')
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fields := strings.Fields(status); len(fields) < 2 || fields[1] != want {
+			t.Errorf("Origin %q: got %q, want %s", origin, strings.TrimSpace(status), want)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Graph of the dependencies between the modules, by <orderEntry type="module"> of the .iml files, in DOT:
+//  go run . graph -d ./platform | dot -Tsvg > modules.svg
+// A node is a module with the number of its packages and source files. The dependencies on the modules outside
+// of the scanned dir are dashed nodes, the ones not in the COMPILE scope are labeled with it.
+// Modules parsed in safe mode have no dependencies, see parseModuleSafeMode.
+// With -cycles, it lists the strongly connected components of the modules instead, i.e. the dependency cycles,
+// with the dependencies that make them up, in any scope, for the build team to break:
+//  go run . graph -d ./platform -cycles -fail
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func runGraph(args []string) error {
+	fs := flag.NewFlagSet("graph", flag.ExitOnError)
+	dir := fs.String("d", "", "dir to scan for modules")
+	out := fs.String("o", "", "DOT file to write, stdout by default")
+	testFramework := fs.Bool("test-framework", false, "include testFramework modules")
+	cycles := fs.Bool("cycles", false, "list the dependency cycles of the modules instead of the graph")
+	fail := fs.Bool("fail", false, "exit with non-zero code if there are dependency cycles, with -cycles")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		fs.Usage()
+		return nil
+	}
+
+	modulesPaths, err := findModules(osFS{}, *dir, *testFramework)
+	if err != nil {
+		return err
+	}
+	if *cycles {
+		deps, err := readModuleDeps(osFS{}, modulesPaths)
+		if err != nil {
+			return err
+		}
+		found := findCycles(deps)
+		if *out != "" {
+			err = writeFile(*out, func(w io.Writer) error { return writeCycles(w, found, deps) })
+		} else {
+			err = writeCycles(os.Stdout, found, deps)
+		}
+		if err != nil {
+			return err
+		}
+		return failedCheck("module-cycles", *fail, len(found))
+	}
+	pkgs, err := scanModules(osFS{}, modulesPaths)
+	if err != nil {
+		return err
+	}
+	if *out != "" {
+		return writeFile(*out, func(w io.Writer) error { return writeGraph(w, osFS{}, modulesPaths, pkgs) })
+	}
+	return writeGraph(os.Stdout, osFS{}, modulesPaths, pkgs)
+}
+
+// writeGraph writes the graph of the modules in DOT, with the counts of their packages.
+func writeGraph(w io.Writer, fsys fs.FS, modulesPaths []string, pkgs map[string]*pkg) error {
+	summaries := summarizeModules(pkgs)
+	deps, err := readModuleDeps(fsys, modulesPaths)
+	if err != nil {
+		return err
+	}
+	modules := append([]string(nil), modulesPaths...)
+	sort.Strings(modules)
+
+	fmt.Fprintln(w, "digraph modules {")
+	fmt.Fprintln(w, "  node [shape=box];")
+	for _, mp := range modules {
+		name := strings.TrimSuffix(filepath.Base(mp), filepath.Ext(mp))
+		packages, files := 0, 0
+		if s := summaries[mp]; s != nil {
+			packages, files = s.Packages, s.Files
+		}
+		fmt.Fprintf(w, "  %q [label=%q];\n", name, fmt.Sprintf("%s\n%d packages, %d files", name, packages, files))
+	}
+
+	external := map[string]bool{}
+	for _, mp := range modules {
+		name := strings.TrimSuffix(filepath.Base(mp), filepath.Ext(mp))
+		for _, d := range deps[name] {
+			if _, ok := deps[d.ModuleName]; !ok && !external[d.ModuleName] {
+				external[d.ModuleName] = true
+				fmt.Fprintf(w, "  %q [style=dashed];\n", d.ModuleName)
+			}
+			if d.Scope != "" && d.Scope != "COMPILE" {
+				fmt.Fprintf(w, "  %q -> %q [label=%q];\n", name, d.ModuleName, d.Scope)
+			} else {
+				fmt.Fprintf(w, "  %q -> %q;\n", name, d.ModuleName)
+			}
+		}
+	}
+	fmt.Fprintln(w, "}")
+	return nil
+}
+
+// readModuleDeps returns the dependencies of the modules on the other modules, by module name.
+func readModuleDeps(fsys fs.FS, modulesPaths []string) (map[string][]orderEntry, error) {
+	deps := make(map[string][]orderEntry, len(modulesPaths))
+	for _, mp := range modulesPaths {
+		m, err := newModuleFromXMLFile(fsys, mp)
+		if err != nil {
+			return nil, err
+		}
+		deps[strings.TrimSuffix(filepath.Base(mp), filepath.Ext(mp))] = m.moduleDeps()
+	}
+	return deps, nil
+}
+
+// findCycles returns the strongly connected components of the modules that have a cycle, by Tarjan's algorithm,
+// each sorted and all sorted by their first module.
+func findCycles(deps map[string][]orderEntry) [][]string {
+	names := make([]string, 0, len(deps))
+	for name := range deps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	index, lowLink := map[string]int{}, map[string]int{}
+	onStack := map[string]bool{}
+	var stack []string
+	var cycles [][]string
+	var connect func(name string)
+	connect = func(name string) {
+		index[name], lowLink[name] = len(index), len(index)
+		stack = append(stack, name)
+		onStack[name] = true
+		selfLoop := false
+		for _, d := range deps[name] {
+			dep := d.ModuleName
+			if _, ok := deps[dep]; !ok {
+				continue // outside of the scanned dir
+			}
+			if dep == name {
+				selfLoop = true
+			}
+			if _, visited := index[dep]; !visited {
+				connect(dep)
+				lowLink[name] = min(lowLink[name], lowLink[dep])
+			} else if onStack[dep] {
+				lowLink[name] = min(lowLink[name], index[dep])
+			}
+		}
+		if lowLink[name] != index[name] {
+			return
+		}
+		var component []string
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			component = append(component, top)
+			if top == name {
+				break
+			}
+		}
+		if len(component) > 1 || selfLoop {
+			sort.Strings(component)
+			cycles = append(cycles, component)
+		}
+	}
+	for _, name := range names {
+		if _, visited := index[name]; !visited {
+			connect(name)
+		}
+	}
+	sort.Slice(cycles, func(i, j int) bool { return cycles[i][0] < cycles[j][0] })
+	return cycles
+}
+
+// writeCycles writes the cycles with the dependencies between their modules, labeled with the scope if not COMPILE.
+func writeCycles(w io.Writer, cycles [][]string, deps map[string][]orderEntry) error {
+	for _, cycle := range cycles {
+		fmt.Fprintf(w, "cycle of %d modules:\n", len(cycle))
+		for _, name := range cycle {
+			for _, d := range deps[name] {
+				if !contains(cycle, d.ModuleName) {
+					continue
+				}
+				if d.Scope != "" && d.Scope != "COMPILE" {
+					fmt.Fprintf(w, "  %s -> %s (%s)\n", name, d.ModuleName, d.Scope)
+				} else {
+					fmt.Fprintf(w, "  %s -> %s\n", name, d.ModuleName)
+				}
+			}
+		}
+	}
+	fmt.Fprintf(w, "%d dependency cycles among %d modules\n", len(cycles), len(deps))
+	return nil
+}
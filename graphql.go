@@ -0,0 +1,535 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// GraphQL endpoint of serve mode, for dashboards to fetch the fields they need in a single request:
+//  POST /graphql {"query": "{ packages(doc: \"missing\", minFiles: 10) { name dir module { name } } }"}
+//  GET  /graphql?query={modules{name coverage}}
+// Schema:
+//  type Query   { packages(doc, module, minFiles, sort, limit, offset): [Package]  // as in /api/packages
+//                 package(dir: String!): Package
+//                 modules: [Module]
+//                 history(since: "168h", namespace: "idea/241"): [Scan] }       // with serve -history
+//  type Package { name dir srcDir doc documented files: [String] filesCount java kotlin scala groovy module: Module }
+//  type Module  { path name packages: [Package] packagesCount documented coverage }
+//  type Scan    { time dir namespace modules: [ModuleSummary] }
+//  type ModuleSummary { module packages documented files java kotlin }
+// Only a subset of GraphQL is supported: fields, aliases and literal arguments, no fragments, variables or introspection.
+// The POST bodies are limited to 1 MiB and the selections to 8 levels of nesting.
+// There are no package owners to query in this tree yet.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	maxGraphQLBody  = 1 << 20 // bytes of a POST request
+	maxGraphQLDepth = 8       // of the nested selections, i.e packages { module { packages { ... } } }
+)
+
+// gqlField is a field of a selection set, i.e `alias: name(arg: 1) { ... }`
+type gqlField struct {
+	alias, name string
+	args        map[string]interface{}
+	selection   []*gqlField
+}
+
+// gqlObject resolves the fields of a GraphQL object type.
+type gqlObject interface {
+	resolve(field *gqlField) (interface{}, error)
+}
+
+// gqlResult is an object of the response, that keeps the order of the query fields.
+type gqlResult struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (r *gqlResult) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, k := range r.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(k)
+		v, err := json.Marshal(r.values[k])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// handleGraphQL executes a query against the last scan.
+func (d *daemon) handleGraphQL(w http.ResponseWriter, r *http.Request, historyPath string) {
+	var req struct {
+		Query string `json:"query"`
+	}
+	switch r.Method {
+	case http.MethodGet:
+		req.Query = r.URL.Query().Get("query")
+	case http.MethodPost:
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxGraphQLBody)).Decode(&req); err != nil {
+			writeGraphQLError(w, http.StatusBadRequest, fmt.Errorf("bad request: %v", err))
+			return
+		}
+	default:
+		writeGraphQLError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+
+	d.mu.RLock()
+	pkgs := d.pkgs
+	d.mu.RUnlock()
+	if pkgs == nil {
+		writeGraphQLError(w, http.StatusServiceUnavailable, fmt.Errorf("not scanned yet"))
+		return
+	}
+
+	selection, err := parseGraphQL(req.Query)
+	if err != nil {
+		writeGraphQLError(w, http.StatusBadRequest, err)
+		return
+	}
+	data, err := execute(&gqlQuery{pkgs: pkgs, historyPath: historyPath}, selection)
+	if err != nil {
+		writeGraphQLError(w, http.StatusOK, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": data})
+}
+
+func writeGraphQLError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]interface{}{"errors": []map[string]string{{"message": err.Error()}}})
+}
+
+// execute resolves the selection on the object, and on every object or list of objects it returns.
+func execute(obj gqlObject, selection []*gqlField) (*gqlResult, error) {
+	res := &gqlResult{values: map[string]interface{}{}}
+	for _, f := range selection {
+		v, err := obj.resolve(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f.name, err)
+		}
+		if v, err = executeValue(v, f); err != nil {
+			return nil, fmt.Errorf("%s.%v", f.name, err)
+		}
+		key := f.alias
+		if key == "" {
+			key = f.name
+		}
+		if _, ok := res.values[key]; !ok {
+			res.keys = append(res.keys, key)
+		}
+		res.values[key] = v
+	}
+	return res, nil
+}
+
+func executeValue(v interface{}, f *gqlField) (interface{}, error) {
+	switch v := v.(type) {
+	case gqlObject:
+		if len(f.selection) == 0 {
+			return nil, fmt.Errorf("%s: an object needs a selection of fields", f.name)
+		}
+		if o, ok := v.(interface{ isNil() bool }); ok && o.isNil() {
+			return nil, nil
+		}
+		return execute(v, f.selection)
+	case []gqlObject:
+		list := make([]interface{}, len(v))
+		for i, o := range v {
+			r, err := executeValue(o, f)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = r
+		}
+		return list, nil
+	}
+	if len(f.selection) > 0 {
+		return nil, fmt.Errorf("%s: a scalar has no fields", f.name)
+	}
+	return v, nil
+}
+
+// gqlQuery is the root Query type.
+type gqlQuery struct {
+	pkgs        map[string]*pkg
+	historyPath string
+	summaries   map[string]*moduleSummary // computed once per query
+}
+
+func (q *gqlQuery) summary(module string) *moduleSummary {
+	if q.summaries == nil {
+		q.summaries = summarizeModules(q.pkgs)
+	}
+	if s := q.summaries[module]; s != nil {
+		return s
+	}
+	return &moduleSummary{}
+}
+
+func (q *gqlQuery) resolve(f *gqlField) (interface{}, error) {
+	switch f.name {
+	case "packages":
+		values := url.Values{}
+		for k, v := range f.args {
+			values.Set(k, gqlArgString(v))
+		}
+		pq, err := parsePackageQuery(values)
+		if err != nil {
+			return nil, err
+		}
+		page, _ := pq.apply(q.pkgs)
+		return q.packages(page), nil
+	case "package":
+		dir, _ := f.args["dir"].(string)
+		return &gqlPackage{q, q.pkgs[filepath.Clean(dir)]}, nil
+	case "modules":
+		q.summary("")
+		var mods []string
+		for m := range q.summaries {
+			mods = append(mods, m)
+		}
+		sort.Strings(mods)
+		list := make([]gqlObject, len(mods))
+		for i, m := range mods {
+			list[i] = &gqlModule{q, m}
+		}
+		return list, nil
+	case "history":
+		if q.historyPath == "" {
+			return nil, fmt.Errorf("no history, start serve with -history")
+		}
+		since := 7 * 24 * time.Hour
+		if s, ok := f.args["since"].(string); ok {
+			var err error
+			if since, err = time.ParseDuration(s); err != nil {
+				return nil, err
+			}
+		}
+		records, err := readHistory(q.historyPath)
+		if err != nil {
+			return nil, err
+		}
+		namespace, _ := f.args["namespace"].(string)
+		var list []gqlObject
+		for _, r := range inNamespace(records, namespace) {
+			if time.Since(r.Time) <= since {
+				list = append(list, &gqlScan{r})
+			}
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("no such field on Query")
+}
+
+// gqlArgString formats an argument as a query parameter of /api/packages, the numbers without an exponent.
+func gqlArgString(v interface{}) string {
+	if n, ok := v.(float64); ok {
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+func (q *gqlQuery) packages(pkgs []*pkg) []gqlObject {
+	list := make([]gqlObject, len(pkgs))
+	for i, p := range pkgs {
+		list[i] = &gqlPackage{q, p}
+	}
+	return list
+}
+
+type gqlPackage struct {
+	q *gqlQuery
+	p *pkg
+}
+
+func (gp *gqlPackage) isNil() bool { return gp.p == nil }
+
+func (gp *gqlPackage) resolve(f *gqlField) (interface{}, error) {
+	p := gp.p
+	switch f.name {
+	case "name":
+		return p.name, nil
+	case "dir":
+		return p.pkgDir, nil
+	case "srcDir":
+		return p.srcDir, nil
+	case "doc":
+		return p.doc, nil
+	case "documented":
+		return p.isDocumented(), nil
+	case "files":
+		return p.files, nil
+	case "filesCount":
+		return len(p.files), nil
+	case "java":
+		return p.filesCnt[".java"], nil
+	case "kotlin":
+		return p.filesCnt[".kt"], nil
+	case "scala":
+		return p.filesCnt[".scala"], nil
+	case "groovy":
+		return p.filesCnt[".groovy"], nil
+	case "module":
+		return &gqlModule{gp.q, p.module}, nil
+	}
+	return nil, fmt.Errorf("no such field on Package")
+}
+
+type gqlModule struct {
+	q    *gqlQuery
+	path string
+}
+
+func (m *gqlModule) resolve(f *gqlField) (interface{}, error) {
+	switch f.name {
+	case "path":
+		return m.path, nil
+	case "name":
+		return strings.TrimSuffix(filepath.Base(m.path), filepath.Ext(m.path)), nil
+	case "packages":
+		var pkgs []*pkg
+		for _, p := range sortedPackages(m.q.pkgs) {
+			if p.module == m.path {
+				pkgs = append(pkgs, p)
+			}
+		}
+		return m.q.packages(pkgs), nil
+	}
+
+	s := m.q.summary(m.path)
+	switch f.name {
+	case "packagesCount":
+		return s.Packages, nil
+	case "documented":
+		return s.Documented, nil
+	case "coverage":
+		return percent(s.Documented, s.Packages), nil
+	}
+	return nil, fmt.Errorf("no such field on Module")
+}
+
+type gqlScan struct {
+	r *historyRecord
+}
+
+func (s *gqlScan) resolve(f *gqlField) (interface{}, error) {
+	switch f.name {
+	case "time":
+		return s.r.Time.Format(time.RFC3339), nil
+	case "dir":
+		return s.r.Dir, nil
+	case "namespace":
+		return s.r.Namespace, nil
+	case "modules":
+		var mods []string
+		for m := range s.r.Modules {
+			mods = append(mods, m)
+		}
+		sort.Strings(mods)
+		list := make([]gqlObject, len(mods))
+		for i, m := range mods {
+			list[i] = &gqlModuleSummary{m, s.r.Modules[m]}
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("no such field on Scan")
+}
+
+type gqlModuleSummary struct {
+	module string
+	s      *moduleSummary
+}
+
+func (ms *gqlModuleSummary) resolve(f *gqlField) (interface{}, error) {
+	switch f.name {
+	case "module":
+		return ms.module, nil
+	case "packages":
+		return ms.s.Packages, nil
+	case "documented":
+		return ms.s.Documented, nil
+	case "files":
+		return ms.s.Files, nil
+	case "java":
+		return ms.s.Java, nil
+	case "kotlin":
+		return ms.s.Kotlin, nil
+	}
+	return nil, fmt.Errorf("no such field on ModuleSummary")
+}
+
+// parseGraphQL parses a query document with a single operation, returning its selection set.
+func parseGraphQL(query string) ([]*gqlField, error) {
+	tokens, err := tokenizeGraphQL(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &condParser{tokens: tokens}
+	if t := p.peek(); t == "query" {
+		p.pos++
+		if t := p.peek(); t != "{" && t != "" { // an operation name
+			p.pos++
+		}
+	}
+	selection, err := parseSelection(p, 1)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q after the query", p.peek())
+	}
+	return selection, nil
+}
+
+func parseSelection(p *condParser, depth int) ([]*gqlField, error) {
+	if p.peek() != "{" {
+		return nil, fmt.Errorf("expected { but got %q", p.peek())
+	}
+	if depth > maxGraphQLDepth {
+		return nil, fmt.Errorf("the selections are nested deeper than %d", maxGraphQLDepth)
+	}
+	p.pos++
+
+	var fields []*gqlField
+	for p.peek() != "}" {
+		if p.peek() == "" {
+			return nil, fmt.Errorf("missing }")
+		}
+		f := &gqlField{name: p.peek()}
+		if !isGraphQLName(f.name) {
+			return nil, fmt.Errorf("expected a field but got %q", f.name)
+		}
+		p.pos++
+		if p.peek() == ":" {
+			p.pos++
+			f.alias, f.name = f.name, p.peek()
+			if !isGraphQLName(f.name) {
+				return nil, fmt.Errorf("expected a field but got %q", f.name)
+			}
+			p.pos++
+		}
+		if p.peek() == "(" {
+			args, err := parseArguments(p)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", f.name, err)
+			}
+			f.args = args
+		}
+		if p.peek() == "{" {
+			selection, err := parseSelection(p, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			f.selection = selection
+		}
+		fields = append(fields, f)
+	}
+	p.pos++
+	return fields, nil
+}
+
+func parseArguments(p *condParser) (map[string]interface{}, error) {
+	p.pos++ // (
+	args := map[string]interface{}{}
+	for p.peek() != ")" {
+		name := p.peek()
+		if !isGraphQLName(name) {
+			return nil, fmt.Errorf("expected an argument but got %q", name)
+		}
+		p.pos++
+		if p.peek() != ":" {
+			return nil, fmt.Errorf("expected : after %s", name)
+		}
+		p.pos++
+
+		switch t := p.peek(); {
+		case strings.HasPrefix(t, `"`):
+			s, err := strconv.Unquote(t)
+			if err != nil {
+				return nil, fmt.Errorf("bad string %s", t)
+			}
+			args[name] = s
+		case t == "true" || t == "false":
+			args[name] = t == "true"
+		case t != "" && (unicode.IsDigit(rune(t[0])) || t[0] == '-'):
+			if n, err := strconv.Atoi(t); err == nil { // an Int, as the limits and the counts are
+				args[name] = n
+				break
+			}
+			n, err := strconv.ParseFloat(t, 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad number %s", t)
+			}
+			args[name] = n
+		default:
+			return nil, fmt.Errorf("unsupported value %q of %s, only literals are", t, name)
+		}
+		p.pos++
+	}
+	p.pos++ // )
+	return args, nil
+}
+
+func isGraphQLName(t string) bool {
+	return t != "" && (unicode.IsLetter(rune(t[0])) || t[0] == '_')
+}
+
+// tokenizeGraphQL splits a query into names, numbers, quoted strings and punctuation, skipping commas and comments.
+func tokenizeGraphQL(s string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c) || c == ',':
+			i++
+		case c == '#':
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+		case c == '"':
+			j := i + 1
+			for j < len(s) && s[j] != '"' {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string in the query")
+			}
+			tokens = append(tokens, s[i:j+1])
+			i = j + 1
+		case unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || c == '-' || c == '.':
+			j := i
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || s[j] == '_' || s[j] == '-' || s[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		case strings.ContainsRune("{}():", c):
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected %q in the query", c)
+		}
+	}
+	return tokens, nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Content hashes of the packages, stored in the snapshots and the indexes, to tell the packages whose sources
+// were edited from the unchanged ones, whatever the modification times and the scanned dirs are:
+//  go run . -d ./platform -snapshot head.json
+//  go run . diff -all base.json head.json     # with the change column: files, content or none
+// The hash chains the name and the sha256 of every source of the package in the order of the names, so renaming,
+// adding or removing a source changes it too. The package docs other than package-info.java are not hashed.
+// An incremental index reads the sources of a modified root to hash them, but counts only the changed packages.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"path/filepath"
+)
+
+// hashContent updates .contentHash with the name and the content of a source file, visited in the order of the names.
+func hashContent(p *pkg, f *sourceFile) error {
+	if !f.isSource() {
+		return nil
+	}
+	content, err := f.content()
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(content)
+	h := sha256.New()
+	h.Write([]byte(p.contentHash))
+	h.Write([]byte(f.name()))
+	h.Write([]byte{0})
+	h.Write(digest[:])
+	p.contentHash = hex.EncodeToString(h.Sum(nil))
+	return nil
+}
+
+// contentChange is how the sources of a package changed between two scans: files if a file was added,
+// removed or renamed, content if the same ones were edited, or "" if none was or the hashes are unknown.
+func contentChange(a, b *pkg) string {
+	switch {
+	case a == nil || b == nil:
+		return ""
+	case !sameFiles(a.files, b.files):
+		return "files"
+	case a.contentHash == "" || b.contentHash == "" || a.contentHash == b.contentHash:
+		return ""
+	}
+	return "content"
+}
+
+func sameFiles(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// visitSources runs the visitors over the sources of an already scanned package.
+func visitSources(fsys fs.FS, p *pkg, visitors ...visitor) error {
+	for _, name := range p.files {
+		path := filepath.Join(p.pkgDir, name)
+		fi, err := fs.Stat(fsys, path)
+		if err != nil {
+			return err
+		}
+		f := &sourceFile{fsys: fsys, path: path, size: fi.Size()}
+		for _, visit := range visitors {
+			if err := visit(p, f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// History of scans is a JSON Lines file with a record per scan, appended with -history,
+// that keeps per-module summaries for the digests and trends.
+// Serve mode appends the snapshots uploaded to its namespaces to the same file, with their namespace,
+// and local scans are labeled with one by -namespace, so the branches can be told apart.
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// historyRecord is a summary of a single scan.
+type historyRecord struct {
+	Time      time.Time                 `json:"time"`
+	Dir       string                    `json:"dir"`
+	Namespace string                    `json:"namespace,omitempty"` // product/branch of an uploaded snapshot
+	Modules   map[string]*moduleSummary `json:"modules"`             // by .iml path
+}
+
+// moduleSummary is the number of packages and files of a module.
+type moduleSummary struct {
+	Packages   int `json:"packages"`
+	Documented int `json:"documented"`
+	Files      int `json:"files"`
+	Java       int `json:"java"`
+	Kotlin     int `json:"kt"`
+
+	CodeLines    int `json:"codeLines,omitempty"` // only counted with -loc
+	CommentLines int `json:"commentLines,omitempty"`
+	BlankLines   int `json:"blankLines,omitempty"`
+}
+
+// summarizeModules groups the packages by modules.
+func summarizeModules(pkgs map[string]*pkg) map[string]*moduleSummary {
+	mods := map[string]*moduleSummary{}
+	for _, p := range pkgs {
+		m, ok := mods[p.module]
+		if !ok {
+			m = &moduleSummary{}
+			mods[p.module] = m
+		}
+		m.Packages++
+		if p.isDocumented() {
+			m.Documented++
+		}
+		m.Files += len(p.files)
+		m.Java += p.filesCnt[".java"]
+		m.Kotlin += p.filesCnt[".kt"]
+		m.CodeLines += p.codeLines
+		m.CommentLines += p.commentLines
+		m.BlankLines += p.blankLines
+	}
+	return mods
+}
+
+// appendHistory adds a record of the scan to the history file.
+func appendHistory(path string, rec *historyRecord) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	blob, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(blob, '\n'))
+	return err
+}
+
+// readHistory returns all the records from the history file, the oldest first.
+func readHistory(path string) ([]*historyRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []*historyRecord
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 64*1024*1024)
+	for n := 1; s.Scan(); n++ {
+		var rec historyRecord
+		if err := json.Unmarshal(s.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("error parsing %s:%d: %v", path, n, err)
+		}
+		records = append(records, &rec)
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+	return records, s.Err()
+}
+
+// inNamespace returns the records of the namespace, the local scans for an empty one.
+func inNamespace(records []*historyRecord, namespace string) []*historyRecord {
+	var found []*historyRecord
+	for _, r := range records {
+		if r.Namespace == namespace {
+			found = append(found, r)
+		}
+	}
+	return found
+}
+
+// historyRange returns the latest record and the latest one at least `since` older than it,
+// or the oldest one if there is none that old.
+func historyRange(records []*historyRecord, since time.Duration) (from, to *historyRecord) {
+	if len(records) == 0 {
+		return nil, nil
+	}
+	to, from = records[len(records)-1], records[0]
+	for _, r := range records {
+		if to.Time.Sub(r.Time) >= since {
+			from = r
+		}
+	}
+	return from, to
+}
@@ -0,0 +1,183 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Standalone HTML report of the packages, to regenerate the jb.gg/platform-packages page without the spreadsheet:
+//  go run . -d ./platform -doc-coverage -html platform-packages.html
+// The report is a single file with the styles and the script embedded, so it can be hosted anywhere or attached
+// as is. The table has the columns of the default output selected by the same flags, the packages and their docs
+// linked at Space or -link-style, and is sorted by a click at a column header and filtered by the text in the box.
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// htmlCell is a cell of the report table, linked if it has a URL, and sorted by sort, numerically if it is a number.
+type htmlCell struct {
+	Text  string
+	URL   string
+	Badge string // CSS class of the doc status badge
+	Sort  string
+}
+
+type htmlReport struct {
+	Dir       string
+	Commit    string
+	Generated string
+	Coverage  string
+	Columns   []string
+	Rows      [][]htmlCell
+}
+
+// writeHTML writes the HTML report of the packages, with the plugin model of the package module if -content-modules is set.
+func writeHTML(w io.Writer, dir string, pkgs map[string]*pkg, contentModules map[string]string, generated time.Time) error {
+	documented, total := docCoverage(pkgs, *coverageFlag)
+	r := htmlReport{
+		Dir:       dir,
+		Commit:    scanCommit,
+		Generated: generated.UTC().Format(time.RFC3339),
+		Coverage:  fmt.Sprintf("%s: %.1f%%, %d of %d", *coverageFlag, percent(documented, total), documented, total),
+	}
+	r.Columns = append([]string{"files"}, sourceExts...)
+	r.Columns = append(r.Columns, "module", "package", "documentation", "api")
+	if *contentModulesFlag {
+		r.Columns = append(r.Columns, "plugin model")
+	}
+	if *docCoverageFlag {
+		r.Columns = append(r.Columns, "doc coverage", "package-info")
+	}
+	if *readmeFlag {
+		r.Columns = append(r.Columns, "readme", "module readme")
+	}
+	if *debtMarkersFlag {
+		r.Columns = append(r.Columns, "debt markers")
+	}
+	if *locFlag {
+		r.Columns = append(r.Columns, "code", "comments", "blank")
+	}
+
+	for _, p := range sortedPackages(pkgs) {
+		row := []htmlCell{numCell(len(p.files))}
+		for _, ext := range sourceExts {
+			row = append(row, numCell(p.filesCnt[ext]))
+		}
+		row = append(row, htmlCell{Text: p.module}, htmlCell{Text: p.name, URL: link(p.pkgDir)}, docBadge(p), htmlCell{Text: p.apiClass()})
+		if *contentModulesFlag {
+			row = append(row, htmlCell{Text: pluginModel(contentModules[p.module])})
+		}
+		if *docCoverageFlag {
+			coverage := htmlCell{Text: p.typeDocCoverage(), Sort: "-1"}
+			if p.publicTypes > 0 {
+				coverage.Sort = strconv.FormatFloat(percent(p.documentedTypes, p.publicTypes), 'f', 1, 64)
+			}
+			row = append(row, coverage, htmlCell{Text: yesNo(p.isDocumented())})
+		}
+		if *readmeFlag {
+			row = append(row, readmeCell(p.readme), readmeCell(p.moduleReadme))
+		}
+		if *debtMarkersFlag {
+			row = append(row, numCell(p.debtMarkers))
+		}
+		if *locFlag {
+			row = append(row, numCell(p.codeLines), numCell(p.commentLines), numCell(p.blankLines))
+		}
+		r.Rows = append(r.Rows, row)
+	}
+	return htmlTemplate.Execute(w, r)
+}
+
+func numCell(n int) htmlCell {
+	return htmlCell{Text: strconv.Itoa(n), Sort: strconv.Itoa(n)}
+}
+
+// docBadge is the documentation status of the package: package-info.java, the legacy package.html, or none.
+func docBadge(p *pkg) htmlCell {
+	switch {
+	case p.isDocumented():
+		return htmlCell{Text: "documented", URL: link(p.doc), Badge: "documented"}
+	case p.isLegacyDoc():
+		return htmlCell{Text: "package.html", URL: link(p.doc), Badge: "legacy"}
+	}
+	return htmlCell{Text: "missing", Badge: "missing"}
+}
+
+func readmeCell(path string) htmlCell {
+	if path == "" {
+		return htmlCell{Text: "-"}
+	}
+	return htmlCell{Text: filepath.Base(path), URL: link(path)}
+}
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Packages of {{.Dir}}</title>
+<style>
+body { font: 14px -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif; margin: 24px; color: #19191c; }
+input { font: inherit; padding: 4px 8px; width: 320px; margin: 8px 0 12px; }
+table { border-collapse: collapse; }
+th, td { padding: 4px 8px; border-bottom: 1px solid #e6e6e6; text-align: left; white-space: nowrap; }
+th { cursor: pointer; user-select: none; position: sticky; top: 0; background: #f4f4f4; }
+th.asc::after { content: " ▲"; }
+th.desc::after { content: " ▼"; }
+a { color: #087cfa; text-decoration: none; }
+.badge { border-radius: 8px; padding: 1px 8px; font-size: 12px; }
+.badge.documented { background: #d7f5dc; color: #126b25; }
+.badge.legacy { background: #fff1c2; color: #7a5a00; }
+.badge.missing { background: #fde1e1; color: #a11d1d; }
+.meta { color: #6c707e; }
+</style>
+</head>
+<body>
+<h1>Packages of {{.Dir}}</h1>
+<p class="meta">doc coverage ({{.Coverage}}){{if .Commit}} · commit <code>{{.Commit}}</code>{{end}} · generated {{.Generated}}</p>
+<input id="filter" type="search" placeholder="Filter packages" autofocus>
+<span id="count" class="meta"></span>
+<table id="packages">
+<thead><tr>{{range .Columns}}<th>{{.}}</th>{{end}}</tr></thead>
+<tbody>
+{{range .Rows}}<tr>{{range .}}<td{{if .Sort}} data-sort="{{.Sort}}"{{end}}>{{if .Badge}}<span class="badge {{.Badge}}">{{end}}{{if .URL}}<a href="{{.URL}}">{{.Text}}</a>{{else}}{{.Text}}{{end}}{{if .Badge}}</span>{{end}}</td>{{end}}</tr>
+{{end}}</tbody>
+</table>
+<script>
+(function () {
+  var table = document.getElementById("packages"), body = table.tBodies[0];
+  var rows = Array.prototype.slice.call(body.rows), filter = document.getElementById("filter"), count = document.getElementById("count");
+  function key(row, i) {
+    var td = row.cells[i];
+    return td.hasAttribute("data-sort") ? parseFloat(td.getAttribute("data-sort")) : td.textContent.toLowerCase();
+  }
+  function update() {
+    var words = filter.value.toLowerCase().split(/\s+/).filter(Boolean), shown = 0;
+    rows.forEach(function (row) {
+      var text = row.textContent.toLowerCase();
+      var match = words.every(function (w) { return text.indexOf(w) >= 0; });
+      row.style.display = match ? "" : "none";
+      if (match) shown++;
+    });
+    count.textContent = shown + " of " + rows.length + " packages";
+  }
+  Array.prototype.forEach.call(table.tHead.rows[0].cells, function (th, i) {
+    th.addEventListener("click", function () {
+      var desc = th.classList.contains("asc");
+      Array.prototype.forEach.call(th.parentNode.cells, function (c) { c.classList.remove("asc", "desc"); });
+      th.classList.add(desc ? "desc" : "asc");
+      rows.sort(function (a, b) {
+        var x = key(a, i), y = key(b, i);
+        return (x < y ? -1 : x > y ? 1 : 0) * (desc ? -1 : 1);
+      });
+      rows.forEach(function (row) { body.appendChild(row); });
+    });
+  });
+  filter.addEventListener("input", update);
+  update();
+})();
+</script>
+</body>
+</html>
+`))
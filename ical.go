@@ -0,0 +1,121 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// iCal export of the documentation review milestones from the config, with the current coverage:
+//  {"milestones": [{"name": "Core API docs", "modules": "platform/core*/**", "date": "2023-03-01", "coverage": 80}]}
+// written by -ical <file> and served at /calendar.ics, so the backlog shows up in team calendars.
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// milestone is a doc review deadline for a set of modules, given by a glob over .iml paths.
+type milestone struct {
+	Name     string  `json:"name"`
+	Modules  string  `json:"modules"`
+	Date     string  `json:"date"`     // YYYY-MM-DD
+	Coverage float64 `json:"coverage"` // target, % of documented packages
+}
+
+// writeICal writes an all-day event per milestone, with the current coverage of its modules.
+func writeICal(w io.Writer, milestones []milestone, pkgs map[string]*pkg, now time.Time) error {
+	var b strings.Builder
+	b.WriteString("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//JetBrains//jet-search//EN\r\nCALSCALE:GREGORIAN\r\n")
+	for _, m := range milestones {
+		date, err := time.Parse("2006-01-02", m.Date)
+		if err != nil {
+			return fmt.Errorf("milestone %q: bad date %q: %v", m.Name, m.Date, err)
+		}
+		scope, err := globToRegexp(m.Modules)
+		if err != nil {
+			return fmt.Errorf("milestone %q: bad modules %q: %v", m.Name, m.Modules, err)
+		}
+
+		mods := map[string]*moduleSummary{}
+		documented, total := 0, 0
+		for module, s := range summarizeModules(pkgs) {
+			if scope.MatchString(module) {
+				mods[module] = s
+				documented, total = documented+s.Documented, total+s.Packages
+			}
+		}
+		names := make([]string, 0, len(mods))
+		for module := range mods {
+			names = append(names, module)
+		}
+		sort.Strings(names)
+
+		summary := fmt.Sprintf("Doc review: %s, %.0f%% documented", m.Name, percent(documented, total))
+		if m.Coverage > 0 {
+			summary += fmt.Sprintf(" of %.0f%% target", m.Coverage)
+		}
+		var description strings.Builder
+		for _, module := range names {
+			s := mods[module]
+			fmt.Fprintf(&description, "%s: %d of %d packages documented\n", module, s.Documented, s.Packages)
+		}
+
+		b.WriteString("BEGIN:VEVENT\r\n")
+		writeICalLine(&b, "UID:"+icalEscape(strings.ReplaceAll(m.Name+"-"+m.Date, " ", "-"))+"@jet-search")
+		fmt.Fprintf(&b, "DTSTAMP:%s\r\n", now.UTC().Format("20060102T150405Z"))
+		fmt.Fprintf(&b, "DTSTART;VALUE=DATE:%s\r\n", date.Format("20060102"))
+		fmt.Fprintf(&b, "DTEND;VALUE=DATE:%s\r\n", date.AddDate(0, 0, 1).Format("20060102"))
+		writeICalLine(&b, "SUMMARY:"+icalEscape(summary))
+		writeICalLine(&b, "DESCRIPTION:"+icalEscape(description.String()))
+		b.WriteString("END:VEVENT\r\n")
+	}
+	b.WriteString("END:VCALENDAR\r\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// icalEscape escapes a TEXT value, RFC 5545 3.3.11
+func icalEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+// icalLineOctets is the longest content line, without the CRLF, RFC 5545 3.1
+const icalLineOctets = 75
+
+// writeICalLine writes the content line folded into the lines of icalLineOctets at most, the continuations
+// starting with a space, and not splitting the UTF-8 sequences.
+func writeICalLine(b *strings.Builder, line string) {
+	limit := icalLineOctets
+	for len(line) > limit {
+		n := limit
+		for n > 0 && !utf8.RuneStart(line[n]) {
+			n--
+		}
+		b.WriteString(line[:n] + "\r\n ")
+		line, limit = line[n:], icalLineOctets-1
+	}
+	b.WriteString(line + "\r\n")
+}
+
+// saveICal writes the milestones to a file.
+func saveICal(path string, milestones []milestone, pkgs map[string]*pkg) error {
+	return writeFile(path, func(w io.Writer) error { return writeICal(w, milestones, pkgs, time.Now()) })
+}
+
+// handleICal responds with the milestones of the config, once the packages are scanned.
+func (d *daemon) handleICal(w http.ResponseWriter, r *http.Request) {
+	d.mu.RLock()
+	pkgs := d.pkgs
+	d.mu.RUnlock()
+	if pkgs == nil {
+		http.Error(w, "not scanned yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	if err := writeICal(w, cfg.Milestones, pkgs, time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
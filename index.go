@@ -0,0 +1,181 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Index is the scan persisted on disk, so the commands that read it do not walk the tree again:
+//  go run . index -d ./platform -o .jetsearch/index
+//  go run . search -snapshot .jetsearch/index concurrency
+//  go run . diff .jetsearch/index head.json
+// It is a snapshot with the files, docs and public types of the packages and the version of the format,
+// so an index written by a newer version is refused rather than misread.
+// With -incremental, only the source roots modified since the index are read again, the packages of the others
+// are kept from the index. A source root is modified if any of its files or dirs is, as a removed file
+// modifies its dir. The files are still listed, but not read. The packages of a modified root are hashed,
+// see hash.go, and only the ones with other sources than in the index are counted again.
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const defaultIndex = ".jetsearch/index"
+
+func runIndex(args []string) error {
+	fs := flag.NewFlagSet("index", flag.ExitOnError)
+	dir := fs.String("d", "", "dir to scan for packages")
+	out := fs.String("o", defaultIndex, "index file to write")
+	testFramework := fs.Bool("test-framework", false, "include testFramework modules")
+	incremental := fs.Bool("incremental", false, "read again only the source roots modified since the index at -o, if there is one of the same dir")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		fs.Usage()
+		return nil
+	}
+
+	var prev *snapshot
+	if *incremental {
+		s, err := readSnapshot(*out)
+		switch {
+		case os.IsNotExist(err):
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "indexing all the source roots: %v\n", err)
+		case s.dir != *dir || s.roots == nil:
+			fmt.Fprintf(os.Stderr, "indexing all the source roots: %s is not an index of %q\n", *out, *dir)
+		default:
+			prev = s
+		}
+	}
+
+	s, changed, err := indexDir(osFS{}, *dir, *testFramework, prev)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(*out), 0755); err != nil {
+		return err
+	}
+	if err := saveSnapshot(*out, s); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "indexed %d packages of %q in %s, read %d of %d source roots\n", len(s.pkgs), *dir, *out, changed, len(s.roots))
+	return nil
+}
+
+// indexDir scans the dir into an index, reading only the source roots modified since the previous index, if any.
+// It returns the index and the number of source roots read.
+func indexDir(fsys fs.FS, dir string, testFramework bool, prev *snapshot) (*snapshot, int, error) {
+	modulesPaths, err := findModules(fsys, dir, testFramework)
+	if err != nil {
+		return nil, 0, err
+	}
+	srcDirPaths, err := grepXMLForSrcDirPaths(fsys, modulesPaths)
+	if err != nil {
+		return nil, 0, err
+	}
+	roots, err := rootModTimes(fsys, srcDirPaths)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if prev == nil {
+		pkgs, err := scanSrcDirs(fsys, srcDirPaths, countSize, hashContent)
+		if err != nil {
+			return nil, 0, err
+		}
+		s := newSnapshot(dir, pkgs)
+		s.publicTypes, s.version, s.roots = true, snapshotVersion, roots
+		return s, len(srcDirPaths), nil
+	}
+
+	// the packages of the modified roots with the same sources keep their counts
+	changed := changedRoots(srcDirPaths, roots, prev)
+	pkgs, err := scanSrcDirs(fsys, changed, hashContent)
+	if err != nil {
+		return nil, 0, err
+	}
+	for pkgDir, p := range pkgs {
+		if q := prev.pkgs[pkgDir]; q != nil && q.contentHash == p.contentHash && q.module == p.module {
+			p.lines, p.publicTypes, p.documentedTypes = q.lines, q.publicTypes, q.documentedTypes
+		} else if err := visitSources(fsys, p, countSize); err != nil {
+			return nil, 0, err
+		}
+	}
+	for pkgDir, p := range prev.pkgs {
+		if _, read := changed[p.srcDir]; !read && srcDirPaths[p.srcDir] == p.module {
+			pkgs[pkgDir] = p
+		}
+	}
+
+	s := newSnapshot(dir, pkgs)
+	s.publicTypes, s.version, s.roots = true, snapshotVersion, roots
+	return s, len(changed), nil
+}
+
+// changedRoots returns the source roots modified since the previous index, or not in it, along with the
+// ones nested in them or containing them, as the nested source roots are scanned together.
+func changedRoots(srcDirPaths map[string]string, roots map[string]time.Time, prev *snapshot) map[string]string {
+	prevModules := map[string]string{}
+	for _, p := range prev.pkgs {
+		prevModules[p.srcDir] = p.module
+	}
+
+	changed := map[string]string{}
+	for srcDir, mod := range srcDirPaths {
+		t, ok := prev.roots[srcDir]
+		if !ok || !t.Equal(roots[srcDir]) || (prevModules[srcDir] != "" && prevModules[srcDir] != mod) {
+			changed[srcDir] = mod
+		}
+	}
+	for srcDir, mod := range srcDirPaths {
+		for c := range changed {
+			if isUnder(srcDir, c) || isUnder(c, srcDir) {
+				changed[srcDir] = mod
+				break
+			}
+		}
+	}
+	return changed
+}
+
+// isUnder checks if the path is the dir or inside it.
+func isUnder(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// rootModTimes returns the latest modification time of the files and dirs of every source root,
+// skipping the same dirs as scanPackages.
+func rootModTimes(fsys fs.FS, srcDirPaths map[string]string) (map[string]time.Time, error) {
+	roots := make(map[string]time.Time, len(srcDirPaths))
+	for srcDir := range srcDirPaths {
+		var latest time.Time
+		err := fs.WalkDir(fsys, srcDir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() && (strings.HasPrefix(d.Name(), ".") || testDataDirs[d.Name()]) {
+				return fs.SkipDir
+			}
+			if _, nested := srcDirPaths[path]; d.IsDir() && nested && path != srcDir {
+				return fs.SkipDir
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if info.ModTime().After(latest) {
+				latest = info.ModTime()
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		roots[srcDir] = latest.UTC()
+	}
+	return roots, nil
+}
@@ -0,0 +1,227 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Jira Cloud sink, filing an issue per module with undocumented packages:
+//  go run . -d ./platform -publish
+//  go run . jira -d ./platform -dry-run
+// for the project from the config:
+//  {"sinks": {"jira": {"url": "https://example.atlassian.net", "project": "DOC", "user": "jet-search@example.com", "issueType": "Task"}}}
+// The API token is the jira-token secret, i.e the JET_SEARCH_JIRA_TOKEN env var, see secrets.go.
+// Issues are labeled jet-search and a module with an open one is skipped, so it can be published on every scan.
+// Suppressed UndocumentedPackage findings are not filed.
+// There is no YouTrack integration in this tree yet to share the issue tracker code with.
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const jiraLabel = "jet-search"
+
+func init() {
+	sinkTypes["jira"] = newJiraSink
+}
+
+// jiraConfig is where to file the issues.
+type jiraConfig struct {
+	URL       string `json:"url"`
+	Project   string `json:"project"` // key
+	User      string `json:"user"`    // email of the API token owner
+	IssueType string `json:"issueType"`
+}
+
+func newJiraSink(config json.RawMessage) (sink, error) {
+	var c jiraConfig
+	if err := json.Unmarshal(config, &c); err != nil {
+		return nil, err
+	}
+	if c.URL == "" || c.Project == "" {
+		return nil, fmt.Errorf("url and project are required")
+	}
+	token, err := secret("jira-token")
+	if err != nil {
+		return nil, err
+	}
+	return &jiraClient{jiraConfig: c, token: token, http: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+// runJira publishes to the jira sink only, or prints the issues it would file.
+func runJira(args []string) error {
+	fs := flag.NewFlagSet("jira", flag.ExitOnError)
+	dir := fs.String("d", "", "dir to scan for packages")
+	testFramework := fs.Bool("test-framework", false, "include testFramework modules")
+	dryRun := fs.Bool("dry-run", false, "print the issues instead of filing them")
+	fs.BoolVar(offlineFlag, "offline", false, "disable all the network access, for -dry-run only")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *offlineFlag && !*dryRun {
+		return fmt.Errorf("filing the issues needs network, disabled by -offline, use -dry-run")
+	}
+	if *dir == "" || cfg.Sinks["jira"] == nil {
+		fs.Usage()
+		return fmt.Errorf("a dir and the jira sink in the config are required")
+	}
+	j, err := newJiraSink(cfg.Sinks["jira"])
+	if err != nil {
+		return err
+	}
+	j.(*jiraClient).dryRun = *dryRun
+
+	pkgs, err := scanDir(*dir, *testFramework)
+	if err != nil {
+		return err
+	}
+	findings, err := collectFindings(pkgs)
+	if err != nil {
+		return err
+	}
+	return j.publish(newSnapshot(*dir, pkgs), findings)
+}
+
+// publish files an issue per module with unsuppressed undocumented packages, unless there is an open one.
+func (j *jiraClient) publish(s *snapshot, findings []finding) error {
+	undocumented := map[string][]*pkg{} // by module
+	for _, f := range findings {
+		if p := s.pkgs[f.path]; f.rule == "UndocumentedPackage" && f.suppressed == "" && p != nil {
+			undocumented[p.module] = append(undocumented[p.module], p)
+		}
+	}
+	modules := make([]string, 0, len(undocumented))
+	for m := range undocumented {
+		modules = append(modules, m)
+	}
+	sort.Strings(modules)
+
+	open := map[string]bool{}
+	if !j.dryRun {
+		var err error
+		if open, err = j.openIssues(); err != nil {
+			return err
+		}
+	}
+	for _, m := range modules {
+		summary, description := jiraIssue(m, undocumented[m])
+		if open[summary] {
+			continue
+		}
+		if j.dryRun {
+			fmt.Printf("%s\n\n%s\n", summary, description)
+			continue
+		}
+		key, err := j.createIssue(summary, description)
+		if err != nil {
+			return fmt.Errorf("error filing the issue for %q: %v", m, err)
+		}
+		fmt.Printf("%s\t%s\t%s/browse/%s\n", key, m, strings.TrimSuffix(j.URL, "/"), key)
+	}
+	return nil
+}
+
+// jiraIssue returns the summary, that identifies the module issue, and the description listing the packages.
+func jiraIssue(module string, pkgs []*pkg) (string, string) {
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].pkgDir < pkgs[j].pkgDir })
+	var b strings.Builder
+	fmt.Fprintf(&b, "Packages of %s without package-info.java:\n", module)
+	for _, p := range pkgs {
+		name := p.name
+		if l := link(p.pkgDir); l != "" {
+			name = "[" + p.name + "|" + l + "]"
+		}
+		fmt.Fprintf(&b, "* %s (%d files)\n", name, len(p.files))
+	}
+	return fmt.Sprintf("Document packages of %s", module), b.String()
+}
+
+type jiraClient struct {
+	jiraConfig
+	token  string
+	http   *http.Client
+	dryRun bool
+}
+
+// openIssues returns the summaries of the issues filed before, that are not done yet.
+func (j *jiraClient) openIssues() (map[string]bool, error) {
+	jql := fmt.Sprintf("project = %q AND labels = %q AND statusCategory != Done", j.Project, jiraLabel)
+	open := map[string]bool{}
+	for start := 0; ; {
+		var resp struct {
+			Total  int `json:"total"`
+			Issues []struct {
+				Fields struct {
+					Summary string `json:"summary"`
+				} `json:"fields"`
+			} `json:"issues"`
+		}
+		query := url.Values{"jql": {jql}, "fields": {"summary"}, "startAt": {fmt.Sprint(start)}, "maxResults": {"100"}}
+		if err := j.call(http.MethodGet, "/rest/api/2/search?"+query.Encode(), nil, &resp); err != nil {
+			return nil, err
+		}
+		for _, issue := range resp.Issues {
+			open[issue.Fields.Summary] = true
+		}
+		start += len(resp.Issues)
+		if len(resp.Issues) == 0 || start >= resp.Total {
+			return open, nil
+		}
+	}
+}
+
+// createIssue files an issue, returning its key.
+func (j *jiraClient) createIssue(summary, description string) (string, error) {
+	issueType := j.IssueType
+	if issueType == "" {
+		issueType = "Task"
+	}
+	req := map[string]interface{}{"fields": map[string]interface{}{
+		"project":     map[string]string{"key": j.Project},
+		"issuetype":   map[string]string{"name": issueType},
+		"summary":     summary,
+		"description": description,
+		"labels":      []string{jiraLabel},
+	}}
+	var resp struct {
+		Key string `json:"key"`
+	}
+	err := j.call(http.MethodPost, "/rest/api/2/issue", req, &resp)
+	return resp.Key, err
+}
+
+func (j *jiraClient) call(method, path string, body, result interface{}) error {
+	var r io.Reader
+	if body != nil {
+		blob, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(blob)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(j.URL, "/")+path, r)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(j.User, j.token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := j.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(msg))
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,507 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Kafka sink, producing an event per changed package and per finding of every scan, for the consumers to react on:
+//  go run . -d ./platform -publish
+// to the topic from the config:
+//  {"sinks": {"kafka": {"brokers": ["kafka-1:9092", "kafka-2:9092"], "topic": "jet-search", "state": "/var/lib/jet-search/kafka.json"}}}
+// The changes are since the scan published before, kept in the state snapshot file, so the first publish has none.
+// Events are JSON, see kafkaEvent, keyed by the package dir or the finding path, so the events of a package
+// keep their order in a partition. Avro is not supported, as it needs a schema registry.
+// The producer speaks the Kafka protocol itself: Metadata v4 to find the partition leaders and Produce v3,
+// with record batches v2, acknowledged by all the in-sync replicas. It does not support TLS or SASL.
+
+import (
+	"bufio"
+	"bytes"
+	bin "encoding/binary" // not to clash with the binary type of the rule expressions in rules.go
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	kafkaClientID  = "jet-search"
+	kafkaBatchSize = 1000 // records per partition in a produce request
+
+	kafkaProduce  = 0
+	kafkaMetadata = 3
+)
+
+func init() {
+	sinkTypes["kafka"] = newKafkaSink
+}
+
+// kafkaConfig is the topic to produce to.
+type kafkaConfig struct {
+	Brokers []string `json:"brokers"` // bootstrap ones, host:port
+	Topic   string   `json:"topic"`
+	State   string   `json:"state"`  // snapshot file of the last published scan, to produce the package changes since
+	Format  string   `json:"format"` // json, the only one
+}
+
+type kafkaSink struct {
+	kafkaConfig
+	timeout time.Duration
+}
+
+func newKafkaSink(config json.RawMessage) (sink, error) {
+	var c kafkaConfig
+	if err := json.Unmarshal(config, &c); err != nil {
+		return nil, err
+	}
+	if len(c.Brokers) == 0 || c.Topic == "" {
+		return nil, fmt.Errorf("brokers and topic are required")
+	}
+	if c.Format != "" && c.Format != "json" {
+		return nil, fmt.Errorf("unsupported format %q, only json", c.Format)
+	}
+	return &kafkaSink{kafkaConfig: c, timeout: 30 * time.Second}, nil
+}
+
+// kafkaEvent is a package-changed or a finding event.
+type kafkaEvent struct {
+	Type    string `json:"type"`
+	Time    string `json:"time"`    // of the scan
+	ScanDir string `json:"scanDir"` // the scanned dir
+
+	Change  string `json:"change,omitempty"` // added, removed, documented or undocumented, see packageChanges
+	Dir     string `json:"dir,omitempty"`
+	Package string `json:"package,omitempty"`
+
+	Rule       string `json:"rule,omitempty"`
+	Level      string `json:"level,omitempty"`
+	Message    string `json:"message,omitempty"`
+	Path       string `json:"path,omitempty"`
+	Suppressed string `json:"suppressed,omitempty"`
+}
+
+// kafkaEvents returns the events of the scan as records, keyed by the package dir or the finding path.
+func kafkaEvents(prev, s *snapshot, findings []finding) ([]kafkaRecord, error) {
+	scanTime := s.time.Format(time.RFC3339)
+	var events []kafkaEvent
+	if prev != nil {
+		for _, c := range packageChanges(prev.pkgs, s.pkgs) {
+			events = append(events, kafkaEvent{Type: c.Type, Time: scanTime, ScanDir: s.dir, Change: c.Change, Dir: c.Dir, Package: c.Package})
+		}
+	}
+	for _, f := range findings {
+		events = append(events, kafkaEvent{Type: "finding", Time: scanTime, ScanDir: s.dir,
+			Rule: f.rule, Level: f.level, Message: f.message, Path: f.path, Suppressed: f.suppressed})
+	}
+
+	records := make([]kafkaRecord, len(events))
+	for i, e := range events {
+		value, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		key := e.Dir
+		if e.Type == "finding" {
+			key = e.Path
+		}
+		records[i] = kafkaRecord{key: []byte(key), value: value, time: s.time}
+	}
+	return records, nil
+}
+
+func (k *kafkaSink) publish(s *snapshot, findings []finding) error {
+	var prev *snapshot
+	if k.State != "" {
+		p, err := readSnapshot(k.State)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		prev = p
+	}
+	records, err := kafkaEvents(prev, s, findings)
+	if err != nil {
+		return err
+	}
+	if err := k.produce(records); err != nil {
+		return err
+	}
+	if k.State != "" {
+		return saveSnapshot(k.State, s)
+	}
+	return nil
+}
+
+// produce sends the records to the leaders of their partitions, by the murmur2 hash of the key as Java clients do.
+func (k *kafkaSink) produce(records []kafkaRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	leaders, addrs, err := k.metadata()
+	if err != nil {
+		return err
+	}
+
+	byLeader := map[int32]map[int32][]kafkaRecord{}
+	for _, r := range records {
+		partition := int32((murmur2(r.key) & 0x7fffffff) % uint32(len(leaders)))
+		leader := leaders[partition]
+		if byLeader[leader] == nil {
+			byLeader[leader] = map[int32][]kafkaRecord{}
+		}
+		byLeader[leader][partition] = append(byLeader[leader][partition], r)
+	}
+
+	for leader, partitions := range byLeader {
+		addr, ok := addrs[leader]
+		if !ok {
+			return fmt.Errorf("no address of the leader broker %d", leader)
+		}
+		conn, err := dialKafka(addr, k.timeout)
+		if err != nil {
+			return err
+		}
+		err = k.produceTo(conn, partitions)
+		conn.Close()
+		if err != nil {
+			return fmt.Errorf("producing to %s: %v", addr, err)
+		}
+	}
+	return nil
+}
+
+// metadata returns the leader of every partition of the topic and the addresses of the brokers, by node ID.
+func (k *kafkaSink) metadata() ([]int32, map[int32]string, error) {
+	var errs []string
+	for _, broker := range k.Brokers {
+		conn, err := dialKafka(broker, k.timeout)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		leaders, addrs, err := conn.metadata(k.Topic)
+		conn.Close()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", broker, err))
+			continue
+		}
+		return leaders, addrs, nil
+	}
+	return nil, nil, fmt.Errorf("no metadata of topic %q from the brokers: %v", k.Topic, errs)
+}
+
+func (k *kafkaSink) produceTo(conn *kafkaConn, partitions map[int32][]kafkaRecord) error {
+	for done := false; !done; {
+		done = true
+		var req kafkaEncoder
+		req.nullableString("") // transactional ID
+		req.int16(-1)          // acks by all the in-sync replicas
+		req.int32(int32(k.timeout / time.Millisecond))
+		req.int32(1) // topics
+		req.string(k.Topic)
+		req.int32(int32(len(partitions)))
+		for partition, records := range partitions {
+			batch := records
+			if len(batch) > kafkaBatchSize {
+				batch, done = batch[:kafkaBatchSize], false
+			}
+			partitions[partition] = records[len(batch):]
+			req.int32(partition)
+			req.bytes(encodeRecordBatch(batch))
+		}
+
+		resp, err := conn.request(kafkaProduce, 3, req.Bytes())
+		if err != nil {
+			return err
+		}
+		d := kafkaDecoder{b: resp}
+		for topics := d.int32(); topics > 0; topics-- {
+			d.string()
+			for n := d.int32(); n > 0; n-- {
+				partition, code := d.int32(), d.int16()
+				d.int64() // base offset
+				d.int64() // log append time
+				if code != 0 {
+					return fmt.Errorf("partition %d: %s", partition, kafkaError(code))
+				}
+			}
+		}
+		if d.err != nil {
+			return d.err
+		}
+		for partition, records := range partitions {
+			if len(records) == 0 {
+				delete(partitions, partition)
+			}
+		}
+	}
+	return nil
+}
+
+// kafkaRecord is a message to produce.
+type kafkaRecord struct {
+	key, value []byte
+	time       time.Time
+}
+
+// encodeRecordBatch encodes the records as a v2 record batch, without compression.
+func encodeRecordBatch(records []kafkaRecord) []byte {
+	first := records[0].time.UnixMilli()
+	var body kafkaEncoder // from the attributes on, covered by the CRC
+	body.int16(0)         // attributes
+	body.int32(int32(len(records) - 1))
+	body.int64(first)
+	body.int64(records[len(records)-1].time.UnixMilli())
+	body.int64(-1) // producer ID
+	body.int16(-1) // producer epoch
+	body.int32(-1) // base sequence
+	body.int32(int32(len(records)))
+	for i, r := range records {
+		var rec kafkaEncoder
+		rec.WriteByte(0) // attributes
+		rec.varint(r.time.UnixMilli() - first)
+		rec.varint(int64(i))
+		rec.varint(int64(len(r.key)))
+		rec.Write(r.key)
+		rec.varint(int64(len(r.value)))
+		rec.Write(r.value)
+		rec.varint(0) // headers
+		body.varint(int64(rec.Len()))
+		body.Write(rec.Bytes())
+	}
+
+	var batch kafkaEncoder
+	batch.int64(0)                             // base offset
+	batch.int32(int32(4 + 1 + 4 + body.Len())) // length after this field
+	batch.int32(-1)                            // partition leader epoch
+	batch.WriteByte(2)                         // magic
+	batch.int32(int32(crc32.Checksum(body.Bytes(), crc32.MakeTable(crc32.Castagnoli))))
+	batch.Write(body.Bytes())
+	return batch.Bytes()
+}
+
+// kafkaConn is a connection to a broker, for one request at a time.
+type kafkaConn struct {
+	net.Conn
+	r             *bufio.Reader
+	timeout       time.Duration
+	correlationID int32
+}
+
+func dialKafka(addr string, timeout time.Duration) (*kafkaConn, error) {
+	conn, err := dialTCP(addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaConn{Conn: conn, r: bufio.NewReader(conn), timeout: timeout}, nil
+}
+
+// request sends a request with the v1 header, returning the response body after the correlation ID.
+func (c *kafkaConn) request(apiKey, version int16, body []byte) ([]byte, error) {
+	c.correlationID++
+	var req kafkaEncoder
+	req.int16(apiKey)
+	req.int16(version)
+	req.int32(c.correlationID)
+	req.string(kafkaClientID)
+	req.Write(body)
+
+	c.SetDeadline(time.Now().Add(c.timeout))
+	size := make([]byte, 4)
+	bin.BigEndian.PutUint32(size, uint32(req.Len()))
+	if _, err := c.Write(append(size, req.Bytes()...)); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(c.r, size); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, bin.BigEndian.Uint32(size))
+	if _, err := io.ReadFull(c.r, resp); err != nil {
+		return nil, err
+	}
+	if len(resp) < 4 || int32(bin.BigEndian.Uint32(resp)) != c.correlationID {
+		return nil, errors.New("unexpected correlation ID of the response")
+	}
+	return resp[4:], nil
+}
+
+// metadata returns the leader of every partition of the topic and the addresses of the brokers, by node ID.
+func (c *kafkaConn) metadata(topic string) ([]int32, map[int32]string, error) {
+	var req kafkaEncoder
+	req.int32(1)
+	req.string(topic)
+	req.WriteByte(0) // no auto topic creation
+	resp, err := c.request(kafkaMetadata, 4, req.Bytes())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	d := kafkaDecoder{b: resp}
+	d.int32() // throttle time
+	addrs := map[int32]string{}
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		id, host, port := d.int32(), d.string(), d.int32()
+		d.string() // rack
+		addrs[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.string() // cluster ID
+	d.int32()  // controller ID
+	var leaders []int32
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		code, name := d.int16(), d.string()
+		d.int8() // is internal
+		if name != topic {
+			return nil, nil, fmt.Errorf("metadata of topic %q instead of %q", name, topic)
+		}
+		if code != 0 {
+			return nil, nil, fmt.Errorf("topic %q: %s", topic, kafkaError(code))
+		}
+		partitions := d.int32()
+		if partitions <= 0 {
+			break
+		}
+		leaders = make([]int32, partitions)
+		for i := int32(0); i < partitions && d.err == nil; i++ {
+			d.int16() // error code, of the replicas
+			index, leader := d.int32(), d.int32()
+			for replicas := d.int32(); replicas > 0; replicas-- {
+				d.int32()
+			}
+			for isr := d.int32(); isr > 0; isr-- {
+				d.int32()
+			}
+			if index >= 0 && index < partitions {
+				leaders[index] = leader
+			}
+		}
+	}
+	if d.err != nil {
+		return nil, nil, d.err
+	}
+	if len(leaders) == 0 {
+		return nil, nil, fmt.Errorf("no partitions of topic %q", topic)
+	}
+	return leaders, addrs, nil
+}
+
+// kafkaError describes the common error codes of the responses.
+func kafkaError(code int16) string {
+	switch code {
+	case 3:
+		return "unknown topic or partition"
+	case 6:
+		return "not the leader of the partition"
+	case 7:
+		return "request timed out"
+	case 10:
+		return "message too large"
+	case 19, 20:
+		return "not enough in-sync replicas"
+	case 29:
+		return "topic authorization failed"
+	}
+	return "error code " + strconv.Itoa(int(code))
+}
+
+// kafkaEncoder writes the primitive types of the protocol.
+type kafkaEncoder struct {
+	bytes.Buffer
+}
+
+func (e *kafkaEncoder) int16(v int16) { bin.Write(e, bin.BigEndian, v) }
+func (e *kafkaEncoder) int32(v int32) { bin.Write(e, bin.BigEndian, v) }
+func (e *kafkaEncoder) int64(v int64) { bin.Write(e, bin.BigEndian, v) }
+
+func (e *kafkaEncoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.WriteString(s)
+}
+
+// nullableString writes null for "".
+func (e *kafkaEncoder) nullableString(s string) {
+	if s == "" {
+		e.int16(-1)
+		return
+	}
+	e.string(s)
+}
+
+func (e *kafkaEncoder) bytes(b []byte) {
+	e.int32(int32(len(b)))
+	e.Write(b)
+}
+
+// varint writes a zigzag encoded variable length integer, as in the records.
+func (e *kafkaEncoder) varint(v int64) {
+	buf := make([]byte, bin.MaxVarintLen64)
+	e.Write(buf[:bin.PutVarint(buf, v)])
+}
+
+// kafkaDecoder reads the primitive types of the protocol, keeping the first error.
+type kafkaDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *kafkaDecoder) next(n int) []byte {
+	if d.err != nil {
+		return make([]byte, n)
+	}
+	if n < 0 || n > len(d.b) {
+		d.err = errors.New("truncated response")
+		return make([]byte, 8)
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b
+}
+
+func (d *kafkaDecoder) int8() int8   { return int8(d.next(1)[0]) }
+func (d *kafkaDecoder) int16() int16 { return int16(bin.BigEndian.Uint16(d.next(2))) }
+func (d *kafkaDecoder) int32() int32 { return int32(bin.BigEndian.Uint32(d.next(4))) }
+func (d *kafkaDecoder) int64() int64 { return int64(bin.BigEndian.Uint64(d.next(8))) }
+
+// string reads a nullable string, "" for null.
+func (d *kafkaDecoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
+
+// murmur2 is the hash of the keys of the Java clients, to partition alike.
+func murmur2(data []byte) uint32 {
+	const (
+		seed = 0x9747b28c
+		m    = 0x5bd1e995
+		r    = 24
+	)
+	length := len(data)
+	h := uint32(seed) ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := bin.LittleEndian.Uint32(data[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	tail := data[length&^3:]
+	switch len(tail) {
+	case 3:
+		h ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(tail[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
@@ -0,0 +1,149 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// God packages, exceeding the limits on files, lines or public types, are refactoring candidates:
+//  go run . check large-packages -d ./platform -max-files 100 -max-loc 20000 -max-public-types 50
+// The limits can also be set in the config: {"packageLimits": {"files": 100, "loc": 20000, "publicTypes": 50}}
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// packageLimits are the max values for a package, 0 being no limit.
+type packageLimits struct {
+	Files       int `json:"files"`
+	LOC         int `json:"loc"`
+	PublicTypes int `json:"publicTypes"`
+}
+
+var (
+	// top-level types start at the beginning of a line
+	javaPublicType   = regexp.MustCompile(`^public\s+((abstract|final|sealed|non-sealed|static|strictfp)\s+)*(class|interface|enum|record|@interface)\s`)
+	kotlinPublicType = regexp.MustCompile(`^((public|open|abstract|sealed|final|data|enum|annotation|inline|value|fun|expect|actual)\s+)*(class|interface|object)\s`)
+	scalaPublicType  = regexp.MustCompile(`^((abstract|final|sealed|case|implicit|open)\s+)*(class|trait|object|enum)\s`)
+	groovyPublicType = regexp.MustCompile(`^((public|abstract|final|static|sealed)\s+)*(class|interface|trait|enum|record|@interface)\s`)
+
+	// public types by the source extension, a type without a modifier is public in all but Java, none in the others of -ext
+	publicTypes = map[string]*regexp.Regexp{".java": javaPublicType, ".kt": kotlinPublicType, ".kts": kotlinPublicType, ".scala": scalaPublicType, ".groovy": groovyPublicType}
+)
+
+// countSize updates .lines, .publicTypes and .documentedTypes with the ones of a source file.
+// A public type is documented if a /** comment precedes it, maybe with annotations in between.
+func countSize(p *pkg, f *sourceFile) error {
+	if !f.isSource() {
+		return nil
+	}
+	content, err := f.content()
+	if err != nil {
+		return err
+	}
+
+	publicType := publicTypes[filepath.Ext(f.path)]
+	s := bufio.NewScanner(bytes.NewReader(content))
+	s.Buffer(nil, 1024*1024)
+	inDoc, docBefore := false, false
+	for s.Scan() {
+		p.lines++
+		line := bytes.TrimSpace(s.Bytes())
+		switch {
+		case publicType != nil && publicType.Match(s.Bytes()):
+			p.publicTypes++
+			if docBefore {
+				p.documentedTypes++
+			}
+			docBefore = false
+		case bytes.HasPrefix(line, []byte("/**")) && !inDoc:
+			inDoc = true
+			fallthrough
+		case inDoc:
+			if bytes.Contains(line, []byte("*/")) {
+				inDoc, docBefore = false, true
+			}
+		case len(line) == 0 || line[0] == '@':
+		default:
+			docBefore = false
+		}
+	}
+	return s.Err()
+}
+
+// largePackage is a package over the limits, by the given factor of the limit.
+type largePackage struct {
+	*pkg
+	over float64
+}
+
+// largePackages returns packages over any of the limits, sorted by the largest factor over the limit.
+func largePackages(pkgs map[string]*pkg, limits packageLimits) []largePackage {
+	var large []largePackage
+	for _, p := range pkgs {
+		over := 0.0
+		for _, m := range []struct{ value, limit int }{
+			{len(p.files), limits.Files}, {p.lines, limits.LOC}, {p.publicTypes, limits.PublicTypes},
+		} {
+			if m.limit > 0 && m.value > m.limit && float64(m.value)/float64(m.limit) > over {
+				over = float64(m.value) / float64(m.limit)
+			}
+		}
+		if over > 0 {
+			large = append(large, largePackage{p, over})
+		}
+	}
+	sort.Slice(large, func(i, j int) bool {
+		if large[i].over != large[j].over {
+			return large[i].over > large[j].over
+		}
+		return large[i].pkgDir < large[j].pkgDir
+	})
+	return large
+}
+
+// checkLargePackages lists packages over the limits.
+func checkLargePackages(args []string) error {
+	fs := flag.NewFlagSet("check large-packages", flag.ExitOnError)
+	dir := fs.String("d", "", "dir to scan for packages")
+	maxFiles := fs.Int("max-files", 0, "max number of .java and .kt files, packageLimits.files from the config by default")
+	maxLOC := fs.Int("max-loc", 0, "max number of lines in the source files, packageLimits.loc from the config by default")
+	maxTypes := fs.Int("max-public-types", 0, "max number of top-level public types, packageLimits.publicTypes from the config by default")
+	fail := fs.Bool("fail", false, "exit with non-zero code if any package is over the limits")
+	addFormatFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	limits := cfg.PackageLimits
+	for _, l := range []struct{ flag, limit *int }{{maxFiles, &limits.Files}, {maxLOC, &limits.LOC}, {maxTypes, &limits.PublicTypes}} {
+		if *l.flag > 0 {
+			*l.limit = *l.flag
+		}
+	}
+	if *dir == "" || limits == (packageLimits{}) {
+		fs.Usage()
+		return nil
+	}
+
+	pkgs, err := scanDir(*dir, false, countSize)
+	if err != nil {
+		return err
+	}
+
+	large := largePackages(pkgs, limits)
+	printHeader([]string{"over limit", "files", "lines", "public types", "module", "package"})
+	for _, p := range large {
+		if *mdFlag {
+			fmt.Printf("x%-5.1f | %-5d | %-6d | %-4d | %-50s | %s\n", p.over, len(p.files), p.lines, p.publicTypes, p.module, hyperlink(link(p.pkgDir), p.name))
+		} else {
+			fmt.Printf("x%.1f\t%d\t%d\t%d\t%s\t%s\n", p.over, len(p.files), p.lines, p.publicTypes, p.module, p.pkgDir)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%d of %d packages over files:%d lines:%d public types:%d\n", len(large), len(pkgs), limits.Files, limits.LOC, limits.PublicTypes)
+
+	return failedCheck("large-packages", *fail, len(large))
+}
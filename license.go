@@ -0,0 +1,157 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// License audit, for the legal review of the third-party code vendored into the modules:
+//  go run . check licenses -d ./platform -default Apache-2.0
+// lists the packages that have files with a license header other than the default one,
+// the most common one among the scanned files if not given. Files without a header are not reported.
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+func init() {
+	ruleDescriptions["MixedLicenses"] = "Package has files under a license other than the default one"
+}
+
+const licenseHeaderLines = 30
+
+var (
+	spdxLicense = regexp.MustCompile(`SPDX-License-Identifier:\s*([\w.+-]+)`)
+
+	// licenseMarkers tell a license by a phrase of its header, the first matching one wins.
+	licenseMarkers = []struct{ license, phrase string }{
+		{"Apache-2.0", "Apache License"},
+		{"Apache-2.0", "Apache 2.0 license"},
+		{"EPL", "Eclipse Public License"},
+		{"LGPL", "GNU Lesser General Public License"},
+		{"GPL", "GNU General Public License"},
+		{"MPL", "Mozilla Public License"},
+		{"MIT", "MIT License"},
+		{"MIT", "Permission is hereby granted, free of charge"},
+		{"BSD", "Redistribution and use in source and binary forms"},
+	}
+)
+
+// detectLicense updates .licenses with the license of the header of a source file, if it has one.
+func detectLicense(p *pkg, f *sourceFile) error {
+	if !f.isSource() {
+		return nil
+	}
+	content, err := f.content()
+	if err != nil {
+		return err
+	}
+
+	var header strings.Builder
+	s := bufio.NewScanner(bytes.NewReader(content))
+	s.Buffer(nil, 1024*1024)
+	for i := 0; i < licenseHeaderLines && s.Scan(); i++ {
+		line := s.Text()
+		if strings.HasPrefix(strings.TrimSpace(line), "package ") {
+			break
+		}
+		header.WriteString(line)
+		header.WriteByte('\n')
+	}
+
+	if license := headerLicense(header.String()); license != "" {
+		if p.licenses == nil {
+			p.licenses = map[string]int{}
+		}
+		p.licenses[license]++
+	}
+	return s.Err()
+}
+
+// headerLicense returns the license of the file header, "" if there is none.
+func headerLicense(header string) string {
+	if m := spdxLicense.FindStringSubmatch(header); m != nil {
+		return m[1]
+	}
+	for _, m := range licenseMarkers {
+		if strings.Contains(header, m.phrase) {
+			return m.license
+		}
+	}
+	return ""
+}
+
+// commonLicense returns the license of most of the files.
+func commonLicense(pkgs map[string]*pkg) string {
+	total := map[string]int{}
+	for _, p := range pkgs {
+		for l, n := range p.licenses {
+			total[l] += n
+		}
+	}
+	common := ""
+	for l, n := range total {
+		if n > total[common] || (n == total[common] && l < common) {
+			common = l
+		}
+	}
+	return common
+}
+
+// licenseFindings reports packages with files under a license other than the default one.
+func licenseFindings(pkgs map[string]*pkg, defaultLicense string) []finding {
+	var findings []finding
+	for _, p := range pkgs {
+		var other []string
+		for l, n := range p.licenses {
+			if l != defaultLicense {
+				other = append(other, fmt.Sprintf("%s:%d", l, n))
+			}
+		}
+		if len(other) == 0 {
+			continue
+		}
+		sort.Strings(other)
+		findings = append(findings, finding{rule: "MixedLicenses", level: "warning",
+			message: fmt.Sprintf("Package %s of %s has files under %s, not %s", p.name, p.module, strings.Join(other, ", "), defaultLicense), path: p.pkgDir})
+	}
+	sortFindings(findings)
+	return findings
+}
+
+// checkLicenses lists packages with files under a license other than the default one.
+func checkLicenses(args []string) error {
+	fs := flag.NewFlagSet("check licenses", flag.ExitOnError)
+	dir := fs.String("d", "", "dir to scan for packages")
+	defaultLicense := fs.String("default", "", "license of the repository, i.e Apache-2.0, the most common one by default")
+	fail := fs.Bool("fail", false, "exit with non-zero code if any package has files under other licenses")
+	addFormatFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		fs.Usage()
+		return nil
+	}
+
+	pkgs, err := scanDir(*dir, false, detectLicense)
+	if err != nil {
+		return err
+	}
+	if *defaultLicense == "" {
+		*defaultLicense = commonLicense(pkgs)
+	}
+
+	findings := licenseFindings(pkgs, *defaultLicense)
+	printFindings(findings)
+	modules := map[string]bool{}
+	for _, f := range findings {
+		modules[pkgs[f.path].module] = true
+	}
+	fmt.Fprintf(os.Stderr, "%d packages in %d modules have files under licenses other than %s\n", len(findings), len(modules), *defaultLicense)
+
+	return failedCheck("licenses", *fail, len(findings))
+}
@@ -0,0 +1,78 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Links to the files, by -link-style:
+//  space   https://jetbrains.team/p/ij/repositories/community/files/[<commit>/]<path>
+//  github  https://github.com/JetBrains/intellij-community/blob/<HEAD or commit>/<path>
+//  none    no links, the reports show the names only
+// -link-base replaces the URL of the scanned tree, i.e with a fork or a self-hosted mirror of the same style:
+//  go run . -d ./platform -link-style github -link-base https://github.com/me/intellij-community -md
+// The repos of the config are linked at their own URLs, in the same style.
+
+import (
+	"fmt"
+	"strings"
+)
+
+const githubURL = "https://github.com/JetBrains/intellij-community"
+
+var linkStyles = []string{"space", "github", "none"}
+
+func checkLinkStyle(style string) error {
+	if !contains(linkStyles, style) {
+		return fmt.Errorf("unknown link style %q, expected one of %s", style, strings.Join(linkStyles, ", "))
+	}
+	return nil
+}
+
+// linkBase returns the URL of the scanned tree.
+func linkBase() string {
+	switch {
+	case *linkBaseFlag != "":
+		return *linkBaseFlag
+	case *linkStyleFlag == "github":
+		return githubURL
+	}
+	return spaceURL
+}
+
+// fileLink returns the link to the path relative to the repo at the base URL, pinned to the commit with -links pinned,
+// or "" with -link-style none.
+func fileLink(base, commit, rel string) string {
+	base = strings.TrimSuffix(base, "/")
+	if *linksFlag != "pinned" {
+		commit = ""
+	}
+	switch *linkStyleFlag {
+	case "none":
+		return ""
+	case "github":
+		ref := "HEAD" // the default branch
+		if commit != "" {
+			ref = commit
+		}
+		return base + "/blob/" + ref + "/" + rel
+	}
+	if commit != "" {
+		base += "/" + commit
+	}
+	return base + "/" + rel
+}
+
+// hyperlink formats the link with the label in the format selected by the flags: a formula in the spreadsheet,
+// or the URL alone with -gs-links url, a Markdown link, or the label alone if there is no link.
+func hyperlink(url, label string) string {
+	switch {
+	case *gsFlag && url == "":
+		return gsCell(label)
+	case url == "":
+		return label
+	case gsPlainLinks():
+		return gsCell(url)
+	case *gsFlag:
+		return gsFormula(url, label)
+	case *mdFlag:
+		return fmt.Sprintf("[%s](%s)", label, url)
+	}
+	return label
+}
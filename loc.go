@@ -0,0 +1,65 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Lines of code of the packages, counted with -loc as code, comment and blank lines of the sources,
+// printed in extra columns and summed per module, i.e to weight the doc coverage by the package size:
+//  go run . -d ./platform -loc -gs
+//  go run . -d ./platform -coverage-metric loc-weighted
+// A line with both code and a comment is code. Comments are //, /* */ and /** */ ones, nested ones count as flat.
+
+import (
+	"bufio"
+	"bytes"
+)
+
+// countLOC updates .codeLines, .commentLines and .blankLines with the ones of a source file.
+func countLOC(p *pkg, f *sourceFile) error {
+	if !f.isSource() {
+		return nil
+	}
+	content, err := f.content()
+	if err != nil {
+		return err
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(content))
+	s.Buffer(nil, 1024*1024)
+	inComment := false
+	for s.Scan() {
+		line := bytes.TrimSpace(s.Bytes())
+		if len(line) == 0 {
+			p.blankLines++
+			continue
+		}
+		code := false
+		for len(line) > 0 {
+			if inComment {
+				end := bytes.Index(line, []byte("*/"))
+				if end < 0 {
+					break
+				}
+				line, inComment = bytes.TrimSpace(line[end+2:]), false
+				continue
+			}
+			if bytes.HasPrefix(line, []byte("//")) {
+				break
+			}
+			if bytes.HasPrefix(line, []byte("/*")) {
+				line, inComment = line[2:], true
+				continue
+			}
+			code = true
+			start := bytes.Index(line, []byte("/*")) // a trailing comment, maybe not closed on this line
+			if start < 0 {
+				break
+			}
+			line, inComment = line[start+2:], true
+		}
+		if code {
+			p.codeLines++
+		} else {
+			p.commentLines++
+		}
+	}
+	return s.Err()
+}
@@ -0,0 +1,124 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Markdown table of the packages, to paste into a wiki page or a review:
+//  go run . -d ./platform -md
+// The packages are grouped by module, each module followed by a subtotal row unless it is the only one,
+// and the table by a total row. The doc status links the package-info.java (✅) or the legacy package.html (🚧).
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// mdTotal sums the columns of the packages of a module or of the table.
+type mdTotal struct {
+	packages, files, documented, legacy       int
+	filesCnt                                  map[string]int
+	publicTypes, documentedTypes, debtMarkers int
+	codeLines, commentLines, blankLines       int
+}
+
+func (t *mdTotal) add(p *pkg) {
+	if t.filesCnt == nil {
+		t.filesCnt = map[string]int{}
+	}
+	t.packages++
+	t.files += len(p.files)
+	for ext, n := range p.filesCnt {
+		t.filesCnt[ext] += n
+	}
+	switch {
+	case p.isDocumented():
+		t.documented++
+	case p.isLegacyDoc():
+		t.legacy++
+	}
+	t.publicTypes += p.publicTypes
+	t.documentedTypes += p.documentedTypes
+	t.debtMarkers += p.debtMarkers
+	t.codeLines += p.codeLines
+	t.commentLines += p.commentLines
+	t.blankLines += p.blankLines
+}
+
+// printPackagesMarkdown prints the packages grouped by module as a Markdown table, with the subtotals and the total.
+func printPackagesMarkdown(w io.Writer, pkgs map[string]*pkg, contentModules map[string]string) {
+	list := listedPackages(pkgs)
+	sort.SliceStable(list, func(i, j int) bool { return list[i].module < list[j].module })
+	modules := 0
+	for i, p := range list {
+		if i == 0 || p.module != list[i-1].module {
+			modules++
+		}
+	}
+
+	fprintCommit(w)
+	fprintHeader(w, packageFields())
+	var total, subtotal mdTotal
+	for i, p := range list {
+		docLink := ""
+		if p.isLegacyDoc() {
+			docLink = hyperlink(link(p.doc), "🚧")
+		} else if p.isDocumented() {
+			docLink = hyperlink(link(p.doc), "✅")
+		}
+		fmt.Fprintf(w, "%-3d | %s | %-50s | %s | %s | %s", len(p.files), fmtFilesCnt(p), p.module, hyperlink(link(p.pkgDir), p.name), docLink, p.apiClass())
+		if *contentModulesFlag {
+			fmt.Fprint(w, " | "+pluginModel(contentModules[p.module]))
+		}
+		if *docCoverageFlag {
+			fmt.Fprintf(w, " | %s | %s", p.typeDocCoverage(), yesNo(p.isDocumented()))
+		}
+		if *readmeFlag {
+			fmt.Fprintf(w, " | %s | %s", fmtReadme(p.readme), fmtReadme(p.moduleReadme))
+		}
+		if *debtMarkersFlag {
+			fmt.Fprintf(w, " | %d", p.debtMarkers)
+		}
+		if *locFlag {
+			fmt.Fprintf(w, " | %d | %d | %d", p.codeLines, p.commentLines, p.blankLines)
+		}
+		fmt.Fprintln(w)
+
+		total.add(p)
+		subtotal.add(p)
+		if modules > 1 && (i == len(list)-1 || list[i+1].module != p.module) {
+			fprintMdTotal(w, p.module, "subtotal", &subtotal, pluginModel(contentModules[p.module]))
+			subtotal = mdTotal{}
+		}
+	}
+	fprintMdTotal(w, "", "total", &total, "")
+}
+
+// fprintMdTotal prints a row of the sums in bold, the doc status being the number of the documented packages.
+func fprintMdTotal(w io.Writer, module, label string, t *mdTotal, model string) {
+	cols := []string{fmt.Sprintf("**%d**", t.files)}
+	for _, ext := range sourceExts {
+		cols = append(cols, fmt.Sprintf("**%d**", t.filesCnt[ext]))
+	}
+	docs := fmt.Sprintf("✅ %d/%d", t.documented, t.packages)
+	if t.legacy > 0 {
+		docs += fmt.Sprintf(", 🚧 %d", t.legacy)
+	}
+	cols = append(cols, fmt.Sprintf("%-50s", module), fmt.Sprintf("**%s** of %d", label, t.packages), docs, "")
+	if *contentModulesFlag {
+		cols = append(cols, model)
+	}
+	if *docCoverageFlag {
+		summed := &pkg{publicTypes: t.publicTypes, documentedTypes: t.documentedTypes}
+		cols = append(cols, summed.typeDocCoverage(), fmt.Sprintf("%d/%d", t.documented, t.packages))
+	}
+	if *readmeFlag {
+		cols = append(cols, "", "")
+	}
+	if *debtMarkersFlag {
+		cols = append(cols, fmt.Sprintf("**%d**", t.debtMarkers))
+	}
+	if *locFlag {
+		cols = append(cols, fmt.Sprintf("**%d**", t.codeLines), fmt.Sprintf("**%d**", t.commentLines), fmt.Sprintf("**%d**", t.blankLines))
+	}
+	fmt.Fprintln(w, strings.TrimRight(strings.Join(cols, " | "), " "))
+}
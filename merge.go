@@ -0,0 +1,143 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Merge combines the snapshots of partial scans, i.e of the sharded CI jobs that scan a subtree each,
+// into one snapshot of the whole tree:
+//  go run . merge platform-a.json platform-b.json -o platform.json
+// A package in several snapshots, as the shards overlapped, is a conflict unless it is the same in all of them.
+// By -on-conflict, a conflict fails the merge, or the package of the first, the last or the newest snapshot wins.
+// The merged snapshot is of the common parent of the scanned dirs, at the time of the newest scan.
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var conflictRules = []string{"fail", "first", "last", "newest"}
+
+func runMerge(args []string) error {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	out := fs.String("o", "", "snapshot file to write, protobuf if it ends with .pb")
+	onConflict := fs.String("on-conflict", "fail", "what to do with a package that differs between the snapshots: "+strings.Join(conflictRules, "|"))
+	fs.BoolVar(offlineFlag, "offline", false, "disable all the network access, for the snapshot files only")
+	srcs, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(srcs) < 2 || *out == "" {
+		fmt.Fprintln(os.Stderr, "usage: merge [flags] <snapshot file or URL>... -o <merged snapshot file>")
+		fs.PrintDefaults()
+		return nil
+	}
+
+	snapshots := make([]*snapshot, len(srcs))
+	for i, src := range srcs {
+		if snapshots[i], err = loadSnapshot(src); err != nil {
+			return err
+		}
+	}
+	merged, conflicts, err := mergeSnapshots(snapshots, srcs, *onConflict)
+	if err != nil {
+		return err
+	}
+	for _, c := range conflicts {
+		fmt.Fprintln(os.Stderr, c)
+	}
+	if len(conflicts) > 0 && *onConflict == "fail" {
+		return fmt.Errorf("%d packages differ between the snapshots, pick the winner with -on-conflict", len(conflicts))
+	}
+	if err := saveSnapshot(*out, merged); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "merged %d packages of %d snapshots in %s, %d conflicts\n", len(merged.pkgs), len(snapshots), *out, len(conflicts))
+	return nil
+}
+
+// mergeSnapshots merges the snapshots, named for the conflicts by the srcs, resolving the conflicts by the rule.
+// It returns the merged snapshot and a description of every conflict.
+func mergeSnapshots(snapshots []*snapshot, srcs []string, rule string) (*snapshot, []string, error) {
+	if !contains(conflictRules, rule) {
+		return nil, nil, fmt.Errorf("unknown conflict rule %q, expected one of %s", rule, strings.Join(conflictRules, ", "))
+	}
+
+	merged := &snapshot{pkgs: map[string]*pkg{}, publicTypes: true}
+	from := map[string]int{} // pkgDir -> the snapshot of the merged package
+	var conflicts []string
+	indexes := true
+	for i, s := range snapshots {
+		if i == 0 || s.time.After(merged.time) {
+			merged.time = s.time
+		}
+		merged.dir = commonDir(merged.dir, s.dir, i == 0)
+		merged.publicTypes = merged.publicTypes && s.publicTypes
+		indexes = indexes && s.roots != nil
+		if i == 0 {
+			merged.commit = s.commit
+		} else if s.commit != merged.commit { // kept only if all the shards are of the same checkout
+			merged.commit = ""
+		}
+
+		for _, p := range sortedPackages(s.pkgs) { // for the conflicts in order
+			pkgDir := p.pkgDir
+			j, seen := from[pkgDir]
+			if !seen || samePackage(merged.pkgs[pkgDir], p) {
+				if !seen {
+					merged.pkgs[pkgDir], from[pkgDir] = p, i
+				}
+				continue
+			}
+			conflicts = append(conflicts, fmt.Sprintf("%s differs in %s and %s", pkgDir, srcs[j], srcs[i]))
+			if rule == "last" || rule == "newest" && s.time.After(snapshots[j].time) {
+				merged.pkgs[pkgDir], from[pkgDir] = p, i
+			}
+		}
+	}
+
+	if indexes { // the roots of the shards, so the merged index can be updated with index -incremental
+		merged.version = snapshotVersion
+		merged.roots = map[string]time.Time{}
+		for _, s := range snapshots {
+			for root, t := range s.roots {
+				if t.After(merged.roots[root]) {
+					merged.roots[root] = t
+				}
+			}
+		}
+	}
+	merged.modules = summarizeModules(merged.pkgs)
+	return merged, conflicts, nil
+}
+
+// samePackage checks if the packages are the same, by their JSON as in the snapshots.
+func samePackage(a, b *pkg) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(ja, jb)
+}
+
+// commonDir returns the deepest dir containing both, or dir as is for the first one.
+func commonDir(common, dir string, first bool) string {
+	dir = filepath.Clean(dir)
+	if first {
+		return dir
+	}
+	for !isUnder(dir, common) && common != "." && common != string(filepath.Separator) {
+		common = filepath.Dir(common)
+	}
+	return common
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,29 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// The backlog of the docs team, the packages to write package-info.java for, the largest first:
+//  go run . -d ./platform -missing-docs -md
+// Only the packages without package-info.java are printed, with the legacy package.html ones, sorted by the number of
+// the files descending, and in Markdown by module and then by the files. The doc coverage is still of all the packages.
+
+import "sort"
+
+// missingDocs returns the packages without package-info.java.
+func missingDocs(pkgs map[string]*pkg) map[string]*pkg {
+	missing := map[string]*pkg{}
+	for dir, p := range pkgs {
+		if !p.isDocumented() {
+			missing[dir] = p
+		}
+	}
+	return missing
+}
+
+// listedPackages returns the packages in the order of the output: by dir, or by the files descending with -missing-docs.
+func listedPackages(pkgs map[string]*pkg) []*pkg {
+	list := sortedPackages(pkgs)
+	if *missingDocsFlag {
+		sort.SliceStable(list, func(i, j int) bool { return len(list[i].files) > len(list[j].files) })
+	}
+	return list
+}
@@ -0,0 +1,113 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Module documentation, tracked apart from the packages as it is written by other people, the module owners:
+//  go run . check module-docs -d ./platform -min 80 -fail
+// A module is documented by a README next to its .iml file, see readme.go, or by a Markdown overview in docs/ of
+// its dir. The check lists the undocumented modules and fails if the share of the documented ones is below -min,
+// 100% by default, with the severity of module-docs in the config, so that it is gated separately from the packages.
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func init() {
+	ruleDescriptions["UndocumentedModule"] = "Module has no README or docs/ overview"
+}
+
+// moduleDoc returns the README of the module dir, or else the first Markdown file of its docs/, "" if there is none.
+func moduleDoc(fsys fs.FS, module string) (string, error) {
+	dir := filepath.Dir(module)
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return "", fmt.Errorf("error listing module dir %q: %v", dir, err)
+	}
+	hasDocs := false
+	for _, e := range entries { // sorted by name
+		if !e.IsDir() && isReadme(e.Name()) {
+			return filepath.Join(dir, e.Name()), nil
+		}
+		hasDocs = hasDocs || (e.IsDir() && e.Name() == "docs")
+	}
+	if !hasDocs {
+		return "", nil
+	}
+	docs := filepath.Join(dir, "docs")
+	if entries, err = fs.ReadDir(fsys, docs); err != nil {
+		return "", fmt.Errorf("error listing module docs %q: %v", docs, err)
+	}
+	for _, e := range entries {
+		if !e.IsDir() && strings.EqualFold(path.Ext(e.Name()), ".md") {
+			return filepath.Join(docs, e.Name()), nil
+		}
+	}
+	return "", nil
+}
+
+// moduleDocFindings reports the modules of the packages without documentation, and returns the number of the modules.
+func moduleDocFindings(fsys fs.FS, pkgs map[string]*pkg) ([]finding, int, error) {
+	modules := map[string]bool{}
+	for _, p := range pkgs {
+		modules[p.module] = true
+	}
+	sorted := make([]string, 0, len(modules))
+	for m := range modules {
+		sorted = append(sorted, m)
+	}
+	sort.Strings(sorted)
+
+	var findings []finding
+	for _, m := range sorted {
+		doc, err := moduleDoc(fsys, m)
+		if err != nil {
+			return nil, 0, err
+		}
+		if doc == "" {
+			name := strings.TrimSuffix(filepath.Base(m), filepath.Ext(m))
+			findings = append(findings, finding{rule: "UndocumentedModule", level: "warning",
+				message: fmt.Sprintf("Module %s has no README or docs/ overview", name), path: m})
+		}
+	}
+	return findings, len(sorted), nil
+}
+
+// checkModuleDocs lists the undocumented modules, failing if the module doc coverage is below the minimal one.
+func checkModuleDocs(args []string) error {
+	fs := flag.NewFlagSet("check module-docs", flag.ExitOnError)
+	dir := fs.String("d", "", "dir to scan for modules")
+	minCoverage := fs.Float64("min", 100, "minimal % of the modules with a README or docs/ overview")
+	fail := fs.Bool("fail", false, "exit with non-zero code if the module doc coverage is below -min")
+	addFormatFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		fs.Usage()
+		return nil
+	}
+
+	pkgs, err := scanDir(*dir, false)
+	if err != nil {
+		return err
+	}
+	findings, total, err := moduleDocFindings(osFS{}, pkgs)
+	if err != nil {
+		return err
+	}
+	printFindings(findings)
+	coverage := percent(total-len(findings), total)
+	fmt.Fprintf(os.Stderr, "module doc coverage: %.1f%%, %d of %d modules, -min %.1f%%\n", coverage, total-len(findings), total, *minCoverage)
+
+	violations := 0
+	if coverage < *minCoverage {
+		violations = len(findings)
+	}
+	return failedCheck("module-docs", *fail, violations)
+}
@@ -0,0 +1,344 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Module-level report: Java language level and JVM target, Kotlin apiVersion.
+// A module either sets those in its .iml or inherits the project defaults from
+//  .idea/misc.xml        <component name="ProjectRootManager" languageLevel="JDK_17" />
+//  .idea/compiler.xml    <bytecodeTargetLevel target="17"><module name="..." target="11" /></bytecodeTargetLevel>
+//  .idea/kotlinc.xml     <component name="KotlinCommonCompilerArguments"><option name="apiVersion" value="1.9" />
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// moduleInfo is a JPS module with the effective toolchain settings.
+type moduleInfo struct {
+	path            string   // path to .iml file
+	name            string   // as in <orderEntry type="module" module-name="..." />
+	javaLevel       string   // LANGUAGE_LEVEL, e.g. JDK_17
+	jvmTarget       string   // bytecode target level, e.g. 17
+	kotlinAPI       string   // apiVersion, e.g. 1.9
+	kotlinJVMTarget string   // Kotlin jvmTarget, e.g. 17
+	facets          []string // facet types, e.g. kotlin-language, android
+	contentModule   string   // path to <idea-plugin package="..."> descriptor, if a content module of V2 plugin model
+	class           string   // by the moduleClasses of the config, "" for a regular module
+}
+
+func (m *moduleInfo) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Path            string   `json:"path"`
+		Name            string   `json:"name"`
+		JavaLevel       string   `json:"javaLanguageLevel,omitempty"`
+		JVMTarget       string   `json:"jvmTarget,omitempty"`
+		KotlinAPI       string   `json:"kotlinApiVersion,omitempty"`
+		KotlinJVMTarget string   `json:"kotlinJvmTarget,omitempty"`
+		Facets          []string `json:"facets,omitempty"`
+		ContentModule   string   `json:"contentModuleDescriptor,omitempty"`
+		Class           string   `json:"class,omitempty"`
+	}{m.path, m.name, m.javaLevel, m.jvmTarget, m.kotlinAPI, m.kotlinJVMTarget, m.facets, m.contentModule, m.class})
+}
+
+// readModules parses the given .iml files and resolves their settings against the project defaults.
+func readModules(rootDir string, modulesPaths []string) ([]*moduleInfo, error) {
+	project, err := readProjectSettings(findProjectDir(rootDir))
+	if err != nil {
+		return nil, err
+	}
+	classify, err := newModuleClassifier(cfg.ModuleClasses)
+	if err != nil {
+		return nil, err
+	}
+
+	var mods []*moduleInfo
+	for _, mp := range modulesPaths {
+		m, err := newModuleFromXMLFile(osFS{}, mp)
+		if err != nil {
+			return nil, err
+		}
+
+		mi := &moduleInfo{
+			path:            mp,
+			name:            strings.TrimSuffix(filepath.Base(mp), filepath.Ext(mp)),
+			javaLevel:       project.javaLevel,
+			jvmTarget:       project.jvmTarget,
+			kotlinAPI:       project.kotlinAPI,
+			kotlinJVMTarget: project.kotlinJVMTarget,
+		}
+		mi.contentModule = contentModuleDescriptor(osFS{}, mp, m)
+		mi.class, _ = classify(mp)
+		if _, ignored := ignoredModule(osFS{}, mp); ignored {
+			mi.class = "ignored"
+		}
+		for _, f := range m.component("FacetManager").Facets {
+			mi.facets = append(mi.facets, f.Type)
+		}
+		if lvl := m.rootManager().LanguageLevel; lvl != "" {
+			mi.javaLevel = lvl
+		}
+		if target, ok := project.moduleJVMTargets[mi.name]; ok {
+			mi.jvmTarget = target
+		}
+		if kf := m.kotlinFacet(); kf != nil && !kf.Configuration.UseProjectSettings {
+			if v := kf.compilerArg("apiVersion"); v != "" {
+				mi.kotlinAPI = v
+			}
+			if v := kf.compilerArg("jvmTarget"); v != "" {
+				mi.kotlinJVMTarget = v
+			}
+		}
+		mods = append(mods, mi)
+	}
+	sort.Slice(mods, func(i, j int) bool { return mods[i].path < mods[j].path })
+	return mods, nil
+}
+
+func printModules(mods []*moduleInfo) {
+	if *jsonFlag {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		panicIfError(enc.Encode(mods))
+		return
+	}
+
+	printHeader([]string{"module", "Java language level", "JVM target", "Kotlin apiVersion", "Kotlin jvmTarget", "facets", "plugin model", "class"})
+	for _, m := range mods {
+		facets := strings.Join(m.facets, ",")
+		model := pluginModel(m.contentModule)
+		if *mdFlag {
+			fmt.Printf("%-50s | %-9s | %-4s | %-4s | %-4s | %-15s | %-2s | %s\n", m.path, m.javaLevel, m.jvmTarget, m.kotlinAPI, m.kotlinJVMTarget, facets, model, m.class)
+		} else {
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", m.path, m.javaLevel, m.jvmTarget, m.kotlinAPI, m.kotlinJVMTarget, facets, model, m.class)
+		}
+	}
+}
+
+// findContentModules returns .iml module path -> content module descriptor, for modules of V2 plugin model.
+func findContentModules(fsys fs.FS, modulesPaths []string) (map[string]string, error) {
+	descriptors := map[string]string{}
+	for _, mp := range modulesPaths {
+		m, err := newModuleFromXMLFile(fsys, mp)
+		if err != nil {
+			return nil, err
+		}
+		if d := contentModuleDescriptor(fsys, mp, m); d != "" {
+			descriptors[mp] = d
+		}
+	}
+	return descriptors, nil
+}
+
+// contentModuleDescriptor looks for <module-name>.xml with the <idea-plugin> root in module's source and resource roots,
+// as content modules of V2 plugin model have one, i.e platform/foo/resources/intellij.platform.foo.xml
+func contentModuleDescriptor(fsys fs.FS, modulePath string, m *module) string {
+	name := strings.TrimSuffix(filepath.Base(modulePath), filepath.Ext(modulePath))
+	for _, sd := range m.rootManager().sourceFolders() {
+		if sd.IsTest {
+			continue
+		}
+		descriptor := filepath.Join(filepath.Dir(modulePath), filepath.Base(sd.Url), name+".xml")
+		if isPluginDescriptor(fsys, descriptor) {
+			return descriptor
+		}
+	}
+	return ""
+}
+
+// isPluginDescriptor checks if the given file exists and is an XML with the <idea-plugin> root.
+func isPluginDescriptor(fsys fs.FS, path string) bool {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	d := xml.NewDecoder(f)
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return false
+		}
+		if se, ok := t.(xml.StartElement); ok {
+			return se.Name.Local == "idea-plugin"
+		}
+	}
+}
+
+// pluginModel returns v2 for content modules and v1 for the classic ones.
+func pluginModel(contentModuleDescriptor string) string {
+	if contentModuleDescriptor != "" {
+		return "v2"
+	}
+	return "v1"
+}
+
+// projectSettings are the defaults from .idea/ that modules inherit.
+type projectSettings struct {
+	javaLevel        string
+	jvmTarget        string
+	moduleJVMTargets map[string]string // module name -> bytecode target level
+	kotlinAPI        string
+	kotlinJVMTarget  string
+}
+
+// findProjectDir looks for the .idea/ dir in the given dir and its parents,
+// as modules are usually scanned in a sub-dir of the project, i.e ./platform
+func findProjectDir(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		if fi, err := os.Stat(filepath.Join(dir, ".idea")); err == nil && fi.IsDir() {
+			return filepath.Join(dir, ".idea")
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// readProjectSettings reads misc.xml, compiler.xml and kotlinc.xml from the given .idea/ dir,
+// if any of them exist.
+func readProjectSettings(ideaDir string) (*projectSettings, error) {
+	ps := &projectSettings{moduleJVMTargets: map[string]string{}}
+	if ideaDir == "" {
+		return ps, nil
+	}
+
+	misc, err := newProjectFromXMLFile(filepath.Join(ideaDir, "misc.xml"))
+	if err != nil {
+		return nil, err
+	}
+	ps.javaLevel = misc.component("ProjectRootManager").LanguageLevel
+
+	compiler, err := newProjectFromXMLFile(filepath.Join(ideaDir, "compiler.xml"))
+	if err != nil {
+		return nil, err
+	}
+	bytecode := compiler.component("CompilerConfiguration").BytecodeTargetLevel
+	ps.jvmTarget = bytecode.Target
+	for _, m := range bytecode.Modules {
+		ps.moduleJVMTargets[m.Name] = m.Target
+	}
+
+	kotlinc, err := newProjectFromXMLFile(filepath.Join(ideaDir, "kotlinc.xml"))
+	if err != nil {
+		return nil, err
+	}
+	ps.kotlinAPI = kotlinc.component("KotlinCommonCompilerArguments").option("apiVersion")
+	ps.kotlinJVMTarget = kotlinc.component("Kotlin2JvmCompilerArguments").option("jvmTarget")
+	return ps, nil
+}
+
+// newProjectFromXMLFile reads given .idea/*.xml file, returning an empty project if it does not exist.
+func newProjectFromXMLFile(path string) (*project, error) {
+	blob, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &project{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading %q: %v\n", path, err)
+	}
+
+	var p project
+	if err := xml.Unmarshal(blob, &p); err != nil {
+		return nil, fmt.Errorf("error parsing XML %q: %v\n", path, err)
+	}
+	return &p, nil
+}
+
+// .idea/*.xml XML schema
+type project struct {
+	XMLName    xml.Name           `xml:"project"`
+	Components []projectComponent `xml:"component"`
+}
+
+type projectComponent struct {
+	Name                string   `xml:"name,attr"`
+	LanguageLevel       string   `xml:"languageLevel,attr,omitempty"` // only on ProjectRootManager
+	Options             []option `xml:"option"`
+	BytecodeTargetLevel struct { // only on CompilerConfiguration
+		Target  string `xml:"target,attr"`
+		Modules []struct {
+			Name   string `xml:"name,attr"`
+			Target string `xml:"target,attr"`
+		} `xml:"module"`
+	} `xml:"bytecodeTargetLevel"`
+}
+
+// component returns a component by name or an empty one, if the project has none.
+func (p *project) component(name string) *projectComponent {
+	for i := range p.Components {
+		if p.Components[i].Name == name {
+			return &p.Components[i]
+		}
+	}
+	return &projectComponent{Name: name}
+}
+
+func (pc *projectComponent) option(name string) string {
+	for _, o := range pc.Options {
+		if o.Name == name {
+			return o.Value
+		}
+	}
+	return ""
+}
+
+type option struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type facet struct {
+	Type          string `xml:"type,attr"`
+	Name          string `xml:"name,attr"`
+	Configuration struct {
+		UseProjectSettings bool `xml:"useProjectSettings,attr"`
+		// both <option name="apiVersion" value="1.3" /> and <stringArg name="apiVersion" arg="1.9" /> are used
+		CompilerOptions []option `xml:"compilerArguments>option"`
+		CompilerArgs    []struct {
+			Name string `xml:"name,attr"`
+			Arg  string `xml:"arg,attr"`
+		} `xml:"compilerArguments>stringArguments>stringArg"`
+	} `xml:"configuration"`
+}
+
+// kotlinFacet returns `<facet type="kotlin-language" />` or nil, if the module does not have one.
+func (m *module) kotlinFacet() *facet {
+	return m.facet("kotlin-language")
+}
+
+// facet returns a facet by type, i.e kotlin-language or android, or nil if the module does not have one.
+func (m *module) facet(facetType string) *facet {
+	facets := m.component("FacetManager").Facets
+	for i := range facets {
+		if facets[i].Type == facetType {
+			return &facets[i]
+		}
+	}
+	return nil
+}
+
+// compilerArg returns the value of a Kotlin compiler argument, set in the facet.
+func (f *facet) compilerArg(name string) string {
+	for _, a := range f.Configuration.CompilerArgs {
+		if a.Name == name {
+			return a.Arg
+		}
+	}
+	for _, o := range f.Configuration.CompilerOptions {
+		if o.Name == name {
+			return o.Value
+		}
+	}
+	return ""
+}
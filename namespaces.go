@@ -0,0 +1,370 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Namespaces let a single server host the snapshots of many products and branches, so teams do not run their own:
+//  go run . -d ./platform -snapshot snapshot.json
+//  curl -X PUT --data-binary @snapshot.json http://localhost:8080/api/snapshots/idea/241
+//  go run . -d ./platform -push http://localhost:8080/api/snapshots/idea/241
+//  GET /api/snapshots                    the namespaces, with the time and the number of packages
+//  GET /api/snapshots/<product>/<branch> the packages of a namespace, filtered and paginated as /api/packages
+// The packages scanned by the server itself are in the namespace given by -namespace, if any.
+// With -snapshots-dir, uploads are kept in <dir>/<product>/<branch>.json and loaded on start.
+// Uploads need the `Authorization: Bearer <token>` header with the upload-token secret, and are refused with 403
+// if the secret is not set, unless -insecure-uploads allows them to anyone. A gzip-compressed body is accepted.
+// An upload identical to the current snapshot of the namespace is ignored, otherwise the response
+// has the number of changed packages, and with -history the snapshot is recorded in the history.
+// With -redis, the snapshots are shared by the replicas of the server, see redis.go.
+
+import (
+	"compress/gzip"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var maxSnapshotSize int64 = 512 << 20 // of an upload, a var for the tests
+
+var namespaceSegment = regexp.MustCompile(`^[\w.-]+$`)
+
+// validNamespace checks the name is product/branch, where a branch can have slashes, i.e idea/release/241
+func validNamespace(name string) bool {
+	segments := strings.Split(name, "/")
+	for _, s := range segments {
+		if !namespaceSegment.MatchString(s) || strings.Trim(s, ".") == "" {
+			return false
+		}
+	}
+	return len(segments) >= 2
+}
+
+// namespaces are the snapshots by product/branch.
+type namespaces struct {
+	dir         string      // to persist the uploads to, none by default
+	token       string      // required for uploads, which are refused without one unless insecure
+	insecure    bool        // uploads without a token, by -insecure-uploads
+	historyPath string      // to record the uploads in, if any
+	cache       *redisCache // shared by the replicas, if any
+
+	own string  // namespace of the scan of the server
+	d   *daemon // that scans
+
+	mu     sync.RWMutex
+	snaps  map[string]*snapshot
+	hashes map[string]string      // of the snapshot packages, to ignore identical uploads
+	puts   map[string]*sync.Mutex // serialize the uploads to a namespace, see put
+}
+
+func newNamespaces(dir, own string, d *daemon) (*namespaces, error) {
+	if own != "" && !validNamespace(own) {
+		return nil, fmt.Errorf("bad namespace %q, want product/branch", own)
+	}
+	ns := &namespaces{dir: dir, own: own, d: d, snaps: map[string]*snapshot{}, hashes: map[string]string{}, puts: map[string]*sync.Mutex{}}
+	if dir == "" {
+		return ns, nil
+	}
+
+	err := filepath.WalkDir(dir, func(path string, e os.DirEntry, err error) error {
+		if err != nil || e.IsDir() || filepath.Ext(path) != ".json" {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, strings.TrimSuffix(path, ".json"))
+		name := filepath.ToSlash(rel)
+		if !validNamespace(name) {
+			return nil
+		}
+		s, err := readSnapshot(path)
+		if err != nil {
+			return err
+		}
+		ns.snaps[name], ns.hashes[name] = s, s.hash()
+		return nil
+	})
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return ns, err
+}
+
+// get returns the snapshot of the namespace, nil if there is none.
+func (ns *namespaces) get(name string) *snapshot {
+	if name == ns.own && ns.own != "" {
+		ns.d.mu.RLock()
+		defer ns.d.mu.RUnlock()
+		if ns.d.pkgs == nil {
+			return nil
+		}
+		return &snapshot{dir: ns.d.dir, time: ns.d.scanned, pkgs: ns.d.pkgs}
+	}
+	ns.sync()
+	ns.mu.RLock()
+	defer ns.mu.RUnlock()
+	return ns.snaps[name]
+}
+
+// hash returns the hash of the snapshot of the namespace, "" for the namespace of the server or none.
+func (ns *namespaces) hash(name string) string {
+	ns.mu.RLock()
+	defer ns.mu.RUnlock()
+	return ns.hashes[name]
+}
+
+// sync loads the snapshots uploaded to the other replicas, if the namespaces are shared in Redis.
+func (ns *namespaces) sync() {
+	if ns.cache == nil {
+		return
+	}
+	hashes, err := ns.cache.hashes()
+	if err != nil {
+		logRedis("list the snapshots", err)
+		return
+	}
+	for name, hash := range hashes {
+		if name == ns.own || ns.hash(name) == hash {
+			continue
+		}
+		s, err := ns.cache.snapshot(name)
+		if err != nil {
+			logRedis("load the snapshot of "+name, err)
+			continue
+		}
+		ns.mu.Lock()
+		ns.snaps[name], ns.hashes[name] = s, hash
+		ns.mu.Unlock()
+	}
+}
+
+// put replaces the snapshot of the namespace, persisting it first,
+// returning the previous one, or false if the packages are the same.
+func (ns *namespaces) put(name string, s *snapshot) (*snapshot, bool, error) {
+	ns.mu.Lock()
+	putMu := ns.puts[name]
+	if putMu == nil {
+		putMu = &sync.Mutex{}
+		ns.puts[name] = putMu
+	}
+	ns.mu.Unlock()
+	putMu.Lock() // so that of two identical uploads, one persists and records the history, and the other is unchanged
+	defer putMu.Unlock()
+
+	hash := s.hash()
+	ns.sync()
+	ns.mu.RLock()
+	prev, same := ns.snaps[name], ns.hashes[name] == hash
+	ns.mu.RUnlock()
+	if same {
+		return prev, false, nil
+	}
+
+	if ns.dir != "" {
+		path := filepath.Join(ns.dir, filepath.FromSlash(name)+".json")
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, false, err
+		}
+		if err := saveSnapshot(path, s); err != nil {
+			return nil, false, err
+		}
+	}
+	if ns.historyPath != "" {
+		rec := &historyRecord{Time: s.time, Dir: s.dir, Namespace: name, Modules: summarizeModules(s.pkgs)}
+		if err := appendHistory(ns.historyPath, rec); err != nil {
+			return nil, false, err
+		}
+	}
+	ns.mu.Lock()
+	ns.snaps[name], ns.hashes[name] = s, hash
+	ns.mu.Unlock()
+	if ns.cache != nil {
+		if err := ns.cache.putSnapshot(name, hash, s); err != nil {
+			logRedis("store the snapshot of "+name, err)
+		}
+	}
+	return prev, true, nil
+}
+
+func (ns *namespaces) names() []string {
+	ns.sync()
+	ns.mu.RLock()
+	names := make([]string, 0, len(ns.snaps)+1)
+	for name := range ns.snaps {
+		names = append(names, name)
+	}
+	ns.mu.RUnlock()
+	if ns.own != "" {
+		names = append(names, ns.own)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// handleSnapshots lists the namespaces, uploads a snapshot to one or queries its packages.
+func (ns *namespaces) handleSnapshots(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/snapshots"), "/")
+	if name == "" {
+		ns.handleList(w, r)
+		return
+	}
+	if !validNamespace(name) {
+		http.Error(w, fmt.Sprintf("bad namespace %q, want product/branch", name), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s := ns.get(name)
+		if s == nil {
+			http.Error(w, fmt.Sprintf("no snapshot in %q", name), http.StatusNotFound)
+			return
+		}
+		q, err := parsePackageQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var key string
+		if hash := ns.hash(name); ns.cache != nil && hash != "" {
+			key = queryKey(hash, r.URL.RawQuery)
+			if total, blob, ok := ns.cache.cachedQuery(key); ok {
+				w.Header().Set("X-Total-Count", fmt.Sprint(total))
+				w.Header().Set("Content-Type", "application/json")
+				w.Write(blob)
+				return
+			}
+		}
+		page, total := q.apply(s.pkgs)
+		w.Header().Set("X-Total-Count", fmt.Sprint(total))
+		if page == nil {
+			page = []*pkg{}
+		}
+		if key != "" {
+			blob, err := json.MarshalIndent(page, "", "  ")
+			if err == nil {
+				ns.cache.cacheQuery(key, total, append(blob, '\n'))
+			}
+		}
+		writeJSON(w, http.StatusOK, page)
+	case http.MethodPut, http.MethodPost:
+		ns.handleUpload(w, r, name)
+	default:
+		http.Error(w, fmt.Sprintf("method %s is not allowed", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+type namespaceSummary struct {
+	Namespace  string `json:"namespace"`
+	Dir        string `json:"dir"`
+	Time       string `json:"time"`
+	Packages   int    `json:"packages"`
+	Documented int    `json:"documented"`
+
+	PublicTypes bool `json:"publicTypes,omitempty"` // counted for each package, see snapshot
+}
+
+func (ns *namespaces) summary(name string, s *snapshot) namespaceSummary {
+	documented := 0
+	for _, p := range s.pkgs {
+		if p.isDocumented() {
+			documented++
+		}
+	}
+	return namespaceSummary{name, s.dir, s.time.Format(time.RFC3339), len(s.pkgs), documented, s.publicTypes}
+}
+
+// uploadResult is the response to an upload.
+type uploadResult struct {
+	namespaceSummary
+	Unchanged bool           `json:"unchanged,omitempty"` // identical to the current snapshot, ignored
+	Changes   map[string]int `json:"changes,omitempty"`   // number of packages by change, see packageChanges
+}
+
+func (ns *namespaces) handleUpload(w http.ResponseWriter, r *http.Request, name string) {
+	if ns.token == "" && !ns.insecure {
+		http.Error(w, "uploads are disabled, the server has no upload-token secret", http.StatusForbidden)
+		return
+	}
+	if !authorized(w, r, ns.token) {
+		return
+	}
+	if name == ns.own {
+		http.Error(w, fmt.Sprintf("%q is scanned by the server", name), http.StatusConflict)
+		return
+	}
+
+	s, ok := readUploadedSnapshot(w, r)
+	if !ok {
+		return
+	}
+	prev, changed, err := ns.put(name, s)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !changed {
+		writeJSON(w, http.StatusOK, uploadResult{namespaceSummary: ns.summary(name, prev), Unchanged: true})
+		return
+	}
+	res := uploadResult{namespaceSummary: ns.summary(name, s)}
+	if prev != nil {
+		res.Changes = map[string]int{}
+		for _, e := range packageChanges(prev.pkgs, s.pkgs) {
+			res.Changes[e.Change]++
+		}
+	}
+	writeJSON(w, http.StatusCreated, res)
+}
+
+// authorized checks the `Authorization: Bearer <token>` header of an upload, if a token is required,
+// responding with 401 otherwise.
+func authorized(w http.ResponseWriter, r *http.Request, token string) bool {
+	if token == "" {
+		return true
+	}
+	got, bearer := r.Header.Get("Authorization"), "Bearer "
+	if !strings.HasPrefix(got, bearer) || subtle.ConstantTimeCompare([]byte(got[len(bearer):]), []byte(token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "a valid upload token is required", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// readUploadedSnapshot reads the snapshot of an upload, gzip-compressed or not, responding with 400 if it is bad.
+func readUploadedSnapshot(w http.ResponseWriter, r *http.Request) (*snapshot, bool) {
+	var body io.Reader = http.MaxBytesReader(w, r.Body, maxSnapshotSize)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("bad gzip: %v", err), http.StatusBadRequest)
+			return nil, false
+		}
+		defer zr.Close()
+		body = io.LimitReader(zr, 4*maxSnapshotSize)
+	}
+	var s snapshot
+	if err := json.NewDecoder(body).Decode(&s); err != nil {
+		http.Error(w, fmt.Sprintf("bad snapshot: %v", err), http.StatusBadRequest)
+		return nil, false
+	}
+	if s.time.IsZero() {
+		s.time = time.Now().UTC()
+	}
+	return &s, true
+}
+
+func (ns *namespaces) handleList(w http.ResponseWriter, r *http.Request) {
+	list := []namespaceSummary{}
+	for _, name := range ns.names() {
+		if s := ns.get(name); s != nil {
+			list = append(list, ns.summary(name, s))
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
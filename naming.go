@@ -0,0 +1,91 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Package naming conventions: lowercase, no underscores and, if configured, one of the prefixes
+//  {"packagePrefixes": ["com.intellij", "org.jetbrains.{module}"]}
+// where {module} is the last part of the module name, i.e `kt` for intellij.platform.kt
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	ruleDescriptions["PackageNameCase"] = "Package name is not lowercase"
+	ruleDescriptions["PackageNameUnderscore"] = "Package name has underscores"
+	ruleDescriptions["PackageNamePrefix"] = "Package name does not start with any of the configured prefixes"
+}
+
+// namingFindings reports packages that violate the naming conventions.
+func namingFindings(pkgs map[string]*pkg, prefixes []string) []finding {
+	var findings []finding
+	for _, p := range pkgs {
+		if p.name == "" { // default package
+			continue
+		}
+		if p.name != strings.ToLower(p.name) {
+			findings = append(findings, finding{rule: "PackageNameCase", level: "warning",
+				message: fmt.Sprintf("Package %s is not lowercase", p.name), path: p.pkgDir})
+		}
+		if strings.Contains(p.name, "_") {
+			findings = append(findings, finding{rule: "PackageNameUnderscore", level: "warning",
+				message: fmt.Sprintf("Package %s has underscores", p.name), path: p.pkgDir})
+		}
+		if len(prefixes) > 0 && !hasPackagePrefix(p, prefixes) {
+			findings = append(findings, finding{rule: "PackageNamePrefix", level: "warning",
+				message: fmt.Sprintf("Package %s does not start with %s", p.name, strings.Join(expandPrefixes(p, prefixes), " or ")), path: p.pkgDir})
+		}
+	}
+	sortFindings(findings)
+	return findings
+}
+
+func hasPackagePrefix(p *pkg, prefixes []string) bool {
+	for _, prefix := range expandPrefixes(p, prefixes) {
+		if p.name == prefix || strings.HasPrefix(p.name, prefix+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// expandPrefixes replaces {module} in the prefixes by the last part of the package module name.
+func expandPrefixes(p *pkg, prefixes []string) []string {
+	name := strings.TrimSuffix(filepath.Base(p.module), filepath.Ext(p.module))
+	suffix := name[strings.LastIndex(name, ".")+1:]
+
+	expanded := make([]string, len(prefixes))
+	for i, prefix := range prefixes {
+		expanded[i] = strings.ReplaceAll(prefix, "{module}", suffix)
+	}
+	return expanded
+}
+
+// checkNaming lists packages that violate the naming conventions.
+func checkNaming(args []string) error {
+	fs := flag.NewFlagSet("check naming", flag.ExitOnError)
+	dir := fs.String("d", "", "dir to scan for packages")
+	fail := fs.Bool("fail", false, "exit with non-zero code if any package violates the conventions")
+	addFormatFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		fs.Usage()
+		return nil
+	}
+
+	pkgs, err := scanDir(*dir, false)
+	if err != nil {
+		return err
+	}
+
+	findings := namingFindings(pkgs, cfg.PackagePrefixes)
+	printFindings(findings)
+	fmt.Fprintf(os.Stderr, "%d naming violations in %d packages\n", len(findings), len(pkgs))
+
+	return failedCheck("naming", *fail, len(findings))
+}
@@ -0,0 +1,70 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Offline mode for the air-gapped and restricted environments: -offline disables all the network access, so
+// a scan never reaches out, whatever the config is:
+//  go run . -d ./platform -offline -snapshot platform.json
+// -push and -publish to the configured sinks, all of which need network, fail before the scan. Any other request,
+// i.e a snapshot URL of diff, merge or convert, Redis of serve or SMTP of digest, fails with errOffline.
+// Serving and the daemon socket are local and keep working, but work and coordinate, that scan over the network,
+// fail before they start. As every flag, it is also set by JET_SEARCH_OFFLINE or the config, for every subcommand,
+// the ones without the flag too, see parseFlags, and embeddings.py honors it too, as it needs the OpenAI API.
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+var errOffline = errors.New("network access is disabled by -offline")
+
+// All the HTTP clients use the default transport, so it is the one place to cut them off.
+func init() {
+	http.DefaultTransport = offlineTransport{http.DefaultTransport}
+}
+
+// offlineTransport fails all the requests with -offline.
+type offlineTransport struct {
+	online http.RoundTripper
+}
+
+func (t offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if *offlineFlag {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, fmt.Errorf("%s %s: %w", req.Method, req.URL, errOffline)
+	}
+	return t.online.RoundTrip(req)
+}
+
+// dialTCP connects to the address, unless -offline.
+func dialTCP(addr string, timeout time.Duration) (net.Conn, error) {
+	if *offlineFlag {
+		return nil, fmt.Errorf("dial %s: %w", addr, errOffline)
+	}
+	return net.DialTimeout("tcp", addr, timeout)
+}
+
+// checkOffline fails fast if -offline is combined with the flags that need network.
+func checkOffline() error {
+	if !*offlineFlag {
+		return nil
+	}
+	if *pushFlag != "" {
+		return fmt.Errorf("-push needs network, disabled by -offline")
+	}
+	if *publishFlag && len(cfg.Sinks) > 0 {
+		names := make([]string, 0, len(cfg.Sinks))
+		for name := range cfg.Sinks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("-publish to the sinks %s needs network, disabled by -offline", strings.Join(names, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Orphaned sources are the ones in no source root of any module, invisible to the report and to the IDE alike,
+// i.e left behind by a module move or never registered in its .iml:
+//  go run . check orphans -d ./platform -fail
+// Every source root counts, test, generated and resource ones too, of all the modules, testFramework
+// and skipped ones included. The dirs not looked into for modules are not looked into for orphans either.
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	ruleDescriptions["OrphanedSources"] = "Sources are not in any source root of a module"
+}
+
+// orphanedSourcesFindings reports the dirs with sources outside of the source roots of all the modules in the dir.
+func orphanedSourcesFindings(fsys fs.FS, dir string) ([]finding, error) {
+	modulesPaths, err := findModulesPaths(fsys, dir, ".iml")
+	if err != nil {
+		return nil, err
+	}
+	roots := map[string]bool{}
+	moduleDirs := map[string]string{} // dir -> .iml module in it
+	for _, mp := range modulesPaths {
+		m, _, err := parseModuleXMLFile(fsys, mp)
+		if err != nil {
+			return nil, err
+		}
+		moduleDirs[filepath.Dir(mp)] = mp
+		var urls []string
+		for _, sd := range m.rootManager().sourceFolders() {
+			urls = append(urls, sd.Url)
+		}
+		if len(urls) == 0 {
+			urls, _ = m.srcDirURLs() // the content roots, if they are the source roots
+		}
+		for _, url := range urls {
+			if srcDir, ok := moduleURLPath(mp, url); ok {
+				roots[srcDir] = true
+			}
+		}
+	}
+
+	orphans := map[string]int{} // dir -> number of the sources in it
+	err = fs.WalkDir(fsys, dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if roots[path] || (path != dir && (strings.HasPrefix(d.Name(), ".") || moduleSkipDirs[d.Name()] || testDataDirs[d.Name()])) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if contains(sourceExts, filepath.Ext(path)) {
+			orphans[filepath.Dir(path)]++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var findings []finding
+	for orphanDir, n := range orphans {
+		msg := fmt.Sprintf("%d source files are not in any source root", n)
+		if mp := enclosingModule(moduleDirs, orphanDir); mp != "" {
+			msg += ", the enclosing module is " + filepath.Base(mp)
+		}
+		findings = append(findings, finding{rule: "OrphanedSources", level: "warning", message: msg, path: orphanDir})
+	}
+	sortFindings(findings)
+	return findings, nil
+}
+
+// enclosingModule returns the .iml module of the deepest module dir that contains the dir, if any.
+func enclosingModule(moduleDirs map[string]string, dir string) string {
+	for {
+		if mp, ok := moduleDirs[dir]; ok {
+			return mp
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// checkOrphans lists the dirs with sources outside of the source roots of all the modules.
+func checkOrphans(args []string) error {
+	fs := flag.NewFlagSet("check orphans", flag.ExitOnError)
+	dir := fs.String("d", "", "dir to scan for modules and orphaned sources")
+	fail := fs.Bool("fail", false, "exit with non-zero code if any sources are not in a source root")
+	addFormatFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		fs.Usage()
+		return nil
+	}
+
+	findings, err := orphanedSourcesFindings(osFS{}, *dir)
+	if err != nil {
+		return err
+	}
+	printFindings(findings)
+	fmt.Fprintf(os.Stderr, "%d dirs with sources are not in any source root\n", len(findings))
+
+	return failedCheck("orphans", *fail, len(findings))
+}
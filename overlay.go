@@ -0,0 +1,75 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Overlay of checkouts, as the ultimate repo has the community one inside, often symlinked:
+//  {"repos": [
+//    {"name": "ultimate", "dir": "/work/ij", "url": "https://jetbrains.team/p/ij/repositories/ultimate/files/"},
+//    {"name": "community", "dir": "/work/ij/community", "url": "https://jetbrains.team/p/ij/repositories/community/files/"}
+//  ]}
+// A path physically lives in the repo with the deepest dir that contains it, once the symlinks are resolved,
+// and is linked to that repo remote. Without repos in the config, paths are linked to community as they are.
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// repo is a checkout and its remote.
+type repo struct {
+	Name string `json:"name"`
+	Dir  string `json:"dir"`
+	URL  string `json:"url"` // of the files at the root of the repo
+
+	commit string // checked out, to link the files at with -commit
+}
+
+var (
+	realPathsMu sync.Mutex
+	realPaths   = map[string]string{} // path -> resolved absolute path
+)
+
+// realPath returns the absolute path with the symlinks resolved, or as is if it can not be resolved.
+func realPath(path string) string {
+	realPathsMu.Lock()
+	defer realPathsMu.Unlock()
+	if rp, ok := realPaths[path]; ok {
+		return rp
+	}
+
+	rp, err := filepath.Abs(path)
+	if err == nil {
+		if resolved, err := filepath.EvalSymlinks(rp); err == nil {
+			rp = resolved
+		}
+	}
+	realPaths[path] = rp
+	return rp
+}
+
+// repoOf returns the repo the path physically lives in and the path relative to it,
+// nil if there are no repos in the config or none contains the path.
+func repoOf(path string) (*repo, string) {
+	rp := realPath(path)
+	var found *repo
+	var foundRel string
+	for i, r := range cfg.Repos {
+		rel, err := filepath.Rel(realPath(r.Dir), rp)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+			continue
+		}
+		if found == nil || len(rel) < len(foundRel) { // the deepest dir
+			found, foundRel = &cfg.Repos[i], rel
+		}
+	}
+	return found, filepath.ToSlash(foundRel)
+}
+
+// link returns the link to the path at the remote of its repo.
+func link(path string) string {
+	if r, rel := repoOf(path); r != nil {
+		return fileLink(r.URL, r.commit, rel)
+	}
+	return fileLink(linkBase(), scanCommit, filepath.ToSlash(path))
+}
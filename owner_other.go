@@ -0,0 +1,12 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+
+//go:build !unix
+
+package main
+
+import "os"
+
+// ownedByUser is true where there are no unix owners, the runtime dir being under the per-user temp dir there.
+func ownedByUser(fi os.FileInfo) bool {
+	return true
+}
@@ -0,0 +1,16 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// ownedByUser checks that the file is owned by the user running the process.
+func ownedByUser(fi os.FileInfo) bool {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && int(st.Uid) == os.Getuid()
+}
@@ -0,0 +1,114 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Stubs of package-info.java for the undocumented packages, to fill in rather than to start from scratch:
+//  go run . gen-package-info -d ./platform -packages com.intellij.openapi.vfs.*,com.intellij.util -dry-run
+// writes package-info.java with the copyright header, a TODO doc comment and the package statement into the dirs of
+// the selected packages, all the undocumented ones by default, a name ending with .* selecting the subpackages too.
+// Only the packages with .java sources get one, the Kotlin-only modules may not compile Java, and the existing
+// files are never overwritten. The legacy package.html is left in place, to move its docs over by hand.
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultPackageInfoHeader = "// Copyright 2000-{year} JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license."
+
+// packageInfoStub returns the content of package-info.java of the package, with the header if it is not "".
+func packageInfoStub(header, name string) string {
+	var b strings.Builder
+	if header != "" {
+		b.WriteString(strings.TrimRight(header, "\n") + "\n")
+	}
+	fmt.Fprintf(&b, "/**\n * TODO: describe the package, what it is for and where to start.\n */\npackage %s;\n", name)
+	return b.String()
+}
+
+// selectedPackage tells if the package is one of the comma-separated names, "" selecting all of them.
+func selectedPackage(name, selection string) bool {
+	if selection == "" {
+		return true
+	}
+	for _, s := range strings.Split(selection, ",") {
+		s = strings.TrimSpace(s)
+		if prefix := strings.TrimSuffix(s, ".*"); prefix != s {
+			if name == prefix || strings.HasPrefix(name, prefix+".") {
+				return true
+			}
+		} else if name == s {
+			return true
+		}
+	}
+	return false
+}
+
+// genPackageInfos writes the stubs of the selected undocumented packages, or lists them only with dryRun,
+// and returns the number of them.
+func genPackageInfos(w io.Writer, pkgs map[string]*pkg, selection, header string, dryRun bool) (int, error) {
+	n := 0
+	for _, p := range sortedPackages(pkgs) {
+		if p.isDocumented() || p.name == "" || p.filesCnt[".java"] == 0 || !selectedPackage(p.name, selection) {
+			continue
+		}
+		path := filepath.Join(p.pkgDir, "package-info.java")
+		if dryRun {
+			fmt.Fprintf(w, "would create %s\n", path)
+			n++
+			continue
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return n, err
+		}
+		_, err = io.WriteString(f, packageInfoStub(header, p.name))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return n, err
+		}
+		fmt.Fprintf(w, "created %s\n", path)
+		n++
+	}
+	return n, nil
+}
+
+// runGenPackageInfo writes the stubs of package-info.java into the scanned dir.
+func runGenPackageInfo(args []string) error {
+	fs := flag.NewFlagSet("gen-package-info", flag.ExitOnError)
+	dir := fs.String("d", "", "dir to scan for packages")
+	testFramework := fs.Bool("test-framework", false, "include testFramework modules")
+	selection := fs.String("packages", "", "comma-separated names of the packages to write package-info.java for, .* for the subpackages too, all the undocumented by default")
+	header := fs.String("header", defaultPackageInfoHeader, "copyright header of the stubs, {year} being the current year, or \"\" for none")
+	dryRun := fs.Bool("dry-run", false, "list the files that would be created instead of creating them")
+	fs.StringVar(apiFlag, "api", "", "comma-separated API classes of the packages to write package-info.java for, all by default: "+strings.Join(apiClasses, ","))
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		fs.Usage()
+		return nil
+	}
+
+	pkgs, err := scanDir(*dir, *testFramework)
+	if err != nil {
+		return err
+	}
+	n, err := genPackageInfos(os.Stdout, pkgs, *selection, strings.ReplaceAll(*header, "{year}", strconv.Itoa(time.Now().Year())), *dryRun)
+	if err != nil {
+		return err
+	}
+	verb := "created"
+	if *dryRun {
+		verb = "to create"
+	}
+	fmt.Fprintf(os.Stderr, "%d package-info.java %s, of %d packages\n", n, verb, len(pkgs))
+	return nil
+}
@@ -0,0 +1,259 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Parquet output of the packages, a typed column per field of packageRow and the lines with -loc, for the data warehouse loaders:
+//  go run . convert scan.json --to parquet -o packages.parquet
+// The file is a single row group of uncompressed, PLAIN encoded, required columns, a page per column,
+// and the metadata in the Thrift compact protocol, see https://github.com/apache/parquet-format.
+
+import (
+	"bytes"
+	bin "encoding/binary" // not to clash with the binary type of the rule expressions in rules.go
+	"io"
+)
+
+// Parquet physical types.
+const (
+	parquetBoolean   = 0
+	parquetInt32     = 1
+	parquetByteArray = 6
+)
+
+// parquetColumn is a column of the values of one of the physical types.
+type parquetColumn struct {
+	name    string
+	kind    int
+	bools   []bool
+	ints    []int32
+	strings []string
+}
+
+// packagesParquetColumns returns the columns of the packages of the snapshot, by the fields of packageRow.
+func packagesParquetColumns(s *snapshot) []*parquetColumn {
+	str := func(name string) *parquetColumn { return &parquetColumn{name: name, kind: parquetByteArray} }
+	num := func(name string) *parquetColumn { return &parquetColumn{name: name, kind: parquetInt32} }
+	scanTime, dir, module, pkgName, pkgDir, doc := str("scan_time"), str("dir"), str("module"), str("package"), str("pkg_dir"), str("doc")
+	documented, api := &parquetColumn{name: "documented", kind: parquetBoolean}, str("api")
+	files, javaFiles, ktFiles := num("files"), num("java_files"), num("kt_files")
+	for _, p := range sortedPackages(s.pkgs) {
+		r := newPackageRow(s, p)
+		scanTime.strings = append(scanTime.strings, r.ScanTime)
+		dir.strings = append(dir.strings, r.Dir)
+		module.strings = append(module.strings, r.Module)
+		pkgName.strings = append(pkgName.strings, r.Package)
+		pkgDir.strings = append(pkgDir.strings, r.PkgDir)
+		doc.strings = append(doc.strings, r.Doc)
+		documented.bools = append(documented.bools, r.Documented)
+		api.strings = append(api.strings, r.API)
+		files.ints = append(files.ints, int32(r.Files))
+		javaFiles.ints = append(javaFiles.ints, int32(r.JavaFiles))
+		ktFiles.ints = append(ktFiles.ints, int32(r.KtFiles))
+	}
+	columns := []*parquetColumn{scanTime, dir, module, pkgName, pkgDir, doc, documented, api, files, javaFiles, ktFiles}
+	if *locFlag {
+		code, comments, blank := num("code_lines"), num("comment_lines"), num("blank_lines")
+		for _, p := range sortedPackages(s.pkgs) {
+			code.ints = append(code.ints, int32(p.codeLines))
+			comments.ints = append(comments.ints, int32(p.commentLines))
+			blank.ints = append(blank.ints, int32(p.blankLines))
+		}
+		columns = append(columns, code, comments, blank)
+	}
+	return columns
+}
+
+// plain returns the PLAIN encoding of the values.
+func (c *parquetColumn) plain() []byte {
+	var b bytes.Buffer
+	switch c.kind {
+	case parquetBoolean:
+		packed := make([]byte, (len(c.bools)+7)/8)
+		for i, v := range c.bools {
+			if v {
+				packed[i/8] |= 1 << (i % 8)
+			}
+		}
+		b.Write(packed)
+	case parquetInt32:
+		for _, v := range c.ints {
+			bin.Write(&b, bin.LittleEndian, v)
+		}
+	case parquetByteArray:
+		for _, v := range c.strings {
+			bin.Write(&b, bin.LittleEndian, uint32(len(v)))
+			b.WriteString(v)
+		}
+	}
+	return b.Bytes()
+}
+
+// writeParquet writes the columns of numRows values each as a Parquet file.
+func writeParquet(w io.Writer, columns []*parquetColumn, numRows int) error {
+	var file bytes.Buffer
+	file.WriteString("PAR1")
+
+	type chunk struct {
+		offset, size int64
+	}
+	chunks := make([]chunk, len(columns))
+	for i, c := range columns {
+		data := c.plain()
+		var header thriftCompact
+		header.i32(1, 0) // DATA_PAGE
+		header.i32(2, int32(len(data)))
+		header.i32(3, int32(len(data)))
+		header.beginStruct(5) // DataPageHeader
+		header.i32(1, int32(numRows))
+		header.i32(2, 0) // PLAIN
+		header.i32(3, 3) // RLE definition levels, none for the required columns
+		header.i32(4, 3) // RLE repetition levels, none either
+		header.endStruct()
+		header.stop()
+
+		chunks[i] = chunk{int64(file.Len()), int64(header.Len() + len(data))}
+		file.Write(header.Bytes())
+		file.Write(data)
+	}
+
+	var meta thriftCompact // FileMetaData
+	meta.i32(1, 1)
+	meta.list(2, thriftStruct, len(columns)+1)
+	meta.beginElem() // the root of the schema
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(columns)))
+	meta.endStruct()
+	for _, c := range columns {
+		meta.beginElem()
+		meta.i32(1, int32(c.kind))
+		meta.i32(3, 0) // REQUIRED
+		meta.binary(4, c.name)
+		if c.kind == parquetByteArray {
+			meta.i32(6, 0) // UTF8
+		}
+		meta.endStruct()
+	}
+	meta.i64(3, int64(numRows))
+	meta.list(4, thriftStruct, 1)
+	meta.beginElem() // RowGroup
+	meta.list(1, thriftStruct, len(columns))
+	var total int64
+	for i, c := range columns {
+		meta.beginElem() // ColumnChunk
+		meta.i64(2, chunks[i].offset)
+		meta.beginStruct(3) // ColumnMetaData
+		meta.i32(1, int32(c.kind))
+		meta.list(2, thriftI32, 1)
+		meta.varint(0) // PLAIN
+		meta.list(3, thriftBinary, 1)
+		meta.str(c.name)
+		meta.i32(4, 0) // UNCOMPRESSED
+		meta.i64(5, int64(numRows))
+		meta.i64(6, chunks[i].size)
+		meta.i64(7, chunks[i].size)
+		meta.i64(9, chunks[i].offset)
+		meta.endStruct()
+		meta.endStruct()
+		total += chunks[i].size
+	}
+	meta.i64(2, total)
+	meta.i64(3, int64(numRows))
+	meta.endStruct()
+	meta.binary(6, "jet-search")
+	meta.stop()
+
+	file.Write(meta.Bytes())
+	bin.Write(&file, bin.LittleEndian, uint32(meta.Len()))
+	file.WriteString("PAR1")
+	_, err := w.Write(file.Bytes())
+	return err
+}
+
+// Thrift compact protocol types.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftCompact writes the fields of the structs, tracking the last field id of each nested one.
+type thriftCompact struct {
+	bytes.Buffer
+	lastID []int
+}
+
+func (t *thriftCompact) varint(v uint64) {
+	for v >= 0x80 {
+		t.WriteByte(byte(v) | 0x80)
+		v >>= 7
+	}
+	t.WriteByte(byte(v))
+}
+
+func (t *thriftCompact) zigzag(v int64) {
+	t.varint(uint64(v<<1) ^ uint64(v>>63))
+}
+
+func (t *thriftCompact) field(id int, kind byte) {
+	if len(t.lastID) == 0 {
+		t.lastID = []int{0}
+	}
+	last := &t.lastID[len(t.lastID)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.WriteByte(byte(delta)<<4 | kind)
+	} else {
+		t.WriteByte(kind)
+		t.zigzag(int64(id))
+	}
+	*last = id
+}
+
+func (t *thriftCompact) i32(id int, v int32) {
+	t.field(id, thriftI32)
+	t.zigzag(int64(v))
+}
+
+func (t *thriftCompact) i64(id int, v int64) {
+	t.field(id, thriftI64)
+	t.zigzag(v)
+}
+
+func (t *thriftCompact) str(s string) {
+	t.varint(uint64(len(s)))
+	t.WriteString(s)
+}
+
+func (t *thriftCompact) binary(id int, s string) {
+	t.field(id, thriftBinary)
+	t.str(s)
+}
+
+func (t *thriftCompact) list(id int, elem byte, size int) {
+	t.field(id, thriftList)
+	if size < 15 {
+		t.WriteByte(byte(size)<<4 | elem)
+	} else {
+		t.WriteByte(0xf0 | elem)
+		t.varint(uint64(size))
+	}
+}
+
+func (t *thriftCompact) beginStruct(id int) {
+	t.field(id, thriftStruct)
+	t.beginElem()
+}
+
+// beginElem begins a struct that is an element of a list, without a field header.
+func (t *thriftCompact) beginElem() {
+	t.lastID = append(t.lastID, 0)
+}
+
+func (t *thriftCompact) endStruct() {
+	t.stop()
+	t.lastID = t.lastID[:len(t.lastID)-1]
+}
+
+func (t *thriftCompact) stop() {
+	t.WriteByte(0)
+}
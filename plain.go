@@ -0,0 +1,83 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Plain text output, a block of "key: value" lines per package, for screen readers and plain text emails:
+//  go run . -d ./platform -plain -summary
+// There are no emoji, colors or tables, the blocks are separated by a blank line and have the lines of the
+// columns selected by the flags, the counts of the extensions only if there are any. Links are on their own lines.
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// printPackagesPlain prints a block per package, with the plugin model of the package module if -content-modules is set.
+func printPackagesPlain(w io.Writer, pkgs map[string]*pkg, contentModules map[string]string) {
+	if scanCommit != "" {
+		fmt.Fprintf(w, "commit: %s\n\n", scanCommit)
+	}
+	for i, p := range listedPackages(pkgs) {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "package: %s\n", p.name)
+		fmt.Fprintf(w, "module: %s\n", p.module)
+		fmt.Fprintf(w, "dir: %s\n", p.pkgDir)
+		if l := link(p.pkgDir); l != "" {
+			fmt.Fprintf(w, "link: %s\n", l)
+		}
+		fmt.Fprintf(w, "files: %s\n", plainFilesCnt(p))
+		fmt.Fprintf(w, "documentation: %s\n", plainDoc(p.doc))
+		fmt.Fprintf(w, "api: %s\n", p.apiClass())
+		if *contentModulesFlag {
+			fmt.Fprintf(w, "plugin model: %s\n", pluginModel(contentModules[p.module]))
+		}
+		if *docCoverageFlag {
+			fmt.Fprintf(w, "doc coverage: %s\n", p.typeDocCoverage())
+			fmt.Fprintf(w, "package-info: %s\n", yesNo(p.isDocumented()))
+		}
+		if *readmeFlag {
+			fmt.Fprintf(w, "readme: %s\n", plainPath(p.readme))
+			fmt.Fprintf(w, "module readme: %s\n", plainPath(p.moduleReadme))
+		}
+		if *debtMarkersFlag {
+			fmt.Fprintf(w, "debt markers: %d\n", p.debtMarkers)
+		}
+		if *locFlag {
+			fmt.Fprintf(w, "code lines: %d\n", p.codeLines)
+			fmt.Fprintf(w, "comment lines: %d\n", p.commentLines)
+			fmt.Fprintf(w, "blank lines: %d\n", p.blankLines)
+		}
+	}
+}
+
+// plainFilesCnt is the number of the files, and of the sources of every extension there are any of, i.e 4, 2 .java, 2 .kt
+func plainFilesCnt(p *pkg) string {
+	parts := []string{strconv.Itoa(len(p.files))}
+	for _, ext := range sourceExts {
+		if n := p.filesCnt[ext]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, ext))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// plainDoc spells out the documentation status of a package.
+func plainDoc(doc string) string {
+	switch {
+	case strings.HasSuffix(doc, ".html"):
+		return "legacy package.html in " + doc
+	case doc != "":
+		return "documented in " + doc
+	}
+	return "missing"
+}
+
+func plainPath(path string) string {
+	if path == "" {
+		return "none"
+	}
+	return path
+}
@@ -0,0 +1,391 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Protobuf encoding of the snapshots and the findings, by the messages of proto/jet_search.proto:
+//  go run . -d ./platform -snapshot platform.pb -findings-pb findings.pb
+//  go run . diff base.pb head.pb
+// The wire format is encoded by hand, as the tree has no dependencies. Keep the field numbers in sync with the schema.
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	pbVarint = 0
+	pbBytes  = 2
+)
+
+// isProtobuf checks if the snapshot or findings file is protobuf by the extension, JSON otherwise.
+func isProtobuf(path string) bool {
+	return filepath.Ext(path) == ".pb"
+}
+
+// pbEncoder writes the fields of a message, skipping the default values as proto3 does.
+type pbEncoder struct {
+	bytes.Buffer
+}
+
+func (e *pbEncoder) uvarint(v uint64) {
+	for v >= 0x80 {
+		e.WriteByte(byte(v) | 0x80)
+		v >>= 7
+	}
+	e.WriteByte(byte(v))
+}
+
+func (e *pbEncoder) tag(field int, wireType int) {
+	e.uvarint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (e *pbEncoder) int(field int, v int64) {
+	if v != 0 {
+		e.tag(field, pbVarint)
+		e.uvarint(uint64(v))
+	}
+}
+
+func (e *pbEncoder) bool(field int, v bool) {
+	if v {
+		e.int(field, 1)
+	}
+}
+
+func (e *pbEncoder) string(field int, s string) {
+	if s != "" {
+		e.bytesField(field, []byte(s))
+	}
+}
+
+func (e *pbEncoder) bytesField(field int, b []byte) {
+	e.tag(field, pbBytes)
+	e.uvarint(uint64(len(b)))
+	e.Write(b)
+}
+
+// message writes an embedded message, even an empty one, as the elements of repeated fields count.
+func (e *pbEncoder) message(field int, write func(m *pbEncoder)) {
+	var m pbEncoder
+	write(&m)
+	e.bytesField(field, m.Bytes())
+}
+
+// pbDecoder reads the fields of a message.
+type pbDecoder struct {
+	b []byte
+}
+
+var errBadProtobuf = errors.New("bad protobuf")
+
+func (d *pbDecoder) uvarint() (uint64, error) {
+	var v uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		if len(d.b) == 0 {
+			return 0, errBadProtobuf
+		}
+		c := d.b[0]
+		d.b = d.b[1:]
+		v |= uint64(c&0x7f) << shift
+		if c < 0x80 {
+			return v, nil
+		}
+	}
+	return 0, errBadProtobuf
+}
+
+// next reads a field, returning its number and either the varint or the bytes of its value.
+// Fixed-size values, not used by the schema, are skipped.
+func (d *pbDecoder) next() (field int, v uint64, b []byte, err error) {
+	for {
+		key, err := d.uvarint()
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		field = int(key >> 3)
+		switch key & 7 {
+		case pbVarint:
+			v, err = d.uvarint()
+			return field, v, nil, err
+		case pbBytes:
+			n, err := d.uvarint()
+			if err != nil || n > uint64(len(d.b)) {
+				return 0, 0, nil, errBadProtobuf
+			}
+			b, d.b = d.b[:n], d.b[n:]
+			return field, 0, b, nil
+		case 1, 5: // fixed64, fixed32
+			n := 8
+			if key&7 == 5 {
+				n = 4
+			}
+			if len(d.b) < n {
+				return 0, 0, nil, errBadProtobuf
+			}
+			d.b = d.b[n:]
+		default:
+			return 0, 0, nil, errBadProtobuf
+		}
+	}
+}
+
+// fields calls f for every field of the message.
+func (d *pbDecoder) fields(f func(field int, v uint64, b []byte) error) error {
+	for len(d.b) > 0 {
+		field, v, b, err := d.next()
+		if err != nil {
+			return err
+		}
+		if err := f(field, v, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mapEntry decodes an entry of a map field, with the key 1 and the value 2.
+func mapEntry(b []byte) (key string, v uint64, value string, err error) {
+	d := pbDecoder{b}
+	err = d.fields(func(field int, fv uint64, fb []byte) error {
+		switch field {
+		case 1:
+			key = string(fb)
+		case 2:
+			v, value = fv, string(fb)
+		}
+		return nil
+	})
+	return key, v, value, err
+}
+
+// sortedKeys returns the keys of a map field, so the encoding is deterministic.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func encodePackagePB(e *pbEncoder, p *pkg) {
+	e.string(1, p.module)
+	e.string(2, p.srcDir)
+	e.string(3, p.pkgDir)
+	e.string(4, p.name)
+	e.string(5, p.doc)
+	for _, f := range p.files {
+		e.bytesField(6, []byte(f))
+	}
+	for _, ext := range sortedKeys(p.filesCnt) {
+		e.message(7, func(m *pbEncoder) { m.string(1, ext); m.int(2, int64(p.filesCnt[ext])) })
+	}
+	for _, rule := range sortedKeys(p.suppressed) {
+		e.message(8, func(m *pbEncoder) { m.string(1, rule); m.string(2, p.suppressed[rule]) })
+	}
+	e.int(9, int64(p.publicTypes))
+	e.int(10, int64(p.debtMarkers))
+	e.int(11, int64(p.documentedTypes))
+	e.string(12, p.apiClass())
+	e.string(13, p.readme)
+	e.string(14, p.moduleReadme)
+	e.int(15, int64(p.codeLines))
+	e.int(16, int64(p.commentLines))
+	e.int(17, int64(p.blankLines))
+	e.string(18, p.contentHash)
+}
+
+func decodePackagePB(b []byte) (*pkg, error) {
+	p := &pkg{filesCnt: map[string]int{}}
+	d := pbDecoder{b}
+	err := d.fields(func(field int, v uint64, b []byte) error {
+		switch field {
+		case 1:
+			p.module = string(b)
+		case 2:
+			p.srcDir = string(b)
+		case 3:
+			p.pkgDir = string(b)
+		case 4:
+			p.name = string(b)
+		case 5:
+			p.doc = string(b)
+		case 6:
+			p.files = append(p.files, string(b))
+		case 7:
+			ext, n, _, err := mapEntry(b)
+			if err != nil {
+				return err
+			}
+			p.filesCnt[ext] = int(int32(n))
+		case 8:
+			rule, _, kind, err := mapEntry(b)
+			if err != nil {
+				return err
+			}
+			if p.suppressed == nil {
+				p.suppressed = map[string]string{}
+			}
+			p.suppressed[rule] = kind
+		case 9:
+			p.publicTypes = int(int32(v))
+		case 10:
+			p.debtMarkers = int(int32(v))
+		case 11:
+			p.documentedTypes = int(int32(v))
+		case 12:
+			if api := string(b); api == "internal" || api == "experimental" {
+				p.apiStatus = api // as in UnmarshalJSON
+			}
+		case 13:
+			p.readme = string(b)
+		case 14:
+			p.moduleReadme = string(b)
+		case 15:
+			p.codeLines = int(int32(v))
+		case 16:
+			p.commentLines = int(int32(v))
+		case 17:
+			p.blankLines = int(int32(v))
+		case 18:
+			p.contentHash = string(b)
+		}
+		return nil
+	})
+	return p, err
+}
+
+// writeSnapshotPB writes the snapshot as a Snapshot message.
+func writeSnapshotPB(w io.Writer, s *snapshot) error {
+	var e pbEncoder
+	e.int(1, int64(s.version))
+	e.string(2, s.dir)
+	e.int(3, s.time.UnixNano())
+	for _, p := range sortedPackages(s.pkgs) {
+		e.message(4, func(m *pbEncoder) { encodePackagePB(m, p) })
+	}
+	e.bool(5, s.publicTypes)
+	for _, root := range sortedKeys(s.roots) {
+		e.message(6, func(m *pbEncoder) { m.string(1, root); m.int(2, s.roots[root].UnixNano()) })
+	}
+	e.string(8, s.commit)
+	for _, path := range sortedKeys(s.modules) {
+		mod := s.modules[path]
+		e.message(7, func(m *pbEncoder) {
+			m.string(1, path)
+			m.int(2, int64(mod.Packages))
+			m.int(3, int64(mod.Documented))
+			m.int(4, int64(mod.Files))
+			m.int(5, int64(mod.Java))
+			m.int(6, int64(mod.Kotlin))
+			m.int(7, int64(mod.CodeLines))
+			m.int(8, int64(mod.CommentLines))
+			m.int(9, int64(mod.BlankLines))
+		})
+	}
+	_, err := w.Write(e.Bytes())
+	return err
+}
+
+// readSnapshotPB reads a Snapshot message, the modules are summarized from the packages.
+func readSnapshotPB(blob []byte) (*snapshot, error) {
+	s := &snapshot{pkgs: map[string]*pkg{}}
+	var nanos int64
+	d := pbDecoder{blob}
+	err := d.fields(func(field int, v uint64, b []byte) error {
+		switch field {
+		case 1:
+			s.version = int(int32(v))
+		case 2:
+			s.dir = string(b)
+		case 3:
+			nanos = int64(v)
+		case 4:
+			p, err := decodePackagePB(b)
+			if err != nil {
+				return err
+			}
+			if p.pkgDir == "" {
+				return fmt.Errorf("a package without pkgDir")
+			}
+			s.pkgs[p.pkgDir] = p
+		case 5:
+			s.publicTypes = v != 0
+		case 6:
+			root, t, _, err := mapEntry(b)
+			if err != nil {
+				return err
+			}
+			if s.roots == nil {
+				s.roots = map[string]time.Time{}
+			}
+			s.roots[root] = time.Unix(0, int64(t)).UTC()
+		case 8:
+			s.commit = string(b)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if s.version > snapshotVersion {
+		return nil, fmt.Errorf("format version %d is newer than the supported %d", s.version, snapshotVersion)
+	}
+	if nanos != 0 && nanos != math.MinInt64 {
+		s.time = time.Unix(0, nanos).UTC()
+	}
+	s.modules = summarizeModules(s.pkgs)
+	return s, nil
+}
+
+// writeFindingsPB writes the findings as a Findings message.
+func writeFindingsPB(path string, findings []finding) error {
+	var e pbEncoder
+	for _, f := range findings {
+		e.message(1, func(m *pbEncoder) {
+			m.string(1, f.rule)
+			m.string(2, f.level)
+			m.string(3, f.message)
+			m.string(4, f.path)
+			m.string(5, f.suppressed)
+		})
+	}
+	return os.WriteFile(path, e.Bytes(), 0644)
+}
+
+// readFindingsPB reads a Findings message.
+func readFindingsPB(blob []byte) ([]finding, error) {
+	var findings []finding
+	d := pbDecoder{blob}
+	err := d.fields(func(field int, _ uint64, b []byte) error {
+		if field != 1 {
+			return nil
+		}
+		var f finding
+		fd := pbDecoder{b}
+		err := fd.fields(func(field int, _ uint64, b []byte) error {
+			switch field {
+			case 1:
+				f.rule = string(b)
+			case 2:
+				f.level = string(b)
+			case 3:
+				f.message = string(b)
+			case 4:
+				f.path = string(b)
+			case 5:
+				f.suppressed = string(b)
+			}
+			return nil
+		})
+		findings = append(findings, f)
+		return err
+	})
+	return findings, err
+}
@@ -0,0 +1,53 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Thin scanner clients: CI agents only scan and push the snapshot to a namespace of the central server,
+// that does the diffing, the history and the serving:
+//  go run . -d ./platform -push https://jet-search.internal/api/snapshots/idea/master
+// The snapshot is gzip-compressed, and sent with the push-token secret, i.e JET_SEARCH_PUSH_TOKEN,
+// that the server requires as its upload-token secret, see secrets.go.
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// pushSnapshot uploads the snapshot to the namespace URL, printing the response of the server.
+func pushSnapshot(url, token string, s *snapshot) error {
+	var body bytes.Buffer
+	zw := gzip.NewWriter(&body)
+	if err := json.NewEncoder(zw).Encode(s); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := (&http.Client{Timeout: 5 * time.Minute}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("PUT %s: %s: %s", url, resp.Status, bytes.TrimSpace(msg))
+	}
+	fmt.Fprintf(os.Stderr, "pushed %d packages to %s: %s\n", len(s.pkgs), url, bytes.TrimSpace(msg))
+	return nil
+}
@@ -0,0 +1,73 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// READMEs of the packages and of their modules, found with -readme and printed in extra columns,
+// linked in the spreadsheet:
+//  go run . -d ./platform -readme -gs
+// A README is README.md or readme.txt, in any case, in the package dir or in the module dir, next to the .iml file.
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var readmeNames = []string{"readme.md", "readme.txt"}
+
+func isReadme(name string) bool {
+	for _, n := range readmeNames {
+		if strings.EqualFold(name, n) {
+			return true
+		}
+	}
+	return false
+}
+
+// findReadme updates .readme with the README of the package dir.
+func findReadme(p *pkg, f *sourceFile) error {
+	if isReadme(f.name()) && (p.readme == "" || f.path < p.readme) { // the same one for either order of the files
+		p.readme = f.path
+	}
+	return nil
+}
+
+// findModuleReadmes updates .moduleReadme of the packages with the README of their module dir.
+func findModuleReadmes(fsys fs.FS, pkgs map[string]*pkg) error {
+	readmes := map[string]string{} // module -> its README
+	for _, p := range pkgs {
+		readme, ok := readmes[p.module]
+		if !ok {
+			dir := filepath.Dir(p.module)
+			entries, err := fs.ReadDir(fsys, dir)
+			if err != nil {
+				return fmt.Errorf("error listing module dir %q: %v", dir, err)
+			}
+			var found []string
+			for _, e := range entries {
+				if !e.IsDir() && isReadme(e.Name()) {
+					found = append(found, filepath.Join(dir, e.Name()))
+				}
+			}
+			sort.Strings(found)
+			if len(found) > 0 {
+				readme = found[0]
+			}
+			readmes[p.module] = readme
+		}
+		p.moduleReadme = readme
+	}
+	return nil
+}
+
+// fmtReadme formats a README column: a link in Markdown and in the spreadsheet, the path otherwise, or - if there is none.
+func fmtReadme(path string) string {
+	switch {
+	case path == "":
+		return "-"
+	case *gsFlag || *mdFlag:
+		return hyperlink(link(path), filepath.Base(path))
+	}
+	return path
+}
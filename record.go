@@ -0,0 +1,232 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Reproducible scans for the bug reports about wrong numbers: -record saves every dir listed and every file read
+// by the scan, the effective flags, the config, the commits and the time of the scan in a bundle, and -replay scans
+// the bundle instead of the dir, with the same result on any machine:
+//  go run . -d ./platform -gs -record wrong-numbers.json.gz   # by the reporter, attached to the issue
+//  go run . -replay wrong-numbers.json.gz                     # by a maintainer
+// The flags given with -replay override the recorded ones, i.e -md to print Markdown instead, and a replay
+// never pushes nor publishes. The bundle is gzip-compressed JSON. Only the package scans are recorded, not -modules.
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// bundle is everything a scan depends on.
+type bundle struct {
+	Version     string                   `json:"version"` // of jet-search that recorded it
+	Flags       map[string]string        `json:"flags"`   // effective values, after the env and the config file
+	Config      *config                  `json:"config"`
+	Time        time.Time                `json:"time"`
+	Commit      string                   `json:"commit,omitempty"`
+	RepoCommits map[string]string        `json:"repoCommits,omitempty"` // repo dir -> commit
+	Dirs        map[string][]bundleEntry `json:"dirs"`                  // dir -> its entries, by filepath.Clean
+	Files       map[string][]byte        `json:"files"`                 // path -> content, by filepath.Clean
+}
+
+type bundleEntry struct {
+	Name string `json:"name"`
+	Dir  bool   `json:"dir,omitempty"`
+	Size int64  `json:"size,omitempty"`
+}
+
+// newBundle returns a bundle with the flags and the config of this run.
+func newBundle() *bundle {
+	b := &bundle{Version: fullVersion(), Flags: map[string]string{}, Config: cfg, Dirs: map[string][]bundleEntry{}, Files: map[string][]byte{}}
+	flag.VisitAll(func(f *flag.Flag) {
+		if f.Name != "record" && f.Name != "replay" && f.Name != "config" {
+			b.Flags[f.Name] = f.Value.String()
+		}
+	})
+	return b
+}
+
+// replay sets the recorded flags not given on the command line, the config, the commits and the time of the scan.
+func (b *bundle) replay() error {
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for name, value := range b.Flags {
+		if flag.Lookup(name) == nil || set[name] {
+			continue // not known to this version, or overridden
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("recorded -%s=%q: %v", name, value, err)
+		}
+	}
+	*pushFlag, *publishFlag = "", false
+	if b.Config != nil {
+		cfg = b.Config
+	}
+	scanCommit = b.Commit
+	for i, r := range cfg.Repos {
+		cfg.Repos[i].commit = b.RepoCommits[r.Dir]
+	}
+	return nil
+}
+
+// recordCommits records the commits resolved by resolveCommits.
+func (b *bundle) recordCommits() {
+	b.Commit = scanCommit
+	for _, r := range cfg.Repos {
+		if r.commit != "" {
+			if b.RepoCommits == nil {
+				b.RepoCommits = map[string]string{}
+			}
+			b.RepoCommits[r.Dir] = r.commit
+		}
+	}
+}
+
+func saveBundle(path string, b *bundle) error {
+	return writeFile(path, func(w io.Writer) error {
+		zw := gzip.NewWriter(w)
+		if err := json.NewEncoder(zw).Encode(b); err != nil {
+			return err
+		}
+		return zw.Close()
+	})
+}
+
+func readBundle(path string) (*bundle, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("error reading bundle %q: %v", path, err)
+	}
+	var b bundle
+	if err := json.NewDecoder(zr).Decode(&b); err != nil {
+		return nil, fmt.Errorf("error reading bundle %q: %v", path, err)
+	}
+	return &b, nil
+}
+
+// recordFS is the filesystem of the OS that records the dirs and the files read into the bundle.
+type recordFS struct {
+	mu sync.Mutex
+	b  *bundle
+}
+
+func (r *recordFS) Open(name string) (fs.File, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	key := filepath.Clean(name)
+	if fi.IsDir() {
+		r.mu.Lock()
+		if _, ok := r.b.Dirs[key]; !ok {
+			r.b.Dirs[key] = []bundleEntry{}
+		}
+		r.mu.Unlock()
+		return f, nil
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.b.Files[key] = data
+	r.mu.Unlock()
+	return &memFile{Reader: bytes.NewReader(data), info: memInfo{filepath.Base(key), int64(len(data)), false}}, nil
+}
+
+func (r *recordFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := os.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	recorded := make([]bundleEntry, len(entries))
+	for i, e := range entries {
+		recorded[i] = bundleEntry{Name: e.Name(), Dir: e.IsDir()}
+		if !e.IsDir() {
+			fi, err := e.Info()
+			if err != nil {
+				return nil, err
+			}
+			recorded[i].Size = fi.Size()
+		}
+	}
+	r.mu.Lock()
+	r.b.Dirs[filepath.Clean(name)] = recorded
+	r.mu.Unlock()
+	return entries, nil
+}
+
+// bundleFS is the filesystem of a recorded scan, where only the recorded dirs and files exist.
+type bundleFS struct {
+	b *bundle
+}
+
+func (b bundleFS) Open(name string) (fs.File, error) {
+	key := filepath.Clean(name)
+	if data, ok := b.b.Files[key]; ok {
+		return &memFile{Reader: bytes.NewReader(data), info: memInfo{filepath.Base(key), int64(len(data)), false}}, nil
+	}
+	if _, ok := b.b.Dirs[key]; ok {
+		return &memFile{Reader: bytes.NewReader(nil), info: memInfo{filepath.Base(key), 0, true}}, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func (b bundleFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	recorded, ok := b.b.Dirs[filepath.Clean(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	entries := make([]fs.DirEntry, len(recorded))
+	for i, e := range recorded {
+		entries[i] = fs.FileInfoToDirEntry(memInfo{e.Name, e.Size, e.Dir})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// memFile is a file or a dir of a bundle, in memory.
+type memFile struct {
+	*bytes.Reader
+	info memInfo
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memFile) Close() error               { return nil }
+
+type memInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return i.size }
+func (i memInfo) IsDir() bool        { return i.dir }
+func (i memInfo) ModTime() time.Time { return time.Time{} }
+func (i memInfo) Sys() interface{}   { return nil }
+
+func (i memInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0o755
+	}
+	return 0o644
+}
@@ -0,0 +1,291 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Redis keeps the uploaded snapshots and the hot query results of serve mode, shared by the replicas behind a load balancer:
+//  JET_SEARCH_REDIS_PASSWORD=... go run . serve -d ./platform -redis redis://redis:6379/0
+// The password is the redis-password secret, see secrets.go, never a part of the URL.
+// An upload to any replica is stored in Redis with the hash of its packages, and the other replicas load it
+// once they see a different hash, so all of them answer the same. Query results of a namespace are cached
+// by the hash, so they never outlive the snapshot. Redis failures are logged, and the replica answers from memory.
+//  jet-search:hashes               namespace -> hash of the packages of its snapshot
+//  jet-search:snapshot:<namespace> gzipped JSON of the snapshot
+//  jet-search:query:<hash>:<query> a page of the packages, for -redis-ttl
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const redisPrefix = "jet-search:"
+
+// redisClient is a minimal client of the RESP protocol over a single connection, reconnecting on failures.
+type redisClient struct {
+	addr     string
+	password string
+	db       int
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// newRedisClient parses a redis://host[:port][/db] URL, with the password of the redis-password secret.
+func newRedisClient(rawURL string) (*redisClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "redis" || u.Hostname() == "" {
+		return nil, fmt.Errorf("bad Redis URL, want redis://host[:port][/db]")
+	}
+	if u.User != nil {
+		return nil, fmt.Errorf("the Redis URL has a password, set the redis-password secret instead, see secrets.go")
+	}
+	c := &redisClient{addr: u.Host}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if c.password, err = secret("redis-password"); err != nil {
+		return nil, err
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("bad Redis db %q", db)
+		}
+	}
+	return c, nil
+}
+
+// redisNil is the reply to a missing key.
+var redisNil = errors.New("redis: nil")
+
+// do sends a command, returning its reply: a string, an int64, a []interface{} or redisNil.
+func (c *redisClient) do(args ...string) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	reply, err := c.roundTrip(args)
+	var netErr net.Error
+	if err != nil && (errors.As(err, &netErr) || err == io.EOF) { // a dropped connection, once more on a new one
+		c.close()
+		reply, err = c.roundTrip(args)
+	}
+	if err != nil && err != redisNil {
+		if _, isReply := err.(redisError); !isReply {
+			c.close()
+		}
+	}
+	return reply, err
+}
+
+func (c *redisClient) close() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// redisError is an error reply of the server.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+func (c *redisClient) roundTrip(args []string) (interface{}, error) {
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return nil, err
+		}
+	}
+	c.conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := c.conn.Write(encodeRedisCommand(args)); err != nil {
+		return nil, err
+	}
+	return readRedisReply(c.r)
+}
+
+func (c *redisClient) connect() error {
+	conn, err := dialTCP(c.addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	c.conn, c.r = conn, bufio.NewReader(conn)
+	var setup [][]string
+	if c.password != "" {
+		setup = append(setup, []string{"AUTH", c.password})
+	}
+	if c.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+	for _, args := range setup {
+		if _, err := c.roundTrip(args); err != nil {
+			c.close()
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeRedisCommand(args []string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	return b.Bytes()
+}
+
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, redisNil
+		}
+		blob := make([]byte, n+2)
+		if _, err := io.ReadFull(r, blob); err != nil {
+			return nil, err
+		}
+		return string(blob[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, redisNil
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			if list[i], err = readRedisReply(r); err != nil && err != redisNil {
+				return nil, err
+			}
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("redis: bad reply %q", line)
+}
+
+func (c *redisClient) get(key string) (string, error) {
+	reply, err := c.do("GET", key)
+	if err != nil {
+		return "", err
+	}
+	s, _ := reply.(string)
+	return s, nil
+}
+
+// redisCache is the state of the namespaces shared by the replicas, see namespaces.
+type redisCache struct {
+	*redisClient
+	ttl time.Duration // of the query results
+}
+
+// logRedis logs a failure, as the replica can still answer from memory.
+func logRedis(what string, err error) {
+	fmt.Fprintf(os.Stderr, "redis: failed to %s: %v\n", what, err)
+}
+
+// putSnapshot stores the snapshot and then its hash, so a replica never sees a hash of a snapshot that is not stored.
+func (c *redisCache) putSnapshot(name, hash string, s *snapshot) error {
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	if err := json.NewEncoder(zw).Encode(s); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if _, err := c.do("SET", redisPrefix+"snapshot:"+name, b.String()); err != nil {
+		return err
+	}
+	_, err := c.do("HSET", redisPrefix+"hashes", name, hash)
+	return err
+}
+
+// hashes returns the hashes of the snapshots by namespace.
+func (c *redisCache) hashes() (map[string]string, error) {
+	reply, err := c.do("HGETALL", redisPrefix+"hashes")
+	if err != nil {
+		return nil, err
+	}
+	list, _ := reply.([]interface{})
+	hashes := make(map[string]string, len(list)/2)
+	for i := 0; i+1 < len(list); i += 2 {
+		name, _ := list[i].(string)
+		hash, _ := list[i+1].(string)
+		hashes[name] = hash
+	}
+	return hashes, nil
+}
+
+func (c *redisCache) snapshot(name string) (*snapshot, error) {
+	blob, err := c.get(redisPrefix + "snapshot:" + name)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := gzip.NewReader(strings.NewReader(blob))
+	if err != nil {
+		return nil, err
+	}
+	var s snapshot
+	if err := json.NewDecoder(zr).Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// queryKey is the key of a cached page of the packages of a snapshot.
+func queryKey(hash, rawQuery string) string {
+	return redisPrefix + "query:" + hash + ":" + rawQuery
+}
+
+// cachedQuery returns the number of all the matching packages and the JSON of the page, false if not cached.
+func (c *redisCache) cachedQuery(key string) (int, []byte, bool) {
+	blob, err := c.get(key)
+	if err != nil {
+		if err != redisNil {
+			logRedis("get "+key, err)
+		}
+		return 0, nil, false
+	}
+	i := strings.IndexByte(blob, '\n')
+	if i < 0 {
+		return 0, nil, false
+	}
+	total, err := strconv.Atoi(blob[:i])
+	if err != nil {
+		return 0, nil, false
+	}
+	return total, []byte(blob[i+1:]), true
+}
+
+func (c *redisCache) cacheQuery(key string, total int, page []byte) {
+	if _, err := c.do("SET", key, strconv.Itoa(total)+"\n"+string(page), "PX", strconv.FormatInt(c.ttl.Milliseconds(), 10)); err != nil {
+		logRedis("set "+key, err)
+	}
+}
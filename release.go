@@ -0,0 +1,174 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Release reports summarize what is new in the API surface between two product versions, for the release notes writers:
+//  go run . report release -repo ~/intellij-community -d platform -from 233.0 -to 241.0 -md
+// Each version is a git tag, checked out to a temporary worktree and scanned,
+// unless there is a <tag>.json snapshot in the -snapshots dir, where the scans are saved for the next time.
+// Newly public packages are listed first, as they must be documented before the release, see isPublic.
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var reports = map[string]func(args []string) error{
+	"release":    reportRelease,
+	"assets":     reportAssets,
+	"size":       reportSize,
+	"duplicates": reportDuplicates,
+
+	"similar-packages": reportSimilarPackages,
+	"extension-points": reportExtensionPoints,
+}
+
+// runReport runs a report by name, given as the first argument.
+func runReport(args []string) error {
+	if len(args) == 0 || reports[args[0]] == nil {
+		names := make([]string, 0, len(reports))
+		for name := range reports {
+			names = append(names, name)
+		}
+		return fmt.Errorf("usage: report <%s> [flags]", strings.Join(names, "|"))
+	}
+	return reports[args[0]](args[1:])
+}
+
+func reportRelease(args []string) error {
+	fs := flag.NewFlagSet("report release", flag.ExitOnError)
+	repo := fs.String("repo", ".", "git checkout with the version tags")
+	dir := fs.String("d", ".", "dir to scan for packages, relative to the repo")
+	from := fs.String("from", "", "tag of the previous version, i.e 233.0")
+	to := fs.String("to", "", "tag of the new version, i.e 241.0")
+	snapshotsDir := fs.String("snapshots", "", "dir of <tag>.json snapshots to read instead of scanning, and to save the scans to")
+	testFramework := fs.Bool("test-framework", false, "include testFramework modules")
+	fail := fs.Bool("fail", false, "exit with non-zero code if any newly public package is undocumented")
+	addFormatFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *from == "" || *to == "" {
+		fs.Usage()
+		return nil
+	}
+
+	var snaps [2]*snapshot
+	for i, tag := range []string{*from, *to} {
+		s, err := tagSnapshot(*repo, *dir, tag, *snapshotsDir, *testFramework)
+		if err != nil {
+			return fmt.Errorf("error scanning %q: %v", tag, err)
+		}
+		snaps[i] = s
+	}
+
+	diffs := diffBranches(snaps[0], snaps[1], true)
+	printReleaseReport(diffs, *from, *to)
+
+	undocumented := 0
+	for _, d := range diffs {
+		if d.NewlyPublic && d.Head != "documented" {
+			undocumented++
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%d newly public packages in %s are undocumented\n", undocumented, *to)
+	return failedCheck("undocumented-new", *fail, undocumented)
+}
+
+// tagSnapshot returns the snapshot of the dir at the tag, from the snapshots dir if it has one.
+func tagSnapshot(repo, dir, tag, snapshotsDir string, testFramework bool) (*snapshot, error) {
+	var path string
+	if snapshotsDir != "" {
+		path = filepath.Join(snapshotsDir, tag+".json")
+		if s, err := readSnapshot(path); err == nil {
+			return s, nil
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	worktree, err := os.MkdirTemp("", "jet-search-"+tag+"-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(worktree)
+	if err := git(repo, "worktree", "add", "--detach", worktree, tag); err != nil {
+		return nil, err
+	}
+	defer git(repo, "worktree", "remove", "--force", worktree)
+
+	scanned := filepath.Join(worktree, dir)
+	pkgs, err := scanDir(scanned, testFramework, countSize, hashContent)
+	if err != nil {
+		return nil, err
+	}
+	s := newSnapshot(scanned, pkgs)
+	s.publicTypes = true
+	if path != "" {
+		if err := os.MkdirAll(snapshotsDir, 0755); err != nil {
+			return nil, err
+		}
+		if err := saveSnapshot(path, s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func git(repo string, args ...string) error {
+	out, err := exec.Command("git", append([]string{"-C", repo}, args...)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// releaseSections group the differences of a release, in the order of the report.
+var releaseSections = []struct {
+	title string
+	match func(d branchDiff) bool
+}{
+	{"Newly public packages", func(d branchDiff) bool { return d.NewlyPublic }},
+	{"New packages", func(d branchDiff) bool { return d.Base == "absent" }},
+	{"Removed packages", func(d branchDiff) bool { return d.Head == "absent" }},
+	{"Renamed packages", func(d branchDiff) bool { return d.RenamedFrom != "" }},
+	{"Newly documented packages", func(d branchDiff) bool { return d.Base == "undocumented" && d.Head == "documented" }},
+	{"Packages that lost documentation", func(d branchDiff) bool { return d.Base == "documented" && d.Head == "undocumented" }},
+}
+
+// printReleaseReport prints a section per kind of change, with a line per package.
+func printReleaseReport(diffs []branchDiff, from, to string) {
+	if *mdFlag {
+		fmt.Printf("# API surface changes %s → %s\n", from, to)
+	} else {
+		printHeader([]string{"change", "package", "dir", from, to})
+	}
+	for _, section := range releaseSections {
+		var matched []branchDiff
+		for _, d := range diffs {
+			if section.match(d) {
+				matched = append(matched, d)
+			}
+		}
+		if *mdFlag {
+			fmt.Printf("\n## %s (%d)\n", section.title, len(matched))
+			for i, d := range matched {
+				if i == 0 {
+					fmt.Println()
+				}
+				state := d.Head
+				if state == "absent" {
+					state = d.Base
+				}
+				fmt.Printf("* `%s` in %s, %s\n", d.Package, d.Dir, state)
+			}
+			continue
+		}
+		for _, d := range matched {
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\n", section.title, d.Package, d.Dir, d.Base, d.Head)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Reports split per module, as a single table of 10k packages is unwieldy in code review and wikis:
+//  go run . -d ./platform -md -o-dir reports/
+// writes reports/<module>.md for every module and reports/index.md linking them, .csv files without -md.
+// The sums of the modules alone, for planning, are printed by
+//  go run . -d ./platform -by-module -md
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// writeReports writes the packages of every module to a file in the dir, and an index of the modules.
+func writeReports(dir string, pkgs map[string]*pkg, contentModules map[string]string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	ext := ".csv"
+	if *mdFlag {
+		ext = ".md"
+	}
+
+	byModule := map[string]map[string]*pkg{}
+	for pkgDir, p := range pkgs {
+		if byModule[p.module] == nil {
+			byModule[p.module] = map[string]*pkg{}
+		}
+		byModule[p.module][pkgDir] = p
+	}
+	modules := make([]string, 0, len(byModule))
+	for m := range byModule {
+		modules = append(modules, m)
+	}
+	sort.Strings(modules)
+
+	summaries := summarizeModules(pkgs)
+	var index [][]string
+	for _, m := range modules {
+		name := strings.TrimSuffix(filepath.Base(m), filepath.Ext(m)) + ext
+		err := writeFile(filepath.Join(dir, name), func(w io.Writer) error {
+			if *mdFlag {
+				printPackages(w, byModule[m], contentModules)
+				return nil
+			}
+			return writePackagesCSV(w, byModule[m])
+		})
+		if err != nil {
+			return err
+		}
+		s := summaries[m]
+		row := []string{m, name, strconv.Itoa(s.Packages), strconv.Itoa(s.Documented),
+			fmt.Sprintf("%.1f", percent(s.Documented, s.Packages))}
+		if *locFlag {
+			row = append(row, strconv.Itoa(s.CodeLines), strconv.Itoa(s.CommentLines), strconv.Itoa(s.BlankLines))
+		}
+		index = append(index, row)
+	}
+
+	return writeFile(filepath.Join(dir, "index"+ext), func(w io.Writer) error {
+		header := []string{"packages", "documented", "coverage %"}
+		if *locFlag {
+			header = append(header, "code", "comments", "blank")
+		}
+		if !*mdFlag {
+			cw := csv.NewWriter(w)
+			cw.Write(append([]string{"module", "report"}, header...))
+			cw.WriteAll(index)
+			return cw.Error()
+		}
+		fprintCommit(w)
+		fprintHeader(w, append([]string{"module"}, header...))
+		for _, r := range index {
+			fmt.Fprintf(w, "[%s](%s) | %s\n", r[0], r[1], strings.Join(r[2:], " | "))
+		}
+		return nil
+	})
+}
+
+// printModuleSummaries prints a row per module with the sums of its packages, in the format selected by the flags.
+func printModuleSummaries(w io.Writer, pkgs map[string]*pkg) {
+	summaries := summarizeModules(pkgs)
+	modules := make([]string, 0, len(summaries))
+	for m := range summaries {
+		modules = append(modules, m)
+	}
+	sort.Strings(modules)
+
+	if *jsonFlag {
+		type moduleRow struct {
+			Module string `json:"module"`
+			*moduleSummary
+			Coverage float64 `json:"coverage"`
+		}
+		rows := make([]moduleRow, len(modules))
+		for i, m := range modules {
+			s := summaries[m]
+			rows[i] = moduleRow{m, s, percent(s.Documented, s.Packages)}
+		}
+		encodeWithSummary(w, "modules", rows, pkgs)
+		return
+	}
+	if *summaryFlag {
+		defer fprintSummary(w, summarize(pkgs))
+	}
+
+	header := []string{"module", "packages", "files", ".java", ".kt", "documented", "coverage %"}
+	if *locFlag {
+		header = append(header, "code", "comments", "blank")
+	}
+	fprintCommit(w)
+	fprintHeader(w, header)
+	sep := "\t"
+	if *mdFlag {
+		sep = " | "
+	}
+	for _, m := range modules {
+		s := summaries[m]
+		row := []string{hyperlink(link(m), m), strconv.Itoa(s.Packages), strconv.Itoa(s.Files), strconv.Itoa(s.Java), strconv.Itoa(s.Kotlin),
+			strconv.Itoa(s.Documented), fmt.Sprintf("%.1f", percent(s.Documented, s.Packages))}
+		if *locFlag {
+			row = append(row, strconv.Itoa(s.CodeLines), strconv.Itoa(s.CommentLines), strconv.Itoa(s.BlankLines))
+		}
+		fmt.Fprintln(w, strings.Join(row, sep))
+	}
+}
+
+// writePackagesCSV writes a package per row, with the links as plain columns.
+func writePackagesCSV(w io.Writer, pkgs map[string]*pkg) error {
+	cw := csv.NewWriter(w)
+	return cw.WriteAll(packagesTable(pkgs))
+}
+
+// packagesTable returns the header and a row per package of the CSV and the XLSX reports.
+func packagesTable(pkgs map[string]*pkg) [][]string {
+	header := append([]string{"files"}, sourceExts...)
+	header = append(header, "module", "package", "dir", "documentation", "link")
+	if *docCoverageFlag {
+		header = append(header, "doc coverage", "package-info")
+	}
+	if *readmeFlag {
+		header = append(header, "readme", "module readme")
+	}
+	if *locFlag {
+		header = append(header, "code", "comments", "blank")
+	}
+	table := [][]string{header}
+	for _, p := range sortedPackages(pkgs) {
+		row := []string{strconv.Itoa(len(p.files))}
+		for _, ext := range sourceExts {
+			row = append(row, strconv.Itoa(p.filesCnt[ext]))
+		}
+		row = append(row, p.module, p.name, p.pkgDir, p.doc, link(p.pkgDir))
+		if *docCoverageFlag {
+			row = append(row, p.typeDocCoverage(), yesNo(p.isDocumented()))
+		}
+		if *readmeFlag {
+			row = append(row, p.readme, p.moduleReadme)
+		}
+		if *locFlag {
+			row = append(row, strconv.Itoa(p.codeLines), strconv.Itoa(p.commentLines), strconv.Itoa(p.blankLines))
+		}
+		table = append(table, row)
+	}
+	return table
+}
+
+// writeFile creates the file and writes it with the given func.
+func writeFile(path string, write func(w io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing %q: %v", path, err)
+	}
+	return f.Close()
+}
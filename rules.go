@@ -0,0 +1,377 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Custom inspection rules, defined in the config file and evaluated for every package:
+//  {"rules": [{
+//    "name": "LargeUndocumentedPackage",
+//    "scope": "platform/core*/**",
+//    "condition": "files >= 20 && !documented",
+//    "severity": "warning",
+//    "message": "{package} has {files} files and no docs"
+//  }]}
+// A condition compares package metrics (see pkgMetrics) with numbers, combined by && || ! and parentheses.
+// A scope is a glob over the package dir or name, where ** matches any number of dirs, all packages if empty.
+// Placeholders in a message are replaced by metrics, {package} and {dir}.
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// rule is a custom inspection from the config file.
+type rule struct {
+	Name      string `json:"name"`
+	Scope     string `json:"scope"`
+	Condition string `json:"condition"`
+	Severity  string `json:"severity"` // error, warning, or info as the checks say it or note as SARIF does, warning by default
+	Message   string `json:"message"`
+}
+
+// pkgMetrics are the values a rule condition can use.
+func pkgMetrics(p *pkg) map[string]float64 {
+	metrics := map[string]float64{
+		"files":      float64(len(p.files)),
+		"sources":    float64(p.sourcesCnt()),
+		"java":       float64(p.filesCnt[".java"]),
+		"kt":         float64(p.filesCnt[".kt"]),
+		"scala":      float64(p.filesCnt[".scala"]),
+		"groovy":     float64(p.filesCnt[".groovy"]),
+		"documented": boolMetric(p.isDocumented()),
+		"legacyDoc":  boolMetric(p.isLegacyDoc()),
+	}
+	for _, ext := range sourceExts { // i.e ts with -ext .ts
+		metrics[strings.TrimPrefix(ext, ".")] = float64(p.filesCnt[ext])
+	}
+	return metrics
+}
+
+func boolMetric(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// collectFindings returns the built-in findings and the ones of the custom rules from the config.
+func collectFindings(pkgs map[string]*pkg) ([]finding, error) {
+	custom, err := ruleFindings(cfg.Rules, pkgs)
+	if err != nil {
+		return nil, err
+	}
+	findings := append(undocumentedFindings(pkgs), namingFindings(pkgs, cfg.PackagePrefixes)...)
+	findings = append(findings, smallPackageFindings(pkgs, cfg.smallPackageFiles())...)
+	findings = append(findings, custom...)
+	for i, f := range findings {
+		p, ok := pkgs[f.path]
+		if !ok { // a file in the package
+			p = pkgs[filepath.Dir(f.path)]
+		}
+		if p != nil {
+			findings[i].suppressed = p.suppressionOf(f.rule)
+		}
+	}
+	sortFindings(findings)
+	return findings, nil
+}
+
+// printFindings prints one finding per line, in the format selected by the flags.
+func printFindings(findings []finding) {
+	printHeader([]string{"severity", "rule", "path", "message", "suppressed"})
+	for _, f := range findings {
+		if *mdFlag {
+			fmt.Printf("%-7s | %s | %s | %s | %s\n", f.level, f.rule, f.path, f.message, f.suppressed)
+		} else {
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\n", f.level, f.rule, f.path, f.message, f.suppressed)
+		}
+	}
+}
+
+// ruleFindings evaluates the rules for every package.
+func ruleFindings(rules []rule, pkgs map[string]*pkg) ([]finding, error) {
+	var findings []finding
+	for _, r := range rules {
+		cond, err := parseCondition(r.Condition)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %v", r.Name, err)
+		}
+		scope, err := globToRegexp(r.Scope)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: bad scope %q: %v", r.Name, r.Scope, err)
+		}
+		severity, err := ruleLevel(r.Severity)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %v", r.Name, err)
+		}
+
+		for _, p := range pkgs {
+			if !scope.MatchString(p.pkgDir) && !scope.MatchString(p.name) {
+				continue
+			}
+			metrics := pkgMetrics(p)
+			v, err := cond.eval(metrics)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: %v", r.Name, err)
+			}
+			if v != 0 {
+				findings = append(findings, finding{rule: r.Name, level: severity, message: r.message(p, metrics), path: p.pkgDir})
+			}
+		}
+	}
+	sortFindings(findings)
+	return findings, nil
+}
+
+// ruleLevel returns the SARIF level of a rule severity, of the vocabulary of the checks or of SARIF.
+func ruleLevel(severity string) (string, error) {
+	switch severity {
+	case "":
+		return "warning", nil
+	case "error", "warning", "note":
+		return severity, nil
+	case "info":
+		return "note", nil
+	}
+	return "", fmt.Errorf("unknown severity %q, expected one of %s or note", severity, strings.Join(severities, ", "))
+}
+
+var placeholder = regexp.MustCompile(`\{(\w+)\}`)
+
+func (r *rule) message(p *pkg, metrics map[string]float64) string {
+	msg := r.Message
+	if msg == "" {
+		msg = fmt.Sprintf("%s: %s", r.Name, r.Condition)
+	}
+	return placeholder.ReplaceAllStringFunc(msg, func(s string) string {
+		name := s[1 : len(s)-1]
+		switch name {
+		case "package":
+			return p.name
+		case "dir":
+			return p.pkgDir
+		}
+		if v, ok := metrics[name]; ok {
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		return s
+	})
+}
+
+// globToRegexp converts a glob, where ** crosses the dir boundaries and * does not, to an anchored regexp.
+func globToRegexp(glob string) (*regexp.Regexp, error) {
+	if glob == "" {
+		glob = "**"
+	}
+	var re strings.Builder
+	re.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case c == '*' && i+1 < len(glob) && glob[i+1] == '*':
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+	return regexp.Compile(re.String())
+}
+
+// expr is a parsed condition, evaluating to a number where booleans are 1 and 0.
+type expr interface {
+	eval(metrics map[string]float64) (float64, error)
+}
+
+type (
+	number float64
+	metric string
+	not    struct{ x expr }
+	binary struct {
+		op   string
+		x, y expr
+	}
+)
+
+func (n number) eval(map[string]float64) (float64, error) { return float64(n), nil }
+
+func (m metric) eval(metrics map[string]float64) (float64, error) {
+	v, ok := metrics[string(m)]
+	if !ok {
+		return 0, fmt.Errorf("unknown metric %q", string(m))
+	}
+	return v, nil
+}
+
+func (n not) eval(metrics map[string]float64) (float64, error) {
+	v, err := n.x.eval(metrics)
+	return boolMetric(v == 0), err
+}
+
+func (b binary) eval(metrics map[string]float64) (float64, error) {
+	x, err := b.x.eval(metrics)
+	if err != nil {
+		return 0, err
+	}
+	// short-circuit, so unknown metrics on the other side are fine
+	if b.op == "&&" && x == 0 {
+		return 0, nil
+	} else if b.op == "||" && x != 0 {
+		return 1, nil
+	}
+	y, err := b.y.eval(metrics)
+	if err != nil {
+		return 0, err
+	}
+
+	switch b.op {
+	case "&&", "||":
+		return boolMetric(y != 0), nil
+	case "<":
+		return boolMetric(x < y), nil
+	case "<=":
+		return boolMetric(x <= y), nil
+	case ">":
+		return boolMetric(x > y), nil
+	case ">=":
+		return boolMetric(x >= y), nil
+	case "==":
+		return boolMetric(x == y), nil
+	case "!=":
+		return boolMetric(x != y), nil
+	}
+	return 0, fmt.Errorf("unknown operator %q", b.op)
+}
+
+// parseCondition parses a condition, i.e `files > 10 && (kt == 0 || !documented)`
+func parseCondition(s string) (expr, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &condParser{tokens: tokens}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in %q", p.tokens[p.pos], s)
+	}
+	return e, nil
+}
+
+func tokenize(s string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || c == '.':
+			j := i
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || s[j] == '_' || s[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		case strings.HasPrefix(s[i:], "&&"), strings.HasPrefix(s[i:], "||"), strings.HasPrefix(s[i:], "<="),
+			strings.HasPrefix(s[i:], ">="), strings.HasPrefix(s[i:], "=="), strings.HasPrefix(s[i:], "!="):
+			tokens = append(tokens, s[i:i+2])
+			i += 2
+		case strings.ContainsRune("()<>!", c):
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected %q in %q", c, s)
+		}
+	}
+	return tokens, nil
+}
+
+// condParser is a recursive descent parser, from the lowest precedence: || && comparison ! operand
+type condParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *condParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *condParser) or() (expr, error) {
+	return p.binary(p.and, "||")
+}
+
+func (p *condParser) and() (expr, error) {
+	return p.binary(p.comparison, "&&")
+}
+
+func (p *condParser) comparison() (expr, error) {
+	return p.binary(p.unary, "<", "<=", ">", ">=", "==", "!=")
+}
+
+func (p *condParser) binary(operand func() (expr, error), ops ...string) (expr, error) {
+	x, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		found := false
+		for _, o := range ops {
+			found = found || op == o
+		}
+		if !found {
+			return x, nil
+		}
+		p.pos++
+		y, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		x = binary{op, x, y}
+	}
+}
+
+func (p *condParser) unary() (expr, error) {
+	switch t := p.peek(); {
+	case t == "":
+		return nil, fmt.Errorf("unexpected end of condition")
+	case t == "!":
+		p.pos++
+		x, err := p.unary()
+		return not{x}, err
+	case t == "(":
+		p.pos++
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return x, nil
+	case t == "true" || t == "false":
+		p.pos++
+		return number(boolMetric(t == "true")), nil
+	case unicode.IsDigit(rune(t[0])):
+		p.pos++
+		n, err := strconv.ParseFloat(t, 64)
+		return number(n), err
+	case unicode.IsLetter(rune(t[0])) || t[0] == '_':
+		p.pos++
+		return metric(t), nil
+	default:
+		return nil, fmt.Errorf("unexpected %q", t)
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Findings in SARIF 2.1.0 format, as read by Qodana and the code-scanning dashboards.
+// With -qodana <results-dir> they are merged into <results-dir>/qodana.sarif.json of an existing Qodana run,
+// so undocumented packages show up alongside the other inspections.
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	sarifSchema  = "https://schemastore.azurewebsites.net/schemas/json/sarif-2.1.0-rtm.5.json"
+	sarifVersion = "2.1.0"
+	toolName     = "jet-search"
+)
+
+// ruleDescriptions are short descriptions of the rules, shown by SARIF viewers.
+var ruleDescriptions = map[string]string{
+	"UndocumentedPackage":        "Package has no package-info.java",
+	"LegacyPackageDocumentation": "Package is documented in legacy package.html",
+}
+
+// finding is a problem with a package, reported as a SARIF result.
+type finding struct {
+	rule       string // i.e UndocumentedPackage
+	level      string // error, warning or note
+	message    string
+	path       string // file or dir the finding is about
+	suppressed string // SARIF suppression kind: inSource or external, if suppressed
+}
+
+// undocumentedFindings reports packages without package-info.java, and the ones with legacy package.html.
+func undocumentedFindings(pkgs map[string]*pkg) []finding {
+	var findings []finding
+	for _, p := range pkgs {
+		switch filepath.Ext(p.doc) {
+		case "":
+			findings = append(findings, finding{rule: "UndocumentedPackage", level: "warning",
+				message: fmt.Sprintf("Package %s (%d files) has no package-info.java", p.name, len(p.files)), path: p.pkgDir})
+		case ".html":
+			findings = append(findings, finding{rule: "LegacyPackageDocumentation", level: "note",
+				message: fmt.Sprintf("Package %s is documented in package.html, consider package-info.java", p.name), path: p.doc})
+		}
+	}
+	sortFindings(findings)
+	return findings
+}
+
+func sortFindings(findings []finding) {
+	sort.Slice(findings, func(i, j int) bool {
+		if findings[i].path != findings[j].path {
+			return findings[i].path < findings[j].path
+		}
+		return findings[i].rule < findings[j].rule
+	})
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifComponent `json:"driver"`
+}
+
+type sarifComponent struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID       string             `json:"ruleId"`
+	Level        string             `json:"level"`
+	Message      sarifMessage       `json:"message"`
+	Locations    []sarifLocation    `json:"locations"`
+	Suppressions []sarifSuppression `json:"suppressions,omitempty"`
+}
+
+type sarifSuppression struct {
+	Kind string `json:"kind"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation struct {
+		ArtifactLocation struct {
+			URI       string `json:"uri"`
+			URIBaseID string `json:"uriBaseId"`
+		} `json:"artifactLocation"`
+	} `json:"physicalLocation"`
+}
+
+// newSarifRun returns a run of this tool, with a rule for every kind of the findings.
+func newSarifRun(findings []finding) sarifRun {
+	run := sarifRun{Tool: sarifTool{Driver: sarifComponent{Name: toolName}}, Results: []sarifResult{}}
+	rules := map[string]bool{}
+	for _, f := range findings {
+		if !rules[f.rule] {
+			rules[f.rule] = true
+			description := ruleDescriptions[f.rule]
+			if description == "" {
+				description = f.rule
+			}
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{ID: f.rule, ShortDescription: sarifMessage{description}})
+		}
+		run.Results = append(run.Results, f.sarifResult())
+	}
+	return run
+}
+
+func (f finding) sarifResult() sarifResult {
+	loc := sarifLocation{}
+	loc.PhysicalLocation.ArtifactLocation.URI = filepath.ToSlash(f.path)
+	loc.PhysicalLocation.ArtifactLocation.URIBaseID = "SRCROOT"
+	r := sarifResult{RuleID: f.rule, Level: f.level, Message: sarifMessage{f.message}, Locations: []sarifLocation{loc}}
+	if f.suppressed != "" {
+		r.Suppressions = []sarifSuppression{{Kind: f.suppressed}}
+	}
+	return r
+}
+
+// writeSarif writes the findings as a SARIF log with a single run.
+func writeSarif(path string, findings []finding) error {
+	blob, err := json.MarshalIndent(sarifLog{sarifSchema, sarifVersion, []sarifRun{newSarifRun(findings)}}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, blob, 0644)
+}
+
+// writeQodana contributes the findings to qodana.sarif.json in the results dir: the results are appended to
+// the first run with the rules as a tool extension, keeping everything else Qodana wrote as is. The results of
+// the rules of a previous jet-search extension are replaced, so that it can be re-run on the same results dir.
+// Without an existing report, a new one is created.
+func writeQodana(resultsDir string, findings []finding) error {
+	path := filepath.Join(resultsDir, "qodana.sarif.json")
+	blob, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		if err := os.MkdirAll(resultsDir, 0755); err != nil {
+			return err
+		}
+		return writeSarif(path, findings)
+	} else if err != nil {
+		return err
+	}
+
+	var log map[string]interface{}
+	if err := json.Unmarshal(blob, &log); err != nil {
+		return fmt.Errorf("error parsing %q: %v", path, err)
+	}
+	runs, _ := log["runs"].([]interface{})
+	if len(runs) == 0 {
+		return writeSarif(path, findings)
+	}
+	run, ok := runs[0].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("error parsing %q: unexpected run %T", path, runs[0])
+	}
+
+	tool, _ := run["tool"].(map[string]interface{})
+	if tool == nil {
+		tool = map[string]interface{}{}
+		run["tool"] = tool
+	}
+	if driver, _ := tool["driver"].(map[string]interface{}); driver != nil && driver["name"] == toolName { // created by us
+		return writeSarif(path, findings)
+	}
+
+	// the results and the extension of a previous run of ours are replaced, to not duplicate them
+	extensions, _ := tool["extensions"].([]interface{})
+	previous := map[string]bool{} // rule IDs
+	var kept []interface{}
+	for _, e := range extensions {
+		ext, _ := e.(map[string]interface{})
+		if ext == nil || ext["name"] != toolName {
+			kept = append(kept, e)
+			continue
+		}
+		rules, _ := ext["rules"].([]interface{})
+		for _, r := range rules {
+			if rule, _ := r.(map[string]interface{}); rule != nil {
+				if id, ok := rule["id"].(string); ok {
+					previous[id] = true
+				}
+			}
+		}
+	}
+	results, _ := run["results"].([]interface{})
+	var keptResults []interface{}
+	for _, r := range results {
+		if result, _ := r.(map[string]interface{}); result != nil {
+			if id, ok := result["ruleId"].(string); ok && previous[id] {
+				continue
+			}
+		}
+		keptResults = append(keptResults, r)
+	}
+
+	ours, err := asJSONObject(newSarifRun(findings))
+	if err != nil {
+		return err
+	}
+	run["results"] = append(keptResults, ours["results"].([]interface{})...)
+	tool["extensions"] = append(kept, ours["tool"].(map[string]interface{})["driver"])
+
+	blob, err = json.MarshalIndent(log, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, blob, 0644)
+}
+
+// asJSONObject converts a struct to a generic JSON object, to be merged with one read from a file.
+func asJSONObject(v interface{}) (map[string]interface{}, error) {
+	blob, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var obj map[string]interface{}
+	err = json.Unmarshal(blob, &obj)
+	return obj, err
+}
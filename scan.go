@@ -0,0 +1,267 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// A single filesystem pass over the source roots, that collects the packages
+// and runs all the analyses (files, docs, ...) as visitors of every package file.
+// Analyses never walk the filesystem on their own and a file content is read at most once.
+// The source roots are walked and the modules parsed by -j goroutines, while the visitors run one at a time,
+// in the order of the package dirs, so the result does not depend on -j.
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// osFS is the filesystem of the OS. Unlike os.DirFS, it opens the paths as given, relative to the working dir
+// or absolute, so the packages of a real scan keep the paths of the -d dir. Fixtures are scanned by any other fs.FS.
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error) {
+	return os.Open(name)
+}
+
+// jobs returns the number of the goroutines to walk the source roots and parse the modules with, see -j.
+func jobs() int {
+	if *jobsFlag < 1 {
+		return runtime.NumCPU()
+	}
+	return *jobsFlag
+}
+
+// inParallel runs f for every index below n on up to jobs() goroutines,
+// returning the error of the lowest index, so the result does not depend on the scheduling.
+func inParallel(n int, f func(i int) error) error {
+	errs := make([]error, n)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < jobs() && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				errs[i] = f(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// visitor is an analysis that sees every file of every package once, during a single scan.
+type visitor func(p *pkg, f *sourceFile) error
+
+// sourceFile is a file in a package dir, which content is read on demand and shared by all the visitors.
+type sourceFile struct {
+	fsys fs.FS
+	path string
+	size int64
+	data []byte
+}
+
+func (f *sourceFile) name() string {
+	return filepath.Base(f.path)
+}
+
+// content reads the file once, for all the visitors.
+func (f *sourceFile) content() ([]byte, error) {
+	if f.data != nil {
+		return f.data, nil
+	}
+	data, err := fs.ReadFile(f.fsys, f.path)
+	if err != nil {
+		return nil, err
+	}
+	f.data = data
+	return data, nil
+}
+
+// defaultSourceExts are the extensions of the sources by default.
+var defaultSourceExts = []string{".java", ".kt", ".scala", ".groovy"}
+
+// sourceExts are the extensions of the sources, counted separately in the packages, set by -ext.
+var sourceExts = defaultSourceExts
+
+var extUsage = "comma-separated extensions of the sources to count, a column each, i.e .java,.kt,.kts,.groovy, by default: " + strings.Join(defaultSourceExts, ",")
+
+// extValue is the -ext flag, setting sourceExts.
+type extValue struct{}
+
+func (extValue) String() string     { return strings.Join(sourceExts, ",") }
+func (extValue) Set(s string) error { return setSourceExts(s) }
+
+func init() {
+	flag.Var(extValue{}, "ext", extUsage)
+}
+
+// setSourceExts sets the source extensions from a comma-separated list, i.e .java,.kt,.kts,.groovy
+func setSourceExts(list string) error {
+	var exts []string
+	for _, ext := range strings.Split(list, ",") {
+		ext = strings.TrimSpace(ext)
+		if len(ext) < 2 || ext[0] != '.' || strings.ContainsAny(ext[1:], "./\\") {
+			return fmt.Errorf("bad extension %q, want i.e .java,.kt", ext)
+		}
+		if !contains(exts, ext) {
+			exts = append(exts, ext)
+		}
+	}
+	sourceExts = exts
+	return nil
+}
+
+// isSource checks if the file is a source by its extension, see sourceExts.
+func (f *sourceFile) isSource() bool {
+	return contains(sourceExts, filepath.Ext(f.path))
+}
+
+// isSkipped checks if the file is not a real source to get a package name or docs from.
+func (f *sourceFile) isSkipped() bool {
+	if strings.HasPrefix(f.name(), "_") { // templates for some code-gen?
+		// platform/testFramework/src/{_FirstInSuiteTest.java, _LastInSuiteTest.java}
+		return true
+	}
+	// skip empty files
+	// platform/testFramework/src/com/intellij/codeInsight/codeVision/CodeVisionTestCase.kt
+	return f.size == 0
+}
+
+// scanPackages walks the given source roots (srcDir -> .iml module) once, collecting the packages
+// and running the visitors over each file of every package.
+func scanPackages(fsys fs.FS, srcDirPaths map[string]string, visitors ...visitor) (map[string]*pkg, error) {
+	type dir struct {
+		srcDir, module string
+		files          []*sourceFile
+	}
+	srcDirs := make([]string, 0, len(srcDirPaths))
+	for srcDir := range srcDirPaths {
+		srcDirs = append(srcDirs, srcDir)
+	}
+	sort.Strings(srcDirs)
+
+	// the roots are walked in parallel, each into its own dirs, merged in the order of the roots
+	walked := make([]map[string]*dir, len(srcDirs))
+	err := inParallel(len(srcDirs), func(i int) error {
+		srcDir, mod := srcDirs[i], srcDirPaths[srcDirs[i]]
+		rootDirs := map[string]*dir{}
+		walked[i] = rootDirs
+		return fs.WalkDir(fsys, srcDir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() && (strings.HasPrefix(d.Name(), ".") || testDataDirs[d.Name()]) {
+				return fs.SkipDir
+			}
+			if _, nested := srcDirPaths[path]; d.IsDir() && nested && path != srcDir { // walked as its own root
+				return fs.SkipDir
+			}
+			if d.IsDir() {
+				return nil
+			}
+
+			di, err := d.Info()
+			if err != nil {
+				return err
+			}
+			pkgDir := filepath.Dir(path)
+			pd, ok := rootDirs[pkgDir]
+			if !ok {
+				pd = &dir{srcDir: srcDir, module: mod}
+				rootDirs[pkgDir] = pd
+			}
+			pd.files = append(pd.files, &sourceFile{fsys: fsys, path: path, size: di.Size()})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	dirs := map[string]*dir{}
+	for _, rootDirs := range walked {
+		for pkgDir, pd := range rootDirs {
+			if _, ok := dirs[pkgDir]; !ok { // nested source roots, the dir is already collected
+				dirs[pkgDir] = pd
+			}
+		}
+	}
+
+	pkgDirs := make([]string, 0, len(dirs))
+	for pkgDir := range dirs {
+		pkgDirs = append(pkgDirs, pkgDir)
+	}
+	sort.Strings(pkgDirs)
+
+	pkgs := map[string]*pkg{}
+	for _, pkgDir := range pkgDirs {
+		pd := dirs[pkgDir]
+
+		// a dir is a package if it has a source file to read the package name from
+		var p *pkg
+		for _, f := range pd.files {
+			if f.isSource() && !f.isSkipped() {
+				pkgName, err := readPkgNameFromFirstLines(fsys, f.path, 100)
+				if err != nil {
+					return nil, err
+				}
+				p = &pkg{module: pd.module, srcDir: pd.srcDir, pkgDir: pkgDir, name: pkgName, filesCnt: map[string]int{}}
+				break
+			}
+		}
+		if p == nil {
+			continue
+		}
+		pkgs[pkgDir] = p
+
+		for _, f := range pd.files {
+			for _, visit := range visitors {
+				if err := visit(p, f); err != nil {
+					return nil, err
+				}
+			}
+			f.data = nil // visited by all
+		}
+	}
+	return pkgs, nil
+}
+
+// countFiles updates .files & .filesCnt with the sources of the package, by extension.
+func countFiles(p *pkg, f *sourceFile) error {
+	if f.isSource() {
+		p.files = append(p.files, f.name())
+		p.filesCnt[filepath.Ext(f.path)]++
+	}
+	return nil
+}
+
+// findDoc updates .doc with the package-info.java or package.html of the package,
+// or .docFiles with the candidates of the docRules of the config, see applyDocRules.
+func findDoc(p *pkg, f *sourceFile) error {
+	if f.isSkipped() {
+		return nil
+	}
+	if len(cfg.DocRules) > 0 {
+		if isDocFile(cfg.DocRules, f.name()) {
+			p.docFiles = append(p.docFiles, f.path)
+		}
+		return nil
+	}
+	if f.name() == "package-info.java" || f.name() == "package.html" {
+		p.doc = f.path
+	}
+	return nil
+}
@@ -2,50 +2,101 @@
 package main
 
 // Collect stats on JVP Packages for JPS modules.
-// The results of `go run ./scan_packages.go -gs -d ./platform`
+// The results of `go run . -gs -d ./platform`
 //  available at https://jb.gg/platform-packages
 
 // It gets the next modules right (by parsing .iml)
 //  * platform/object-serializer/annotations
 //  * platform/structuralsearch/source
 //  * platform/util/concurrency (and ui and util, wich are in the same root)
+//  * platform/built-in-server/client/node-rpc-client/intellij.nodeRpcClient.iml source dirs:0
+//    no <sourceFolder />, but <orderEntry type="sourceFolder" forTests="false" />: the content roots
+//    are the source roots of a `<module type="JAVA_MODULE" ..>`, and a `<module type="WEB_MODULE" ..>` has none
 // It also skips
-//  1. platform/built-in-server/client/node-rpc-client/intellij.nodeRpcClient.iml source dirs:0
-//    a default srcDir for `<module type="JAVA_MODULE" ..>` vs `<module type="WEB_MODULE" ..>?`
-//    coupled with <orderEntry type="sourceFolder" forTests="false" />
-//  2. platform/icons/intellij.platform.icons.iml                             source dirs:2
-//  3. platform/platform-resources/intellij.platform.resources.iml            source dirs:1
-//  4. platform/platform-resources-en/intellij.platform.resources.en.iml      source dirs:1
-//  5. platform/workspaceModel/storage/testEntities/intellij.platform.workspaceModel.storage.testEntities.iml source dirs:2
+//  1. platform/icons/intellij.platform.icons.iml                             source dirs:2
+//  2. platform/platform-resources/intellij.platform.resources.iml            source dirs:1
+//  3. platform/platform-resources-en/intellij.platform.resources.en.iml      source dirs:1
+//  4. platform/workspaceModel/storage/testEntities/intellij.platform.workspaceModel.storage.testEntities.iml source dirs:2
 
 import (
 	"bufio"
+	"encoding/csv"
+	"encoding/json"
 	"encoding/xml"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
-	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/bzz/jet-search/classifier"
 )
 
 const spaceURL = "https://jetbrains.team/p/ij/repositories/community/files/"
 
 var (
-	dirFlag = flag.String("d", "", "dir to scan for packages")
-	mdFlag  = flag.Bool("md", false, "format output as Markdown")
-	gsFlag  = flag.Bool("gs", false, "format output as a Spreadsheet")
-	csvFlag = flag.String("csv", "", "save files in a csv format")
+	dirFlag     = flag.String("d", "", "dir to scan for packages")
+	mdFlag      = flag.Bool("md", false, "format output as Markdown")
+	plainFlag   = flag.Bool("plain", false, "format output as plain text, a block of key: value lines per package, for screen readers and emails")
+	gsFlag      = flag.Bool("gs", false, "format output as a Spreadsheet")
+	gsLinksFlag = flag.String("gs-links", "formula", "link the files in the -gs output by HYPERLINK formulas, or by plain URLs for the locales of other formula syntax: "+strings.Join(gsLinkModes, "|"))
+	colorFlag   = flag.String("color", "auto", "color the doc status and the packages over the limits in the default output: "+strings.Join(colorModes, "|"))
+	csvFlag     = flag.String("csv", "", "save a row per source file with its absolute path, package, module and extension in a CSV file, for the indexers")
+
+	sqliteFlag = flag.String("sqlite", "", "save the modules, source roots, packages and files in a SQLite database, with the sqlite3 tool")
+
+	modulesFlag        = flag.Bool("modules", false, "list modules with their Java language level and Kotlin apiVersion instead of packages")
+	missingDocsFlag    = flag.Bool("missing-docs", false, "print only the packages without package-info.java, the largest first, as the backlog of the docs team")
+	summaryFlag        = flag.Bool("summary", false, "append the totals of the modules, packages, files, documented packages and Kotlin adoption to the output")
+	byModuleFlag       = flag.Bool("by-module", false, "print a row per module with the number of its packages, files and documented packages instead of the packages")
+	jsonFlag           = flag.Bool("json", false, "format output as JSON array of packages, or of modules with -modules")
+	jobsFlag           = flag.Int("j", runtime.NumCPU(), "number of source roots walked and modules parsed in parallel")
+	apiFlag            = flag.String("api", "", "comma-separated API classes of the packages to report, all by default: "+strings.Join(apiClasses, ","))
+	jsonlFlag          = flag.Bool("jsonl", false, "format output as JSON Lines, a package per line")
+	findingsFlag       = flag.Bool("findings", false, "list findings of the built-in and the config rules instead of packages")
+	sarifFlag          = flag.String("sarif", "", "save findings of the built-in and the config rules in a SARIF file")
+	findingsPBFlag     = flag.String("findings-pb", "", "save findings of the built-in and the config rules in a protobuf file, see proto/jet_search.proto")
+	coverageFlag       = flag.String("coverage-metric", "packages", "print doc coverage by number of packages, by public API size or by lines of code: packages|api-weighted|loc-weighted")
+	historyFlag        = flag.String("history", "", "append a summary of the scan to the given history file")
+	namespaceFlag      = flag.String("namespace", "", "product/branch of the scan to label its history record with, i.e idea/241")
+	decisionLogFlag    = flag.String("decision-log", "", "save the decisions that changed what got scanned, i.e. modules skipped or parsed in safe mode, to the given file")
+	oDirFlag           = flag.String("o-dir", "", "write a report per module and an index of them to the given dir instead of printing the packages, in Markdown with -md or CSV")
+	snapshotFlag       = flag.String("snapshot", "", "save the scanned packages in a snapshot file, protobuf if it ends with .pb, to upload to a serve mode namespace")
+	pushFlag           = flag.String("push", "", "upload the snapshot of the scan to the given namespace URL of a server, i.e https://host/api/snapshots/idea/master")
+	icalFlag           = flag.String("ical", "", "save doc review milestones from the config with the current coverage in an iCal file")
+	publishFlag        = flag.Bool("publish", false, "publish the scan and the findings to the sinks from the config")
+	qodanaFlag         = flag.String("qodana", "", "add undocumented packages to qodana.sarif.json in the given Qodana results dir")
+	testFrameworkFlag  = flag.Bool("test-framework", false, "include testFramework modules, that ship test APIs, skipped by default")
+	docCoverageFlag    = flag.Bool("doc-coverage", false, "add columns with the share of the public top-level types with a doc comment and if there is package-info.java")
+	commitFlag         = flag.Bool("commit", false, "print the commit of the scanned dir by git rev-parse HEAD above the packages")
+	shardFlag          = flag.String("shard", "", "scan only the i-th of n shards of the modules, i.e 3/8, to merge the snapshots of the shards later")
+	linkStyleFlag      = flag.String("link-style", "space", "link the files at Space, at the GitHub mirror, or not at all: "+strings.Join(linkStyles, "|"))
+	linkBaseFlag       = flag.String("link-base", "", "URL of the scanned tree to link the files at, instead of the one of -link-style, i.e of a fork")
+	linksFlag          = flag.String("links", "head", "link the files at the head of the default branch, or pinned to the commit of the scanned dir: head|pinned")
+	readmeFlag         = flag.Bool("readme", false, "add columns with the README.md or readme.txt of the package dir and of the module dir")
+	debtMarkersFlag    = flag.Bool("debt-markers", false, "add a column with the number of TODO, FIXME and XXX markers in the package sources")
+	offlineFlag        = flag.Bool("offline", false, "disable all the network access, failing with -push or the sinks of -publish, for the air-gapped environments")
+	locFlag            = flag.Bool("loc", false, "add columns with the code, comment and blank lines of the package sources, summed per module in -o-dir")
+	htmlFlag           = flag.String("html", "", "save a standalone HTML report of the packages, with a sortable and filterable table, in the given file")
+	badgeDirFlag       = flag.String("badge-dir", "", "write a doc coverage badge per module and for all the packages, as shields.io JSON and SVG, to the given dir")
+	treemapFlag        = flag.String("treemap", "", "save the treemap of the package names, with the files, code lines with -loc and doc status, as JSON in the given file")
+	xlsxFlag           = flag.String("xlsx", "", "save the packages in an XLSX workbook, a sheet per top-level dir, in the given file")
+	revFlag            = flag.String("rev", "", "scan the given git revision of the dir, read from git rather than the working tree, i.e for the history")
+	recordFlag         = flag.String("record", "", "record the flags, the config, the commits and every file read by the scan into the given bundle, to -replay it")
+	replayFlag         = flag.String("replay", "", "scan the given bundle of -record instead of the dir, with the recorded flags unless given")
+	contentModulesFlag = flag.Bool("content-modules", false, "add a column with the plugin model of the package module: v2 for content modules, v1 for classic ones")
 )
 
 // TODO(bzz):
 //  * option to output a table in .md format
 // 		* cloumn: mark packages \w existing JavaDoc (road works emoji)
-//      * column: mark modules (or packages?) \w readme
-//      * column: "doc coverage"? quantify presence of documentation
-//  * get the commit sha (git rev-parse ?)
 
 //  * srcDir: does module type="JAVA_MODULE" has any defaults?
 
@@ -56,142 +107,591 @@ type pkg struct {
 	name     string // as in `import ...`
 	doc      string // existing documentation
 	files    []string
-	filesCnt map[string]int // number of .kt and .java files
+	filesCnt map[string]int // number of the sources by extension, see sourceExts
+
+	suppressed map[string]string // rule, or "all" -> suppression kind: inSource or external
+	apiStatus  string            // internal or experimental by the package annotation, see apiClass
+
+	readme       string // README in the package dir, only found by findReadme
+	moduleReadme string // README in the module dir, only found by findModuleReadmes
+
+	lines           int            // in source files, only counted by countSize
+	publicTypes     int            // top-level, only counted by countSize
+	documentedTypes int            // public ones with a doc comment, only counted by countSize
+	debtMarkers     int            // TODO, FIXME and XXX, only counted by countDebtMarkers
+	codeLines       int            // in source files, only counted by countLOC
+	commentLines    int            // in source files, only counted by countLOC
+	blankLines      int            // in source files, only counted by countLOC
+	licenses        map[string]int // license -> number of files with its header, only detected by detectLicense
+	bytes           int64          // of all the files, only summed by sumBytes
+	contentHash     string         // of the sources, only hashed by hashContent
+	docFiles        []string       // candidates of doc, only found by findDoc with the docRules of the config
+}
+
+// pkgJSON is the JSON form of a package, in the API and the snapshots.
+type pkgJSON struct {
+	Module          string            `json:"module"`
+	SrcDir          string            `json:"srcDir"`
+	PkgDir          string            `json:"pkgDir"`
+	Name            string            `json:"name"`
+	Doc             string            `json:"doc,omitempty"`
+	Files           []string          `json:"files"`
+	FilesCnt        map[string]int    `json:"filesCnt"`
+	Suppressed      map[string]string `json:"suppressed,omitempty"`
+	PublicTypes     int               `json:"publicTypes,omitempty"`     // only counted for the snapshots, see isPublic
+	DebtMarkers     int               `json:"debtMarkers,omitempty"`     // only counted with -debt-markers
+	DocumentedTypes int               `json:"documentedTypes,omitempty"` // public types with a doc comment, counted with publicTypes
+	API             string            `json:"api"`                       // see apiClass
+	Readme          string            `json:"readme,omitempty"`          // only found with -readme
+	ModuleReadme    string            `json:"moduleReadme,omitempty"`    // only found with -readme
+	CodeLines       int               `json:"codeLines,omitempty"`       // only counted with -loc
+	CommentLines    int               `json:"commentLines,omitempty"`    // only counted with -loc
+	BlankLines      int               `json:"blankLines,omitempty"`      // only counted with -loc
+	ContentHash     string            `json:"contentHash,omitempty"`     // only hashed for the snapshots
+}
+
+func (p *pkg) MarshalJSON() ([]byte, error) {
+	return json.Marshal(pkgJSON{p.module, p.srcDir, p.pkgDir, p.name, p.doc, p.files, p.filesCnt, p.suppressed, p.publicTypes, p.debtMarkers, p.documentedTypes, p.apiClass(), p.readme, p.moduleReadme, p.codeLines, p.commentLines, p.blankLines, p.contentHash})
+}
+
+func (p *pkg) UnmarshalJSON(blob []byte) error {
+	var j pkgJSON
+	if err := json.Unmarshal(blob, &j); err != nil {
+		return err
+	}
+	if j.FilesCnt == nil {
+		j.FilesCnt = map[string]int{}
+	}
+	*p = pkg{module: j.Module, srcDir: j.SrcDir, pkgDir: j.PkgDir, name: j.Name, doc: j.Doc, files: j.Files, filesCnt: j.FilesCnt, suppressed: j.Suppressed, publicTypes: j.PublicTypes, debtMarkers: j.DebtMarkers, documentedTypes: j.DocumentedTypes, readme: j.Readme, moduleReadme: j.ModuleReadme, codeLines: j.CodeLines, commentLines: j.CommentLines, blankLines: j.BlankLines, contentHash: j.ContentHash}
+	if j.API == "internal" || j.API == "experimental" {
+		p.apiStatus = j.API // not told by the name in the other scans, as apiClass does
+	}
+	return nil
+}
+
+// commands are run by the name given as the first argument, the default being scanning for packages.
+var commands = map[string]func(args []string) error{
+	"check":            runCheck,
+	"daemon":           runDaemon,
+	"search":           runSearch,
+	"explain":          daemonClient("explain"),
+	"ctl":              runCtl,
+	"serve":            runServe,
+	"digest":           runDigest,
+	"jira":             runJira,
+	"diff":             runDiff,
+	"report":           runReport,
+	"index":            runIndex,
+	"convert":          runConvert,
+	"merge":            runMerge,
+	"coordinate":       runCoordinate,
+	"work":             runWork,
+	"graph":            runGraph,
+	"gen-package-info": runGenPackageInfo,
+	"history":          runHistory,
+	"version":          runVersion,
+	"self-update":      runSelfUpdate,
 }
 
 func main() {
-	flag.Parse()
+	if len(os.Args) > 1 && commands[os.Args[1]] != nil {
+		err := commands[os.Args[1]](os.Args[2:])
+		if err == errCheckFailed {
+			os.Exit(1)
+		} else if err != nil {
+			fmt.Fprintln(os.Stderr, redact(err.Error()))
+			os.Exit(2)
+		}
+		return
+	}
+
+	if err := parseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	var fsys fs.FS = osFS{}
+	var rec *bundle
+	if *replayFlag != "" {
+		b, err := readBundle(*replayFlag)
+		if err == nil {
+			err = b.replay()
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		fsys = bundleFS{b}
+		rec = b
+	}
 	if *dirFlag == "" {
 		flag.Usage()
 		return
 	}
+	if err := checkCoverageMetric(*coverageFlag); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := checkLinkMode(*linksFlag); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := checkLinkStyle(*linkStyleFlag); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := checkGsLinkMode(*gsLinksFlag); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := checkColorMode(*colorFlag); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := checkOffline(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if (*recordFlag != "" || *replayFlag != "") && *modulesFlag {
+		fmt.Fprintln(os.Stderr, "-modules is not recorded, drop -record and -replay")
+		os.Exit(2)
+	}
+	if *plainFlag && (*mdFlag || *gsFlag || *jsonFlag || *jsonlFlag) {
+		fmt.Fprintln(os.Stderr, "-plain does not work with -md, -gs, -json and -jsonl")
+		os.Exit(2)
+	}
+	if *revFlag != "" && (*modulesFlag || *recordFlag != "" || *replayFlag != "") {
+		fmt.Fprintln(os.Stderr, "-rev does not work with -modules, -record and -replay")
+		os.Exit(2)
+	}
+	if *namespaceFlag != "" && !validNamespace(*namespaceFlag) {
+		fmt.Fprintf(os.Stderr, "bad namespace %q, want product/branch\n", *namespaceFlag)
+		os.Exit(2)
+	}
 
-	ext := ".iml"
-	modulesPaths, err := findModulesPaths(*dirFlag, ext)
+	if *decisionLogFlag != "" {
+		f, err := os.Create(*decisionLogFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error opening a file %q for writing: %v\n", *decisionLogFlag, err)
+			os.Exit(2)
+		}
+		defer f.Close()
+		decisionLog = f
+	}
+
+	if *modulesFlag {
+		modulesPaths, err := findAllModules(osFS{}, *dirFlag, *testFrameworkFlag)
+		panicIfError(err)
+		mods, err := readModules(*dirFlag, modulesPaths)
+		panicIfError(err)
+		printModules(mods)
+		return
+	}
+
+	if *recordFlag != "" {
+		rec = newBundle()
+		rec.Time = time.Now().UTC()
+		fsys = &recordFS{b: rec}
+	}
+	var rev *gitFS
+	if *revFlag != "" {
+		g, err := newGitFS(*dirFlag, *revFlag)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		rev = g
+		defer rev.Close()
+		fsys = rev
+		scanCommit = rev.commit
+	}
+	modulesPaths, err := findModules(fsys, *dirFlag, *testFrameworkFlag)
 	if err != nil {
-		fmt.Printf("error walking the path %q looking for *%q: %v\n", *dirFlag, ext, err)
+		fmt.Println(err)
 		return
 	}
+	if *shardFlag != "" {
+		i, n, err := parseShard(*shardFlag)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		modulesPaths = shardModules(*dirFlag, modulesPaths, i, n)
+		fmt.Fprintf(os.Stderr, "scanning %d modules of shard %d/%d\n", len(modulesPaths), i, n)
+	}
+	if (*commitFlag || *linksFlag == "pinned") && *replayFlag == "" && *revFlag == "" {
+		if err := resolveCommits(*dirFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "linking the files at the default branch: %v\n", err)
+		}
+		if rec != nil {
+			rec.recordCommits()
+		}
+	}
 
-	srcDirPaths, err := grepXMLForSrcDirPaths(modulesPaths)
+	var contentModules map[string]string
+	if *contentModulesFlag {
+		contentModules, err = findContentModules(fsys, modulesPaths)
+		panicIfError(err)
+	}
+
+	visitors := coverageVisitors(*coverageFlag)
+	if (*snapshotFlag != "" || *pushFlag != "" || *docCoverageFlag) && *coverageFlag != "api-weighted" {
+		visitors = append(visitors, countSize) // for the newly public packages in diffs, and the doc coverage columns
+	}
+	if *snapshotFlag != "" || *pushFlag != "" {
+		visitors = append(visitors, hashContent) // for the changed packages in diffs
+	}
+	if *debtMarkersFlag {
+		visitors = append(visitors, countDebtMarkers)
+	}
+	if *locFlag && *coverageFlag != "loc-weighted" {
+		visitors = append(visitors, countLOC)
+	}
+	if *readmeFlag {
+		visitors = append(visitors, findReadme)
+	}
+	pkgs, err := scanModules(fsys, modulesPaths, visitors...)
 	panicIfError(err)
+	if *readmeFlag {
+		panicIfError(findModuleReadmes(fsys, pkgs))
+	}
+	scanTime := time.Now().UTC()
+	if rec != nil {
+		scanTime = rec.Time
+	} else if rev != nil {
+		scanTime = rev.time.UTC()
+	}
+	if *recordFlag != "" {
+		if err := saveBundle(*recordFlag, rec); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing the bundle to %q: %v\n", *recordFlag, err)
+		}
+	}
 
-	// collect the packages
-	pkgs := map[string]*pkg{}
-	for srcDir, mod := range srcDirPaths {
-		err := filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
-			if err != nil {
-				return err
-			}
-			if d.IsDir() && strings.HasPrefix(d.Name(), ".") {
-				return filepath.SkipDir
-			}
-			if d.IsDir() {
-				return nil
-			}
+	if *historyFlag != "" {
+		hr := &historyRecord{Time: scanTime, Dir: *dirFlag, Namespace: *namespaceFlag, Modules: summarizeModules(pkgs)}
+		if err := appendHistory(*historyFlag, hr); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing history to %q: %v\n", *historyFlag, err)
+		}
+	}
 
-			if strings.HasPrefix(filepath.Base(path), "_") { // templates for some code-gen?
-				// platform/testFramework/src/{_FirstInSuiteTest.java, _LastInSuiteTest.java}
-				return nil
-			}
-			if di, _ := d.Info(); di.Size() == 0 { // skip empty files
-				// platform/testFramework/src/com/intellij/codeInsight/codeVision/CodeVisionTestCase.kt
-				return nil
-			}
+	snap := newSnapshot(*dirFlag, pkgs)
+	snap.time = scanTime
+	snap.publicTypes = true
+	snap.commit = scanCommit
+	if *snapshotFlag != "" {
+		if err := saveSnapshot(*snapshotFlag, snap); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing the snapshot to %q: %v\n", *snapshotFlag, err)
+		}
+	}
 
-			pkgDir := filepath.Dir(path)
-			if existingPkg, ok := pkgs[pkgDir]; ok {
-				if strings.HasSuffix(path, "package-info.java") || strings.HasSuffix(path, "package.html") {
-					existingPkg.doc = path
-				}
-				return nil // skip the rest of the files for a known package
-			}
+	if *pushFlag != "" {
+		token, err := secret("push-token")
+		if err == nil {
+			err = pushSnapshot(*pushFlag, token, snap)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error pushing the snapshot: %s\n", redact(err.Error()))
+			os.Exit(2)
+		}
+	}
 
-			if strings.HasSuffix(path, ".java") || strings.HasSuffix(path, ".kt") {
-				pkgName, err := readPkgNameFromFirstLines(path, 100)
-				if err != nil {
-					return err
-				}
-
-				newPkg := &pkg{module: mod, srcDir: srcDir, pkgDir: pkgDir, name: pkgName}
-				if strings.HasSuffix(path, "package-info.java") || strings.HasSuffix(path, "package.html") {
-					newPkg.doc = path
-				}
-				pkgs[pkgDir] = newPkg
-			}
-			return nil
-		})
-		panicIfError(err)
+	if *icalFlag != "" {
+		if err := saveICal(*icalFlag, cfg.Milestones, pkgs); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing iCal to %q: %v\n", *icalFlag, err)
+		}
 	}
 
-	// collect the files
-	readPkgDirsToCollectFiles(pkgs)
+	var findings []finding
+	if *findingsFlag || *sarifFlag != "" || *findingsPBFlag != "" || *qodanaFlag != "" || *publishFlag {
+		findings, err = collectFindings(pkgs)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	if *sarifFlag != "" {
+		if err := writeSarif(*sarifFlag, findings); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing SARIF to %q: %v\n", *sarifFlag, err)
+		}
+	}
+	if *findingsPBFlag != "" {
+		if err := writeFindingsPB(*findingsPBFlag, findings); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing findings to %q: %v\n", *findingsPBFlag, err)
+		}
+	}
+	if *qodanaFlag != "" {
+		if err := writeQodana(*qodanaFlag, findings); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing Qodana results to %q: %v\n", *qodanaFlag, err)
+		}
+	}
+	if *publishFlag {
+		if err := publish(snap, findings); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+	if *findingsFlag {
+		printFindings(findings)
+		return
+	}
 
-	// print: header
-	fields := []string{"files", ".java", ".kt", "module", "package", "documentation"}
-	if *gsFlag {
-		fmt.Println(strings.Join(fields, "\t"))
+	if *oDirFlag != "" {
+		if err := writeReports(*oDirFlag, pkgs, contentModules); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing reports to %q: %v\n", *oDirFlag, err)
+			os.Exit(2)
+		}
+	} else if *byModuleFlag {
+		printModuleSummaries(os.Stdout, pkgs)
+	} else if *missingDocsFlag {
+		printPackages(os.Stdout, missingDocs(pkgs), contentModules)
+	} else {
+		printPackages(os.Stdout, pkgs, contentModules)
 	}
-	if *mdFlag {
-		fmt.Println(strings.Join(fields, " | "))
-		fmt.Print("--")
-		for i := 0; i < (len(fields) - 1); i++ {
-			fmt.Print("|--")
+
+	documented, total := docCoverage(pkgs, *coverageFlag)
+	fmt.Fprintf(os.Stderr, "doc coverage (%s): %.1f%%, %d of %d\n", *coverageFlag, percent(documented, total), documented, total)
+
+	if *csvFlag != "" {
+		if err := writeFile(*csvFlag, func(w io.Writer) error { return writeFilesIndex(w, pkgs) }); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing files to %q: %v\n", *csvFlag, err)
+		}
+	}
+
+	if *sqliteFlag != "" {
+		mods, err := readModules(*dirFlag, modulesPaths)
+		if err == nil {
+			err = saveSQLite(*sqliteFlag, mods, pkgs)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error writing SQLite database to %q: %v\n", *sqliteFlag, err)
+		}
+	}
+
+	if *xlsxFlag != "" {
+		if err := writeFile(*xlsxFlag, func(w io.Writer) error { return writeWorkbook(w, packagesWorkbook(*dirFlag, pkgs)) }); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing XLSX to %q: %v\n", *xlsxFlag, err)
+		}
+	}
+
+	if *badgeDirFlag != "" {
+		if err := writeBadges(*badgeDirFlag, pkgs); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing badges to %q: %v\n", *badgeDirFlag, err)
+		}
+	}
+
+	if *treemapFlag != "" {
+		if err := writeFile(*treemapFlag, func(w io.Writer) error { return writeTreemap(w, *dirFlag, pkgs) }); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing the treemap to %q: %v\n", *treemapFlag, err)
 		}
-		fmt.Println()
 	}
 
+	if *htmlFlag != "" {
+		if err := writeFile(*htmlFlag, func(w io.Writer) error { return writeHTML(w, *dirFlag, pkgs, contentModules, scanTime) }); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing HTML to %q: %v\n", *htmlFlag, err)
+		}
+	}
+}
+
+// sortedPackages returns the packages sorted by dir, so the output does not depend on the map order.
+func sortedPackages(pkgs map[string]*pkg) []*pkg {
+	list := make([]*pkg, 0, len(pkgs))
+	for _, p := range pkgs {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].pkgDir < list[j].pkgDir })
+	return list
+}
+
+// printPackages prints a package per line in the format selected by the flags,
+// with the plugin model of the package module if -content-modules is set.
+func printPackages(w io.Writer, pkgs map[string]*pkg, contentModules map[string]string) {
+	if *jsonFlag {
+		encodeWithSummary(w, "packages", listedPackages(pkgs), pkgs)
+		return
+	}
+	if *summaryFlag {
+		defer fprintSummary(w, summarize(pkgs))
+	}
+	if *jsonlFlag {
+		enc := json.NewEncoder(w)
+		for _, p := range listedPackages(pkgs) {
+			panicIfError(enc.Encode(p))
+		}
+		return
+	}
+
+	if *mdFlag {
+		printPackagesMarkdown(w, pkgs, contentModules)
+		return
+	}
+	if *plainFlag {
+		printPackagesPlain(w, pkgs, contentModules)
+		return
+	}
+
+	// print: header
+	fprintCommit(w)
+	fprintHeader(w, packageFields())
+
 	// print: body
-	for _, pkg := range pkgs {
-		pkgLink := spaceURL + pkg.pkgDir
+	color := !*gsFlag && colorized(w)
+	for _, pkg := range listedPackages(pkgs) {
+		pkgLink := link(pkg.pkgDir)
 		fmtPkgLink := pkg.pkgDir
 
 		docSign := ""
-		if strings.HasSuffix(pkg.doc, ".html") {
+		if pkg.isLegacyDoc() {
 			docSign = "🚧"
-		} else if strings.HasSuffix(pkg.doc, ".java") {
+		} else if pkg.isDocumented() {
 			docSign = "✅"
 		}
 
+		model := ""
+		if *contentModulesFlag {
+			model = pluginModel(contentModules[pkg.module])
+		}
+
 		if *gsFlag {
-			fmtPkgLink = fmt.Sprintf(`=HYPERLINK("%s","%s")`, pkgLink, pkg.name)
+			fmtPkgLink = hyperlink(pkgLink, pkg.name)
+			if gsPlainLinks() {
+				fmtPkgLink = gsCell(pkg.name)
+			}
 
 			fmtDocLink := ""
 			if docSign != "" {
-				fmtDocLink = fmt.Sprintf(`=HYPERLINK("%s","%s")`, spaceURL+pkg.doc, docSign)
+				fmtDocLink = hyperlink(link(pkg.doc), docSign)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s", len(pkg.files), fmtFilesCnt(pkg), gsCell(pkg.module), fmtPkgLink, fmtDocLink, pkg.apiClass())
+			if gsPlainLinks() {
+				fmt.Fprint(w, "\t"+gsCell(pkgLink))
 			}
-			fmt.Printf("%d\t%d\t%d\t%s\t%s\t%s\n", len(pkg.files), pkg.filesCnt[".java"], pkg.filesCnt[".kt"], pkg.module, fmtPkgLink, fmtDocLink)
-		} else if *mdFlag {
-			fmtPkgLink = fmt.Sprintf("[%s](%s)", pkg.name, pkgLink)
-			fmt.Printf("%-3d | %-3d | %-3d | %-50s | %s\n", len(pkg.files), pkg.filesCnt[".java"], pkg.filesCnt[".kt"], pkg.module, fmtPkgLink)
+		} else if color {
+			files := paint(overColor(len(pkg.files), cfg.PackageLimits.Files), strconv.Itoa(len(pkg.files)))
+			doc := paint(docColor(pkg), strings.TrimSpace(docSign+" "+pkg.doc))
+			if pkg.doc == "" {
+				doc = paint(colorRed, "missing")
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s", files, fmtFilesCnt(pkg), fmtPkgLink, doc, pkg.apiClass())
 		} else {
-			fmt.Printf("%d\t%d\t%d\t%s\t%s\n", len(pkg.files), pkg.filesCnt[".java"], pkg.filesCnt[".kt"], fmtPkgLink, docSign+" "+pkg.doc)
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s", len(pkg.files), fmtFilesCnt(pkg), fmtPkgLink, docSign+" "+pkg.doc, pkg.apiClass())
+		}
+		if *contentModulesFlag {
+			fmt.Fprint(w, "\t"+model)
+		}
+		if *docCoverageFlag {
+			fmt.Fprintf(w, "\t%s\t%s", pkg.typeDocCoverage(), yesNo(pkg.isDocumented()))
 		}
+		if *readmeFlag {
+			fmt.Fprintf(w, "\t%s\t%s", fmtReadme(pkg.readme), fmtReadme(pkg.moduleReadme))
+		}
+		if *debtMarkersFlag {
+			markers := strconv.Itoa(pkg.debtMarkers)
+			if color && pkg.debtMarkers > 0 {
+				markers = paint(colorYellow, markers)
+			}
+			fmt.Fprintf(w, "\t%s", markers)
+		}
+		if *locFlag {
+			code := strconv.Itoa(pkg.codeLines)
+			if color {
+				code = paint(overColor(pkg.codeLines, cfg.PackageLimits.LOC), code)
+			}
+			fmt.Fprintf(w, "\t%s\t%d\t%d", code, pkg.commentLines, pkg.blankLines)
+		}
+		fmt.Fprintln(w)
 
 	}
+}
 
-	if *csvFlag != "" {
-		// f := csv.NewWriter()
-		f, err := os.Create(*csvFlag)
-		if err != nil {
-			fmt.Printf("error opening a file %q for writing: %v\n", *csvFlag, err)
-			return
+// packageFields returns the header of the packages table, with the columns selected by the flags.
+func packageFields() []string {
+	fields := append([]string{"files"}, sourceExts...)
+	fields = append(fields, "module", "package", "documentation", "api")
+	if gsPlainLinks() {
+		fields = append(fields, "link")
+	}
+	if *contentModulesFlag {
+		fields = append(fields, "plugin model")
+	}
+	if *docCoverageFlag {
+		fields = append(fields, "doc coverage", "package-info")
+	}
+	if *readmeFlag {
+		fields = append(fields, "readme", "module readme")
+	}
+	if *debtMarkersFlag {
+		fields = append(fields, "debt markers")
+	}
+	if *locFlag {
+		fields = append(fields, "code", "comments", "blank")
+	}
+	return fields
+}
+
+// fmtFilesCnt formats the number of the sources of each extension, a column each, in the format selected by the flags.
+func fmtFilesCnt(p *pkg) string {
+	cols := make([]string, len(sourceExts))
+	for i, ext := range sourceExts {
+		if *mdFlag {
+			cols[i] = fmt.Sprintf("%-3d", p.filesCnt[ext])
+		} else {
+			cols[i] = strconv.Itoa(p.filesCnt[ext])
 		}
-		defer f.Close()
+	}
+	if *mdFlag {
+		return strings.Join(cols, " | ")
+	}
+	return strings.Join(cols, "\t")
+}
 
-		//  compare the output to `find .`
-		for _, p := range pkgs {
-			relDir := p.pkgDir[len(*dirFlag)+1:]
-			for _, file := range p.files {
-				fmt.Fprintln(f, filepath.Join(relDir, file))
-				// io.Write (f, filepath.Join(p.pkgDir, file))
+// writeFilesIndex writes a CSV row per source file, with the absolute path, package name, module and extension.
+func writeFilesIndex(w io.Writer, pkgs map[string]*pkg) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"path", "package", "module", "ext"})
+	for _, p := range sortedPackages(pkgs) {
+		dir := realPath(p.pkgDir)
+		for _, file := range p.files {
+			if ext := filepath.Ext(file); contains(sourceExts, ext) {
+				cw.Write([]string{filepath.Join(dir, file), p.name, p.module, ext})
 			}
 		}
 	}
+	cw.Flush()
+	return cw.Error()
 }
 
-func readPkgNameFromFirstLines(path string, n int) (string, error) {
-	f, err := os.Open(path)
+// addFormatFlags adds the output format flags of the default command to a subcommand.
+func addFormatFlags(fs *flag.FlagSet) {
+	fs.BoolVar(mdFlag, "md", false, "format output as Markdown")
+	fs.BoolVar(gsFlag, "gs", false, "format output as a Spreadsheet")
+	fs.StringVar(gsLinksFlag, "gs-links", "formula", "link the files in the -gs output by HYPERLINK formulas, or by plain URLs for the locales of other formula syntax: "+strings.Join(gsLinkModes, "|"))
+	fs.IntVar(jobsFlag, "j", runtime.NumCPU(), "number of source roots walked and modules parsed in parallel")
+	fs.StringVar(linkStyleFlag, "link-style", "space", "link the files at Space, at the GitHub mirror, or not at all: "+strings.Join(linkStyles, "|"))
+	fs.StringVar(linkBaseFlag, "link-base", "", "URL of the scanned tree to link the files at, instead of the one of -link-style, i.e of a fork")
+	fs.StringVar(apiFlag, "api", "", "comma-separated API classes of the packages to report, all by default: "+strings.Join(apiClasses, ","))
+	fs.BoolVar(offlineFlag, "offline", false, "disable all the network access")
+	fs.Var(extValue{}, "ext", extUsage)
+}
+
+// printHeader prints table header in the format selected by the flags, if the format has one.
+func printHeader(fields []string) {
+	fprintHeader(os.Stdout, fields)
+}
+
+func fprintHeader(w io.Writer, fields []string) {
+	if *gsFlag {
+		fmt.Fprintln(w, strings.Join(fields, "\t"))
+	}
+	if *mdFlag {
+		fmt.Fprintln(w, strings.Join(fields, " | "))
+		fmt.Fprint(w, "--")
+		for i := 0; i < (len(fields) - 1); i++ {
+			fmt.Fprint(w, "|--")
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+func readPkgNameFromFirstLines(fsys fs.FS, path string, n int) (string, error) {
+	f, err := fsys.Open(path)
 	if err != nil {
 		return "", err
 	}
@@ -199,15 +699,27 @@ func readPkgNameFromFirstLines(path string, n int) (string, error) {
 
 	i := 0
 	pkgName := ""
+	scala := strings.HasSuffix(path, ".scala")
 	scanner := bufio.NewScanner(f)
 	for scanner.Scan() && i < n { // read first 100 lines
-		if strings.HasPrefix(scanner.Text(), "package ") {
-			ss := strings.Fields(scanner.Text())
+		line := scanner.Text()
+		if strings.HasPrefix(line, "package ") {
+			ss := strings.Fields(line)
+			if scala && len(ss) > 2 && ss[1] == "object" { // members of the package, in the package of the clauses above
+				pkgName = joinPkgName(pkgName, strings.TrimSuffix(ss[2], "{"))
+				break
+			}
 			if len(ss) != 2 {
 				fmt.Fprintf(os.Stderr, "fail to get package name for %q\n", path)
 			}
-			pkgName = strings.TrimRight(ss[1], ";")
-			break
+			if !scala {
+				pkgName = strings.TrimRight(ss[1], ";")
+				break
+			}
+			// chained clauses, package a.b then package c is the package a.b.c
+			pkgName = joinPkgName(pkgName, strings.TrimRight(ss[1], ";"))
+		} else if trimmed := strings.TrimSpace(line); pkgName != "" && trimmed != "" && !isCommentLine(trimmed) {
+			break // past the package clauses
 		}
 		i++
 	}
@@ -219,87 +731,200 @@ func readPkgNameFromFirstLines(path string, n int) (string, error) {
 	return pkgName, nil
 }
 
-// readPkgDirsToCollectFiles updates .files & .fileCnt for each package in a map by reading .pkgDir from FS once.
-func readPkgDirsToCollectFiles(pkgs map[string]*pkg) {
-	for pkgDir, pkg := range pkgs {
-		files, err := os.ReadDir(pkgDir)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed to travers fs for package %q, %+v", pkgDir, err)
-			continue
-		}
+func joinPkgName(outer, name string) string {
+	if outer == "" {
+		return name
+	}
+	return outer + "." + name
+}
 
-		filesCnt := map[string]int{}
-		for _, f := range files {
-			fName := f.Name()
-			if !f.IsDir() && (strings.HasSuffix(fName, ".java") || strings.HasSuffix(fName, ".kt")) {
-				pkg.files = append(pkg.files, fName)
-				filesCnt[filepath.Ext(fName)] = filesCnt[filepath.Ext(fName)] + 1
-			}
-		}
-		pkg.filesCnt = filesCnt
-		// fmt.Printf("%d\t%d\t%d\t%s\n", len(pkg.files), filesCnt[".java"], filesCnt[".kt"], pkgDir)
+func isCommentLine(line string) bool {
+	return strings.HasPrefix(line, "//") || strings.HasPrefix(line, "/*") || strings.HasPrefix(line, "*")
+}
+
+// findModules returns paths to all .iml modules in the dir to scan, skipping the ones of skipped classes
+// and testFramework ones unless asked not to.
+func findModules(fsys fs.FS, dir string, testFramework bool) ([]string, error) {
+	modulesPaths, err := findAllModules(fsys, dir, testFramework)
+	if err != nil {
+		return nil, err
 	}
+	return skipClassifiedModules(fsys, modulesPaths)
 }
 
-// grepXMLForSrcDirPaths return map of source Dir root -> .iml module
-func grepXMLForSrcDirPaths(modulesPaths []string) (map[string]string, error) {
-	srcDirs := make(map[string]string, len(modulesPaths))
-	for _, mp := range modulesPaths { // parse XMLs
-		module, err := newModuleFromXMLFile(mp)
-		if err != nil {
+// findAllModules is findModules that keeps the modules of skipped classes, i.e to list them.
+func findAllModules(fsys fs.FS, dir string, testFramework bool) ([]string, error) {
+	ext := ".iml"
+	modulesPaths, err := findModulesPaths(fsys, dir, ext)
+	if err != nil {
+		return nil, fmt.Errorf("error walking the path %q looking for *%q: %v", dir, ext, err)
+	}
+	if !testFramework {
+		modulesPaths = skipTestFrameworkModules(modulesPaths)
+	}
+	return modulesPaths, nil
+}
+
+// scanDir finds the modules in the dir and scans their source roots for packages.
+func scanDir(dir string, testFramework bool, extra ...visitor) (map[string]*pkg, error) {
+	return scanFS(osFS{}, dir, testFramework, extra...)
+}
+
+// scanFS is scanDir of a dir in the given filesystem, i.e of a fixture.
+func scanFS(fsys fs.FS, dir string, testFramework bool, extra ...visitor) (map[string]*pkg, error) {
+	modulesPaths, err := findModules(fsys, dir, testFramework)
+	if err != nil {
+		return nil, err
+	}
+	return scanModules(fsys, modulesPaths, extra...)
+}
+
+// scanModules collects the packages and their files from the source roots of the given modules in a single pass,
+// running the extra analyses along with the default ones.
+func scanModules(fsys fs.FS, modulesPaths []string, extra ...visitor) (map[string]*pkg, error) {
+	srcDirPaths, err := grepXMLForSrcDirPaths(fsys, modulesPaths)
+	if err != nil {
+		return nil, err
+	}
+	return scanSrcDirs(fsys, srcDirPaths, extra...)
+}
+
+// scanSrcDirs is scanModules of the given source roots, i.e of the changed ones only.
+func scanSrcDirs(fsys fs.FS, srcDirPaths map[string]string, extra ...visitor) (map[string]*pkg, error) {
+	visitors := append([]visitor{countFiles, findDoc, findSuppressions, findAPIStatus}, extra...)
+	pkgs, err := scanPackages(fsys, srcDirPaths, visitors...)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.DocRules) > 0 {
+		if err := applyDocRules(fsys, pkgs, cfg.DocRules); err != nil {
 			return nil, err
 		}
+	}
+	return filterAPI(pkgs)
+}
 
-		//// .srcDirURL() errors on n == 0
-		// n := module.srcDirCount()
-		// if n == 0 { // 145 -> 140
-		// 	continue
-		// }
+// grepXMLForSrcDirPaths return map of source Dir root -> .iml module, for every source root of the modules
+func grepXMLForSrcDirPaths(fsys fs.FS, modulesPaths []string) (map[string]string, error) {
+	type parsed struct {
+		module   *module
+		safeMode string // why the module was parsed in safe mode, if it was
+	}
+	modules := make([]parsed, len(modulesPaths))
+	err := inParallel(len(modulesPaths), func(i int) error { // parse XMLs
+		m, safeMode, err := parseModuleXMLFile(fsys, modulesPaths[i])
+		modules[i] = parsed{m, safeMode}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	srcDirs := make(map[string]string, len(modulesPaths))
+	for i, mp := range modulesPaths {
+		module := modules[i].module
+		if modules[i].safeMode != "" {
+			logDecision(mp, "parsed in safe mode, only the source folders are read: %s", modules[i].safeMode)
+		}
 
-		srcDirURL, err := module.srcDirURL()
+		srcDirURLs, err := module.srcDirURLs()
 		if err != nil {
-			// fmt.Fprintf(os.Stderr, "%s has no source dir", mp)
+			logDecision(mp, "skipped: %v", err)
 			continue
 		}
-		srcDir := filepath.Join(filepath.Dir(mp), filepath.Base(srcDirURL))
-		srcDirs[srcDir] = mp
-		// fmt.Printf("%-76s  <sourceFolder/>:%+v, actual:%d, %s\n", mp, len(module.Component.SourceFolders), n, srcDir)
+		if len(module.rootManager().sourceFolders()) == 0 {
+			logDecision(mp, "content roots are the source roots: no <sourceFolder />, but <orderEntry type=\"sourceFolder\" forTests=\"false\" />")
+		}
+		for _, url := range srcDirURLs {
+			srcDir, ok := moduleURLPath(mp, url)
+			if !ok {
+				logDecision(mp, "source root %q skipped: not under $MODULE_DIR$", url)
+				continue
+			}
+			if other, ok := srcDirs[srcDir]; ok && other != mp {
+				logDecision(mp, "source root %q skipped: already a source root of %s", url, other)
+				continue
+			}
+			srcDirs[srcDir] = mp
+		}
 	}
 	return srcDirs, nil
 }
 
-// newModuleFromXMLFile reads given XML file and parses it as a module struct.
-func newModuleFromXMLFile(path string) (*module, error) {
-	blob, err := os.ReadFile(path)
+// moduleURLPath returns the path of a file://$MODULE_DIR$/... URL of the module.
+func moduleURLPath(modulePath, url string) (string, bool) {
+	const prefix = "file://$MODULE_DIR$"
+	if !strings.HasPrefix(url, prefix) {
+		return "", false
+	}
+	return filepath.Join(filepath.Dir(modulePath), filepath.FromSlash(strings.TrimPrefix(url, prefix))), true
+}
+
+// newModuleFromXMLFile reads given XML file and parses it as a module struct,
+// falling back to the safe mode for a broken XML.
+func newModuleFromXMLFile(fsys fs.FS, path string) (*module, error) {
+	m, safeMode, err := parseModuleXMLFile(fsys, path)
+	if safeMode != "" {
+		logDecision(path, "parsed in safe mode, only the source folders are read: %s", safeMode)
+	}
+	return m, err
+}
+
+// parseModuleXMLFile is newModuleFromXMLFile that returns the reason of the safe mode instead of logging it,
+// to be called in parallel.
+func parseModuleXMLFile(fsys fs.FS, path string) (*module, string, error) {
+	blob, err := fs.ReadFile(fsys, path)
 	if err != nil {
-		return nil, fmt.Errorf("error reading %q: %v\n", path, err)
+		return nil, "", fmt.Errorf("error reading %q: %v\n", path, err)
 	}
 
 	var m module
 	if err := xml.Unmarshal(blob, &m); err != nil {
-		return nil, fmt.Errorf("error parsing XML %q: %v\n", path, err)
+		safe := parseModuleSafeMode(blob)
+		if safe == nil {
+			return nil, "", fmt.Errorf("error parsing XML %q: %v\n", path, err)
+		}
+		return safe, err.Error(), nil
 	}
-	return &m, nil
+	return &m, "", nil
 }
 
-// findModulesPaths traverses filesystem from the rootDir, skipping test directories,
-// returning all files with the given extension.
-func findModulesPaths(rootDir, fileExt string) ([]string, error) {
-	skipDirs := map[string]bool{
-		"test": true, "tests": true, "testData": true, "testSources": true, "testSource": true, "testSrc": true, "testResources": true,
-		"gen": true, "generated": true,
-		"resources":     true,
-		"build-scripts": true, // TODO(bzz): confirm, filters 5 modules
+// testDataDirs hold test fixtures, that are never scanned, even inside source roots.
+// Unlike them, testFramework sources ship test APIs that are worth documenting.
+var testDataDirs = map[string]bool{"testData": true, "testResources": true}
+
+// moduleSkipDirs are the dirs not looked into for modules, see findModulesPaths.
+var moduleSkipDirs = map[string]bool{
+	"test": true, "tests": true, "testSources": true, "testSource": true, "testSrc": true,
+	"gen": true, "generated": true,
+	"resources":     true,
+	"build-scripts": true, // TODO(bzz): confirm, filters 5 modules
+}
+
+// skipTestFrameworkModules returns the given modules except the testFramework ones.
+func skipTestFrameworkModules(modulesPaths []string) []string {
+	var mods []string
+	for _, mp := range modulesPaths {
+		if !classifier.IsTestFramework(mp) {
+			mods = append(mods, mp)
+		}
 	}
-	testModules := regexp.MustCompile(fmt.Sprintf("[tT]ests%s$", fileExt))
+	return mods
+}
 
+// findModulesPaths traverses filesystem from the rootDir, skipping test directories,
+// returning all files with the given extension. Test modules are told by their class, see classify.go.
+func findModulesPaths(fsys fs.FS, rootDir, fileExt string) ([]string, error) {
 	var modules []string
-	err := filepath.WalkDir(rootDir, func(path string, d fs.DirEntry, err error) error {
-		if d.IsDir() && (strings.HasPrefix(d.Name(), ".") || skipDirs[d.Name()]) {
-			return filepath.SkipDir
+	err := fs.WalkDir(fsys, rootDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && (strings.HasPrefix(d.Name(), ".") || moduleSkipDirs[d.Name()] || testDataDirs[d.Name()]) {
+			return fs.SkipDir
 		}
 
-		if strings.HasSuffix(d.Name(), fileExt) && !testModules.MatchString(d.Name()) {
+		if strings.HasSuffix(d.Name(), fileExt) {
 			modules = append(modules, path)
 		}
 		return nil
@@ -309,18 +934,74 @@ func findModulesPaths(rootDir, fileExt string) ([]string, error) {
 
 // .iml XML schema
 type module struct {
-	XMLName   xml.Name `xml:"module"`
-	Component struct { // can this be removed? not really, as we need specificaly the one with `name="NewModuleRootManager"`
-		// see ./platform/remoteDev-util/intellij.remoteDev.util.iml for multiple ones + type="GENERAL_MODULE"
-		XMLName       xml.Name `xml:"component"`
-		Name          string   `xml:"name,attr,omitempty"` // TODO(bzz): convert to slice and pick only NewModuleRootManager
-		SourceFolders []srcDir `xml:"content>sourceFolder"`
-	} `xml:"component"`
+	XMLName    xml.Name    `xml:"module"`
+	Type       string      `xml:"type,attr,omitempty"` // JAVA_MODULE, WEB_MODULE, etc
+	Components []component `xml:"component"`
+	// see ./platform/remoteDev-util/intellij.remoteDev.util.iml for multiple ones + type="GENERAL_MODULE"
+}
+
+type component struct {
+	XMLName       xml.Name      `xml:"component"`
+	Name          string        `xml:"name,attr,omitempty"`
+	LanguageLevel string        `xml:"LANGUAGE_LEVEL,attr,omitempty"` // only on NewModuleRootManager, e.g. JDK_17
+	ContentRoots  []contentRoot `xml:"content"`
+	Facets        []facet       `xml:"facet"`      // only on FacetManager
+	OrderEntries  []orderEntry  `xml:"orderEntry"` // only on NewModuleRootManager
+}
+
+// contentRoot is a dir of the module, i.e <content url="file://$MODULE_DIR$">, with the source folders in it.
+type contentRoot struct {
+	Url           string   `xml:"url,attr"`
+	SourceFolders []srcDir `xml:"sourceFolder"`
+}
+
+// orderEntry is a dependency, i.e <orderEntry type="module" module-name="intellij.platform.util" scope="TEST" />,
+// or the sources of the module itself, <orderEntry type="sourceFolder" forTests="false" />
+type orderEntry struct {
+	Type       string `xml:"type,attr"`
+	ModuleName string `xml:"module-name,attr,omitempty"`
+	Scope      string `xml:"scope,attr,omitempty"`    // COMPILE by default, TEST, RUNTIME or PROVIDED
+	ForTests   string `xml:"forTests,attr,omitempty"` // only on the sourceFolder one
+}
+
+// sourceFolders returns the source folders of all the content roots.
+func (c *component) sourceFolders() []srcDir {
+	var folders []srcDir
+	for _, root := range c.ContentRoots {
+		folders = append(folders, root.SourceFolders...)
+	}
+	return folders
+}
+
+// rootManager returns the `name="NewModuleRootManager"` component, that has the source folders.
+func (m *module) rootManager() *component {
+	return m.component("NewModuleRootManager")
+}
+
+// component returns a component by name or an empty one, if the module has none.
+func (m *module) component(name string) *component {
+	for i := range m.Components {
+		if m.Components[i].Name == name {
+			return &m.Components[i]
+		}
+	}
+	return &component{Name: name}
+}
+
+// moduleDeps returns the dependencies on the other modules, in the order of the .iml.
+func (m *module) moduleDeps() []orderEntry {
+	var deps []orderEntry
+	for _, e := range m.rootManager().OrderEntries {
+		if e.Type == "module" && e.ModuleName != "" {
+			deps = append(deps, e)
+		}
+	}
+	return deps
 }
 
 func (m *module) srcDirCount() int {
 	n := 0
-	for _, d := range m.Component.SourceFolders {
+	for _, d := range m.rootManager().sourceFolders() {
 		if !d.Generated && !d.IsTest && !d.isResource() { // 150 -> 145
 			n++
 		}
@@ -328,13 +1009,54 @@ func (m *module) srcDirCount() int {
 	return n
 }
 
-func (m *module) srcDirURL() (string, error) {
-	for _, d := range m.Component.SourceFolders {
+// srcDirURLs returns the URLs of the source folders, except test, generated and resource ones,
+// or of the content roots of a module without any source folders, see contentRootsAsSrcDirs.
+func (m *module) srcDirURLs() ([]string, error) {
+	rm := m.rootManager()
+	folders := rm.sourceFolders()
+	if len(folders) == 0 && m.ownProductionSources() {
+		return m.contentRootsAsSrcDirs()
+	}
+	var urls []string
+	for _, d := range folders {
 		if !d.Generated && !d.IsTest && !d.isResource() {
-			return d.Url, nil
+			urls = append(urls, d.Url)
 		}
 	}
-	return "", errors.New("no <sourceFolder /> that is not test or resource")
+	if len(urls) == 0 {
+		return nil, errors.New("no <sourceFolder /> that is not test or resource")
+	}
+	return urls, nil
+}
+
+// ownProductionSources checks if the module has its own production sources in the classpath,
+// by <orderEntry type="sourceFolder" forTests="false" />
+func (m *module) ownProductionSources() bool {
+	for _, e := range m.rootManager().OrderEntries {
+		if e.Type == "sourceFolder" && e.ForTests != "true" {
+			return true
+		}
+	}
+	return false
+}
+
+// contentRootsAsSrcDirs returns the content roots of a module with its own production sources, but without
+// any source folders, as its source roots: the sources of a JAVA_MODULE lie right in them, i.e
+// platform/built-in-server/client/node-rpc-client, while a WEB_MODULE or any other has no JVM sources.
+func (m *module) contentRootsAsSrcDirs() ([]string, error) {
+	if m.Type != "" && m.Type != "JAVA_MODULE" {
+		return nil, fmt.Errorf("no <sourceFolder />, and a %s has no JVM sources in its content roots", m.Type)
+	}
+	var urls []string
+	for _, root := range m.rootManager().ContentRoots {
+		if root.Url != "" {
+			urls = append(urls, root.Url)
+		}
+	}
+	if len(urls) == 0 {
+		return nil, errors.New("no <sourceFolder /> nor <content /> root")
+	}
+	return urls, nil
 }
 
 type srcDir struct {
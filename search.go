@@ -0,0 +1,143 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Search of the packages by name, in the daemon or in a persisted scan, i.e a -snapshot file or an index:
+//  go run . search concurrency
+//  go run . search -snapshot platform.json uConc
+// Packages are ranked by how the query matches the name: exact, prefix, substring and then by camel humps,
+// i.e uConc matches com.intellij.util.concurrency, each hump of the query being a prefix of a word of the name.
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// packageMatch is how a query matches a package name, the better the lower.
+type packageMatch int
+
+const (
+	noMatch packageMatch = iota
+	exactMatch
+	prefixMatch
+	substringMatch
+	camelHumpMatch
+)
+
+// matchPackage returns how the query matches the package name.
+func matchPackage(name, query string) packageMatch {
+	switch {
+	case query == "":
+		return noMatch
+	case name == query:
+		return exactMatch
+	case strings.HasPrefix(name, query):
+		return prefixMatch
+	case strings.Contains(name, query):
+		return substringMatch
+	case matchCamelHumps(splitWords(name), splitHumps(query)):
+		return camelHumpMatch
+	}
+	return noMatch
+}
+
+// splitWords splits a package name by the dots and the camel case, i.e com.intellij.openapi.vfs.newvfs, or ui.jcef.JBCefApp
+func splitWords(name string) []string {
+	var words []string
+	for _, segment := range strings.Split(name, ".") {
+		words = append(words, splitHumps(segment)...)
+	}
+	return words
+}
+
+// splitHumps splits before every upper case letter, and by the dots.
+func splitHumps(s string) []string {
+	var humps []string
+	start := 0
+	for i, r := range s {
+		if r == '.' || (unicode.IsUpper(r) && i > start) {
+			if i > start {
+				humps = append(humps, s[start:i])
+			}
+			start = i
+			if r == '.' {
+				start++
+			}
+		}
+	}
+	if start < len(s) {
+		humps = append(humps, s[start:])
+	}
+	return humps
+}
+
+// matchCamelHumps checks if every hump is a case-insensitive prefix of a word, in order.
+func matchCamelHumps(words, humps []string) bool {
+	if len(humps) == 0 {
+		return false
+	}
+	i := 0
+	for _, w := range words {
+		if i < len(humps) && strings.HasPrefix(strings.ToLower(w), strings.ToLower(humps[i])) {
+			i++
+		}
+	}
+	return i == len(humps)
+}
+
+// searchPackages returns the packages matching the query, the best matches first.
+func searchPackages(pkgs map[string]*pkg, query string) []*pkg {
+	var found []*pkg
+	matches := map[*pkg]packageMatch{}
+	for _, p := range pkgs {
+		if m := matchPackage(p.name, query); m != noMatch {
+			found = append(found, p)
+			matches[p] = m
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if matches[found[i]] != matches[found[j]] {
+			return matches[found[i]] < matches[found[j]]
+		}
+		if found[i].name != found[j].name {
+			return found[i].name < found[j].name
+		}
+		return found[i].pkgDir < found[j].pkgDir
+	})
+	return found
+}
+
+// printSearch prints the found packages with their dir, module and link.
+func printSearch(w io.Writer, found []*pkg) {
+	for _, p := range found {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.name, p.pkgDir, p.module, link(p.pkgDir))
+	}
+}
+
+// runSearch searches the packages of a persisted scan with -snapshot, or of the daemon.
+func runSearch(args []string) error {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	socket := fs.String("socket", defaultSocket, "unix socket of the daemon")
+	snapshotPath := fs.String("snapshot", "", "snapshot file or URL, or index file, of a persisted scan to search instead of the daemon, see -snapshot and index")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: search [-snapshot file|URL] [-socket path] <query>")
+	}
+	if *snapshotPath == "" {
+		return callDaemon(*socket, os.Stdout, []string{"search", fs.Arg(0)})
+	}
+
+	s, err := loadSnapshot(*snapshotPath)
+	if err != nil {
+		return err
+	}
+	printSearch(os.Stdout, searchPackages(s.pkgs, fs.Arg(0)))
+	return nil
+}
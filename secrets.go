@@ -0,0 +1,133 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Secrets of the integrations are never flags, that end up in the shell history, the process list and the CI logs.
+// A secret, i.e jira-token, comes from, in order of precedence:
+//  1. an environment variable            JET_SEARCH_JIRA_TOKEN=...
+//  2. a file named by one                JET_SEARCH_JIRA_TOKEN_FILE=/run/secrets/jira-token
+//  3. a Vault KV v2 secret of the config {"vault": {"addr": "https://vault:8200", "path": "secret/data/jet-search"}}
+// The Vault secret has a key per secret name, read with the token of VAULT_TOKEN, or of the file of VAULT_TOKEN_FILE,
+// and the address defaults to VAULT_ADDR. The values of the secrets are redacted from the printed errors.
+// The secrets are push-token, upload-token, coordinator-token, redis-password, smtp-password and <sink>-password
+// or <sink>-token of the sinks.
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// vaultConfig is the Vault KV v2 secret with the secrets of the integrations.
+type vaultConfig struct {
+	Addr string `json:"addr"` // VAULT_ADDR by default
+	Path string `json:"path"` // i.e secret/data/jet-search
+}
+
+var secrets struct {
+	mu       sync.Mutex
+	resolved []string          // values, to redact
+	vault    map[string]string // read once, nil until then
+}
+
+// secret returns the value of the secret, or "" if it is not set anywhere.
+func secret(name string) (string, error) {
+	value, err := lookupSecret(name)
+	if err != nil || value == "" {
+		return "", err
+	}
+	secrets.mu.Lock()
+	defer secrets.mu.Unlock()
+	if !contains(secrets.resolved, value) {
+		secrets.resolved = append(secrets.resolved, value)
+	}
+	return value, nil
+}
+
+func lookupSecret(name string) (string, error) {
+	if value, ok := os.LookupEnv(envVar(name)); ok {
+		return value, nil
+	}
+	if path, ok := os.LookupEnv(envVar(name) + "_FILE"); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("error reading secret %s: %v", name, err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	if cfg.Vault.Path == "" {
+		return "", nil
+	}
+	secrets.mu.Lock()
+	defer secrets.mu.Unlock()
+	if secrets.vault == nil {
+		values, err := readVault(cfg.Vault)
+		if err != nil {
+			return "", fmt.Errorf("error reading secret %s from Vault: %v", name, err)
+		}
+		secrets.vault = values
+	}
+	return secrets.vault[name], nil
+}
+
+// readVault reads the string values of the Vault KV v2 secret.
+func readVault(c vaultConfig) (map[string]string, error) {
+	addr := c.Addr
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if path := os.Getenv("VAULT_TOKEN_FILE"); token == "" && path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if addr == "" || token == "" {
+		return nil, fmt.Errorf("the Vault address and VAULT_TOKEN are required")
+	}
+
+	url := strings.TrimSuffix(addr, "/") + "/v1/" + strings.TrimPrefix(c.Path, "/")
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	var secret struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	for k, v := range secret.Data.Data {
+		if s, ok := v.(string); ok {
+			values[k] = s
+		}
+	}
+	return values, nil
+}
+
+// redact replaces the values of the resolved secrets in the message.
+func redact(msg string) string {
+	secrets.mu.Lock()
+	defer secrets.mu.Unlock()
+	for _, value := range secrets.resolved {
+		msg = strings.ReplaceAll(msg, value, "[redacted]")
+	}
+	return msg
+}
@@ -0,0 +1,281 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Serve mode exposes the scanned packages over HTTP:
+//  go run . serve -d ./platform -addr :8080
+//  GET /api/packages  the packages, as JSON, filtered and paginated by the query, see packageQuery
+//  GET /healthz       liveness, the server is up
+//  GET /readyz        readiness, the packages are scanned and the scan is not older than -max-scan-age
+//  GET /feed.atom     packages that became undocumented since the previous scan
+//  GET /calendar.ics  doc review milestones from the config
+//  GET /ws            WebSocket of scan progress and changed packages, see events.go
+//  /api/snapshots/    snapshots of other products and branches, see namespaces.go, shared by the replicas with -redis
+//  GET /api/diff      packages documented on one branch but not the other, see diff.go
+//  /graphql           GraphQL queries of the packages, modules and history, see graphql.go
+//  GET /treemap       treemap of the packages by the doc status, of GET /api/treemap, see treemap.go
+// The first scan runs in background, so the probes answer right away.
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	dir := fs.String("d", "", "dir to scan for packages")
+	addr := fs.String("addr", ":8080", "address to listen on")
+	socket := fs.String("socket", "", "unix socket for control commands, none by default")
+	testFramework := fs.Bool("test-framework", false, "include testFramework modules")
+	maxScanAge := fs.Duration("max-scan-age", 0, "report not ready if the last scan is older, no limit by default")
+	rescanEvery := fs.Duration("rescan-every", 0, "scan periodically, only once by default")
+	namespace := fs.String("namespace", "", "product/branch namespace of the scanned dir, among the uploaded snapshots")
+	snapshotsDir := fs.String("snapshots-dir", "", "dir to keep the uploaded snapshots in, only in memory by default")
+	historyPath := fs.String("history", "", "history file, written by -history, to query over GraphQL")
+	redisURL := fs.String("redis", "", "redis://host[:port][/db] to share the snapshots and cache the queries in with the other replicas, none by default")
+	redisTTL := fs.Duration("redis-ttl", time.Minute, "time to cache the query results in Redis for")
+	wsOrigins := fs.String("ws-origins", "", "comma-separated origins of the web UIs of the other hosts to allow the events WebSocket to, i.e https://dashboard.example.com")
+	insecureUploads := fs.Bool("insecure-uploads", false, "accept the uploads of snapshots without the upload-token secret, from anyone")
+	fs.BoolVar(offlineFlag, "offline", false, "disable all the network access, serving locally without -redis")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		fs.Usage()
+		return nil
+	}
+	if *offlineFlag && *redisURL != "" {
+		return fmt.Errorf("-redis needs network, disabled by -offline")
+	}
+	if *redisTTL < time.Millisecond { // PX 0 is an error
+		return fmt.Errorf("-redis-ttl %v is below the 1ms resolution of Redis", *redisTTL)
+	}
+
+	d := newDaemon(*dir, *testFramework)
+	if *wsOrigins != "" {
+		for _, o := range strings.Split(*wsOrigins, ",") {
+			d.origins = append(d.origins, strings.TrimSpace(o))
+		}
+	}
+	ns, err := newNamespaces(*snapshotsDir, *namespace, d)
+	if err != nil {
+		return err
+	}
+	if ns.token, err = secret("upload-token"); err != nil {
+		return err
+	}
+	ns.insecure, ns.historyPath = *insecureUploads, *historyPath
+	if *redisURL != "" {
+		rc, err := newRedisClient(*redisURL)
+		if err != nil {
+			return err
+		}
+		ns.cache = &redisCache{rc, *redisTTL}
+	}
+	go func() {
+		for {
+			if err := d.rescan(); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to scan %q: %v\n", *dir, err)
+			}
+			if *rescanEvery == 0 {
+				return
+			}
+			time.Sleep(*rescanEvery)
+		}
+	}()
+	if *socket != "" {
+		go func() {
+			fmt.Fprintf(os.Stderr, "control socket failed: %v\n", d.listen(*socket))
+		}()
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/packages", d.handlePackages)
+	mux.HandleFunc("/api/snapshots", ns.handleSnapshots)
+	mux.HandleFunc("/api/snapshots/", ns.handleSnapshots)
+	mux.HandleFunc("/api/diff", ns.handleDiff)
+	mux.HandleFunc("/api/treemap", d.handleTreemap)
+	mux.HandleFunc("/treemap", handleTreemapPage)
+	mux.HandleFunc("/feed.atom", d.handleFeed)
+	mux.HandleFunc("/calendar.ics", d.handleICal)
+	mux.HandleFunc("/ws", d.handleEvents)
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, r *http.Request) {
+		d.handleGraphQL(w, r, *historyPath)
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		d.handleReady(w, r, *maxScanAge)
+	})
+	fmt.Fprintf(os.Stderr, "serving %q on %s\n", *dir, *addr)
+	return http.ListenAndServe(*addr, mux)
+}
+
+// packageQuery selects a page of the packages, i.e
+//
+//	/api/packages?doc=missing&module=platform/core*/**&api=public,experimental&minFiles=10&sort=-files&limit=50&offset=100
+type packageQuery struct {
+	doc      string          // missing, legacy or present (package-info.java), any by default
+	api      map[string]bool // API classes, see apiClass, any by default
+	module   *regexp.Regexp  // glob over the .iml path
+	minFiles int
+	sort     string // dir, name or files, descending with a - prefix, dir by default
+	limit    int    // all by default
+	offset   int
+}
+
+func parsePackageQuery(values url.Values) (*packageQuery, error) {
+	q := &packageQuery{doc: values.Get("doc"), sort: values.Get("sort")}
+	switch q.doc {
+	case "", "missing", "legacy", "present":
+	default:
+		return nil, fmt.Errorf("bad doc %q, want missing, legacy or present", q.doc)
+	}
+	switch strings.TrimPrefix(q.sort, "-") {
+	case "", "dir", "name", "files":
+	default:
+		return nil, fmt.Errorf("bad sort %q, want dir, name or files", q.sort)
+	}
+	api, err := parseAPIClasses(values.Get("api"))
+	if err != nil {
+		return nil, err
+	}
+	q.api = api
+	if m := values.Get("module"); m != "" {
+		re, err := globToRegexp(m)
+		if err != nil {
+			return nil, fmt.Errorf("bad module %q: %v", m, err)
+		}
+		q.module = re
+	}
+	for name, v := range map[string]*int{"minFiles": &q.minFiles, "limit": &q.limit, "offset": &q.offset} {
+		if s := values.Get(name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("bad %s %q, want a non-negative number", name, s)
+			}
+			*v = n
+		}
+	}
+	return q, nil
+}
+
+func (q *packageQuery) matches(p *pkg) bool {
+	switch {
+	case q.doc == "missing" && p.doc != "",
+		q.doc == "legacy" && !p.isLegacyDoc(),
+		q.doc == "present" && !p.isDocumented():
+		return false
+	}
+	return len(p.files) >= q.minFiles && (q.module == nil || q.module.MatchString(p.module)) && (q.api == nil || q.api[p.apiClass()])
+}
+
+// apply returns the page of the matching packages and the number of all of them.
+func (q *packageQuery) apply(pkgs map[string]*pkg) ([]*pkg, int) {
+	var list []*pkg
+	for _, p := range sortedPackages(pkgs) {
+		if q.matches(p) {
+			list = append(list, p)
+		}
+	}
+	desc := strings.HasPrefix(q.sort, "-")
+	switch strings.TrimPrefix(q.sort, "-") {
+	case "name":
+		sort.SliceStable(list, func(i, j int) bool { return list[i].name < list[j].name != desc })
+	case "files":
+		sort.SliceStable(list, func(i, j int) bool {
+			if len(list[i].files) == len(list[j].files) {
+				return false
+			}
+			return len(list[i].files) < len(list[j].files) != desc
+		})
+	default:
+		if desc {
+			sort.SliceStable(list, func(i, j int) bool { return list[i].pkgDir > list[j].pkgDir })
+		}
+	}
+
+	total := len(list)
+	if q.offset > total {
+		q.offset = total
+	}
+	list = list[q.offset:]
+	if q.limit > 0 && q.limit < len(list) {
+		list = list[:q.limit]
+	}
+	return list, total
+}
+
+// handlePackages responds with a page of the packages matching the query, once they are scanned.
+// The number of all the matching ones is in the X-Total-Count header.
+func (d *daemon) handlePackages(w http.ResponseWriter, r *http.Request) {
+	d.mu.RLock()
+	pkgs := d.pkgs
+	d.mu.RUnlock()
+	if pkgs == nil {
+		http.Error(w, "not scanned yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	q, err := parsePackageQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, total := q.apply(pkgs)
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if page == nil {
+		page = []*pkg{}
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// handleReady reports the state of the scan, failing until the packages are scanned or if the scan is too old.
+func (d *daemon) handleReady(w http.ResponseWriter, r *http.Request, maxScanAge time.Duration) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	status := struct {
+		Ready    bool   `json:"ready"`
+		Loaded   bool   `json:"snapshotLoaded"`
+		Packages int    `json:"packages"`
+		Scanned  string `json:"scanned,omitempty"`
+		ScanAge  string `json:"scanAge,omitempty"`
+		Error    string `json:"error,omitempty"`
+	}{Loaded: d.pkgs != nil, Packages: len(d.pkgs)}
+
+	age := time.Since(d.scanned)
+	if status.Loaded {
+		status.Scanned = d.scanned.Format(time.RFC3339)
+		status.ScanAge = age.Round(time.Second).String()
+	}
+	if d.scanErr != nil {
+		status.Error = d.scanErr.Error()
+	}
+	status.Ready = status.Loaded && (maxScanAge == 0 || age <= maxScanAge)
+
+	code := http.StatusOK
+	if !status.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, status)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write response: %v\n", err)
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Shards split the scan of the whole tree between CI jobs, each scanning a part of the modules:
+//  go run . -d . -shard 3/8 -snapshot shard-3.json
+//  go run . merge shard-*.json -o all.json
+// A module is in the shard by the hash of its path relative to the scanned dir, so the shards do not depend
+// on the checkout location or on the other modules, and a new module does not move the others between shards.
+
+import (
+	"crypto/sha256"
+	bin "encoding/binary" // not to clash with the binary type of the rule expressions in rules.go
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// parseShard parses the i/n shard, numbered from 1.
+func parseShard(shard string) (i, n int, err error) {
+	is, ns, ok := strings.Cut(shard, "/")
+	if ok {
+		i, err = strconv.Atoi(is)
+	}
+	if ok && err == nil {
+		n, err = strconv.Atoi(ns)
+	}
+	if !ok || err != nil || n < 1 || i < 1 || i > n {
+		return 0, 0, fmt.Errorf("bad shard %q, want i/n with 1 <= i <= n, i.e 3/8", shard)
+	}
+	return i, n, nil
+}
+
+// shardModules returns the modules of the i-th of the n shards.
+func shardModules(dir string, modulesPaths []string, i, n int) []string {
+	var shard []string
+	for _, mp := range modulesPaths {
+		rel, err := filepath.Rel(dir, mp)
+		if err != nil {
+			rel = mp
+		}
+		// sha256 for the well mixed low bits, the names repeated in the paths cancel out in FNV
+		h := sha256.Sum256([]byte(filepath.ToSlash(rel)))
+		if int(bin.BigEndian.Uint64(h[:8])%uint64(n)) == i-1 {
+			shard = append(shard, mp)
+		}
+	}
+	return shard
+}
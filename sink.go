@@ -0,0 +1,120 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Sinks are the destinations a scan is published to with -publish, each configured by its name in the config:
+//  {"sinks": {"jira": {"url": "https://example.atlassian.net", "project": "DOC", "user": "jet-search@example.com"}}}
+// A new destination registers its constructor in sinkTypes, without touching the scan.
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// snapshot is the result of a single scan.
+type snapshot struct {
+	dir     string
+	time    time.Time
+	pkgs    map[string]*pkg
+	modules map[string]*moduleSummary
+
+	publicTypes bool                 // counted by countSize, to tell the newly public packages
+	version     int                  // of the format, set in indexes only
+	roots       map[string]time.Time // source root -> its latest modification, set in indexes only
+	commit      string               // of the scanned dir, set with -commit or -links pinned only
+}
+
+func newSnapshot(dir string, pkgs map[string]*pkg) *snapshot {
+	return &snapshot{dir: dir, time: time.Now().UTC(), pkgs: pkgs, modules: summarizeModules(pkgs)}
+}
+
+// sink publishes a scan and its findings somewhere.
+type sink interface {
+	publish(s *snapshot, findings []finding) error
+}
+
+// sinkTypes are the known sinks, by name, constructed from their config.
+var sinkTypes = map[string]func(config json.RawMessage) (sink, error){}
+
+// configuredSinks returns the sinks from the config, sorted by name.
+func configuredSinks() ([]string, []sink, error) {
+	names := make([]string, 0, len(cfg.Sinks))
+	for name := range cfg.Sinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	sinks := make([]sink, len(names))
+	for i, name := range names {
+		newSink, ok := sinkTypes[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown sink %q in the config", name)
+		}
+		s, err := newSink(cfg.Sinks[name])
+		if err != nil {
+			return nil, nil, fmt.Errorf("sink %q: %v", name, err)
+		}
+		sinks[i] = s
+	}
+	return names, sinks, nil
+}
+
+// publish publishes to every configured sink, reporting the ones that failed.
+func publish(s *snapshot, findings []finding) error {
+	names, sinks, err := configuredSinks()
+	if err != nil {
+		return err
+	}
+	var failed []string
+	for i, sk := range sinks {
+		if err := sk.publish(s, findings); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", names[i], redact(err.Error())))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to publish to %v", failed)
+	}
+	return nil
+}
+
+// packageRow is a package of a scan, as stored by the database sinks.
+type packageRow struct {
+	ScanTime   string `json:"scan_time"` // RFC 3339
+	Dir        string `json:"dir"`
+	Module     string `json:"module"`
+	Package    string `json:"package"`
+	PkgDir     string `json:"pkg_dir"`
+	Doc        string `json:"doc"`
+	Documented bool   `json:"documented"`
+	API        string `json:"api"`
+	Files      int    `json:"files"`
+	JavaFiles  int    `json:"java_files"`
+	KtFiles    int    `json:"kt_files"`
+}
+
+func newPackageRow(s *snapshot, p *pkg) packageRow {
+	return packageRow{
+		ScanTime: s.time.Format(time.RFC3339), Dir: s.dir, Module: p.module, Package: p.name, PkgDir: p.pkgDir, Doc: p.doc,
+		Documented: p.isDocumented(), API: p.apiClass(),
+		Files: len(p.files), JavaFiles: p.filesCnt[".java"], KtFiles: p.filesCnt[".kt"],
+	}
+}
+
+// fileRow is a source file of a scan, as stored by the database sinks.
+type fileRow struct {
+	ScanTime   string `json:"scan_time"` // RFC 3339
+	Dir        string `json:"dir"`
+	Module     string `json:"module"`
+	Package    string `json:"package"`
+	PkgDir     string `json:"pkg_dir"`
+	File       string `json:"file"`
+	Ext        string `json:"ext"`
+	Documented bool   `json:"documented"`
+	API        string `json:"api"`
+}
+
+func newFileRow(pr packageRow, file string) fileRow {
+	return fileRow{pr.ScanTime, pr.Dir, pr.Module, pr.Package, pr.PkgDir, file, filepath.Ext(file), pr.Documented, pr.API}
+}
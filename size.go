@@ -0,0 +1,101 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Size report of the largest modules or packages, for shrinking the checkout:
+//  go run . report size -d ./platform -by module -top 20
+// A module is every file in its dir, as for the assets, and a package is the files of its dir in a source root.
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// sumBytes updates .bytes with the size of a file.
+func sumBytes(p *pkg, f *sourceFile) error {
+	p.bytes += f.size
+	return nil
+}
+
+// sizeEntry is a module or a package, with its size.
+type sizeEntry struct {
+	name  string
+	files int
+	bytes int64
+}
+
+func reportSize(args []string) error {
+	fs := flag.NewFlagSet("report size", flag.ExitOnError)
+	dir := fs.String("d", "", "dir to scan for modules")
+	by := fs.String("by", "module", "aggregate the sizes by module or package")
+	top := fs.Int("top", 20, "number of the largest ones to list, all if 0")
+	testFramework := fs.Bool("test-framework", false, "include testFramework modules")
+	addFormatFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *dir == "" || (*by != "module" && *by != "package") {
+		fs.Usage()
+		return nil
+	}
+
+	var entries []sizeEntry
+	if *by == "module" {
+		modulesPaths, err := findAllModules(osFS{}, *dir, *testFramework)
+		if err != nil {
+			return err
+		}
+		byModule := map[string]*sizeEntry{}
+		err = walkModuleFiles(osFS{}, modulesPaths, func(module, path string, size int64) error {
+			e, ok := byModule[module]
+			if !ok {
+				e = &sizeEntry{name: module}
+				byModule[module] = e
+			}
+			e.files++
+			e.bytes += size
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, e := range byModule {
+			entries = append(entries, *e)
+		}
+	} else {
+		pkgs, err := scanDir(*dir, *testFramework, sumBytes)
+		if err != nil {
+			return err
+		}
+		for _, p := range pkgs {
+			entries = append(entries, sizeEntry{p.pkgDir, len(p.files), p.bytes})
+		}
+	}
+
+	var total int64
+	for _, e := range entries {
+		total += e.bytes
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].bytes != entries[j].bytes {
+			return entries[i].bytes > entries[j].bytes
+		}
+		return entries[i].name < entries[j].name
+	})
+	if *top > 0 && *top < len(entries) {
+		entries = entries[:*top]
+	}
+
+	sep := "\t"
+	if *mdFlag {
+		sep = " | "
+	}
+	printHeader([]string{*by, "files", "bytes", "share"})
+	for _, e := range entries {
+		fmt.Println(strings.Join([]string{e.name, fmt.Sprint(e.files), fmt.Sprint(e.bytes), fmt.Sprintf("%.1f%%", percent(int(e.bytes), int(total)))}, sep))
+	}
+	fmt.Fprintf(os.Stderr, "%d bytes in total\n", total)
+	return nil
+}
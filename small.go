@@ -0,0 +1,81 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Empty and near-empty packages are candidates for consolidation, and the ones with package-info.java only
+// are not "fully documented" packages but noise in the report.
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+func init() {
+	ruleDescriptions["SmallPackage"] = "Package has too few source files"
+	ruleDescriptions["DocOnlyPackage"] = "Package has package-info.java only"
+}
+
+// sourcesCnt is the number of source files, not counting package-info.java
+func (p *pkg) sourcesCnt() int {
+	n := len(p.files)
+	for _, f := range p.files {
+		if f == "package-info.java" {
+			n--
+		}
+	}
+	return n
+}
+
+func (c *config) smallPackageFiles() int {
+	if c.SmallPackageFiles == nil {
+		return 1
+	}
+	return *c.SmallPackageFiles
+}
+
+// smallPackageFindings reports packages with package-info.java only and the ones with at most maxFiles source files.
+func smallPackageFindings(pkgs map[string]*pkg, maxFiles int) []finding {
+	var findings []finding
+	for _, p := range pkgs {
+		switch n := p.sourcesCnt(); {
+		case n == 0:
+			findings = append(findings, finding{rule: "DocOnlyPackage", level: "note",
+				message: fmt.Sprintf("Package %s has package-info.java only", p.name), path: p.pkgDir})
+		case n <= maxFiles:
+			findings = append(findings, finding{rule: "SmallPackage", level: "note",
+				message: fmt.Sprintf("Package %s has %d source files, consider merging it", p.name, n), path: p.pkgDir})
+		}
+	}
+	sortFindings(findings)
+	return findings
+}
+
+// checkSmallPackages lists empty and near-empty packages.
+func checkSmallPackages(args []string) error {
+	fs := flag.NewFlagSet("check small-packages", flag.ExitOnError)
+	dir := fs.String("d", "", "dir to scan for packages")
+	maxFiles := fs.Int("max-files", -1, "max number of source files in a package to report it, smallPackageFiles from the config or 1 by default")
+	fail := fs.Bool("fail", false, "exit with non-zero code if any package is reported")
+	addFormatFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		fs.Usage()
+		return nil
+	}
+	if *maxFiles < 0 {
+		*maxFiles = cfg.smallPackageFiles()
+	}
+
+	pkgs, err := scanDir(*dir, false)
+	if err != nil {
+		return err
+	}
+
+	findings := smallPackageFindings(pkgs, *maxFiles)
+	printFindings(findings)
+	fmt.Fprintf(os.Stderr, "%d of %d packages have at most %d source files\n", len(findings), len(pkgs), *maxFiles)
+
+	return failedCheck("small-packages", *fail, len(findings))
+}
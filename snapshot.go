@@ -0,0 +1,89 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Snapshots are the packages of a scan in a JSON file, written by -snapshot and uploaded to serve mode namespaces:
+//  {"dir": "./platform", "time": "2023-01-31T10:00:00Z", "packages": [{"module": ..., "pkgDir": ..., ...}]}
+// An index, written by the index command, is a snapshot with the version of its format, see index.go.
+// Snapshot files with the .pb extension are protobuf instead, see protobuf.go.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// snapshotVersion is the version of the format, bumped on changes the older readers can not handle.
+const snapshotVersion = 1
+
+type snapshotJSON struct {
+	Version  int       `json:"version,omitempty"` // of the format, written in indexes only, see snapshotVersion
+	Dir      string    `json:"dir"`
+	Time     time.Time `json:"time"`
+	Packages []*pkg    `json:"packages"`
+
+	PublicTypes bool                 `json:"publicTypes,omitempty"` // counted for each package
+	Roots       map[string]time.Time `json:"roots,omitempty"`       // source root -> its latest modification, in indexes only
+	Commit      string               `json:"commit,omitempty"`      // of the scanned dir, if it was resolved
+}
+
+func (s *snapshot) MarshalJSON() ([]byte, error) {
+	return json.Marshal(snapshotJSON{s.version, s.dir, s.time, sortedPackages(s.pkgs), s.publicTypes, s.roots, s.commit})
+}
+
+func (s *snapshot) UnmarshalJSON(blob []byte) error {
+	var j snapshotJSON
+	if err := json.Unmarshal(blob, &j); err != nil {
+		return err
+	}
+	if j.Version > snapshotVersion {
+		return fmt.Errorf("format version %d is newer than the supported %d", j.Version, snapshotVersion)
+	}
+	pkgs := make(map[string]*pkg, len(j.Packages))
+	for _, p := range j.Packages {
+		if p == nil || p.pkgDir == "" {
+			return fmt.Errorf("a package without pkgDir")
+		}
+		pkgs[p.pkgDir] = p
+	}
+	*s = snapshot{dir: j.Dir, time: j.Time, pkgs: pkgs, modules: summarizeModules(pkgs), publicTypes: j.PublicTypes, version: j.Version, roots: j.Roots, commit: j.Commit}
+	return nil
+}
+
+// hash identifies the packages of the snapshot, regardless of when and where it was scanned.
+func (s *snapshot) hash() string {
+	h := sha256.New()
+	json.NewEncoder(h).Encode(sortedPackages(s.pkgs))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func saveSnapshot(path string, s *snapshot) error {
+	return writeFile(path, func(w io.Writer) error {
+		if isProtobuf(path) {
+			return writeSnapshotPB(w, s)
+		}
+		return json.NewEncoder(w).Encode(s)
+	})
+}
+
+func readSnapshot(path string) (*snapshot, error) {
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if isProtobuf(path) {
+		s, err := readSnapshotPB(blob)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing snapshot %q: %v", path, err)
+		}
+		return s, nil
+	}
+	var s snapshot
+	if err := json.Unmarshal(blob, &s); err != nil {
+		return nil, fmt.Errorf("error parsing snapshot %q: %v", path, err)
+	}
+	return &s, nil
+}
@@ -0,0 +1,46 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Cells of the -gs output, pasted into a spreadsheet as is:
+//  go run . -d ./platform -gs -gs-links url | pbcopy
+// The values starting with = + - @, i.e of a module dir of a weird name, are prefixed with ' so that they are
+// text rather than formulas, and the tabs and the line breaks in them are spaces, not to split the rows.
+// The links are HYPERLINK formulas by default, and plain URLs with -gs-links url, for the locales where the
+// arguments of the formulas are separated by ; rather than , and the spreadsheets that do not take formulas at all.
+// The packages then have a link column of their own, the name being in the package column.
+
+import (
+	"fmt"
+	"strings"
+)
+
+var gsLinkModes = []string{"formula", "url"}
+
+func checkGsLinkMode(mode string) error {
+	if !contains(gsLinkModes, mode) {
+		return fmt.Errorf("unknown -gs-links %q, expected one of %s", mode, strings.Join(gsLinkModes, ", "))
+	}
+	return nil
+}
+
+// gsPlainLinks tells if the links of the -gs output are plain URLs rather than formulas.
+func gsPlainLinks() bool {
+	return *gsFlag && *gsLinksFlag == "url"
+}
+
+var gsCellReplacer = strings.NewReplacer("\t", " ", "\r\n", " ", "\r", " ", "\n", " ")
+
+// gsCell makes the value a text cell of the spreadsheet, that is never evaluated as a formula.
+func gsCell(s string) string {
+	s = gsCellReplacer.Replace(s)
+	if s != "" && strings.ContainsRune("=+-@", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// gsFormula returns the HYPERLINK formula, with the quotes of the URL and the label doubled.
+func gsFormula(url, label string) string {
+	quote := func(s string) string { return strings.ReplaceAll(gsCellReplacer.Replace(s), `"`, `""`) }
+	return fmt.Sprintf(`=HYPERLINK("%s","%s")`, quote(url), quote(label))
+}
@@ -0,0 +1,143 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// SQLite export of a scan, for ad-hoc SQL over the modules, source roots, packages and their files:
+//  go run . -d ./platform -sqlite scan.db
+//  sqlite3 scan.db "select m.name, count(*) from packages p join modules m on m.id = p.module_id where p.doc = '' group by 1 order by 2 desc"
+// The database is built by the sqlite3 command line tool, that has to be on the PATH, from the SQL script of writeSQL.
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// sqlSchema is the schema of the tables, the packages having a column of the files count of every sourceExts.
+const sqlSchema = `CREATE TABLE modules (
+  id INTEGER PRIMARY KEY,
+  path TEXT NOT NULL UNIQUE,
+  name TEXT NOT NULL,
+  java_level TEXT,
+  kotlin_api TEXT,
+  class TEXT
+);
+CREATE TABLE source_roots (
+  id INTEGER PRIMARY KEY,
+  module_id INTEGER NOT NULL REFERENCES modules(id),
+  dir TEXT NOT NULL UNIQUE
+);
+CREATE TABLE packages (
+  id INTEGER PRIMARY KEY,
+  name TEXT NOT NULL,
+  dir TEXT NOT NULL UNIQUE,
+  module_id INTEGER NOT NULL REFERENCES modules(id),
+  source_root_id INTEGER NOT NULL REFERENCES source_roots(id),
+  doc TEXT,
+  api TEXT NOT NULL%s
+);
+CREATE TABLE files (
+  id INTEGER PRIMARY KEY,
+  package_id INTEGER NOT NULL REFERENCES packages(id),
+  name TEXT NOT NULL,
+  ext TEXT NOT NULL
+);
+CREATE INDEX packages_name ON packages(name);
+CREATE INDEX packages_module ON packages(module_id);
+CREATE INDEX modules_name ON modules(name);
+CREATE INDEX files_package ON files(package_id);
+`
+
+// sqlFilesColumn is the name of the column of the files count by the extension, i.e java_files of .java.
+func sqlFilesColumn(ext string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, strings.TrimPrefix(ext, ".")) + "_files"
+}
+
+// sqlString quotes a string as an SQL literal.
+func sqlString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// writeSQL writes the SQL script that creates the tables and inserts the modules and the packages.
+// Modules of the packages that are not among the given ones, are inserted with the name of the .iml file.
+func writeSQL(w io.Writer, mods []*moduleInfo, pkgs map[string]*pkg) error {
+	var b bytes.Buffer
+	b.WriteString("BEGIN TRANSACTION;\n")
+	var columns strings.Builder
+	for _, ext := range sourceExts {
+		fmt.Fprintf(&columns, ",\n  %s INTEGER NOT NULL", sqlFilesColumn(ext))
+	}
+	fmt.Fprintf(&b, sqlSchema, columns.String())
+
+	moduleIDs := map[string]int{}
+	addModule := func(m *moduleInfo) int {
+		if id, ok := moduleIDs[m.path]; ok {
+			return id
+		}
+		id := len(moduleIDs) + 1
+		moduleIDs[m.path] = id
+		fmt.Fprintf(&b, "INSERT INTO modules VALUES (%d, %s, %s, %s, %s, %s);\n", id,
+			sqlString(m.path), sqlString(m.name), sqlString(m.javaLevel), sqlString(m.kotlinAPI), sqlString(m.class))
+		return id
+	}
+	for _, m := range mods {
+		addModule(m)
+	}
+
+	rootIDs := map[string]int{}
+	fileID := 0
+	for i, p := range sortedPackages(pkgs) {
+		moduleID := addModule(&moduleInfo{path: p.module, name: strings.TrimSuffix(filepath.Base(p.module), ".iml")})
+		rootID, ok := rootIDs[p.srcDir]
+		if !ok {
+			rootID = len(rootIDs) + 1
+			rootIDs[p.srcDir] = rootID
+			fmt.Fprintf(&b, "INSERT INTO source_roots VALUES (%d, %d, %s);\n", rootID, moduleID, sqlString(p.srcDir))
+		}
+
+		id := i + 1
+		fmt.Fprintf(&b, "INSERT INTO packages VALUES (%d, %s, %s, %d, %d, %s, %s", id,
+			sqlString(p.name), sqlString(p.pkgDir), moduleID, rootID, sqlString(p.doc), sqlString(p.apiClass()))
+		for _, ext := range sourceExts {
+			fmt.Fprintf(&b, ", %d", p.filesCnt[ext])
+		}
+		b.WriteString(");\n")
+		for _, f := range p.files {
+			fileID++
+			fmt.Fprintf(&b, "INSERT INTO files VALUES (%d, %d, %s, %s);\n", fileID, id, sqlString(f), sqlString(filepath.Ext(f)))
+		}
+	}
+	b.WriteString("COMMIT;\n")
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// saveSQLite replaces the database at the path with the one of the given modules and packages.
+// The database is built into a temp file next to it and renamed over it on success only,
+// the previous one staying in place for the readers if sqlite3 fails.
+func saveSQLite(path string, mods []*moduleInfo, pkgs map[string]*pkg) error {
+	var script bytes.Buffer
+	if err := writeSQL(&script, mods, pkgs); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name()) // no-op after the rename
+	cmd := exec.Command("sqlite3", "-bail", tmp.Name())
+	cmd.Stdin = &script
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("sqlite3 %s: %v\n%s", path, err, out)
+	}
+	return os.Rename(tmp.Name(), path)
+}
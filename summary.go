@@ -0,0 +1,84 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Summary of the scan after the packages, or the modules with -by-module, in every output format:
+//  go run . -d ./platform -md -summary
+// The tables are followed by a section of the totals, JSON is an object of the packages and the summary,
+// and JSON Lines has the summary on the last line. Kotlin adoption is the share of .kt in the .java and .kt sources.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// scanSummary is the totals of the packages of a scan.
+type scanSummary struct {
+	Modules        int     `json:"modules"`
+	Packages       int     `json:"packages"`
+	Files          int     `json:"files"`
+	Documented     int     `json:"documented"`
+	Undocumented   int     `json:"undocumented"`
+	KotlinAdoption float64 `json:"kotlinAdoption"` // % of .kt in .java and .kt sources
+}
+
+func summarize(pkgs map[string]*pkg) scanSummary {
+	var s scanSummary
+	modules := map[string]bool{}
+	java, kotlin := 0, 0
+	for _, p := range pkgs {
+		modules[p.module] = true
+		s.Packages++
+		s.Files += len(p.files)
+		if p.isDocumented() {
+			s.Documented++
+		} else {
+			s.Undocumented++
+		}
+		java += p.filesCnt[".java"]
+		kotlin += p.filesCnt[".kt"]
+	}
+	s.Modules = len(modules)
+	s.KotlinAdoption = percent(kotlin, java+kotlin)
+	return s
+}
+
+// fprintSummary prints the summary section in the format selected by the flags, after a blank line.
+func fprintSummary(w io.Writer, s scanSummary) {
+	if *jsonlFlag {
+		panicIfError(json.NewEncoder(w).Encode(struct {
+			Summary scanSummary `json:"summary"`
+		}{s}))
+		return
+	}
+	fields := []string{"modules", "packages", "files", "documented", "undocumented", "Kotlin adoption"}
+	values := []string{strconv.Itoa(s.Modules), strconv.Itoa(s.Packages), strconv.Itoa(s.Files),
+		strconv.Itoa(s.Documented), strconv.Itoa(s.Undocumented), fmt.Sprintf("%.1f%%", s.KotlinAdoption)}
+	if *mdFlag {
+		fmt.Fprint(w, "\n### Summary\n\n")
+		fprintHeader(w, fields)
+		fmt.Fprintln(w, strings.Join(values, " | "))
+		return
+	}
+	fmt.Fprintln(w)
+	for i, f := range fields {
+		if *plainFlag {
+			fmt.Fprintf(w, "%s: %s\n", f, values[i])
+		} else {
+			fmt.Fprintf(w, "%s\t%s\n", f, values[i])
+		}
+	}
+}
+
+// encodeWithSummary encodes the rows as the JSON output does, in an object with the summary under the key with -summary.
+func encodeWithSummary(w io.Writer, key string, rows interface{}, pkgs map[string]*pkg) {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if !*summaryFlag {
+		panicIfError(enc.Encode(rows))
+		return
+	}
+	panicIfError(enc.Encode(map[string]interface{}{key: rows, "summary": summarize(pkgs)}))
+}
@@ -0,0 +1,65 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Findings for a package are suppressed by
+//  * a .jet-search-suppress file in the package dir, with a rule name per line or `all`, # for comments
+//  * a marker comment in package-info.java: // jet-search:suppress LargePackage, KotlinOnly
+// Suppressed findings are still reported, marked as such.
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+)
+
+const (
+	suppressFile   = ".jet-search-suppress"
+	suppressMarker = "jet-search:suppress"
+)
+
+// findSuppressions updates .suppressed with the rules from the suppress file or package-info.java comments.
+func findSuppressions(p *pkg, f *sourceFile) error {
+	var kind string
+	switch f.name() {
+	case suppressFile:
+		kind = "external"
+	case "package-info.java":
+		kind = "inSource"
+	default:
+		return nil
+	}
+
+	content, err := f.content()
+	if err != nil {
+		return err
+	}
+	s := bufio.NewScanner(bytes.NewReader(content))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if kind == "inSource" {
+			i := strings.Index(line, suppressMarker)
+			if i < 0 {
+				continue
+			}
+			line = strings.TrimSuffix(strings.TrimSpace(line[i+len(suppressMarker):]), "*/")
+		} else if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+
+		for _, r := range strings.FieldsFunc(line, func(c rune) bool { return c == ',' || c == ' ' || c == '\t' }) {
+			if p.suppressed == nil {
+				p.suppressed = map[string]string{}
+			}
+			p.suppressed[r] = kind
+		}
+	}
+	return s.Err()
+}
+
+// suppressionOf returns the suppression kind of the rule for the package, if it is suppressed.
+func (p *pkg) suppressionOf(rule string) string {
+	if kind, ok := p.suppressed[rule]; ok {
+		return kind
+	}
+	return p.suppressed["all"]
+}
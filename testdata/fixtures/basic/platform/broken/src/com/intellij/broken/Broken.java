@@ -0,0 +1,3 @@
+package com.intellij.broken;
+
+public class Broken {}
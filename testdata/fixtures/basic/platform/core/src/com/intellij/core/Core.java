@@ -0,0 +1,5 @@
+// Copyright 2000-2022 JetBrains s.r.o. Use of this source code is governed by the Apache 2.0 license.
+package com.intellij.core;
+
+/** Core. */
+public class Core {}
@@ -0,0 +1,5 @@
+// Licensed under the Apache License, Version 2.0
+package com.intellij.core.impl
+
+@Service(Service.Level.PROJECT)
+class CoreImpl
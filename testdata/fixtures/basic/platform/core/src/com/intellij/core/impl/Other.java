@@ -0,0 +1,6 @@
+/*
+ * SPDX-License-Identifier: MIT
+ */
+package com.intellij.core.impl;
+
+public class Other {}
@@ -0,0 +1,3 @@
+package com.intellij.core.impl;
+
+class $NAME$ {}
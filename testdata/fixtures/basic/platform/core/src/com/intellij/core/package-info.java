@@ -0,0 +1,2 @@
+/** Core API. */
+package com.intellij.core;
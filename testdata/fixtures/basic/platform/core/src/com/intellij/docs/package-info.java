@@ -0,0 +1,5 @@
+/** x */
+@ApiStatus.Experimental
+package com.intellij.docs;
+
+import org.jetbrains.annotations.ApiStatus;
@@ -0,0 +1,2 @@
+package com.intellij.core.tests;
+class CoreTest {}
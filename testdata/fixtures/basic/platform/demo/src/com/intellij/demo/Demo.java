@@ -0,0 +1,3 @@
+package com.intellij.demo;
+
+class Demo {}
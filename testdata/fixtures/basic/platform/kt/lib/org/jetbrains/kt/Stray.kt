@@ -0,0 +1,4 @@
+package org.jetbrains.kt
+
+// moved out of src/, but not registered in the .iml
+class Stray
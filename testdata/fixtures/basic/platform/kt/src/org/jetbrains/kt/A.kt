@@ -0,0 +1,6 @@
+package org.jetbrains.kt
+
+/** Doc */
+class A
+
+class B
@@ -0,0 +1,3 @@
+package com.intellij.old;
+
+public class Old {}
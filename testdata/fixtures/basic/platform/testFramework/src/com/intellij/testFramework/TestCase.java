@@ -0,0 +1,3 @@
+package com.intellij.testFramework;
+
+public class TestCase {}
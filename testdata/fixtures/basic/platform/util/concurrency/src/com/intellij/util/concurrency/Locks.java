@@ -0,0 +1,3 @@
+package com.intellij.util.concurrency;
+
+public final class Locks {}
@@ -0,0 +1,4 @@
+package com.intellij.util;
+
+// TODO: split, FIXME: name
+public final class Util {} // XXX
@@ -0,0 +1,7 @@
+package com.intellij.util.io;
+
+/**
+ * File utilities.
+ */
+@Deprecated
+public final class Files {}
@@ -0,0 +1,2 @@
+/** IO utilities. jet-search:suppress SmallPackage */
+package com.intellij.util.io;
@@ -0,0 +1,3 @@
+package com.intellij.util;
+
+public class UtilTest {}
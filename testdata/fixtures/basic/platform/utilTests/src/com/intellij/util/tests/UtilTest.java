@@ -0,0 +1,3 @@
+package com.intellij.util.tests;
+
+class UtilTest {}
@@ -0,0 +1,72 @@
+BEGIN TRANSACTION;
+CREATE TABLE modules (
+  id INTEGER PRIMARY KEY,
+  path TEXT NOT NULL UNIQUE,
+  name TEXT NOT NULL,
+  java_level TEXT,
+  kotlin_api TEXT,
+  class TEXT
+);
+CREATE TABLE source_roots (
+  id INTEGER PRIMARY KEY,
+  module_id INTEGER NOT NULL REFERENCES modules(id),
+  dir TEXT NOT NULL UNIQUE
+);
+CREATE TABLE packages (
+  id INTEGER PRIMARY KEY,
+  name TEXT NOT NULL,
+  dir TEXT NOT NULL UNIQUE,
+  module_id INTEGER NOT NULL REFERENCES modules(id),
+  source_root_id INTEGER NOT NULL REFERENCES source_roots(id),
+  doc TEXT,
+  api TEXT NOT NULL,
+  java_files INTEGER NOT NULL,
+  kt_files INTEGER NOT NULL,
+  scala_files INTEGER NOT NULL,
+  groovy_files INTEGER NOT NULL
+);
+CREATE TABLE files (
+  id INTEGER PRIMARY KEY,
+  package_id INTEGER NOT NULL REFERENCES packages(id),
+  name TEXT NOT NULL,
+  ext TEXT NOT NULL
+);
+CREATE INDEX packages_name ON packages(name);
+CREATE INDEX packages_module ON packages(module_id);
+CREATE INDEX modules_name ON modules(name);
+CREATE INDEX files_package ON files(package_id);
+INSERT INTO modules VALUES (1, 'platform/broken/intellij.platform.broken.iml', 'intellij.platform.broken', '', '', '');
+INSERT INTO source_roots VALUES (1, 1, 'platform/broken/src');
+INSERT INTO packages VALUES (1, 'com.intellij.broken', 'platform/broken/src/com/intellij/broken', 1, 1, '', 'public', 1, 0, 0, 0);
+INSERT INTO files VALUES (1, 1, 'Broken.java', '.java');
+INSERT INTO modules VALUES (2, 'platform/core/intellij.platform.core.iml', 'intellij.platform.core', '', '', '');
+INSERT INTO source_roots VALUES (2, 2, 'platform/core/src');
+INSERT INTO packages VALUES (2, 'com.intellij.core', 'platform/core/src/com/intellij/core', 2, 2, 'platform/core/src/com/intellij/core/package-info.java', 'public', 2, 0, 0, 0);
+INSERT INTO files VALUES (2, 2, 'Core.java', '.java');
+INSERT INTO files VALUES (3, 2, 'package-info.java', '.java');
+INSERT INTO packages VALUES (3, 'com.intellij.core.impl', 'platform/core/src/com/intellij/core/impl', 2, 2, '', 'impl', 2, 2, 0, 0);
+INSERT INTO files VALUES (4, 3, 'CoreImpl.kt', '.kt');
+INSERT INTO files VALUES (5, 3, 'Empty.kt', '.kt');
+INSERT INTO files VALUES (6, 3, 'Other.java', '.java');
+INSERT INTO files VALUES (7, 3, '_Template.java', '.java');
+INSERT INTO packages VALUES (4, 'com.intellij.docs', 'platform/core/src/com/intellij/docs', 2, 2, 'platform/core/src/com/intellij/docs/package-info.java', 'experimental', 1, 0, 0, 0);
+INSERT INTO files VALUES (8, 4, 'package-info.java', '.java');
+INSERT INTO modules VALUES (3, 'platform/kt/intellij.platform.kt.iml', 'intellij.platform.kt', '', '', '');
+INSERT INTO source_roots VALUES (3, 3, 'platform/kt/src');
+INSERT INTO packages VALUES (5, 'org.jetbrains.kt', 'platform/kt/src/org/jetbrains/kt', 3, 3, '', 'public', 0, 1, 0, 0);
+INSERT INTO files VALUES (9, 5, 'A.kt', '.kt');
+INSERT INTO modules VALUES (4, 'platform/old/intellij.platform.old.iml', 'intellij.platform.old', '', '', '');
+INSERT INTO source_roots VALUES (4, 4, 'platform/old/src');
+INSERT INTO packages VALUES (6, 'com.intellij.old', 'platform/old/src/com/intellij/old', 4, 4, '', 'public', 1, 0, 0, 0);
+INSERT INTO files VALUES (10, 6, 'Old.java', '.java');
+INSERT INTO modules VALUES (5, 'platform/util/intellij.platform.util.iml', 'intellij.platform.util', '', '', '');
+INSERT INTO source_roots VALUES (5, 5, 'platform/util/concurrency/src');
+INSERT INTO packages VALUES (7, 'com.intellij.util.concurrency', 'platform/util/concurrency/src/com/intellij/util/concurrency', 5, 5, '', 'public', 1, 0, 0, 0);
+INSERT INTO files VALUES (11, 7, 'Locks.java', '.java');
+INSERT INTO source_roots VALUES (6, 5, 'platform/util/src');
+INSERT INTO packages VALUES (8, 'com.intellij.util', 'platform/util/src/com/intellij/util', 5, 6, 'platform/util/src/com/intellij/util/package.html', 'public', 1, 0, 0, 0);
+INSERT INTO files VALUES (12, 8, 'Util.java', '.java');
+INSERT INTO packages VALUES (9, 'com.intellij.util.io', 'platform/util/src/com/intellij/util/io', 5, 6, 'platform/util/src/com/intellij/util/io/package-info.java', 'public', 2, 0, 0, 0);
+INSERT INTO files VALUES (13, 9, 'Files.java', '.java');
+INSERT INTO files VALUES (14, 9, 'package-info.java', '.java');
+COMMIT;
@@ -0,0 +1,280 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Time series of the history, a row per scan and module in the long format Grafana and Datawrapper import as is,
+// with the doc coverage, the packages, the files and the share of Kotlin in the sources:
+//  go run . history export -history trend.jsonl -format csv -o coverage.csv
+//  go run . history export -history trend.jsonl -modules 'platform/core*/**' -chart coverage.svg
+// Every scan has a row of the total of the selected modules too, with the module "total". The chart is the total
+// coverage over time, in SVG, or in PNG without the labels for the mail clients that do not show SVG. The weekly
+// digest attaches the PNG chart of every group with -chart.
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// seriesPoint is the doc coverage of a module, or of the total of the modules, at a scan.
+type seriesPoint struct {
+	Time       time.Time `json:"date"`
+	Module     string    `json:"module"`
+	Coverage   float64   `json:"coverage"` // % of the documented packages
+	Packages   int       `json:"packages"`
+	Documented int       `json:"documented"`
+	Files      int       `json:"files"`
+	Kotlin     float64   `json:"kotlin"` // % of .kt in the .java and .kt sources
+}
+
+const seriesTotal = "total"
+
+// timeSeries returns the points of the modules in the scope and of their total, in the order of the records.
+func timeSeries(records []*historyRecord, scope *regexp.Regexp) []seriesPoint {
+	var points []seriesPoint
+	for _, r := range records {
+		mods := make([]string, 0, len(r.Modules))
+		for m := range r.Modules {
+			if scope.MatchString(m) {
+				mods = append(mods, m)
+			}
+		}
+		sort.Strings(mods)
+		var total moduleSummary
+		for _, m := range mods {
+			s := r.Modules[m]
+			points = append(points, newSeriesPoint(r.Time, m, s))
+			total.Packages += s.Packages
+			total.Documented += s.Documented
+			total.Files += s.Files
+			total.Java += s.Java
+			total.Kotlin += s.Kotlin
+		}
+		points = append(points, newSeriesPoint(r.Time, seriesTotal, &total))
+	}
+	return points
+}
+
+func newSeriesPoint(t time.Time, module string, s *moduleSummary) seriesPoint {
+	return seriesPoint{Time: t.UTC(), Module: module, Packages: s.Packages, Documented: s.Documented, Files: s.Files,
+		Coverage: math.Round(percent(s.Documented, s.Packages)*10) / 10,
+		Kotlin:   math.Round(percent(s.Kotlin, s.Java+s.Kotlin)*10) / 10}
+}
+
+func writeSeriesCSV(w io.Writer, points []seriesPoint) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"date", "module", "coverage", "packages", "documented", "files", "kotlin"})
+	for _, p := range points {
+		cw.Write([]string{p.Time.Format(time.RFC3339), p.Module, strconv.FormatFloat(p.Coverage, 'f', 1, 64),
+			strconv.Itoa(p.Packages), strconv.Itoa(p.Documented), strconv.Itoa(p.Files), strconv.FormatFloat(p.Kotlin, 'f', 1, 64)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// the size of the chart and the margins of its plot area
+const (
+	chartWidth, chartHeight = 640, 320
+	chartLeft, chartRight   = 48, 16
+	chartTop, chartBottom   = 16, 32
+)
+
+func seriesTotals(points []seriesPoint) []seriesPoint {
+	var totals []seriesPoint
+	for _, p := range points {
+		if p.Module == seriesTotal {
+			totals = append(totals, p)
+		}
+	}
+	return totals
+}
+
+// chartPoints returns the positions of the total coverage in the plot area, in pixels from the top left corner.
+func chartPoints(points []seriesPoint) []image.Point {
+	totals := seriesTotals(points)
+	plotWidth, plotHeight := chartWidth-chartLeft-chartRight, chartHeight-chartTop-chartBottom
+	xy := make([]image.Point, len(totals))
+	for i, p := range totals {
+		x := chartLeft + plotWidth/2 // a single scan is in the middle
+		if span := totals[len(totals)-1].Time.Sub(totals[0].Time); span > 0 {
+			x = chartLeft + int(float64(plotWidth)*float64(p.Time.Sub(totals[0].Time))/float64(span))
+		}
+		xy[i] = image.Point{x, chartTop + int(float64(plotHeight)*(100-p.Coverage)/100)}
+	}
+	return xy
+}
+
+// writeChartSVG draws the total coverage over time, with the 0-100% axis and the dates of the first and last scans.
+func writeChartSVG(w io.Writer, points []seriesPoint) error {
+	xy := chartPoints(points)
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="11">`+"\n", chartWidth, chartHeight)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/>`+"\n", chartWidth, chartHeight)
+	for pct := 0; pct <= 100; pct += 25 {
+		y := chartTop + (chartHeight-chartTop-chartBottom)*(100-pct)/100
+		fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#e6e6e6"/>`+"\n", chartLeft, y, chartWidth-chartRight, y)
+		fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="end" fill="#6c707e">%d%%</text>`+"\n", chartLeft-6, y+4, pct)
+	}
+	if totals := seriesTotals(points); len(totals) > 0 {
+		first, last := totals[0], totals[len(totals)-1]
+		fmt.Fprintf(&b, `<text x="%d" y="%d" fill="#6c707e">%s</text>`+"\n", chartLeft, chartHeight-10, first.Time.Format("2006-01-02"))
+		fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="end" fill="#6c707e">%s</text>`+"\n", chartWidth-chartRight, chartHeight-10, last.Time.Format("2006-01-02"))
+		coords := make([]string, len(xy))
+		for i, p := range xy {
+			coords[i] = fmt.Sprintf("%d,%d", p.X, p.Y)
+		}
+		fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="#087cfa" stroke-width="2"/>`+"\n", strings.Join(coords, " "))
+		for i, p := range xy {
+			fmt.Fprintf(&b, `<circle cx="%d" cy="%d" r="3" fill="#087cfa"><title>%s: %.1f%%</title></circle>`+"\n",
+				p.X, p.Y, totals[i].Time.Format("2006-01-02"), totals[i].Coverage)
+		}
+	}
+	b.WriteString("</svg>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeChartPNG draws the chart of writeChartSVG without the labels.
+func writeChartPNG(w io.Writer, points []seriesPoint) error {
+	img := image.NewRGBA(image.Rect(0, 0, chartWidth, chartHeight))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	grid, line := color.RGBA{0xe6, 0xe6, 0xe6, 0xff}, color.RGBA{0x08, 0x7c, 0xfa, 0xff}
+	for pct := 0; pct <= 100; pct += 25 {
+		y := chartTop + (chartHeight-chartTop-chartBottom)*(100-pct)/100
+		for x := chartLeft; x <= chartWidth-chartRight; x++ {
+			img.Set(x, y, grid)
+		}
+	}
+	xy := chartPoints(points)
+	for i, p := range xy {
+		drawDot(img, p, 3, line)
+		if i > 0 {
+			drawLine(img, xy[i-1], p, line)
+		}
+	}
+	return png.Encode(w, img)
+}
+
+// drawLine draws a line 2px wide from a to b.
+func drawLine(img *image.RGBA, a, b image.Point, c color.Color) {
+	steps := abs(b.X - a.X)
+	if dy := abs(b.Y - a.Y); dy > steps {
+		steps = dy
+	}
+	for i := 0; i <= steps; i++ {
+		x, y := a.X, a.Y
+		if steps > 0 {
+			x += (b.X - a.X) * i / steps
+			y += (b.Y - a.Y) * i / steps
+		}
+		drawDot(img, image.Point{x, y}, 1, c)
+	}
+}
+
+func drawDot(img *image.RGBA, p image.Point, r int, c color.Color) {
+	for dx := -r; dx <= r; dx++ {
+		for dy := -r; dy <= r; dy++ {
+			if dx*dx+dy*dy <= r*r {
+				img.Set(p.X+dx, p.Y+dy, c)
+			}
+		}
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// writeChart writes the chart in the format of the file extension, .svg or .png.
+func writeChart(path string, points []seriesPoint) error {
+	switch filepath.Ext(path) {
+	case ".svg":
+		return writeFile(path, func(w io.Writer) error { return writeChartSVG(w, points) })
+	case ".png":
+		return writeFile(path, func(w io.Writer) error { return writeChartPNG(w, points) })
+	}
+	return fmt.Errorf("unknown chart format of %q, expected .svg or .png", path)
+}
+
+// chartPNG returns the PNG chart of the total coverage of the modules in the scope, to attach to the digest.
+func chartPNG(records []*historyRecord, scope *regexp.Regexp) ([]byte, error) {
+	var b bytes.Buffer
+	if err := writeChartPNG(&b, timeSeries(records, scope)); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// runExport writes the time series of the history, and the chart of it with -chart.
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("history export", flag.ExitOnError)
+	historyPath := fs.String("history", "", "history file, written by -history")
+	namespace := fs.String("namespace", "", "product/branch of the uploaded snapshots to export, the local scans by default")
+	modules := fs.String("modules", "", "glob of the .iml paths of the modules to export, all by default")
+	format := fs.String("format", "json", "format of the series: json|csv")
+	out := fs.String("o", "", "file to write the series to, stdout by default")
+	chart := fs.String("chart", "", "draw the total coverage over time in the given .svg or .png file")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *historyPath == "" {
+		fs.Usage()
+		return nil
+	}
+	if *format != "json" && *format != "csv" {
+		return fmt.Errorf("unknown format %q, expected json or csv", *format)
+	}
+	scope, err := globToRegexp(*modules)
+	if err != nil {
+		return fmt.Errorf("bad modules %q: %v", *modules, err)
+	}
+
+	records, err := readHistory(*historyPath)
+	if err != nil {
+		return err
+	}
+	points := timeSeries(inNamespace(records, *namespace), scope)
+	if *chart != "" {
+		if err := writeChart(*chart, points); err != nil {
+			return err
+		}
+	}
+	return writeSeries(*out, *format, points)
+}
+
+// writeSeries writes the points in the format, json or csv, to the file, or to stdout if it is "".
+func writeSeries(path, format string, points []seriesPoint) error {
+	write := func(w io.Writer) error {
+		switch format {
+		case "csv":
+			return writeSeriesCSV(w, points)
+		case "json":
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			return enc.Encode(points)
+		}
+		return fmt.Errorf("unknown format %q, expected json or csv", format)
+	}
+	if path == "" {
+		return write(os.Stdout)
+	}
+	return writeFile(path, write)
+}
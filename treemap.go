@@ -0,0 +1,185 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Treemap of the packages, to see at a glance where the undocumented mass is:
+//  go run . -d ./platform -loc -treemap treemap.json
+//  GET /treemap       the page of serve mode drawing it, zoomed in by a click and out by the path above
+//  GET /api/treemap   the JSON of it
+// The hierarchy is by the segments of the package names, in the shape d3.hierarchy and the like take as is:
+// every node has the files and the code lines of its own package, if it is one, and the children. The packages
+// of the same name in several modules are merged, documented if any is. The lines are counted with -loc only.
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// treemapNode is a segment of the package names, the package of that name if Package is set.
+type treemapNode struct {
+	Name     string         `json:"name"`
+	Package  string         `json:"package,omitempty"`
+	Doc      string         `json:"doc,omitempty"`   // documented, legacy or missing, for a package
+	Files    int            `json:"files,omitempty"` // of the package, not of the children
+	Lines    int            `json:"lines,omitempty"` // code lines of the package, with -loc
+	Children []*treemapNode `json:"children,omitempty"`
+}
+
+// treemapDocs orders the doc states, the best last.
+var treemapDocs = map[string]int{"missing": 1, "legacy": 2, "documented": 3}
+
+// buildTreemap returns the tree of the package names, the children sorted by name.
+func buildTreemap(name string, pkgs map[string]*pkg) *treemapNode {
+	root := &treemapNode{Name: name}
+	for _, p := range sortedPackages(pkgs) {
+		n := root
+		for _, segment := range strings.Split(p.name, ".") {
+			n = n.child(segment)
+		}
+		n.Package = p.name
+		n.Files += len(p.files)
+		n.Lines += p.codeLines
+		if doc := docBadge(p).Badge; treemapDocs[doc] > treemapDocs[n.Doc] {
+			n.Doc = doc
+		}
+	}
+	root.sort()
+	return root
+}
+
+func (n *treemapNode) child(name string) *treemapNode {
+	for _, c := range n.Children {
+		if c.Name == name {
+			return c
+		}
+	}
+	c := &treemapNode{Name: name}
+	n.Children = append(n.Children, c)
+	return c
+}
+
+func (n *treemapNode) sort() {
+	sort.Slice(n.Children, func(i, j int) bool { return n.Children[i].Name < n.Children[j].Name })
+	for _, c := range n.Children {
+		c.sort()
+	}
+}
+
+func writeTreemap(w io.Writer, name string, pkgs map[string]*pkg) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(buildTreemap(name, pkgs))
+}
+
+func (d *daemon) handleTreemap(w http.ResponseWriter, r *http.Request) {
+	d.mu.RLock()
+	pkgs := d.pkgs
+	d.mu.RUnlock()
+	if pkgs == nil {
+		http.Error(w, "not scanned yet", http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, http.StatusOK, buildTreemap(d.dir, pkgs))
+}
+
+func handleTreemapPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, treemapPage)
+}
+
+// treemapPage draws the treemap of /api/treemap, the area by the files or the code lines, the color by the doc status.
+const treemapPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Treemap of the packages</title>
+<style>
+body { font: 13px -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif; margin: 16px; color: #19191c; }
+#map { position: relative; width: 100%; height: calc(100vh - 96px); }
+.node { position: absolute; box-sizing: border-box; border: 1px solid #fff; overflow: hidden; cursor: pointer; padding: 2px 4px; white-space: nowrap; }
+.documented { background: #9fdcaa; }
+.legacy { background: #ffe08a; }
+.missing { background: #f4a3a3; }
+.group { background: #e6e6e6; }
+#path a { color: #087cfa; cursor: pointer; }
+.meta { color: #6c707e; }
+</style>
+</head>
+<body>
+<p><span id="path"></span> <span class="meta">area by <select id="weight"><option value="files">files</option><option value="lines">code lines</option></select>,
+<span class="documented">&nbsp;documented&nbsp;</span> <span class="legacy">&nbsp;package.html&nbsp;</span> <span class="missing">&nbsp;missing&nbsp;</span></span></p>
+<div id="map"></div>
+<script>
+(function () {
+  var map = document.getElementById("map"), path = document.getElementById("path"), weight = document.getElementById("weight");
+  var root, stack = [];
+  function total(n) {
+    if (n.total === undefined || n.weight !== weight.value) {
+      n.weight = weight.value;
+      n.total = (n[weight.value] || 0) + (n.children || []).reduce(function (s, c) { return s + total(c); }, 0);
+    }
+    return n.total;
+  }
+  // items of the node: the children, and the package itself if it has sources
+  function items(n) {
+    var list = (n.children || []).filter(function (c) { return total(c) > 0; });
+    if (n.package && n[weight.value] > 0 && list.length) {
+      list.push({name: n.name, package: n.package, doc: n.doc, files: n.files, lines: n.lines});
+    }
+    return list.sort(function (a, b) { return total(b) - total(a); });
+  }
+  // slice and dice, the direction of the longer side, to a depth of 3
+  function layout(n, x, y, w, h, depth) {
+    var list = depth < 3 ? items(n) : [];
+    if (!list.length || w < 24 || h < 16) {
+      draw(n, x, y, w, h, n.package ? n.doc : "group");
+      return;
+    }
+    var sum = list.reduce(function (s, c) { return s + total(c); }, 0), offset = 0;
+    list.forEach(function (c) {
+      var share = total(c) / sum;
+      if (w >= h) {
+        layout(c, x + offset, y, w * share, h, depth + 1);
+        offset += w * share;
+      } else {
+        layout(c, x, y + offset, w, h * share, depth + 1);
+        offset += h * share;
+      }
+    });
+  }
+  function draw(n, x, y, w, h, cls) {
+    var div = document.createElement("div");
+    div.className = "node " + cls;
+    div.style.left = x + "px"; div.style.top = y + "px"; div.style.width = w + "px"; div.style.height = h + "px";
+    div.textContent = w > 40 && h > 14 ? n.name : "";
+    div.title = (n.package || n.name) + ": " + total(n) + " " + weight.value + (n.doc ? ", " + n.doc : "");
+    div.onclick = function () { if (n.children && n.children.length) { stack.push(n); render(); } };
+    map.appendChild(div);
+  }
+  function render() {
+    map.innerHTML = "";
+    path.innerHTML = "";
+    stack.forEach(function (n, i) {
+      var a = document.createElement("a");
+      a.textContent = (i > 1 ? "." : i ? " / " : "") + (n.name || "all");
+      a.onclick = function () { stack = stack.slice(0, i + 1); render(); };
+      path.appendChild(a);
+    });
+    var n = stack[stack.length - 1];
+    layout(n, 0, 0, map.clientWidth, map.clientHeight, 0);
+  }
+  weight.onchange = render;
+  window.onresize = render;
+  fetch("api/treemap").then(function (r) { return r.json(); }).then(function (data) {
+    root = data;
+    root.name = "";
+    stack = [root];
+    render();
+  });
+})();
+</script>
+</body>
+</html>
+`
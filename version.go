@@ -0,0 +1,216 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// Version of the binary and updates from the artifact store, so that everyone runs the same scanner:
+//  jet-search version -check      exits with 1 if there is a newer release
+//  jet-search self-update         replaces the binary with the latest release
+// The releases are built with -ldflags "-X main.version=1.4.0" and published to the store, set by -updates-url,
+// JET_SEARCH_UPDATES_URL or the config flags, as
+//  <updates-url>/latest.json                            {"version": "1.4.0", "sha256": {"linux-amd64": "<hex>", ...}}
+//  <updates-url>/<version>/jet-search-<os>-<arch>       the binaries, .exe on Windows
+// A binary that does not match the checksum of latest.json is never installed. The checksums come from the same
+// unauthenticated store as the binaries, so they only guard against corrupt downloads, not against a compromised store.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// version is set at the release build, dev otherwise.
+var version = "dev"
+
+// fullVersion returns the version with the commit of a dev build, if it is known.
+func fullVersion() string {
+	if version != "dev" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" && len(s.Value) >= 12 {
+				return version + "-" + s.Value[:12]
+			}
+		}
+	}
+	return version
+}
+
+// release is the latest.json of the artifact store.
+type release struct {
+	Version string            `json:"version"`
+	SHA256  map[string]string `json:"sha256"` // <os>-<arch> -> checksum of the binary
+}
+
+func platform() string {
+	return runtime.GOOS + "-" + runtime.GOARCH
+}
+
+// releaseVersion is the version of a release, a part of the URLs of its binaries.
+var releaseVersion = regexp.MustCompile(`^[0-9]+(\.[0-9]+)*$`)
+
+func latestRelease(updatesURL string) (*release, error) {
+	url := strings.TrimSuffix(updatesURL, "/") + "/latest.json"
+	resp, err := (&http.Client{Timeout: time.Minute}).Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	var r release
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", url, err)
+	}
+	if r.Version == "" {
+		return nil, fmt.Errorf("no version in %s", url)
+	}
+	if !releaseVersion.MatchString(r.Version) {
+		return nil, fmt.Errorf("bad version %q in %s, want i.e 1.4.0", r.Version, url)
+	}
+	return &r, nil
+}
+
+// olderVersion checks if the dotted version a is older than b, a dev build being older than any release.
+func olderVersion(a, b string) bool {
+	if a == "dev" {
+		return true
+	}
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			return x < y
+		}
+	}
+	return false
+}
+
+func runVersion(args []string) error {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	check := fs.Bool("check", false, "check the artifact store for a newer release, exiting with non-zero code if there is one")
+	updatesURL := fs.String("updates-url", "", "URL of the artifact store with the releases")
+	fs.BoolVar(offlineFlag, "offline", false, "disable all the network access, failing with -check")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	fmt.Printf("jet-search %s %s\n", fullVersion(), platform())
+	if !*check {
+		return nil
+	}
+	if *offlineFlag {
+		return fmt.Errorf("-check needs network, disabled by -offline")
+	}
+	if *updatesURL == "" {
+		return fmt.Errorf("-updates-url is required to check for a newer release")
+	}
+	latest, err := latestRelease(*updatesURL)
+	if err != nil {
+		return err
+	}
+	if !olderVersion(version, latest.Version) {
+		fmt.Println("up to date")
+		return nil
+	}
+	fmt.Printf("%s is released, run jet-search self-update\n", latest.Version)
+	return errCheckFailed
+}
+
+func runSelfUpdate(args []string) error {
+	fs := flag.NewFlagSet("self-update", flag.ExitOnError)
+	updatesURL := fs.String("updates-url", "", "URL of the artifact store with the releases")
+	force := fs.Bool("force", false, "install the latest release even if it is not newer, i.e over a dev build")
+	fs.BoolVar(offlineFlag, "offline", false, "disable all the network access, failing as the update needs it")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *updatesURL == "" {
+		fs.Usage()
+		return nil
+	}
+	if *offlineFlag {
+		return fmt.Errorf("updating from %s needs network, disabled by -offline", *updatesURL)
+	}
+	latest, err := latestRelease(*updatesURL)
+	if err != nil {
+		return err
+	}
+	if !*force && !olderVersion(version, latest.Version) {
+		fmt.Printf("jet-search %s is up to date\n", version)
+		return nil
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	if err := installRelease(*updatesURL, latest, exe); err != nil {
+		return err
+	}
+	fmt.Printf("updated %s from %s to %s\n", exe, version, latest.Version)
+	return nil
+}
+
+// installRelease downloads the binary of the release for this platform, verifies its checksum
+// and replaces the binary at the path with it. The checksum is of latest.json, so it only catches a corrupt download.
+func installRelease(updatesURL string, r *release, path string) error {
+	want := r.SHA256[platform()]
+	if want == "" {
+		return fmt.Errorf("no %s binary in release %s", platform(), r.Version)
+	}
+	name := "jet-search-" + platform()
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	url := strings.TrimSuffix(updatesURL, "/") + "/" + r.Version + "/" + name
+	resp, err := (&http.Client{Timeout: 10 * time.Minute}).Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+
+	// next to the binary, to be renamed over it
+	f, err := os.CreateTemp(filepath.Dir(path), ".jet-search-update-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // if not renamed
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), resp.Body); err != nil {
+		f.Close()
+		return fmt.Errorf("error downloading %s: %v", url, err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(got, want) {
+		return fmt.Errorf("checksum of %s is %s, want %s", url, got, want)
+	}
+	if err := os.Chmod(f.Name(), 0o755); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,202 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// The server side of the WebSocket protocol, RFC 6455, as much of it as pushing events needs:
+// unfragmented text messages to the client, and pings and close from it. Client messages are ignored.
+// The browsers are only let in from the same host or the allowed origins, not from any page the developer visits,
+// while the other clients, that send no Origin, always are.
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const (
+	websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	opText  = 0x1
+	opClose = 0x8
+	opPing  = 0x9
+	opPong  = 0xA
+
+	maxControlFrame = 125
+)
+
+type webSocket struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+	mu   sync.Mutex // of writes
+}
+
+var errWebSocketOrigin = errors.New("WebSocket connections from this origin are not allowed")
+
+// allowedOrigin checks the Origin of the handshake is of the same host as the request, or one of the origins,
+// i.e https://dashboard.example.com, or there is none.
+func allowedOrigin(r *http.Request, origins []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, o := range origins {
+		if strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
+
+// upgradeWebSocket takes over the connection of the request from one of the allowed origins, see allowedOrigin,
+// completing the opening handshake.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request, origins []string) (*webSocket, error) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || !headerContains(r.Header, "Connection", "upgrade") {
+		return nil, errors.New("not a WebSocket handshake")
+	}
+	if !allowedOrigin(r, origins) {
+		return nil, errWebSocketOrigin
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, errors.New("unsupported WebSocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, errors.New("missing Sec-WebSocket-Key")
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("the connection can not be taken over")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &webSocket{conn: conn, rw: rw}, nil
+}
+
+// headerContains checks if a comma-separated header has the token, i.e `Connection: keep-alive, Upgrade`
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (ws *webSocket) Close() error {
+	return ws.conn.Close()
+}
+
+func (ws *webSocket) writeText(payload []byte) error {
+	return ws.writeFrame(opText, payload)
+}
+
+// writeFrame writes a final, unmasked frame, as the server ones are.
+func (ws *webSocket) writeFrame(opcode byte, payload []byte) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	header := []byte{0x80 | opcode, 0}
+	switch n := len(payload); {
+	case n <= maxControlFrame:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = appendBigEndian(header, uint64(n), 2)
+	default:
+		header[1] = 127
+		header = appendBigEndian(header, uint64(n), 8)
+	}
+	ws.rw.Write(header)
+	ws.rw.Write(payload)
+	return ws.rw.Flush()
+}
+
+// readUntilClose reads the client frames, answering pings, until the client closes the connection or it fails.
+func (ws *webSocket) readUntilClose() {
+	for {
+		opcode, payload, err := ws.readFrame()
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case opPing:
+			if ws.writeFrame(opPong, payload) != nil {
+				return
+			}
+		case opClose:
+			ws.writeFrame(opClose, payload)
+			return
+		}
+	}
+}
+
+// readFrame reads a frame of the client, that is always masked.
+func (ws *webSocket) readFrame() (byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(ws.rw, header[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := header[0] & 0x0F
+	if header[1]&0x80 == 0 {
+		return 0, nil, errors.New("unmasked client frame")
+	}
+
+	n := uint64(header[1] & 0x7F)
+	if n == 126 || n == 127 {
+		ext := make([]byte, 2)
+		if n == 127 {
+			ext = make([]byte, 8)
+		}
+		if _, err := io.ReadFull(ws.rw, ext); err != nil {
+			return 0, nil, err
+		}
+		n = 0
+		for _, b := range ext {
+			n = n<<8 | uint64(b)
+		}
+	}
+	if n > 1<<20 {
+		return 0, nil, errors.New("client frame is too large")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(ws.rw, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(ws.rw, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
+
+// appendBigEndian appends the size bytes of the number, most significant first.
+func appendBigEndian(b []byte, n uint64, size int) []byte {
+	for i := size - 1; i >= 0; i-- {
+		b = append(b, byte(n>>(8*i)))
+	}
+	return b
+}
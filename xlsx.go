@@ -0,0 +1,247 @@
+// Copyright 2000-2022 JetBrains s.r.o. and contributors. Use of this source code is governed by the Apache 2.0 license.
+package main
+
+// XLSX output of the packages, the columns of the CSV report, to open in Excel or import to Sheets as is:
+//  go run . -d ./platform -xlsx packages.xlsx
+//  go run . convert scan.json --to xlsx -o packages.xlsx
+// -xlsx writes a sheet per top-level dir of the scanned one, with the packages and their docs linked, while
+// convert writes a single sheet. The header row is frozen and bold, the counts are numbers with a thousands separator.
+// The workbook is the minimal set of the Office Open XML parts, with inline strings.
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// xlsxSheet is a sheet of the workbook, the first row being the header.
+type xlsxSheet struct {
+	name string
+	rows [][]xlsxCell
+}
+
+// xlsxCell is a number if the text is one, linked if it has a URL.
+type xlsxCell struct {
+	text, url string
+}
+
+// the styles of the cells, by the index in cellXfs of xlsxStyles
+const (
+	xlsxHeaderStyle = 1
+	xlsxNumberStyle = 2
+	xlsxLinkStyle   = 3
+)
+
+const xlsxStyles = `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+	`<fonts count="3"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font>` +
+	`<font><u/><sz val="11"/><color rgb="FF0563C1"/><name val="Calibri"/></font></fonts>` +
+	`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+	`<borders count="1"><border/></borders>` +
+	`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+	`<cellXfs count="4"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+	`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>` +
+	`<xf numFmtId="3" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` + // #,##0
+	`<xf numFmtId="0" fontId="2" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>` +
+	`</styleSheet>`
+
+// writeXLSX writes the table, the first row being the header, as a workbook of one sheet.
+func writeXLSX(w io.Writer, table [][]string) error {
+	sheet := xlsxSheet{name: "packages"}
+	for _, row := range table {
+		cells := make([]xlsxCell, len(row))
+		for i, text := range row {
+			cells[i] = xlsxCell{text: text}
+		}
+		sheet.rows = append(sheet.rows, cells)
+	}
+	return writeWorkbook(w, []xlsxSheet{sheet})
+}
+
+// packagesWorkbook returns a sheet of the packages per top-level dir of the scanned one, with the columns
+// of packagesTable, the packages and their docs linked.
+func packagesWorkbook(dir string, pkgs map[string]*pkg) []xlsxSheet {
+	byTop := map[string]map[string]*pkg{}
+	for pkgDir, p := range pkgs {
+		rel, err := filepath.Rel(dir, pkgDir)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			rel = pkgDir
+		}
+		top := strings.SplitN(filepath.ToSlash(rel), "/", 2)[0]
+		if byTop[top] == nil {
+			byTop[top] = map[string]*pkg{}
+		}
+		byTop[top][pkgDir] = p
+	}
+	if len(byTop) == 0 { // a sheet with the header
+		byTop["packages"] = map[string]*pkg{}
+	}
+	tops := make([]string, 0, len(byTop))
+	for top := range byTop {
+		tops = append(tops, top)
+	}
+	sort.Strings(tops)
+
+	var sheets []xlsxSheet
+	for _, top := range tops {
+		table := packagesTable(byTop[top])
+		column := map[string]int{}
+		for i, name := range table[0] {
+			column[name] = i
+		}
+		sheet := xlsxSheet{name: top}
+		for i, row := range table {
+			cells := make([]xlsxCell, len(row))
+			for j, text := range row {
+				cells[j] = xlsxCell{text: text}
+			}
+			if i > 0 {
+				cells[column["package"]].url = row[column["link"]]
+				cells[column["link"]].url = row[column["link"]]
+				if doc := row[column["documentation"]]; doc != "" {
+					cells[column["documentation"]].url = link(doc)
+				}
+			}
+			sheet.rows = append(sheet.rows, cells)
+		}
+		sheets = append(sheets, sheet)
+	}
+	return sheets
+}
+
+// writeWorkbook writes the sheets, with the header rows frozen, as a workbook.
+func writeWorkbook(w io.Writer, sheets []xlsxSheet) error {
+	var types, workbook, rels strings.Builder
+	types.WriteString(`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+	workbook.WriteString(`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+	rels.WriteString(`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rIdStyles" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`)
+	names := map[string]bool{}
+	for i, sheet := range sheets {
+		n := i + 1
+		fmt.Fprintf(&types, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, n)
+		fmt.Fprintf(&workbook, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xmlEscape(xlsxSheetName(sheet.name, names)), n, n)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, n, n)
+	}
+	types.WriteString(`</Types>`)
+	workbook.WriteString(`</sheets></workbook>`)
+	rels.WriteString(`</Relationships>`)
+
+	parts := []struct{ path, content string }{
+		{"[Content_Types].xml", types.String()},
+		{"_rels/.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", workbook.String()},
+		{"xl/_rels/workbook.xml.rels", rels.String()},
+		{"xl/styles.xml", xlsxStyles},
+	}
+	for i, sheet := range sheets {
+		content, sheetRels := xlsxWorksheet(sheet)
+		parts = append(parts, struct{ path, content string }{fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1), content})
+		if sheetRels != "" {
+			parts = append(parts, struct{ path, content string }{fmt.Sprintf("xl/worksheets/_rels/sheet%d.xml.rels", i+1), sheetRels})
+		}
+	}
+
+	zw := zip.NewWriter(w)
+	for _, part := range parts {
+		f, err := zw.Create(part.path)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, xml.Header+part.content); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// xlsxWorksheet returns the XML of the sheet and of its relationships to the URLs of the links, if it has any.
+func xlsxWorksheet(sheet xlsxSheet) (string, string) {
+	var b, links, rels strings.Builder
+	b.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>` +
+		`<sheetData>`)
+	nLinks := 0
+	for i, row := range sheet.rows {
+		fmt.Fprintf(&b, `<row r="%d">`, i+1)
+		for j, cell := range row {
+			ref := xlsxColumn(j) + strconv.Itoa(i+1)
+			if i == 0 {
+				fmt.Fprintf(&b, `<c r="%s" s="%d" t="inlineStr"><is><t>%s</t></is></c>`, ref, xlsxHeaderStyle, xmlEscape(cell.text))
+				continue
+			}
+			if _, err := strconv.Atoi(cell.text); err == nil {
+				fmt.Fprintf(&b, `<c r="%s" s="%d"><v>%s</v></c>`, ref, xlsxNumberStyle, cell.text)
+				continue
+			}
+			if cell.url == "" {
+				fmt.Fprintf(&b, `<c r="%s" t="inlineStr"><is><t>%s</t></is></c>`, ref, xmlEscape(cell.text))
+				continue
+			}
+			nLinks++
+			fmt.Fprintf(&b, `<c r="%s" s="%d" t="inlineStr"><is><t>%s</t></is></c>`, ref, xlsxLinkStyle, xmlEscape(cell.text))
+			fmt.Fprintf(&links, `<hyperlink ref="%s" r:id="rId%d"/>`, ref, nLinks)
+			fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/hyperlink" Target="%s" TargetMode="External"/>`, nLinks, xmlEscape(cell.url))
+		}
+		b.WriteString(`</row>`)
+	}
+	b.WriteString(`</sheetData>`)
+	if nLinks == 0 {
+		b.WriteString(`</worksheet>`)
+		return b.String(), ""
+	}
+	b.WriteString(`<hyperlinks>` + links.String() + `</hyperlinks></worksheet>`)
+	return b.String(), `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` + rels.String() + `</Relationships>`
+}
+
+// xlsxSheetName returns a valid and unique name of a sheet: up to 31 characters, without []:*?/\
+func xlsxSheetName(name string, taken map[string]bool) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	if name == "" {
+		name = "packages"
+	}
+	if r := []rune(name); len(r) > 31 {
+		name = string(r[:31])
+	}
+	unique := name
+	for i := 2; taken[strings.ToLower(unique)]; i++ {
+		suffix := "~" + strconv.Itoa(i)
+		r := []rune(name)
+		if len(r)+len(suffix) > 31 {
+			r = r[:31-len(suffix)]
+		}
+		unique = string(r) + suffix
+	}
+	taken[strings.ToLower(unique)] = true
+	return unique
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// xlsxColumn returns the letters of the column by its index, i.e AA for 26.
+func xlsxColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}